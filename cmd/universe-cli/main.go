@@ -1,8 +0,0 @@
-package main
-
-import "fmt"
-
-func main() {
-	fmt.Println("Universe CLI starting...")
-	// TODO: Implement CLI logic
-}
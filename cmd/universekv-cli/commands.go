@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"universe/pkg/client"
+
+	"github.com/spf13/cobra"
+)
+
+func newGetCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get KEY",
+		Short: "Print the value of a key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := a.withTimeout(cmd.Context())
+			defer cancel()
+
+			value, err := a.client.Get(ctx, args[0])
+			if errors.Is(err, client.ErrNotFound) {
+				return fmt.Errorf("%s: key not found", args[0])
+			}
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			out.Write(value)
+			fmt.Fprintln(out)
+			return nil
+		},
+	}
+}
+
+// newSetCommand returns set, which reads the value from stdin if it is not
+// given, except interactively, where stdin holds the commands.
+func newSetCommand(a *app, interactive bool) *cobra.Command {
+	var ttl durationFlag
+	cmd := &cobra.Command{
+		Use:   "set KEY [VALUE]",
+		Short: "Set a key, to the value read from stdin if none is given",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 && interactive {
+				return errors.New("set needs a value in interactive mode")
+			}
+			var value []byte
+			if len(args) == 2 {
+				value = []byte(args[1])
+			} else {
+				var err error
+				if value, err = io.ReadAll(cmd.InOrStdin()); err != nil {
+					return fmt.Errorf("read value: %w", err)
+				}
+			}
+
+			ctx, cancel := a.withTimeout(cmd.Context())
+			defer cancel()
+			if err := a.client.SetWithTTL(ctx, args[0], value, ttl.value); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "OK")
+			return nil
+		},
+	}
+	cmd.Flags().Var(&ttl, "ttl", "expire the key after this duration, e.g. 90s")
+	return cmd
+}
+
+func newDelCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:     "del KEY...",
+		Aliases: []string{"delete"},
+		Short:   "Delete keys and print how many existed",
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := a.withTimeout(cmd.Context())
+			defer cancel()
+
+			deleted := 0
+			for _, key := range args {
+				existed, err := a.client.Delete(ctx, key)
+				if err != nil {
+					return err
+				}
+				if existed {
+					deleted++
+				}
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), deleted)
+			return nil
+		},
+	}
+}
+
+func newScanCommand(a *app) *cobra.Command {
+	var keysOnly bool
+	var limit int
+	cmd := &cobra.Command{
+		Use:   "scan [PREFIX]",
+		Short: "List the keys with a prefix and their values, tab-separated",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := a.withTimeout(cmd.Context())
+			defer cancel()
+
+			out := bufio.NewWriter(cmd.OutOrStdout())
+			defer out.Flush()
+			scan := a.client.Scan(ctx, firstArg(args))
+			for n := 0; (limit <= 0 || n < limit) && scan.Next(); n++ {
+				item := scan.KeyValue()
+				out.WriteString(item.Key)
+				if !keysOnly {
+					out.WriteByte('\t')
+					out.Write(item.Value)
+				}
+				out.WriteByte('\n')
+			}
+			return scan.Err()
+		},
+	}
+	cmd.Flags().BoolVar(&keysOnly, "keys-only", false, "print only the keys")
+	cmd.Flags().IntVar(&limit, "limit", 0, "stop after this many keys; 0 lists all")
+	return cmd
+}
+
+func newWatchCommand(a *app) *cobra.Command {
+	var revision uint64
+	cmd := &cobra.Command{
+		Use:   "watch [PREFIX]",
+		Short: "Print changes to the keys with a prefix until interrupted",
+		Long: `Print changes to the keys with a prefix until interrupted, one per line as
+revision, type (set or delete), key and value, tab-separated.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			var w *client.Watcher
+			var err error
+			if revision > 0 {
+				w, err = a.client.WatchFrom(ctx, firstArg(args), revision)
+			} else {
+				w, err = a.client.Watch(ctx, firstArg(args))
+			}
+			if err != nil {
+				return err
+			}
+			defer w.Close()
+
+			out := cmd.OutOrStdout()
+			for event := range w.Events() {
+				fmt.Fprintf(out, "%d\t%s\t%s\t%s\n", event.Revision, event.Type, event.Key, event.Value)
+			}
+			if err := w.Err(); err != nil && ctx.Err() == nil {
+				return err
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Uint64Var(&revision, "rev", 0, "first replay the changes after this revision")
+	return cmd
+}
+
+// backupRecord is one line of a backup file.
+type backupRecord struct {
+	Key      string `json:"key"`
+	Value    []byte `json:"value"`
+	Revision uint64 `json:"revision"`
+}
+
+func newBackupCommand(a *app) *cobra.Command {
+	var prefix string
+	cmd := &cobra.Command{
+		Use:   "backup FILE",
+		Short: "Write the keys and values to a file, or to stdout for -",
+		Long: `Write the keys with a prefix and their values to FILE, or to stdout when FILE
+is -, as JSON lines holding the key, the base64-encoded value and the revision
+it was written at.
+
+The backup is read page by page while the server keeps serving, so it is not
+a point-in-time snapshot: keys written meanwhile may or may not be in it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// A backup may take long; only an interrupt stops it.
+			ctx := cmd.Context()
+			path := args[0]
+			if path == "-" {
+				n, err := backup(ctx, a.client, prefix, cmd.OutOrStdout())
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "backed up %d keys\n", n)
+				return nil
+			}
+
+			// Write to a temporary file first, so a failed backup does
+			// not leave a truncated file behind under the name asked for.
+			file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(file.Name())
+			n, err := backup(ctx, a.client, prefix, file)
+			if err == nil {
+				err = file.Sync()
+			}
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+			if err := os.Rename(file.Name(), path); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "backed up %d keys to %s\n", n, path)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&prefix, "prefix", "", "back up only the keys with this prefix")
+	return cmd
+}
+
+// backup writes the keys with prefix to w and returns how many it wrote.
+func backup(ctx context.Context, c *client.Client, prefix string, w io.Writer) (int, error) {
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+	n := 0
+	scan := c.Scan(ctx, prefix)
+	for scan.Next() {
+		item := scan.KeyValue()
+		if err := encoder.Encode(backupRecord{Key: item.Key, Value: item.Value, Revision: item.Revision}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := scan.Err(); err != nil {
+		return n, err
+	}
+	return n, out.Flush()
+}
+
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+// durationFlag is a duration flag that also takes a plain number of
+// seconds, as the server's ttl parameter does.
+type durationFlag struct {
+	value time.Duration
+}
+
+func (d *durationFlag) String() string {
+	if d.value == 0 {
+		return ""
+	}
+	return d.value.String()
+}
+
+func (d *durationFlag) Set(raw string) error {
+	if seconds, err := strconv.ParseUint(raw, 10, 32); err == nil {
+		d.value = time.Duration(seconds) * time.Second
+		return nil
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	if parsed < 0 {
+		return errors.New("must not be negative")
+	}
+	d.value = parsed
+	return nil
+}
+
+func (d *durationFlag) Type() string {
+	return "duration"
+}
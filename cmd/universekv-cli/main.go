@@ -0,0 +1,126 @@
+// Command universekv-cli talks to a running universekv server over its HTTP
+// API, for operations and debugging. It runs one command, such as
+//
+//	universekv-cli get users/1
+//
+// or, started without one, reads commands interactively.
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"os/signal"
+	"time"
+	"universe/pkg/client"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultAddr = "http://localhost:8080"
+
+	// envPrefix matches the server's, so one environment configures both.
+	envPrefix = "UNIVERSEKV_"
+)
+
+// app is the state shared by the commands: the connection settings and the
+// client made from them, which interactive mode reuses across commands.
+type app struct {
+	addr    string
+	token   string
+	timeout time.Duration
+	retries int
+
+	client *client.Client
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	a := &app{}
+	if err := newRootCommand(a, false).ExecuteContext(ctx); err != nil {
+		os.Exit(1)
+	}
+}
+
+// newRootCommand returns the command tree. Interactive mode builds a fresh
+// one for every line it reads, without the connection flags, which only
+// the command line sets.
+func newRootCommand(a *app, interactive bool) *cobra.Command {
+	root := &cobra.Command{
+		Use:   "universekv-cli",
+		Short: "Command-line client for a universekv server",
+		Long: `universekv-cli talks to a running universekv server over its HTTP API.
+Run it with a command to run that command, or without one to enter commands
+interactively.`,
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return a.connect()
+		},
+	}
+	if interactive {
+		root.Long = "Enter a command per line; quit or end of input leaves."
+		root.CompletionOptions.DisableDefaultCmd = true
+	} else {
+		flags := root.PersistentFlags()
+		flags.StringVar(&a.addr, "addr", envOr("ADDR", defaultAddr), "base URL of the server's HTTP API (env "+envPrefix+"ADDR)")
+		flags.StringVar(&a.token, "token", os.Getenv(envPrefix+"TOKEN"), "bearer token to authenticate with (env "+envPrefix+"TOKEN)")
+		flags.DurationVar(&a.timeout, "timeout", 10*time.Second, "give up on a command after this long, retries included; watch is not limited")
+		flags.IntVar(&a.retries, "retries", client.DefaultMaxRetries, "retries of a request the server could not serve")
+		root.RunE = func(cmd *cobra.Command, args []string) error {
+			return a.repl(cmd.Context())
+		}
+	}
+
+	root.AddCommand(
+		newGetCommand(a),
+		newSetCommand(a, interactive),
+		newDelCommand(a),
+		newScanCommand(a),
+		newWatchCommand(a),
+		newBackupCommand(a),
+	)
+	return root
+}
+
+func envOr(name, fallback string) string {
+	if value, ok := os.LookupEnv(envPrefix + name); ok {
+		return value
+	}
+	return fallback
+}
+
+// connect makes the client on first use.
+func (a *app) connect() error {
+	if a.client != nil {
+		return nil
+	}
+	opts := []client.Option{client.WithRetries(a.retries)}
+	if a.token != "" {
+		opts = append(opts, client.WithBearerToken(a.token))
+	}
+	c, err := client.New(a.addr, opts...)
+	if err != nil {
+		return err
+	}
+	a.client = c
+	return nil
+}
+
+// withTimeout bounds a command's context by the --timeout flag.
+func (a *app) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, a.timeout)
+}
+
+// host is the server's host, for the interactive prompt.
+func (a *app) host() string {
+	if u, err := url.Parse(a.addr); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return a.addr
+}
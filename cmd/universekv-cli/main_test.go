@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"universe/pkg/testutil"
+)
+
+// execute runs the command line args against the server at addr with
+// stdin as its input, returning what it printed.
+func execute(ctx context.Context, addr, stdin string, args ...string) (stdout, stderr string, err error) {
+	cmd := newRootCommand(&app{}, false)
+	var out, errOut bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&errOut)
+	cmd.SetIn(strings.NewReader(stdin))
+	cmd.SetArgs(append([]string{"--addr", addr, "--retries", "0"}, args...))
+	err = cmd.ExecuteContext(ctx)
+	return out.String(), errOut.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	server := testutil.StartTestServer(t)
+
+	for _, tc := range []struct {
+		name    string
+		stdin   string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "set", args: []string{"set", "a", "1"}, want: "OK\n"},
+		{name: "get", args: []string{"get", "a"}, want: "1\n"},
+		{name: "get missing", args: []string{"get", "missing"}, wantErr: "missing: key not found"},
+		{name: "set from stdin", stdin: "from stdin", args: []string{"set", "b"}, want: "OK\n"},
+		{name: "get from stdin", args: []string{"get", "b"}, want: "from stdin\n"},
+		{name: "set with ttl", args: []string{"set", "c", "3", "--ttl", "1h"}, want: "OK\n"},
+		{name: "set with ttl seconds", args: []string{"set", "d", "4", "--ttl", "60"}, want: "OK\n"},
+		{name: "set with negative ttl", args: []string{"set", "e", "5", "--ttl", "-1s"}, wantErr: "must not be negative"},
+		{name: "scan", args: []string{"scan"}, want: "a\t1\nb\tfrom stdin\nc\t3\nd\t4\n"},
+		{name: "scan prefix", args: []string{"scan", "b"}, want: "b\tfrom stdin\n"},
+		{name: "scan keys only", args: []string{"scan", "--keys-only", "--limit", "2"}, want: "a\nb\n"},
+		{name: "del", args: []string{"del", "a", "missing", "d"}, want: "2\n"},
+		{name: "delete alias", args: []string{"delete", "a"}, want: "0\n"},
+		{name: "scan after del", args: []string{"scan", "--keys-only"}, want: "b\nc\n"},
+		{name: "wrong arguments", args: []string{"get"}, wantErr: "accepts 1 arg(s)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, _, err := execute(context.Background(), server.URL, tc.stdin, tc.args...)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if out != tc.want {
+				t.Fatalf("expected output %q, got %q", tc.want, out)
+			}
+		})
+	}
+
+	if _, ok := server.Store.Get("e"); ok {
+		t.Fatalf("a set with an invalid ttl was applied")
+	}
+}
+
+func TestInteractiveSetNeedsValue(t *testing.T) {
+	server := testutil.StartTestServer(t)
+	cmd := newRootCommand(&app{addr: server.URL}, true)
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	cmd.SetIn(strings.NewReader("not the value"))
+	cmd.SetArgs([]string{"set", "a"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "interactive mode") {
+		t.Fatalf("expected set without a value to fail interactively, got %v", err)
+	}
+}
+
+func TestWatchCommand(t *testing.T) {
+	server := testutil.StartTestServer(t)
+	if err := server.Store.Set("w/a", []byte("1")); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	if err := server.Store.Set("w/b", []byte("2")); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, w := io.Pipe()
+	defer r.Close()
+	cmd := newRootCommand(&app{}, false)
+	cmd.SetOut(w)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs([]string{"--addr", server.URL, "watch", "w/", "--rev", "1"})
+	done := make(chan error, 1)
+	go func() { done <- cmd.ExecuteContext(ctx) }()
+
+	// The change after revision 1 is replayed.
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read event: %v", err)
+	}
+	if line != "2\tset\tw/b\t2\n" {
+		t.Fatalf("unexpected event line %q", line)
+	}
+
+	// An interrupt ends the watch without an error.
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected an interrupted watch to succeed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watch did not stop")
+	}
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	source := testutil.StartTestServer(t)
+	want := map[string]string{"users/1": "ada", "users/2": "grace\nhopper", "users/3": "", "other": "skipped"}
+	for key, value := range want {
+		if err := source.Store.Set(key, []byte(value)); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	delete(want, "other")
+
+	path := filepath.Join(t.TempDir(), "users.jsonl")
+	_, stderr, err := execute(context.Background(), source.URL, "", "backup", path, "--prefix", "users/")
+	if err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	if !strings.Contains(stderr, "backed up 3 keys") {
+		t.Fatalf("unexpected backup summary %q", stderr)
+	}
+	if matches, _ := filepath.Glob(path + ".*.tmp"); len(matches) != 0 {
+		t.Fatalf("expected no temporary files left, got %v", matches)
+	}
+
+	// Restore the backup into another server and back that up to stdout.
+	target := testutil.StartTestServer(t)
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open backup: %v", err)
+	}
+	defer file.Close()
+	lines := bufio.NewScanner(file)
+	for lines.Scan() {
+		var record backupRecord
+		if err := json.Unmarshal(lines.Bytes(), &record); err != nil {
+			t.Fatalf("decode backup line %q: %v", lines.Text(), err)
+		}
+		if record.Revision == 0 {
+			t.Fatalf("expected the revision in backup line %q", lines.Text())
+		}
+		if _, _, err := execute(context.Background(), target.URL, string(record.Value), "set", record.Key); err != nil {
+			t.Fatalf("restore %s: %v", record.Key, err)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		t.Fatalf("read backup: %v", err)
+	}
+
+	out, _, err := execute(context.Background(), target.URL, "", "backup", "-")
+	if err != nil {
+		t.Fatalf("backup restored: %v", err)
+	}
+	got := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var record backupRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("decode backup line %q: %v", line, err)
+		}
+		got[record.Key] = string(record.Value)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the restored keys %v, got %v", want, got)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"  get   a ", []string{"get", "a"}},
+		{`set k "two words"`, []string{"set", "k", "two words"}},
+		{`set k 'it\'s'`, nil},
+		{`set k 'a \ b'`, []string{"set", "k", `a \ b`}},
+		{`set k "say \"hi\""`, []string{"set", "k", `say "hi"`}},
+		{`set k a\ b`, []string{"set", "k", "a b"}},
+		{`set k ""`, []string{"set", "k", ""}},
+	} {
+		got, err := splitArgs(tc.line)
+		if tc.want == nil && tc.line != "" {
+			if err == nil {
+				t.Fatalf("%q: expected an error, got %q", tc.line, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%q: expected %q, got %q, %v", tc.line, tc.want, got, err)
+		}
+	}
+}
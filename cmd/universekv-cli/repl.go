@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// repl reads commands from stdin and runs them, printing a prompt when
+// stdin is a terminal, until quit or the end of the input. An interrupt
+// stops the command running, such as a watch, rather than the session.
+func (a *app) repl(ctx context.Context) error {
+	if err := a.connect(); err != nil {
+		return err
+	}
+	// Interrupts are handled per command from here on.
+	ctx = context.WithoutCancel(ctx)
+
+	prompt := ""
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		prompt = a.host() + "> "
+		fmt.Fprintf(os.Stderr, "connected to %s; type help for the commands, quit to leave\n", a.addr)
+	}
+
+	lines := bufio.NewScanner(os.Stdin)
+	lines.Buffer(make([]byte, 64<<10), 16<<20)
+	for {
+		fmt.Fprint(os.Stdout, prompt)
+		if !lines.Scan() {
+			if prompt != "" {
+				fmt.Fprintln(os.Stdout)
+			}
+			return lines.Err()
+		}
+		args, err := splitArgs(lines.Text())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" || args[0] == "exit" {
+			return nil
+		}
+		a.run(ctx, args)
+	}
+}
+
+// run runs one interactive command; cobra reports its errors.
+func (a *app) run(ctx context.Context, args []string) {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	cmd := newRootCommand(a, true)
+	cmd.SetArgs(args)
+	_ = cmd.ExecuteContext(ctx)
+}
+
+// splitArgs splits a command line into arguments at unquoted whitespace.
+// Single quotes keep everything up to the next one; within double quotes
+// and outside quotes a backslash escapes the next character.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
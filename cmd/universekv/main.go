@@ -3,27 +3,303 @@
 // @description A distributed key-value store API
 // @host localhost:8080
 // @BasePath /
+// @schemes http https
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+	"universe/internal/config"
+	"universe/internal/logging"
+	"universe/internal/panics"
+	"universe/internal/server/grpc"
 	"universe/internal/server/http"
+	"universe/internal/server/resp"
 	"universe/internal/store"
+	"universe/internal/systemd"
 )
 
+var logger = logging.For(logging.CategoryServer)
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "wal" {
+		os.Exit(walCommand(os.Args[2:]))
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	runtime.SetMutexProfileFraction(cfg.MutexProfileFraction)
+	runtime.SetBlockProfileRate(cfg.BlockProfileRate)
+
+	if err := logging.ParseLevels(cfg.LogLevel); err != nil {
+		fatal("parse log levels", err)
+	}
+	handler := logging.NewHandler(os.Stderr)
+	if sampling := cfg.LogSampling; sampling.First > 0 {
+		handler = logging.NewSampler(handler, sampling.Tick, sampling.First, sampling.Thereafter)
+	}
+	logging.SetHandler(handler)
+
+	policy, err := panics.ParsePolicy(cfg.Panic.Policy)
+	if err != nil {
+		fatal("parse panic policy", err)
+	}
+	panics.SetPolicy(policy)
+	if cfg.Panic.Webhook != "" {
+		panics.OnReport(panics.WebhookReporter(cfg.Panic.Webhook))
+	}
+
+	syncPolicy, err := store.ParseSyncPolicy(cfg.WAL.Sync)
+	if err != nil {
+		fatal("parse wal sync policy", err)
+	}
+
+	compression, err := store.ParseCompression(cfg.WAL.Compression)
+	if err != nil {
+		fatal("parse wal compression", err)
+	}
+
+	recoveryMode, err := store.ParseRecoveryMode(cfg.WAL.Recovery)
+	if err != nil {
+		fatal("parse wal recovery mode", err)
+	}
+
+	if cfg.HTTP.ACL.Enabled && len(cfg.HTTP.Auth.Tokens) == 0 && len(cfg.HTTP.Auth.Users) == 0 {
+		fatal("enable access control", errors.New("-http-acl needs -http-auth-tokens or -http-auth-users"))
+	}
+	httpAuth := len(cfg.HTTP.Auth.Tokens) > 0 || len(cfg.HTTP.Auth.Users) > 0
+	if cfg.GRPC.Enabled && httpAuth {
+		fatal("enable grpc", errors.New("the gRPC API has no authentication yet and would bypass -http-auth-*"))
+	}
+	if cfg.RESP.Enabled && httpAuth {
+		fatal("enable resp", errors.New("the Redis protocol server has no authentication yet and would bypass -http-auth-*"))
+	}
+
 	fmt.Println("Universe KV Server starting...")
 
-	store, err := store.New("universe.wal")
+	// The store recovers in the background while the servers already
+	// answer health probes, so a long replay is not mistaken for a hang.
+	store, recovered, err := store.Open(cfg.WALPath(), store.Options{
+		WAL: store.WALOptions{
+			SegmentSize:   cfg.WAL.SegmentSize,
+			Sync:          syncPolicy,
+			FlushInterval: cfg.WAL.FlushInterval,
+			BufferSize:    cfg.WAL.BufferSize,
+			Compression:   compression,
+			Recovery:      recoveryMode,
+		},
+		CheckpointOnClose:  cfg.Store.CheckpointOnClose,
+		HistoryRevisions:   cfg.Store.HistoryRevisions,
+		RecoverPrefixes:    cfg.Store.RecoverPrefixes,
+		WarmPrefixes:       cfg.Store.WarmPrefixes,
+		TTLJitter:          cfg.Store.TTLJitter,
+		MaxKeyLength:       cfg.Store.MaxKeyLength,
+		MaxValueSize:       cfg.Store.MaxValueSize,
+		SlowWriteThreshold: cfg.Store.SlowWriteThreshold,
+		SweepInterval:      cfg.Store.Sweep.Interval,
+		SweepBatchSize:     cfg.Store.Sweep.BatchSize,
+		SweepMaxShare:      cfg.Store.Sweep.MaxShare,
+	})
 	if err != nil {
-		panic(err)
+		fatal("open store", err)
 	}
-	defer store.Close()
 
-	httpServer := http.NewServer(store)
-	if err := httpServer.Start(); err != nil {
-		panic(err)
+	serverOptions := http.Options{
+		Config: http.ServerConfig{
+			Address:            cfg.HTTP.Address,
+			Port:               cfg.HTTP.Port,
+			ReadHeaderTimeout:  cfg.HTTP.ReadHeaderTimeout,
+			ReadTimeout:        cfg.HTTP.ReadTimeout,
+			WriteTimeout:       cfg.HTTP.WriteTimeout,
+			IdleTimeout:        cfg.HTTP.IdleTimeout,
+			MaxBodyBytes:       cfg.HTTP.MaxBodyBytes,
+			CertFile:           cfg.HTTP.TLS.CertFile,
+			KeyFile:            cfg.HTTP.TLS.KeyFile,
+			ClientCAFile:       cfg.HTTP.TLS.ClientCAFile,
+			ClientCertOptional: cfg.HTTP.TLS.ClientCertOptional,
+		},
+		Auth: http.AuthConfig{
+			Tokens: cfg.HTTP.Auth.Tokens,
+			Users:  cfg.HTTP.Auth.Users,
+		},
+		ACL: http.ACLConfig{
+			Enabled: cfg.HTTP.ACL.Enabled,
+			Admins:  cfg.HTTP.ACL.Admins,
+		},
+		AsyncAck:         cfg.HTTP.AsyncAck,
+		BlockProfileRate: cfg.BlockProfileRate,
 	}
+	if cfg.HTTP.Chaos != "" {
+		if serverOptions.Chaos, err = http.LoadChaosConfig(cfg.HTTP.Chaos); err != nil {
+			fatal("load chaos config", err)
+		}
+	}
+	servers := []namedServer{{"http", http.NewServerWithOptions(store, serverOptions)}}
+	if cfg.GRPC.Enabled {
+		servers = append(servers, namedServer{"grpc", grpc.NewServerWithOptions(store, grpc.Options{
+			Config: grpc.Config{
+				Address: cfg.GRPC.Address,
+				Port:    cfg.GRPC.Port,
+			},
+			Etcd: cfg.GRPC.Etcd,
+		})})
+	}
+	if cfg.RESP.Enabled {
+		servers = append(servers, namedServer{"resp", resp.NewServer(store, resp.WithConfig(resp.Config{
+			Address: cfg.RESP.Address,
+			Port:    cfg.RESP.Port,
+		}))})
+	}
+
+	stop := make(chan string, 1)
+	panics.SetShutdown(func() { requestShutdown(stop, "panic") })
+	go handleSignals(stop)
 
-	defer httpServer.Stop()
+	// Every listener is bound before systemd is told the service is ready,
+	// so that nothing connecting after that is refused.
+	for _, s := range servers {
+		if err := s.Listen(); err != nil {
+			_ = store.Close()
+			fatal("listen "+s.name, err)
+		}
+	}
+	// The PID file is written last, once nothing can fail the start any
+	// more: fatal exits without cleaning up and would leave it behind.
+	if cfg.PIDFile != "" {
+		if err := writePIDFile(cfg.PIDFile); err != nil {
+			_ = store.Close()
+			fatal("write pid file", err)
+		}
+	}
+	serveErr := make(chan error, len(servers))
+	for _, s := range servers {
+		go func() { serveErr <- s.Start() }()
+	}
+
+	exitCode := run(stop, serveErr, recovered)
+	if !shutdown(servers, store, cfg.ShutdownTimeout) {
+		exitCode = 1
+	}
+	if cfg.PIDFile != "" {
+		_ = os.Remove(cfg.PIDFile)
+	}
+	os.Exit(exitCode)
+}
+
+// run waits for the store to recover, reports readiness to systemd and
+// then serves until a shutdown is requested or a server fails. It returns
+// the exit code.
+func run(stop <-chan string, serveErr, recovered <-chan error) int {
+	for {
+		select {
+		case reason := <-stop:
+			logger.Info("shutting down", "reason", reason)
+			return 0
+		case err := <-serveErr:
+			logger.Error("server failed", "error", err)
+			return 1
+		case err := <-recovered:
+			if err != nil {
+				logger.Error("recover store", "error", err)
+				return 1
+			}
+			recovered = nil
+			if _, err := systemd.Notify(systemd.StateReady); err != nil {
+				logger.Warn("systemd readiness notification failed", "error", err)
+			}
+		}
+	}
+}
+
+// handleSignals requests a shutdown on SIGINT/SIGTERM; a second one while
+// shutting down exits at once. SIGHUP is acknowledged but does not stop the
+// server since there is no configuration to reload yet.
+func handleSignals(stop chan<- string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			logger.Info("SIGHUP received, nothing to reload")
+			continue
+		}
+		if !requestShutdown(stop, sig.String()) {
+			logger.Warn("second signal received, exiting without draining", "signal", sig.String())
+			os.Exit(1)
+		}
+	}
+}
+
+// requestShutdown asks main to shut down, reporting false if a shutdown was
+// already requested.
+func requestShutdown(stop chan<- string, reason string) bool {
+	select {
+	case stop <- reason:
+		return true
+	default:
+		return false
+	}
+}
+
+// namedServer is one of the API servers main runs on the store.
+type namedServer struct {
+	name string
+	server
+}
+
+type server interface {
+	Listen() error
+	Start() error
+	Stop(ctx context.Context) error
+}
+
+// shutdown drains the servers in order, all within timeout, then flushes
+// and closes the store. It reports whether all went cleanly.
+func shutdown(servers []namedServer, store *store.Store, timeout time.Duration) bool {
+	if _, err := systemd.Notify(systemd.StateStopping); err != nil {
+		logger.Warn("systemd stopping notification failed", "error", err)
+	}
+
+	clean := true
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.Stop(ctx); err != nil {
+			logger.Error("stop "+s.name+" server", "error", err)
+			clean = false
+		}
+	}
+	if err := store.Close(); err != nil {
+		logger.Error("close store", "error", err)
+		clean = false
+	}
+	return clean
+}
+
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func writePIDFile(path string) error {
+	pid := strconv.Itoa(os.Getpid()) + "\n"
+	if err := os.WriteFile(path, []byte(pid), 0o644); err != nil {
+		return fmt.Errorf("write pid file: %w", err)
+	}
+	return nil
 }
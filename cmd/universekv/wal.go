@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"universe/internal/store"
+)
+
+// maxShownValue is how many bytes of a value wal inspect prints.
+const maxShownValue = 64
+
+const walUsage = `usage:
+  universekv wal inspect [-values] <wal path>
+  universekv wal repair [-mode truncate|skip] <wal path>
+
+Stop the server before repairing its WAL.
+`
+
+// walCommand runs the wal subcommands and returns the exit code.
+func walCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, walUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "inspect":
+		return walInspect(args[1:])
+	case "repair":
+		return walRepair(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, walUsage)
+		return 2
+	}
+}
+
+// walInspect prints every record of a WAL and exits with 1 if any is
+// corrupt.
+func walInspect(args []string) int {
+	flags := flag.NewFlagSet("wal inspect", flag.ContinueOnError)
+	values := flags.Bool("values", false, "print values, up to 64 bytes each")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, walUsage)
+		return 2
+	}
+
+	var records, corrupt int
+	var corruptBytes int64
+	err := store.InspectWAL(flags.Arg(0), func(record store.WALRecord) error {
+		fmt.Printf("segment %d offset %d size %d: ", record.Segment, record.Offset, record.Size)
+		if record.Err != nil {
+			corrupt++
+			corruptBytes += record.Size
+			fmt.Printf("CORRUPT %v\n", record.Err)
+			return nil
+		}
+		records++
+		fmt.Printf("v%d ", record.Version)
+		printEntry(os.Stdout, record.Entry, *values)
+		for _, op := range record.Entry.Batch {
+			fmt.Print("    ")
+			printEntry(os.Stdout, op, *values)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("%d records, %d corrupt (%d bytes)\n", records, corrupt, corruptBytes)
+	if corrupt > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printEntry(w io.Writer, entry store.WALEntry, values bool) {
+	fmt.Fprintf(w, "%s", entry.Type)
+	if entry.Revision != 0 {
+		fmt.Fprintf(w, " rev=%d", entry.Revision)
+	}
+	if entry.Type == store.OperationBatch {
+		fmt.Fprintf(w, " ops=%d\n", len(entry.Batch))
+		return
+	}
+	fmt.Fprintf(w, " key=%q", entry.Key)
+	if entry.Type == store.OperationSet {
+		fmt.Fprintf(w, " value_bytes=%d", len(entry.Value))
+		if values {
+			shown := entry.Value[:min(len(entry.Value), maxShownValue)]
+			fmt.Fprintf(w, " value=%q", shown)
+		}
+	}
+	if entry.ExpiresAt != 0 {
+		fmt.Fprintf(w, " expires=%s", time.Unix(0, entry.ExpiresAt).UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintln(w)
+}
+
+// walRepair removes corrupt records from a WAL.
+func walRepair(args []string) int {
+	flags := flag.NewFlagSet("wal repair", flag.ContinueOnError)
+	modeName := flags.String("mode", "truncate", "truncate (drop everything from the first corrupt record on) or skip (drop only corrupt records)")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, walUsage)
+		return 2
+	}
+	mode, err := store.ParseRepairMode(*modeName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	result, err := store.RepairWAL(flags.Arg(0), mode)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if result.Corrupt == 0 {
+		fmt.Println("no corrupt records found")
+		return 0
+	}
+	if mode == store.RepairTruncate {
+		fmt.Printf("truncated at the first corrupt record, discarded %d bytes\n", result.DiscardedBytes)
+	} else {
+		fmt.Printf("removed %d corrupt records, discarded %d bytes\n", result.Corrupt, result.DiscardedBytes)
+	}
+	return 0
+}
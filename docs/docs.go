@@ -15,23 +15,1351 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/admin/acl": {
+            "get": {
+                "description": "List the stored grants of every principal. Admins named in the server configuration are not listed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List access grants",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/http.ACLEntry"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/acl/{principal}": {
+            "put": {
+                "description": "Replace the grants of a principal. Each grant allows read, write or admin on the keys starting with its prefix; an empty prefix covers every key.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Replace access grants",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token name or basic-auth user",
+                        "name": "principal",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Grants",
+                        "name": "grants",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/http.Grant"
+                            }
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque caller context recorded in the audit log",
+                        "name": "X-Audit-Context",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid grants",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "write could not be persisted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Remove all stored grants of a principal.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Revoke access grants",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token name or basic-auth user",
+                        "name": "principal",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque caller context recorded in the audit log",
+                        "name": "X-Audit-Context",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "write could not be persisted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/analytics": {
+            "get": {
+                "description": "Scan the keyspace and report key counts and sizes grouped by prefix, plus a value size histogram.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Keyspace analytics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key segment delimiter (default \\",
+                        "name": "delimiter",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of segments forming a prefix (default 1)",
+                        "name": "depth",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of prefixes to return, ranked by bytes (default 20)",
+                        "name": "top",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/store.Analytics"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid analytics request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/clients": {
+            "get": {
+                "description": "List open client connections with their remote address, operation and byte counters.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List connected clients",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/http.ClientInfo"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/clients/{id}": {
+            "delete": {
+                "description": "Close the connection with the given client id.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Kill a client connection",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Client id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "client not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/diagnostics": {
+            "get": {
+                "description": "Dump goroutine and recovered panic counts, store/WAL queue depths and sampled mutex contention on store locks. Contention is only collected while the mutex profile fraction is non-zero.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Runtime diagnostics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/events": {
+            "get": {
+                "description": "Stream administrative actions and TTL expirations as they happen, as server-sent events named admin, carrying an AdminEvent, and expiration, carrying an ExpirationEvent. The stream ends if the client falls too far behind.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Stream admin events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Stream only admin or only expiration events",
+                        "name": "topic",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/http.AdminEvent"
+                        }
+                    },
+                    "400": {
+                        "description": "unknown topic",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/profile": {
+            "get": {
+                "description": "Capture a pprof profile (cpu, heap, allocs, goroutine, block, mutex, threadcreate) and stream it back. cpu, block and mutex profiles are sampled for the requested number of seconds, and hold only the events of that window; one such capture runs at a time.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Capture a runtime profile",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Profile type",
+                        "name": "type",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Sampling window in seconds (default 30, max 300)",
+                        "name": "seconds",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid profile request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "profile capture already running",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "profile capture failed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/relocate": {
+            "post": {
+                "description": "Move the WAL, checkpoint and manifest to another directory, e.g. on a new volume, while serving. Sealed files are copied first; writes pause only while the active segment is copied and appends switch over. The old path is left with a redirect to the new one, but the configured path should be updated before the next restart.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Move the data directory",
+                "parameters": [
+                    {
+                        "description": "Target directory",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/http.RelocateRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque caller context recorded in the audit log",
+                        "name": "X-Audit-Context",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/http.RelocateResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid target directory",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "relocation already in progress",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "relocation failed; the data stays where it was",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "store closed or failing writes",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "/delete/{key}": {
             "delete": {
-                "description": "Delete a key-value pair from the store",
+                "description": "Delete a key-value pair from the store",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kv"
+                ],
+                "summary": "Delete key-value pair",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque caller context recorded in the audit log",
+                        "name": "X-Audit-Context",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Return a Server-Timing breakdown when set",
+                        "name": "X-Debug-Timing",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "421": {
+                        "description": "key not served by this node",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "write could not be persisted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/get/{key}": {
+            "get": {
+                "description": "Get the value for a given key and, unless rev is given, the revision it was last written at",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kv"
+                ],
+                "summary": "Get value by key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Return the value as of this revision",
+                        "name": "rev",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Return a Server-Timing breakdown when set",
+                        "name": "X-Debug-Timing",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid or future revision",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "key not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "410": {
+                        "description": "revision compacted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/healthz": {
+            "get": {
+                "description": "Report that the process is up and serving HTTP. It does not check the store.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Liveness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/keys": {
+            "get": {
+                "description": "List keys in lexical order, one page at a time. Pass the returned next token as cursor to fetch the following page.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kv"
+                ],
+                "summary": "List keys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only list keys with this prefix",
+                        "name": "prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 100, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Continuation token from the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/http.KeysResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid listing request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Report whether the node should receive traffic: the store has replayed its WAL, the WAL accepts writes and the server is not shutting down. While the WAL is replayed the body reports the replay's progress under recovery.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/set/{key}": {
+            "post": {
+                "description": "Set a key-value pair in the store",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kv"
+                ],
+                "summary": "Set key-value pair",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Value",
+                        "name": "value",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/http.SetBody"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Expire the key after this duration, e.g. 90s or 10m; a plain number is seconds",
+                        "name": "ttl",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque caller context recorded in the audit log",
+                        "name": "X-Audit-Context",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Return a Server-Timing breakdown when set",
+                        "name": "X-Debug-Timing",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "421": {
+                        "description": "key not served by this node",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "write could not be persisted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/batch": {
+            "post": {
+                "description": "Apply the sets and deletes atomically and in order: after a crash either all of them are recovered or none. On success the response carries the revision of the write.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kv"
+                ],
+                "summary": "Apply a batch of writes",
+                "parameters": [
+                    {
+                        "description": "Operations",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/http.BatchRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque caller context recorded in the audit log",
+                        "name": "X-Audit-Context",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "421": {
+                        "description": "key not served by this node",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "write could not be persisted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/cas": {
+            "post": {
+                "description": "Apply the sets and deletes atomically if every condition holds. Each condition checks one key for existence, its last-written revision or its value. On success the response carries the revision of the write.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kv"
+                ],
+                "summary": "Conditional multi-key write",
+                "parameters": [
+                    {
+                        "description": "Conditions and writes",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/http.CASRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque caller context recorded in the audit log",
+                        "name": "X-Audit-Context",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "condition failed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "421": {
+                        "description": "key not served by this node",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "write could not be persisted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/id/{sequence}": {
+            "post": {
+                "description": "Issue count consecutive IDs, starting at the returned id, from the named sequence. IDs increase monotonically, also across restarts, but may have gaps.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kv"
+                ],
+                "summary": "Issue unique IDs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Sequence name",
+                        "name": "sequence",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "How many IDs to issue (default 1, max 1000)",
+                        "name": "count",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid count",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "reservation could not be persisted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/kv/{key}": {
+            "get": {
+                "description": "Return the value of key as the response body: as application/json when it is valid JSON and accepted, otherwise verbatim as application/octet-stream. The ETag is the revision the key was last written at; a matching If-None-Match gets 304. HEAD returns the headers only.",
+                "produces": [
+                    "application/json",
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "kv"
+                ],
+                "summary": "Get a key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Return the value as of this revision",
+                        "name": "rev",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag from an earlier response",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Return a Server-Timing breakdown when set",
+                        "name": "X-Debug-Timing",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "the value",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "304": {
+                        "description": "not modified"
+                    },
+                    "400": {
+                        "description": "invalid or future revision",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "key not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "406": {
+                        "description": "value is not JSON and raw bytes are not accepted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "410": {
+                        "description": "revision compacted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Store the request body as the value of key: verbatim when sent as application/octet-stream, otherwise as a JSON document. Keys may contain slashes.",
+                "consumes": [
+                    "application/json",
+                    "application/octet-stream"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kv"
+                ],
+                "summary": "Put a key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Value",
+                        "name": "value",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Expire the key after this duration, e.g. 90s or 10m; a plain number is seconds",
+                        "name": "ttl",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "async to be answered once the write is journaled, before it is durable; needs the server's async-ack mode",
+                        "name": "ack",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque caller context recorded in the audit log",
+                        "name": "X-Audit-Context",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Return a Server-Timing breakdown when set",
+                        "name": "X-Debug-Timing",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "replaced",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "201": {
+                        "description": "created",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "202": {
+                        "description": "journaled; confirm with the token",
+                        "schema": {
+                            "$ref": "#/definitions/http.WriteAccepted"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid value, ttl or ack",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "421": {
+                        "description": "key not served by this node",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "write could not be persisted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete key.",
+                "tags": [
+                    "kv"
+                ],
+                "summary": "Delete a key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "async to be answered once the delete is journaled, before it is durable; needs the server's async-ack mode",
+                        "name": "ack",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque caller context recorded in the audit log",
+                        "name": "X-Audit-Context",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Return a Server-Timing breakdown when set",
+                        "name": "X-Debug-Timing",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "journaled; confirm with the token",
+                        "schema": {
+                            "$ref": "#/definitions/http.WriteAccepted"
+                        }
+                    },
+                    "204": {
+                        "description": "deleted"
+                    },
+                    "400": {
+                        "description": "invalid ack",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "key not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "421": {
+                        "description": "key not served by this node",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "write could not be persisted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/lock/{name}": {
+            "get": {
+                "description": "Return the current holder of the named lock.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "locks"
+                ],
+                "summary": "Inspect a lock",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Lock name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/http.LockInfo"
+                        }
+                    },
+                    "404": {
+                        "description": "lock not held",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Extend the named lock by ttl_seconds. Fails with 409 if the token no longer holds the lock.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "locks"
+                ],
+                "summary": "Refresh a lock",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Lock name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Token and TTL",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/http.LockRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/http.LockInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid lock request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "lock not held",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Acquire the named lock for owner. The lock is released automatically after ttl_seconds (default 30, max 3600) unless refreshed. The returned fencing token increases with every acquisition; pass it to downstream systems to reject stale holders.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "locks"
+                ],
+                "summary": "Acquire a lock",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Lock name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Owner and TTL",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/http.LockRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque caller context recorded in the audit log",
+                        "name": "X-Audit-Context",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/http.LockInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid lock request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "lock held by another owner",
+                        "schema": {
+                            "$ref": "#/definitions/http.LockInfo"
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Release the named lock. Fails with 409 if the token no longer holds the lock.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "kv"
+                    "locks"
                 ],
-                "summary": "Delete key-value pair",
+                "summary": "Release a lock",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Key",
-                        "name": "key",
+                        "description": "Lock name",
+                        "name": "name",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Fencing token returned on acquire",
+                        "name": "token",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque caller context recorded in the audit log",
+                        "name": "X-Audit-Context",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -41,25 +1369,92 @@ const docTemplate = `{
                             "type": "object",
                             "additionalProperties": true
                         }
+                    },
+                    "400": {
+                        "description": "invalid token",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "lock not held",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
             }
         },
-        "/get/{key}": {
+        "/v1/mget": {
+            "post": {
+                "description": "Read many keys in one request. The results are in the order of the keys asked for; missing keys have found set to false.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kv"
+                ],
+                "summary": "Get many keys",
+                "parameters": [
+                    {
+                        "description": "Keys",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/http.MGetRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/http.MGetItem"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "invalid request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/writes/{token}": {
             "get": {
-                "description": "Get the value for a given key",
+                "description": "Report whether a write acknowledged with ack=async is still pending, durable on disk or failed. Tokens do not survive a restart; after one, read the key back instead.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "kv"
                 ],
-                "summary": "Get value by key",
+                "summary": "Check a write",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Key",
-                        "name": "key",
+                        "description": "Token returned by the write",
+                        "name": "token",
                         "in": "path",
                         "required": true
                     }
@@ -68,12 +1463,11 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/http.WriteStatusResponse"
                         }
                     },
                     "404": {
-                        "description": "key not found",
+                        "description": "unknown token",
                         "schema": {
                             "type": "string"
                         }
@@ -81,43 +1475,53 @@ const docTemplate = `{
                 }
             }
         },
-        "/set/{key}": {
-            "post": {
-                "description": "Set a key-value pair in the store",
-                "consumes": [
-                    "application/json"
-                ],
+        "/watch/{prefix}": {
+            "get": {
+                "description": "Stream changes to keys with the given prefix as server-sent events. Each event is named after the change (set or delete), carries the revision as its id and a WatchEvent as data. The stream ends if the client falls too far behind; resume it by passing the last id seen as rev or in the Last-Event-ID header. If the changes since that revision have been compacted, the stream sends a single history-lost event carrying a HistoryLostEvent and ends; re-read the keys before watching again.",
                 "produces": [
-                    "application/json"
+                    "text/event-stream"
                 ],
                 "tags": [
                     "kv"
                 ],
-                "summary": "Set key-value pair",
+                "summary": "Watch keys",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Key",
-                        "name": "key",
-                        "in": "path",
-                        "required": true
+                        "description": "Key prefix; empty watches every key",
+                        "name": "prefix",
+                        "in": "path"
                     },
                     {
-                        "description": "Value",
-                        "name": "value",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/http.SetBody"
-                        }
+                        "type": "integer",
+                        "description": "Resume after this revision",
+                        "name": "rev",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Resume after this revision, as sent by EventSource on reconnect",
+                        "name": "Last-Event-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/http.WatchEvent"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid revision",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "streaming unsupported",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
@@ -125,14 +1529,370 @@ const docTemplate = `{
         }
     },
     "definitions": {
-        "http.SetBody": {
+        "http.ACLEntry": {
+            "type": "object",
+            "properties": {
+                "grants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/http.Grant"
+                    }
+                },
+                "principal": {
+                    "type": "string"
+                }
+            }
+        },
+        "http.AdminEvent": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "principal": {
+                    "type": "string"
+                },
+                "target": {
+                    "type": "string"
+                }
+            }
+        },
+        "http.BatchOp": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "type": "string"
+                },
+                "op": {
+                    "type": "string",
+                    "enum": [
+                        "set",
+                        "delete"
+                    ]
+                },
+                "value": {
+                    "type": "object"
+                }
+            }
+        },
+        "http.BatchRequest": {
+            "type": "object",
+            "properties": {
+                "ops": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/http.BatchOp"
+                    }
+                }
+            }
+        },
+        "http.CASCondition": {
             "type": "object",
             "properties": {
+                "exists": {
+                    "description": "Exists requires the key to be present (true) or absent (false).",
+                    "type": "boolean"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "revision": {
+                    "description": "Revision requires the key to have been last written at this\nrevision, as returned by GET /get/{key}.",
+                    "type": "integer"
+                },
                 "value": {
+                    "description": "Value requires the key to hold exactly this JSON value.",
+                    "type": "object"
+                }
+            }
+        },
+        "http.CASRequest": {
+            "type": "object",
+            "properties": {
+                "delete": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "if": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/http.CASCondition"
+                    }
+                },
+                "set": {
+                    "type": "object",
+                    "additionalProperties": {}
+                }
+            }
+        },
+        "http.ClientInfo": {
+            "type": "object",
+            "properties": {
+                "bytes_in": {
+                    "type": "integer"
+                },
+                "bytes_out": {
+                    "type": "integer"
+                },
+                "connected_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "listener": {
+                    "type": "string"
+                },
+                "ops": {
+                    "type": "integer"
+                },
+                "principal": {
+                    "type": "string"
+                },
+                "remote_addr": {
+                    "type": "string"
+                }
+            }
+        },
+        "http.Grant": {
+            "type": "object",
+            "properties": {
+                "permission": {
+                    "type": "string",
+                    "enum": [
+                        "read",
+                        "write",
+                        "admin"
+                    ]
+                },
+                "prefix": {
+                    "type": "string"
+                }
+            }
+        },
+        "http.KeysResponse": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "next": {
+                    "type": "string"
+                }
+            }
+        },
+        "http.LockInfo": {
+            "type": "object",
+            "properties": {
+                "acquired_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "integer"
+                }
+            }
+        },
+        "http.LockRequest": {
+            "type": "object",
+            "properties": {
+                "owner": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "integer"
+                },
+                "ttl_seconds": {
+                    "type": "integer"
+                }
+            }
+        },
+        "http.MGetItem": {
+            "type": "object",
+            "properties": {
+                "bytes": {
                     "type": "array",
                     "items": {
                         "type": "integer"
                     }
+                },
+                "found": {
+                    "type": "boolean"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "revision": {
+                    "type": "integer"
+                },
+                "value": {
+                    "type": "object"
+                }
+            }
+        },
+        "http.MGetRequest": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "http.RelocateRequest": {
+            "type": "object",
+            "properties": {
+                "dir": {
+                    "type": "string"
+                }
+            }
+        },
+        "http.RelocateResponse": {
+            "type": "object",
+            "properties": {
+                "bytes": {
+                    "type": "integer"
+                },
+                "elapsed_ms": {
+                    "type": "integer"
+                },
+                "files": {
+                    "type": "integer"
+                },
+                "from": {
+                    "type": "string"
+                },
+                "pause_ms": {
+                    "description": "PauseMS is how long writes were held up.",
+                    "type": "integer"
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "http.SetBody": {
+            "type": "object",
+            "properties": {
+                "ttl": {
+                    "description": "TTL expires the key after a duration such as \"90s\" or \"10m\"; a plain\nnumber is read as seconds.",
+                    "type": "string"
+                },
+                "value": {}
+            }
+        },
+        "http.WatchEvent": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "type": "string"
+                },
+                "revision": {
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "http.WriteAccepted": {
+            "type": "object",
+            "properties": {
+                "status": {
+                    "type": "string"
+                },
+                "token": {
+                    "description": "Token is passed to GET /v1/writes/{token} to confirm durability.",
+                    "type": "string"
+                }
+            }
+        },
+        "http.WriteStatusResponse": {
+            "type": "object",
+            "properties": {
+                "status": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "store.Analytics": {
+            "type": "object",
+            "properties": {
+                "key_bytes": {
+                    "type": "integer"
+                },
+                "keys": {
+                    "type": "integer"
+                },
+                "prefixes": {
+                    "type": "integer"
+                },
+                "top_prefixes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/store.PrefixUsage"
+                    }
+                },
+                "value_bytes": {
+                    "type": "integer"
+                },
+                "value_size_histogram": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/store.SizeBucket"
+                    }
+                }
+            }
+        },
+        "store.PrefixUsage": {
+            "type": "object",
+            "properties": {
+                "key_bytes": {
+                    "type": "integer"
+                },
+                "keys": {
+                    "type": "integer"
+                },
+                "prefix": {
+                    "type": "string"
+                },
+                "value_bytes": {
+                    "type": "integer"
+                }
+            }
+        },
+        "store.SizeBucket": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "max": {
+                    "type": "integer"
+                },
+                "min": {
+                    "type": "integer"
                 }
             }
         }
@@ -144,7 +1904,7 @@ var SwaggerInfo = &swag.Spec{
 	Version:          "1.0",
 	Host:             "localhost:8080",
 	BasePath:         "/",
-	Schemes:          []string{},
+	Schemes:          []string{"http", "https"},
 	Title:            "Universe API",
 	Description:      "A distributed key-value store API",
 	InfoInstanceName: "swagger",
@@ -2,4 +2,21 @@ module universe
 
 go 1.25.1
 
-require github.com/mhmtszr/concurrent-swiss-map v1.0.8
+require (
+	github.com/google/pprof v0.0.0-20260115054156-294ebfa9ad83
+	github.com/klauspost/compress v1.18.0
+	github.com/mhmtszr/concurrent-swiss-map v1.0.8
+	github.com/spf13/cobra v1.10.2
+	google.golang.org/grpc v1.75.0
+	google.golang.org/protobuf v1.36.6
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/spf13/pflag v1.0.9 // indirect
+	golang.org/x/net v0.41.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/text v0.26.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250707201910-8d1bb00bc6a7 // indirect
+)
@@ -0,0 +1,405 @@
+// Package config loads the server configuration from a YAML file,
+// environment variables and command-line flags, in increasing order of
+// precedence.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"universe/internal/server/grpc"
+	"universe/internal/server/http"
+	"universe/internal/server/resp"
+	"universe/internal/store"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefixes the environment variables that set flags, e.g.
+// UNIVERSEKV_HTTP_PORT for -http-port.
+const EnvPrefix = "UNIVERSEKV_"
+
+// Config is the server configuration. The YAML keys are the field tags; each
+// setting also has a flag, noted on the field where its name differs.
+type Config struct {
+	// File is the configuration file the settings were read from, if any.
+	File string `yaml:"-"`
+
+	// DataDir is the directory relative WAL paths are resolved against.
+	DataDir string `yaml:"data_dir"`
+	PIDFile string `yaml:"pid_file"`
+	// LogLevel is a level spec such as "info,wal=debug,http=warn".
+	LogLevel             string        `yaml:"log_level"`
+	MutexProfileFraction int           `yaml:"mutex_profile_fraction"`
+	BlockProfileRate     int           `yaml:"block_profile_rate"`
+	ShutdownTimeout      time.Duration `yaml:"shutdown_timeout"`
+
+	LogSampling LogSamplingConfig `yaml:"log_sampling"`
+	Panic       PanicConfig       `yaml:"panic"`
+	WAL         WALConfig         `yaml:"wal"`
+	Store       StoreConfig       `yaml:"store"`
+	HTTP        HTTPConfig        `yaml:"http"`
+	GRPC        GRPCConfig        `yaml:"grpc"`
+	RESP        RESPConfig        `yaml:"resp"`
+}
+
+// LogSamplingConfig rate limits repeated log messages (-log-sampling-*).
+// Errors are always logged.
+type LogSamplingConfig struct {
+	// First is how many records of a message are logged per Tick before
+	// sampling starts; zero disables sampling.
+	First int `yaml:"first"`
+	// Thereafter logs every Thereafter-th record of a message after the
+	// first ones; zero drops them.
+	Thereafter int           `yaml:"thereafter"`
+	Tick       time.Duration `yaml:"tick"`
+}
+
+// PanicConfig configures the handling of recovered panics (-panic-*).
+type PanicConfig struct {
+	Policy  string `yaml:"policy"`
+	Webhook string `yaml:"webhook"`
+}
+
+// WALConfig configures the write-ahead log (-wal-*).
+type WALConfig struct {
+	// Path is the WAL file, relative to DataDir unless absolute.
+	Path          string        `yaml:"path"`
+	Sync          string        `yaml:"sync"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	BufferSize    int           `yaml:"buffer_size"`
+	SegmentSize   int64         `yaml:"segment_size"`
+	Compression   string        `yaml:"compression"`
+	Recovery      string        `yaml:"recovery"`
+}
+
+// StoreConfig configures the store.
+type StoreConfig struct {
+	CheckpointOnClose  bool          `yaml:"checkpoint_on_close"`
+	HistoryRevisions   int           `yaml:"history_revisions"`
+	RecoverPrefixes    []string      `yaml:"recover_prefixes"`
+	WarmPrefixes       []string      `yaml:"warm_prefixes"`
+	TTLJitter          float64       `yaml:"ttl_jitter"`
+	MaxKeyLength       int           `yaml:"max_key_length"`
+	MaxValueSize       int           `yaml:"max_value_size"`
+	SlowWriteThreshold time.Duration `yaml:"slow_write_threshold"`
+	Sweep              SweepConfig   `yaml:"sweep"`
+}
+
+// SweepConfig tunes the deletion of expired keys (-sweep-*).
+type SweepConfig struct {
+	Interval  time.Duration `yaml:"interval"`
+	BatchSize int           `yaml:"batch_size"`
+	MaxShare  float64       `yaml:"max_share"`
+}
+
+// HTTPConfig configures the HTTP API (-http-*).
+type HTTPConfig struct {
+	Address           string        `yaml:"address"`
+	Port              int           `yaml:"port"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	MaxBodyBytes      int64         `yaml:"max_body_bytes"`
+	// AsyncAck lets writes ask with ack=async to be answered before they
+	// are durable (-http-async-ack).
+	AsyncAck bool       `yaml:"async_ack"`
+	TLS      TLSConfig  `yaml:"tls"`
+	Auth     AuthConfig `yaml:"auth"`
+	ACL      ACLConfig  `yaml:"acl"`
+	// Chaos is the path of a chaos rules JSON file (-chaos).
+	Chaos string `yaml:"chaos"`
+}
+
+// GRPCConfig configures the gRPC API (-grpc*), which serves the same store
+// as the HTTP API.
+type GRPCConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+	Port    int    `yaml:"port"`
+	// Etcd also serves the etcd v3 KV and Watch services on the same port.
+	Etcd bool `yaml:"etcd"`
+}
+
+// RESPConfig configures the Redis protocol server (-resp*), which serves
+// the same store to Redis clients.
+type RESPConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+	Port    int    `yaml:"port"`
+}
+
+// TLSConfig configures HTTPS and client certificates (-http-tls-*). The
+// files are PEM encoded.
+type TLSConfig struct {
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ClientCAFile       string `yaml:"client_ca_file"`
+	ClientCertOptional bool   `yaml:"client_cert_optional"`
+}
+
+// AuthConfig lists the credentials the HTTP API accepts (-http-auth-*).
+// With none set the API is open.
+type AuthConfig struct {
+	// Tokens maps client names to their bearer tokens.
+	Tokens map[string]string `yaml:"tokens"`
+	// Users maps basic-auth user names to their passwords.
+	Users map[string]string `yaml:"users"`
+}
+
+// ACLConfig enables per-prefix access control (-http-acl*). The grants
+// themselves are managed through /admin/acl and kept in the store.
+type ACLConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Admins are principals with admin permission on every key.
+	Admins []string `yaml:"admins"`
+}
+
+// Default returns the configuration used when nothing is set.
+func Default() Config {
+	return Config{
+		DataDir:         ".",
+		LogLevel:        "info",
+		ShutdownTimeout: 15 * time.Second,
+		LogSampling:     LogSamplingConfig{Thereafter: 100, Tick: time.Second},
+		Panic:           PanicConfig{Policy: "restart"},
+		WAL: WALConfig{
+			Path:          "universe.wal",
+			Sync:          "interval",
+			FlushInterval: store.DefaultFlushInterval,
+			BufferSize:    store.DefaultBufferSize,
+			SegmentSize:   store.DefaultSegmentSize,
+			Compression:   "none",
+			Recovery:      "strict",
+		},
+		Store: StoreConfig{
+			CheckpointOnClose:  true,
+			HistoryRevisions:   store.DefaultHistoryRevisions,
+			MaxKeyLength:       store.DefaultMaxKeyLength,
+			MaxValueSize:       store.DefaultMaxValueSize,
+			SlowWriteThreshold: store.DefaultSlowWriteThreshold,
+			Sweep: SweepConfig{
+				Interval:  store.DefaultSweepInterval,
+				BatchSize: store.DefaultSweepBatchSize,
+				MaxShare:  store.DefaultSweepMaxShare,
+			},
+		},
+		HTTP: HTTPConfig{
+			Port:              http.DefaultPort,
+			ReadHeaderTimeout: http.DefaultReadHeaderTimeout,
+			ReadTimeout:       http.DefaultReadTimeout,
+			WriteTimeout:      http.DefaultWriteTimeout,
+			IdleTimeout:       http.DefaultIdleTimeout,
+			MaxBodyBytes:      http.DefaultMaxBodyBytes,
+		},
+		GRPC: GRPCConfig{
+			Port: grpc.DefaultPort,
+		},
+		RESP: RESPConfig{
+			Port: resp.DefaultPort,
+		},
+	}
+}
+
+// WALPath returns the WAL path resolved against DataDir.
+func (c Config) WALPath() string {
+	if filepath.IsAbs(c.WAL.Path) {
+		return c.WAL.Path
+	}
+	return filepath.Join(c.DataDir, c.WAL.Path)
+}
+
+// Load parses args, the command line without the program name, and builds
+// the configuration from the defaults, the file named by -config (or
+// UNIVERSEKV_CONFIG), the UNIVERSEKV_* environment variables and the flags,
+// each overriding the one before. It returns flag.ErrHelp for -h.
+func Load(args []string) (Config, error) {
+	cfg := Default()
+	flags := flag.NewFlagSet("universekv", flag.ContinueOnError)
+	file := flags.String("config", os.Getenv(EnvPrefix+"CONFIG"), "read settings from this YAML file; flags and UNIVERSEKV_* variables override it")
+	cfg.register(flags)
+
+	// The first parse only finds the file; the flags given are applied
+	// again once it has been read.
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if flags.NArg() > 0 {
+		return Config{}, fmt.Errorf("config: unexpected arguments %q", flags.Args())
+	}
+	given := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = f.Value.String() })
+
+	if *file != "" {
+		if err := cfg.readFile(*file); err != nil {
+			return Config{}, err
+		}
+		cfg.File = *file
+	}
+	if err := applyEnv(flags, given); err != nil {
+		return Config{}, err
+	}
+	for name, value := range given {
+		if err := flags.Set(name, value); err != nil {
+			return Config{}, fmt.Errorf("config: -%s: %w", name, err)
+		}
+	}
+	return cfg, nil
+}
+
+// readFile overlays the settings in the YAML file at path. Unknown keys are
+// rejected so that typos do not go unnoticed.
+func (c *Config) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config: parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv sets every flag not given on the command line from its
+// environment variable, if that is set.
+func applyEnv(flags *flag.FlagSet, given map[string]string) error {
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if _, ok := given[f.Name]; ok || f.Name == "config" || err != nil {
+			return
+		}
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok {
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("config: %s: %w", name, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// register defines a flag for every setting, writing into c.
+func (c *Config) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.DataDir, "data-dir", c.DataDir, "directory relative WAL paths are resolved against")
+	flags.StringVar(&c.PIDFile, "pid-file", c.PIDFile, "write the process id to this file while running")
+	flags.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log levels, e.g. \"info,wal=debug,http=warn\"")
+	flags.IntVar(&c.MutexProfileFraction, "mutex-profile-fraction", c.MutexProfileFraction, "sample 1/n mutex contention events for /admin/diagnostics (0 disables)")
+	flags.IntVar(&c.BlockProfileRate, "block-profile-rate", c.BlockProfileRate, "sample one blocking event per n nanoseconds spent blocked (0 disables)")
+	flags.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long to wait for in-flight requests to finish on shutdown")
+
+	flags.IntVar(&c.LogSampling.First, "log-sampling-first", c.LogSampling.First, "log this many records of a message per tick, then sample the rest (0 disables sampling)")
+	flags.IntVar(&c.LogSampling.Thereafter, "log-sampling-thereafter", c.LogSampling.Thereafter, "after the first records of a message in a tick, log every n-th (0 drops them)")
+	flags.DurationVar(&c.LogSampling.Tick, "log-sampling-tick", c.LogSampling.Tick, "how long log sampling counts a message's records before starting over")
+
+	flags.StringVar(&c.Panic.Policy, "panic-policy", c.Panic.Policy, "what to do after a recovered panic: restart, shutdown or crash")
+	flags.StringVar(&c.Panic.Webhook, "panic-webhook", c.Panic.Webhook, "POST panic reports as JSON to this URL")
+
+	flags.StringVar(&c.WAL.Path, "wal-path", c.WAL.Path, "WAL file, relative to -data-dir unless absolute")
+	flags.StringVar(&c.WAL.Sync, "wal-sync", c.WAL.Sync, "when to fsync the WAL: always (before acknowledging a write), interval or never")
+	flags.DurationVar(&c.WAL.FlushInterval, "wal-flush-interval", c.WAL.FlushInterval, "how often buffered WAL writes are flushed")
+	flags.IntVar(&c.WAL.BufferSize, "wal-buffer-size", c.WAL.BufferSize, "flush the WAL once this many writes are buffered")
+	flags.Int64Var(&c.WAL.SegmentSize, "wal-segment-size", c.WAL.SegmentSize, "rotate the WAL to a new segment after this many bytes (0 disables rotation)")
+	flags.StringVar(&c.WAL.Compression, "wal-compression", c.WAL.Compression, "compress large WAL and checkpoint records: none, snappy or zstd")
+	flags.StringVar(&c.WAL.Recovery, "wal-recovery", c.WAL.Recovery, "what to do with a corrupt WAL record on startup: strict (refuse to start) or truncate (discard it and everything after it)")
+
+	flags.BoolVar(&c.Store.CheckpointOnClose, "checkpoint-on-close", c.Store.CheckpointOnClose, "write a checkpoint on shutdown so the next start replays no WAL entries")
+	flags.IntVar(&c.Store.HistoryRevisions, "history-revisions", c.Store.HistoryRevisions, "how many revisions of past values GET /get/{key}?rev=N can read")
+	flags.Var((*listValue)(&c.Store.RecoverPrefixes), "recover-prefixes", "comma-separated key prefixes; when set, only these keys are recovered and served")
+	flags.Var((*listValue)(&c.Store.WarmPrefixes), "warm-prefixes", "comma-separated key prefixes recovered first; the rest load in the background after startup")
+	flags.IntVar(&c.Store.MaxKeyLength, "max-key-length", c.Store.MaxKeyLength, "reject writes to keys longer than this many bytes (negative disables)")
+	flags.IntVar(&c.Store.MaxValueSize, "max-value-size", c.Store.MaxValueSize, "reject values larger than this many bytes with 413 (negative disables)")
+	flags.DurationVar(&c.Store.SlowWriteThreshold, "slow-write-threshold", c.Store.SlowWriteThreshold, "log writes slower than this with their request ID (negative disables)")
+	flags.DurationVar(&c.Store.Sweep.Interval, "sweep-interval", c.Store.Sweep.Interval, "how often expired keys are deleted")
+	flags.IntVar(&c.Store.Sweep.BatchSize, "sweep-batch-size", c.Store.Sweep.BatchSize, "delete at most this many expired keys per sweep")
+	flags.Float64Var(&c.Store.Sweep.MaxShare, "sweep-max-share", c.Store.Sweep.MaxShare, "fraction of -sweep-interval one sweep may run for; the rest of the backlog waits for the next sweep")
+	flags.Float64Var(&c.Store.TTLJitter, "ttl-jitter", c.Store.TTLJitter, "randomly shorten or lengthen each TTL by up to this fraction, e.g. 0.1, so keys written together do not expire together")
+
+	flags.StringVar(&c.HTTP.Address, "http-address", c.HTTP.Address, "host or IP the HTTP API listens on (empty for all interfaces)")
+	flags.IntVar(&c.HTTP.Port, "http-port", c.HTTP.Port, "port the HTTP API listens on")
+	flags.DurationVar(&c.HTTP.ReadHeaderTimeout, "http-read-header-timeout", c.HTTP.ReadHeaderTimeout, "how long a client may take to send request headers (negative disables)")
+	flags.DurationVar(&c.HTTP.ReadTimeout, "http-read-timeout", c.HTTP.ReadTimeout, "how long a client may take to send a whole request (negative disables)")
+	flags.DurationVar(&c.HTTP.WriteTimeout, "http-write-timeout", c.HTTP.WriteTimeout, "how long writing a response may take; watches are exempt (negative disables)")
+	flags.DurationVar(&c.HTTP.IdleTimeout, "http-idle-timeout", c.HTTP.IdleTimeout, "how long an idle keep-alive connection is kept open (negative disables)")
+	flags.Int64Var(&c.HTTP.MaxBodyBytes, "http-max-body-bytes", c.HTTP.MaxBodyBytes, "reject request bodies larger than this with 413 (negative disables)")
+	flags.BoolVar(&c.HTTP.AsyncAck, "http-async-ack", c.HTTP.AsyncAck, "let writes ask with ack=async to be answered once journaled, before they are durable; confirm them at /v1/writes/{token}")
+	flags.StringVar(&c.HTTP.TLS.CertFile, "http-tls-cert", c.HTTP.TLS.CertFile, "serve HTTPS with this certificate chain (needs -http-tls-key)")
+	flags.StringVar(&c.HTTP.TLS.KeyFile, "http-tls-key", c.HTTP.TLS.KeyFile, "private key for -http-tls-cert")
+	flags.StringVar(&c.HTTP.TLS.ClientCAFile, "http-tls-client-ca", c.HTTP.TLS.ClientCAFile, "require client certificates signed by a CA in this bundle (mutual TLS)")
+	flags.BoolVar(&c.HTTP.TLS.ClientCertOptional, "http-tls-client-cert-optional", c.HTTP.TLS.ClientCertOptional, "accept clients without a certificate, verifying only those that present one")
+	flags.Var((*mapValue)(&c.HTTP.Auth.Tokens), "http-auth-tokens", "comma-separated name=token pairs; requests must send one of the tokens as \"Authorization: Bearer <token>\"")
+	flags.Var((*mapValue)(&c.HTTP.Auth.Users), "http-auth-users", "comma-separated user=password pairs accepted as basic-auth credentials")
+	flags.BoolVar(&c.HTTP.ACL.Enabled, "http-acl", c.HTTP.ACL.Enabled, "limit each authenticated client to the key prefixes granted to it through /admin/acl")
+	flags.Var((*listValue)(&c.HTTP.ACL.Admins), "http-acl-admins", "comma-separated principals with admin permission on every key")
+	flags.StringVar(&c.HTTP.Chaos, "chaos", c.HTTP.Chaos, "inject latency, errors and dropped watch events as described by this JSON file (staging only)")
+	flags.BoolVar(&c.GRPC.Enabled, "grpc", c.GRPC.Enabled, "also serve the gRPC API")
+	flags.StringVar(&c.GRPC.Address, "grpc-address", c.GRPC.Address, "host or IP the gRPC API listens on (empty for all interfaces)")
+	flags.IntVar(&c.GRPC.Port, "grpc-port", c.GRPC.Port, "port the gRPC API listens on")
+	flags.BoolVar(&c.GRPC.Etcd, "grpc-etcd", c.GRPC.Etcd, "also serve the etcd v3 KV and Watch services on the gRPC port")
+	flags.BoolVar(&c.RESP.Enabled, "resp", c.RESP.Enabled, "also serve GET, SET, DEL, EXISTS, TTL, EXPIRE and KEYS over the Redis protocol")
+	flags.StringVar(&c.RESP.Address, "resp-address", c.RESP.Address, "host or IP the Redis protocol server listens on (empty for all interfaces)")
+	flags.IntVar(&c.RESP.Port, "resp-port", c.RESP.Port, "port the Redis protocol server listens on")
+}
+
+// listValue is a comma-separated flag value, dropping empty items.
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// mapValue is a comma-separated list of key=value pairs.
+type mapValue map[string]string
+
+func (m *mapValue) String() string {
+	if m == nil || len(*m) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(*m))
+	for key, value := range *m {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m *mapValue) Set(value string) error {
+	*m = nil
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		if *m == nil {
+			*m = make(map[string]string)
+		}
+		(*m)[key] = value
+	}
+	return nil
+}
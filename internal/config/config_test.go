@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "universe.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+data_dir: /var/lib/universe
+log_level: debug
+wal:
+  flush_interval: 250ms
+  buffer_size: 500
+store:
+  recover_prefixes: [users/, orders/]
+http:
+  port: 9000
+  read_timeout: 1m
+`)
+
+	cfg, err := Load([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.HTTP.Port != 9000 || cfg.LogLevel != "debug" || cfg.WAL.FlushInterval != 250*time.Millisecond || cfg.WAL.BufferSize != 500 {
+		t.Fatalf("file settings not applied: %+v", cfg)
+	}
+	if cfg.HTTP.ReadTimeout != time.Minute || cfg.HTTP.WriteTimeout != Default().HTTP.WriteTimeout {
+		t.Fatalf("expected file timeouts over defaults, got %+v", cfg.HTTP)
+	}
+	if !reflect.DeepEqual(cfg.Store.RecoverPrefixes, []string{"users/", "orders/"}) {
+		t.Fatalf("unexpected prefixes %q", cfg.Store.RecoverPrefixes)
+	}
+	if got := cfg.WALPath(); got != "/var/lib/universe/universe.wal" {
+		t.Fatalf("unexpected wal path %q", got)
+	}
+
+	t.Setenv("UNIVERSEKV_HTTP_PORT", "9100")
+	t.Setenv("UNIVERSEKV_RECOVER_PREFIXES", "a/,b/")
+	t.Setenv("UNIVERSEKV_HTTP_AUTH_TOKENS", "ci=abc, deploy=d=ef")
+	if cfg, err = Load([]string{"-config", path}); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.HTTP.Port != 9100 || !reflect.DeepEqual(cfg.Store.RecoverPrefixes, []string{"a/", "b/"}) {
+		t.Fatalf("expected the environment to override the file, got %+v", cfg)
+	}
+	if want := map[string]string{"ci": "abc", "deploy": "d=ef"}; !reflect.DeepEqual(cfg.HTTP.Auth.Tokens, want) {
+		t.Fatalf("unexpected tokens %q", cfg.HTTP.Auth.Tokens)
+	}
+
+	if cfg, err = Load([]string{"-http-port", "9200", "-config", path, "-wal-path", "/tmp/other.wal"}); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.HTTP.Port != 9200 || cfg.LogLevel != "debug" {
+		t.Fatalf("expected flags to override the environment, got %+v", cfg)
+	}
+	if got := cfg.WALPath(); got != "/tmp/other.wal" {
+		t.Fatalf("expected an absolute wal path to be kept, got %q", got)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Fatalf("expected defaults, got %+v", cfg)
+	}
+	if got := cfg.WALPath(); got != "universe.wal" {
+		t.Fatalf("unexpected wal path %q", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for name, args := range map[string][]string{
+		"unknown key":  {"-config", writeConfig(t, "http:\n  prot: 9000\n")},
+		"bad duration": {"-config", writeConfig(t, "shutdown_timeout: soon\n")},
+		"missing file": {"-config", filepath.Join(t.TempDir(), "missing.yaml")},
+		"bad flag":     {"-http-port", "x"},
+		"extra args":   {"serve"},
+		"bad pair":     {"-http-auth-users", "alice"},
+	} {
+		if _, err := Load(args); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+
+	t.Setenv("UNIVERSEKV_WAL_BUFFER_SIZE", "many")
+	if _, err := Load(nil); err == nil || !strings.Contains(err.Error(), "UNIVERSEKV_WAL_BUFFER_SIZE") {
+		t.Fatalf("expected the variable to be named in the error, got %v", err)
+	}
+}
+
+func TestExampleConfigMatchesDefaults(t *testing.T) {
+	cfg, err := Load([]string{"-config", "../../configs/universekv-example.yaml"})
+	if err != nil {
+		t.Fatalf("load example: %v", err)
+	}
+	cfg.File = ""
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Fatalf("example config drifted from the defaults:\n%+v\n%+v", cfg, Default())
+	}
+}
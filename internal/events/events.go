@@ -0,0 +1,124 @@
+// Package events is an in-process publish/subscribe bus that decouples the
+// subsystems producing changes, such as the store's write path, from the
+// ones reacting to them, such as watchers. A new consumer subscribes to the
+// bus instead of being called from the producer.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Topic names a kind of event. The payload type of each topic is fixed.
+type Topic string
+
+const (
+	// TopicWrite is published for every key set or deleted, including
+	// writes replayed by recovery; the payload is a store.Event.
+	TopicWrite Topic = "write"
+	// TopicExpiration is published when the store deletes a key because
+	// its TTL passed, in addition to the write deleting it; the payload
+	// is a store.Expiration.
+	TopicExpiration Topic = "expiration"
+	// TopicAdmin is published for administrative actions taken through
+	// the server; the payload is an AdminAction.
+	TopicAdmin Topic = "admin"
+)
+
+// Event is a published payload together with its topic.
+type Event struct {
+	Topic   Topic
+	Payload any
+}
+
+// AdminAction describes an administrative action.
+type AdminAction struct {
+	// Action names what was done, e.g. "grants replaced".
+	Action string
+	// Principal is who did it, or "" without authentication.
+	Principal string
+	// Target is what it was done to, such as a principal or client id.
+	Target string
+}
+
+// Handler receives the events of the topics it subscribed to. Handlers run
+// in the publisher's goroutine, in publishing order, and often while it
+// holds locks, so they must return quickly; slow work belongs on a channel
+// or goroutine of the subscriber's own. An event a handler publishes is
+// delivered to every subscriber before the one being handled reaches the
+// handlers after it.
+type Handler func(Event)
+
+type subscription struct {
+	handler Handler
+	topics  map[Topic]bool
+}
+
+// Bus delivers published events to the subscribers of their topic. The
+// zero value is ready to use.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers atomic.Pointer[[]*subscription]
+}
+
+// New returns an empty bus.
+func New() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers handler for events of topics, or of every topic when
+// none are given. The returned function unsubscribes it; events being
+// published concurrently may still be delivered.
+func (b *Bus) Subscribe(handler Handler, topics ...Topic) func() {
+	sub := &subscription{handler: handler}
+	if len(topics) > 0 {
+		sub.topics = make(map[Topic]bool, len(topics))
+		for _, topic := range topics {
+			sub.topics[topic] = true
+		}
+	}
+
+	b.update(func(subs []*subscription) []*subscription { return append(subs, sub) })
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.update(func(subs []*subscription) []*subscription {
+				for i, s := range subs {
+					if s == sub {
+						return append(subs[:i:i], subs[i+1:]...)
+					}
+				}
+				return subs
+			})
+		})
+	}
+}
+
+// update replaces the subscriber list with a changed copy, so that Publish
+// can read it without locking.
+func (b *Bus) update(change func([]*subscription) []*subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var subs []*subscription
+	if current := b.subscribers.Load(); current != nil {
+		subs = append(subs, *current...)
+	}
+	subs = change(subs)
+	b.subscribers.Store(&subs)
+}
+
+// Publish delivers payload to the subscribers of topic and returns once
+// every handler has run.
+func (b *Bus) Publish(topic Topic, payload any) {
+	subs := b.subscribers.Load()
+	if subs == nil {
+		return
+	}
+	event := Event{Topic: topic, Payload: payload}
+	for _, sub := range *subs {
+		if sub.topics == nil || sub.topics[topic] {
+			sub.handler(event)
+		}
+	}
+}
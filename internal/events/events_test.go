@@ -0,0 +1,92 @@
+package events
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBusTopics(t *testing.T) {
+	bus := New()
+	var writes, all []Topic
+	stopWrites := bus.Subscribe(func(e Event) { writes = append(writes, e.Topic) }, TopicWrite)
+	bus.Subscribe(func(e Event) { all = append(all, e.Topic) })
+
+	bus.Publish(TopicWrite, 1)
+	bus.Publish(TopicAdmin, AdminAction{Action: "test"})
+	stopWrites()
+	stopWrites()
+	bus.Publish(TopicWrite, 2)
+
+	if want := []Topic{TopicWrite}; !reflect.DeepEqual(writes, want) {
+		t.Fatalf("expected %v until unsubscribed, got %v", want, writes)
+	}
+	if want := []Topic{TopicWrite, TopicAdmin, TopicWrite}; !reflect.DeepEqual(all, want) {
+		t.Fatalf("expected every topic, got %v", all)
+	}
+}
+
+func TestBusOrder(t *testing.T) {
+	bus := New()
+	var got []string
+	for _, name := range []string{"first", "second"} {
+		bus.Subscribe(func(e Event) { got = append(got, fmt.Sprint(name, e.Payload)) }, TopicWrite)
+	}
+
+	bus.Publish(TopicWrite, 1)
+	bus.Publish(TopicWrite, 2)
+
+	// Events arrive in publishing order, at subscribers in subscription
+	// order.
+	if want := []string{"first1", "second1", "first2", "second2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestBusUnsubscribeWhilePublishing(t *testing.T) {
+	bus := New()
+	var got []string
+	var stop func()
+	stop = bus.Subscribe(func(e Event) {
+		got = append(got, fmt.Sprint("once", e.Payload))
+		stop()
+	})
+	bus.Subscribe(func(e Event) { got = append(got, fmt.Sprint("always", e.Payload)) })
+
+	bus.Publish(TopicWrite, 1)
+	bus.Publish(TopicWrite, 2)
+
+	// The event being published still reaches every subscriber.
+	if want := []string{"once1", "always1", "always2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestBusPublishFromHandler(t *testing.T) {
+	bus := New()
+	var got []string
+	bus.Subscribe(func(e Event) {
+		got = append(got, fmt.Sprint("expiration", e.Payload))
+		bus.Publish(TopicAdmin, AdminAction{Action: "expired"})
+	}, TopicExpiration)
+	bus.Subscribe(func(e Event) {
+		got = append(got, fmt.Sprint("all", e.Payload))
+	})
+
+	done := make(chan struct{})
+	go func() {
+		bus.Publish(TopicExpiration, "k")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("publishing from a handler deadlocked")
+	}
+
+	// The nested event is delivered before the outer one moves on.
+	if want := []string{"expirationk", "all{expired  }", "allk"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
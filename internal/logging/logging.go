@@ -0,0 +1,224 @@
+// Package logging routes the server's structured logs through a shared,
+// replaceable slog.Handler with per-category levels.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Categories used by the server packages.
+const (
+	CategoryServer  = "server"
+	CategoryHTTP    = "http"
+	CategoryGRPC    = "grpc"
+	CategoryRESP    = "resp"
+	CategoryStore   = "store"
+	CategoryWAL     = "wal"
+	CategoryCluster = "cluster"
+	CategoryAudit   = "audit"
+)
+
+// categoryKey is the attribute carrying the category on every record.
+const categoryKey = "category"
+
+// requestIDKey is the attribute carrying the request ID on records logged
+// with a context from WithRequestID.
+const requestIDKey = "request_id"
+
+var (
+	mu           sync.RWMutex
+	handler      slog.Handler
+	defaultLevel = slog.LevelInfo
+	levels       = map[string]slog.Level{}
+)
+
+// SetHandler routes every category logger through h. A nil handler restores
+// the process-wide slog default.
+func SetHandler(h slog.Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handler = h
+}
+
+// NewHandler returns a text handler writing to w, for SetHandler. It passes
+// records down to the lowest level any category is set to, leaving the
+// filtering to the category levels; the process-wide slog default drops
+// everything below Info.
+func NewHandler(w io.Writer) slog.Handler {
+	return slog.NewTextHandler(w, &slog.HandlerOptions{Level: lowestLevel{}})
+}
+
+// lowestLevel is the lowest of the default and category levels, as they are
+// set when it is asked.
+type lowestLevel struct{}
+
+func (lowestLevel) Level() slog.Level {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	lowest := defaultLevel
+	for _, level := range levels {
+		lowest = min(lowest, level)
+	}
+	return lowest
+}
+
+// SetLevel sets the minimum level for a single category.
+func SetLevel(category string, level slog.Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	levels[category] = level
+}
+
+// SetDefaultLevel sets the minimum level for categories without an explicit level.
+func SetDefaultLevel(level slog.Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultLevel = level
+}
+
+// ParseLevels applies a comma separated list of levels such as
+// "info,wal=debug,http=warn". An entry without a category sets the default.
+func ParseLevels(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		category, levelText, hasCategory := strings.Cut(part, "=")
+		if !hasCategory {
+			levelText = category
+		}
+
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(levelText)); err != nil {
+			return fmt.Errorf("logging: parse level %q: %w", part, err)
+		}
+
+		if hasCategory {
+			SetLevel(category, level)
+		} else {
+			SetDefaultLevel(level)
+		}
+	}
+
+	return nil
+}
+
+// For returns a logger for the category. The logger resolves the handler and
+// level on every call, so it is safe to create loggers at package init before
+// SetHandler is called.
+func For(category string) *slog.Logger {
+	return slog.New(&categoryHandler{category: category})
+}
+
+// MaxRequestIDLength bounds the request IDs accepted from clients.
+const MaxRequestIDLength = 128
+
+// ValidRequestID reports whether a client-supplied request ID can be used:
+// printable ASCII without spaces, and not too long to log.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+type requestIDContextKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID. Records
+// logged with the returned context, or one derived from it, carry the ID
+// as the request_id attribute.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+func currentHandler() slog.Handler {
+	mu.RLock()
+	h := handler
+	mu.RUnlock()
+
+	if h == nil {
+		return slog.Default().Handler()
+	}
+	return h
+}
+
+func categoryLevel(category string) slog.Level {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if level, ok := levels[category]; ok {
+		return level
+	}
+	return defaultLevel
+}
+
+// categoryHandler filters by category level and forwards to the current
+// handler, replaying WithAttrs/WithGroup calls onto it.
+type categoryHandler struct {
+	category string
+	wrap     []func(slog.Handler) slog.Handler
+}
+
+func (h *categoryHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level < categoryLevel(h.category) {
+		return false
+	}
+	return currentHandler().Enabled(ctx, level)
+}
+
+func (h *categoryHandler) Handle(ctx context.Context, r slog.Record) error {
+	target := currentHandler().WithAttrs([]slog.Attr{slog.String(categoryKey, h.category)})
+	for _, wrap := range h.wrap {
+		target = wrap(target)
+	}
+	if id := RequestID(ctx); id != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String(requestIDKey, id))
+	}
+	return target.Handle(ctx, r)
+}
+
+func (h *categoryHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *categoryHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *categoryHandler) with(wrap func(slog.Handler) slog.Handler) slog.Handler {
+	next := make([]func(slog.Handler) slog.Handler, len(h.wrap), len(h.wrap)+1)
+	copy(next, h.wrap)
+	return &categoryHandler{category: h.category, wrap: append(next, wrap)}
+}
@@ -0,0 +1,121 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCategoryLevels(t *testing.T) {
+	var buf bytes.Buffer
+	SetHandler(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	t.Cleanup(func() {
+		SetHandler(nil)
+		SetDefaultLevel(slog.LevelInfo)
+		mu.Lock()
+		levels = map[string]slog.Level{}
+		mu.Unlock()
+	})
+
+	if err := ParseLevels("warn,wal=debug"); err != nil {
+		t.Fatalf("parse levels: %v", err)
+	}
+
+	For(CategoryWAL).Debug("wal debug")
+	For(CategoryHTTP).Info("http info")
+	For(CategoryHTTP).Warn("http warn")
+
+	out := buf.String()
+	if !strings.Contains(out, "wal debug") || !strings.Contains(out, "category=wal") {
+		t.Fatalf("expected wal debug record, got %q", out)
+	}
+	if strings.Contains(out, "http info") {
+		t.Fatalf("expected http info to be filtered, got %q", out)
+	}
+	if !strings.Contains(out, "http warn") {
+		t.Fatalf("expected http warn record, got %q", out)
+	}
+
+	if err := ParseLevels("wal=loud"); err == nil {
+		t.Fatalf("expected error for unknown level")
+	}
+}
+
+func TestNewHandlerPassesCategoryLevels(t *testing.T) {
+	var buf bytes.Buffer
+	SetHandler(NewSampler(NewHandler(&buf), time.Minute, 10, 0))
+	t.Cleanup(func() {
+		SetHandler(nil)
+		SetDefaultLevel(slog.LevelInfo)
+		mu.Lock()
+		levels = map[string]slog.Level{}
+		mu.Unlock()
+	})
+
+	// Only the levels are configured, as the server does from -log-level.
+	For(CategoryWAL).Debug("wal debug before")
+	if err := ParseLevels("info,wal=debug"); err != nil {
+		t.Fatalf("parse levels: %v", err)
+	}
+	For(CategoryWAL).Debug("wal debug")
+	For(CategoryHTTP).Debug("http debug")
+	For(CategoryHTTP).Info("http info")
+
+	out := buf.String()
+	if strings.Contains(out, "wal debug before") {
+		t.Fatalf("expected wal debug to be filtered at the default level, got %q", out)
+	}
+	if !strings.Contains(out, "msg=\"wal debug\"") || !strings.Contains(out, "category=wal") {
+		t.Fatalf("expected the wal debug record, got %q", out)
+	}
+	if strings.Contains(out, "http debug") {
+		t.Fatalf("expected http debug to be filtered, got %q", out)
+	}
+	if !strings.Contains(out, "http info") {
+		t.Fatalf("expected the http info record, got %q", out)
+	}
+}
+
+func TestRequestIDAttribute(t *testing.T) {
+	var buf bytes.Buffer
+	SetHandler(slog.NewTextHandler(&buf, nil))
+	t.Cleanup(func() { SetHandler(nil) })
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	if got := RequestID(ctx); got != "req-1" {
+		t.Fatalf("expected req-1, got %q", got)
+	}
+	For(CategoryStore).With("key", "a").InfoContext(ctx, "with id")
+	For(CategoryStore).Info("without id")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], "key=a request_id=req-1") {
+		t.Fatalf("expected the request id on the record, got %q", lines[0])
+	}
+	if strings.Contains(lines[1], "request_id") {
+		t.Fatalf("expected no request id without one in the context, got %q", lines[1])
+	}
+}
+
+func TestSamplerDropsRepeatedMessages(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewSampler(slog.NewTextHandler(&buf, nil), time.Hour, 2, 5))
+
+	for i := 0; i < 12; i++ {
+		logger.Info("flush")
+	}
+	logger.Error("flush failed")
+
+	if got := strings.Count(buf.String(), "msg=flush\n"); got != 4 {
+		t.Fatalf("expected 4 sampled records (first 2, then 7th and 12th), got %d", got)
+	}
+	if !strings.Contains(buf.String(), "flush failed") {
+		t.Fatalf("errors must never be sampled out")
+	}
+}
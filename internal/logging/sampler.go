@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Sampler is a slog.Handler that rate limits repeated messages. Within each
+// tick it forwards the first records of a given message and then only every
+// Thereafter-th one. Records at slog.LevelError and above are never dropped.
+type Sampler struct {
+	next       slog.Handler
+	first      int
+	thereafter int
+	tick       time.Duration
+	state      *samplerState
+}
+
+type samplerState struct {
+	mu     sync.Mutex
+	counts map[string]*sampleCount
+}
+
+type sampleCount struct {
+	windowStart time.Time
+	n           int
+}
+
+// NewSampler wraps next, forwarding the first `first` records per message and
+// tick and then every `thereafter`-th record (0 drops the rest).
+func NewSampler(next slog.Handler, tick time.Duration, first, thereafter int) *Sampler {
+	return &Sampler{
+		next:       next,
+		first:      first,
+		thereafter: thereafter,
+		tick:       tick,
+		state:      &samplerState{counts: make(map[string]*sampleCount)},
+	}
+}
+
+func (s *Sampler) Enabled(ctx context.Context, level slog.Level) bool {
+	return s.next.Enabled(ctx, level)
+}
+
+func (s *Sampler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelError && !s.allow(r.Message, r.Time) {
+		return nil
+	}
+	return s.next.Handle(ctx, r)
+}
+
+func (s *Sampler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *s
+	clone.next = s.next.WithAttrs(attrs)
+	return &clone
+}
+
+func (s *Sampler) WithGroup(name string) slog.Handler {
+	clone := *s
+	clone.next = s.next.WithGroup(name)
+	return &clone
+}
+
+func (s *Sampler) allow(message string, now time.Time) bool {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	count, ok := s.state.counts[message]
+	if !ok || now.Sub(count.windowStart) >= s.tick {
+		count = &sampleCount{windowStart: now}
+		s.state.counts[message] = count
+	}
+
+	count.n++
+	if count.n <= s.first {
+		return true
+	}
+	if s.thereafter <= 0 {
+		return false
+	}
+	return (count.n-s.first)%s.thereafter == 0
+}
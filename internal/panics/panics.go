@@ -0,0 +1,177 @@
+// Package panics captures panics from request handlers and background
+// goroutines, reports them with stack traces and applies a process-wide
+// policy: crash, restart the subsystem, or shut down gracefully.
+package panics
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+	"universe/internal/logging"
+)
+
+// Policy decides what happens after a panic has been reported.
+type Policy int
+
+const (
+	// PolicyRestart recovers the panic; background subsystems are restarted
+	// and failed requests answered with 500.
+	PolicyRestart Policy = iota
+	// PolicyShutdown recovers the panic and triggers a graceful shutdown.
+	PolicyShutdown
+	// PolicyCrash terminates the process immediately.
+	PolicyCrash
+)
+
+// ParsePolicy parses "restart", "shutdown" or "crash".
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "restart":
+		return PolicyRestart, nil
+	case "shutdown":
+		return PolicyShutdown, nil
+	case "crash":
+		return PolicyCrash, nil
+	default:
+		return 0, fmt.Errorf("panics: unknown policy %q", s)
+	}
+}
+
+func (p Policy) String() string {
+	switch p {
+	case PolicyRestart:
+		return "restart"
+	case PolicyShutdown:
+		return "shutdown"
+	case PolicyCrash:
+		return "crash"
+	default:
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+}
+
+// Report describes a captured panic.
+type Report struct {
+	Subsystem string    `json:"subsystem"`
+	Value     string    `json:"value"`
+	Stack     string    `json:"stack"`
+	Time      time.Time `json:"time"`
+	Policy    string    `json:"policy"`
+}
+
+// restartDelay is how long a panicked background subsystem waits before it
+// is started again, so a persistent panic does not spin.
+const restartDelay = time.Second
+
+var logger = logging.For(logging.CategoryServer)
+
+var (
+	mu        sync.RWMutex
+	policy    = PolicyRestart
+	reporters []func(Report)
+	shutdown  func()
+
+	count        atomic.Int64
+	shutdownOnce sync.Once
+)
+
+// SetPolicy sets the process-wide panic policy.
+func SetPolicy(p Policy) {
+	mu.Lock()
+	defer mu.Unlock()
+	policy = p
+}
+
+// OnReport registers fn to be called synchronously for every captured panic.
+func OnReport(fn func(Report)) {
+	mu.Lock()
+	defer mu.Unlock()
+	reporters = append(reporters, fn)
+}
+
+// SetShutdown registers the function invoked under PolicyShutdown. It runs
+// on its own goroutine at most once.
+func SetShutdown(fn func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	shutdown = fn
+}
+
+// Count returns the number of panics captured since start.
+func Count() int64 {
+	return count.Load()
+}
+
+// Go runs fn on a new goroutine tracked by wg (which may be nil). If fn
+// panics the panic is handled by the current policy; under PolicyRestart fn
+// is started again after a short delay.
+func Go(subsystem string, wg *sync.WaitGroup, fn func()) {
+	if wg != nil {
+		wg.Add(1)
+	}
+
+	go func() {
+		if wg != nil {
+			defer wg.Done()
+		}
+
+		for {
+			if !runProtected(subsystem, fn) {
+				return
+			}
+			time.Sleep(restartDelay)
+			logger.Warn("restarting subsystem after panic", "subsystem", subsystem)
+		}
+	}()
+}
+
+// runProtected runs fn and reports whether it panicked and should be restarted.
+func runProtected(subsystem string, fn func()) (restart bool) {
+	defer func() {
+		if value := recover(); value != nil {
+			restart = Handle(subsystem, value) == PolicyRestart
+		}
+	}()
+
+	fn()
+	return false
+}
+
+// Handle reports a recovered panic value and applies the policy. It returns
+// the policy that was applied; under PolicyCrash it does not return.
+func Handle(subsystem string, value any) Policy {
+	mu.RLock()
+	p := policy
+	fns := append([]func(Report){}, reporters...)
+	stop := shutdown
+	mu.RUnlock()
+
+	count.Add(1)
+	report := Report{
+		Subsystem: subsystem,
+		Value:     fmt.Sprint(value),
+		Stack:     string(debug.Stack()),
+		Time:      time.Now(),
+		Policy:    p.String(),
+	}
+
+	logger.Error("panic recovered", "subsystem", subsystem, "value", report.Value, "policy", report.Policy, "stack", report.Stack)
+	for _, fn := range fns {
+		fn(report)
+	}
+
+	switch p {
+	case PolicyCrash:
+		fmt.Fprintf(os.Stderr, "panic in %s: %s\n\n%s", subsystem, report.Value, report.Stack)
+		os.Exit(2)
+	case PolicyShutdown:
+		if stop != nil {
+			shutdownOnce.Do(func() { go stop() })
+		}
+	}
+
+	return p
+}
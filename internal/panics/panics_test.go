@@ -0,0 +1,62 @@
+package panics
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestGoRestartsPanickingSubsystem(t *testing.T) {
+	var reports []Report
+	var mu sync.Mutex
+	OnReport(func(r Report) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, r)
+	})
+	t.Cleanup(func() {
+		reporters = nil
+	})
+
+	runs := 0
+	var wg sync.WaitGroup
+	Go("test", &wg, func() {
+		runs++
+		if runs == 1 {
+			panic("boom")
+		}
+	})
+	wg.Wait()
+
+	if runs != 2 {
+		t.Fatalf("expected subsystem to be restarted once, ran %d times", runs)
+	}
+	if len(reports) != 1 || reports[0].Subsystem != "test" || reports[0].Value != "boom" {
+		t.Fatalf("unexpected reports: %+v", reports)
+	}
+	if reports[0].Stack == "" {
+		t.Fatalf("expected stack trace in report")
+	}
+}
+
+func TestShutdownPolicyInvokesHookOnce(t *testing.T) {
+	SetPolicy(PolicyShutdown)
+	t.Cleanup(func() {
+		SetPolicy(PolicyRestart)
+		SetShutdown(nil)
+	})
+
+	called := make(chan struct{}, 2)
+	SetShutdown(func() { called <- struct{}{} })
+
+	if p := Handle("test", "first"); p != PolicyShutdown {
+		t.Fatalf("expected shutdown policy, got %v", p)
+	}
+	Handle("test", "second")
+
+	<-called
+	select {
+	case <-called:
+		t.Fatalf("expected shutdown hook to run once")
+	default:
+	}
+}
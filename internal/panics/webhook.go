@@ -0,0 +1,35 @@
+package panics
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const webhookTimeout = 5 * time.Second
+
+// WebhookReporter returns a reporter that POSTs every Report as JSON to url.
+// Delivery failures are logged and otherwise ignored.
+func WebhookReporter(url string) func(Report) {
+	client := &http.Client{Timeout: webhookTimeout}
+
+	return func(report Report) {
+		body, err := json.Marshal(report)
+		if err != nil {
+			logger.Error("encode panic report", "error", err)
+			return
+		}
+
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			logger.Error("deliver panic report", "url", url, "error", err)
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			logger.Error("deliver panic report", "url", url, "status", resp.StatusCode)
+		}
+	}
+}
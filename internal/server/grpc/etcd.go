@@ -0,0 +1,425 @@
+package grpc
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"universe/internal/store"
+	"universe/pkg/proto/etcdpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// etcdKV serves the etcd v3 KV service of pkg/proto/etcd.proto, so that
+// tools written for etcd can use the store for simple cases.
+//
+// The store keeps no create revision or per-key version, so KeyValues
+// report the mod revision as the create revision and a version of 1. Past
+// revisions, leases, transactions and compaction are not provided.
+type etcdKV struct {
+	etcdpb.UnimplementedKVServer
+
+	store *store.Store
+}
+
+// etcdWatch serves the etcd v3 Watch service.
+type etcdWatch struct {
+	etcdpb.UnimplementedWatchServer
+
+	server *grpcServer
+}
+
+// keyRange is an etcd key range: the single key start, or the keys from
+// start up to but not including end, or all keys from start on when end
+// is empty.
+type keyRange struct {
+	start, end string
+	single     bool
+}
+
+// newKeyRange converts an etcd key and range_end.
+func newKeyRange(key, rangeEnd []byte) keyRange {
+	switch {
+	case len(rangeEnd) == 0:
+		return keyRange{start: string(key), single: true}
+	case bytes.Equal(rangeEnd, []byte{0}):
+		return keyRange{start: string(key)}
+	default:
+		return keyRange{start: string(key), end: string(rangeEnd)}
+	}
+}
+
+func (r keyRange) includes(key string) bool {
+	if r.single {
+		return key == r.start
+	}
+	return key >= r.start && (r.end == "" || key < r.end)
+}
+
+// prefix returns the longest prefix shared by every key in the range, for
+// watching it.
+func (r keyRange) prefix() string {
+	if r.single {
+		return r.start
+	}
+	if r.end == "" {
+		return ""
+	}
+	n := 0
+	for n < len(r.start) && n < len(r.end) && r.start[n] == r.end[n] {
+		n++
+	}
+	return r.start[:n]
+}
+
+func (r keyRange) iteratorOptions(keysOnly bool) store.IteratorOptions {
+	opts := store.IteratorOptions{Start: r.start, End: r.end, KeysOnly: keysOnly}
+	if r.single {
+		opts.End = r.start + "\x00"
+	}
+	return opts
+}
+
+func etcdHeader(revision uint64) *etcdpb.ResponseHeader {
+	return &etcdpb.ResponseHeader{Revision: int64(revision)}
+}
+
+func etcdKeyValue(key string, value []byte, revision uint64) *etcdpb.KeyValue {
+	return &etcdpb.KeyValue{
+		Key:            []byte(key),
+		Value:          value,
+		ModRevision:    int64(revision),
+		CreateRevision: int64(revision),
+		Version:        1,
+	}
+}
+
+func (s *etcdKV) Range(ctx context.Context, req *etcdpb.RangeRequest) (*etcdpb.RangeResponse, error) {
+	if current := s.store.Revision(); req.Revision > 0 && uint64(req.Revision) != current {
+		if uint64(req.Revision) > current {
+			return nil, status.Errorf(codes.OutOfRange, "revision %d is not yet written", req.Revision)
+		}
+		return nil, status.Error(codes.Unimplemented, "reading at a past revision is not supported")
+	}
+	if req.MinCreateRevision != 0 || req.MaxCreateRevision != 0 {
+		return nil, status.Error(codes.Unimplemented, "create revisions are not tracked")
+	}
+	var less func(a, b *etcdpb.KeyValue) int
+	switch req.SortTarget {
+	case etcdpb.RangeRequest_KEY:
+	case etcdpb.RangeRequest_MOD:
+		less = func(a, b *etcdpb.KeyValue) int { return cmp.Compare(a.ModRevision, b.ModRevision) }
+	case etcdpb.RangeRequest_VALUE:
+		less = func(a, b *etcdpb.KeyValue) int { return bytes.Compare(a.Value, b.Value) }
+	default:
+		return nil, status.Errorf(codes.Unimplemented, "sorting by %v is not supported", req.SortTarget)
+	}
+
+	r := newKeyRange(req.Key, req.RangeEnd)
+	it := s.store.NewIterator(r.iteratorOptions(req.KeysOnly && less == nil))
+	defer it.Close()
+
+	resp := &etcdpb.RangeResponse{Header: etcdHeader(it.Revision())}
+	for it.Next() {
+		mod := int64(it.ModRevision())
+		if (req.MinModRevision != 0 && mod < req.MinModRevision) || (req.MaxModRevision != 0 && mod > req.MaxModRevision) {
+			continue
+		}
+		resp.Count++
+		if !req.CountOnly {
+			resp.Kvs = append(resp.Kvs, etcdKeyValue(it.Key(), it.Value(), it.ModRevision()))
+		}
+	}
+
+	if less != nil {
+		slices.SortStableFunc(resp.Kvs, less)
+	}
+	if req.SortOrder == etcdpb.RangeRequest_DESCEND {
+		slices.Reverse(resp.Kvs)
+	}
+	if req.Limit > 0 && int64(len(resp.Kvs)) > req.Limit {
+		resp.Kvs = resp.Kvs[:req.Limit]
+		resp.More = true
+	}
+	if req.KeysOnly {
+		for _, kv := range resp.Kvs {
+			kv.Value = nil
+		}
+	}
+	return resp, nil
+}
+
+func (s *etcdKV) Put(ctx context.Context, req *etcdpb.PutRequest) (*etcdpb.PutResponse, error) {
+	if req.Lease != 0 || req.IgnoreLease {
+		return nil, status.Error(codes.Unimplemented, "leases are not supported")
+	}
+	if req.IgnoreValue {
+		return nil, status.Error(codes.Unimplemented, "ignore_value is not supported")
+	}
+	key := string(req.Key)
+	var batch store.WriteBatch
+	batch.Set(key, req.Value)
+
+	if !req.PrevKv {
+		revision, err := s.store.CheckAndWrite(nil, &batch)
+		if err != nil {
+			return nil, storeError(ctx, err)
+		}
+		return &etcdpb.PutResponse{Header: etcdHeader(revision)}, nil
+	}
+
+	// The previous value is read and then overwritten only if it is
+	// still current, retrying when another write got in between.
+	for {
+		value, mod, ok := s.store.GetVersion(key)
+		condition := store.IfMissing(key)
+		if ok {
+			condition = store.IfRevision(key, mod)
+		}
+		revision, err := s.store.CheckAndWrite([]store.Condition{condition}, &batch)
+		if errors.Is(err, store.ErrConditionFailed) && ctx.Err() == nil {
+			continue
+		}
+		if err != nil {
+			return nil, storeError(ctx, err)
+		}
+		resp := &etcdpb.PutResponse{Header: etcdHeader(revision)}
+		if ok {
+			resp.PrevKv = etcdKeyValue(key, value, mod)
+		}
+		return resp, nil
+	}
+}
+
+func (s *etcdKV) DeleteRange(ctx context.Context, req *etcdpb.DeleteRangeRequest) (*etcdpb.DeleteRangeResponse, error) {
+	r := newKeyRange(req.Key, req.RangeEnd)
+
+	// The keys are listed and then deleted only if none has changed
+	// meanwhile, retrying otherwise, so the deletion is one revision.
+	for {
+		it := s.store.NewIterator(r.iteratorOptions(!req.PrevKv))
+		revision := it.Revision()
+		var batch store.WriteBatch
+		var conditions []store.Condition
+		var prev []*etcdpb.KeyValue
+		for it.Next() {
+			batch.Delete(it.Key())
+			conditions = append(conditions, store.IfRevision(it.Key(), it.ModRevision()))
+			if req.PrevKv {
+				prev = append(prev, etcdKeyValue(it.Key(), it.Value(), it.ModRevision()))
+			}
+		}
+		it.Close()
+		if len(conditions) == 0 {
+			return &etcdpb.DeleteRangeResponse{Header: etcdHeader(revision)}, nil
+		}
+
+		written, err := s.store.CheckAndWrite(conditions, &batch)
+		if errors.Is(err, store.ErrConditionFailed) && ctx.Err() == nil {
+			continue
+		}
+		if err != nil {
+			return nil, storeError(ctx, err)
+		}
+		return &etcdpb.DeleteRangeResponse{
+			Header:  etcdHeader(written),
+			Deleted: int64(len(conditions)),
+			PrevKvs: prev,
+		}, nil
+	}
+}
+
+// Watch serves the watches a client creates on one stream until it closes
+// the stream or the server shuts down.
+func (s *etcdWatch) Watch(stream grpc.BidiStreamingServer[etcdpb.WatchRequest, etcdpb.WatchResponse]) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	w := &etcdWatchStream{
+		store:   s.server.store,
+		stream:  stream,
+		ctx:     ctx,
+		watches: make(map[int64]context.CancelFunc),
+	}
+
+	received := make(chan error, 1)
+	go func() { received <- w.receive() }()
+	select {
+	case err := <-received:
+		return err
+	case <-s.server.streams.Done():
+		return status.Error(codes.Unavailable, "server shutting down")
+	}
+}
+
+// etcdWatchStream is one Watch stream and the watches created on it.
+type etcdWatchStream struct {
+	store  *store.Store
+	stream grpc.BidiStreamingServer[etcdpb.WatchRequest, etcdpb.WatchResponse]
+	ctx    context.Context
+
+	// sendMu serializes the responses of the watches.
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	watches map[int64]context.CancelFunc
+	nextID  int64
+}
+
+func (w *etcdWatchStream) send(resp *etcdpb.WatchResponse) error {
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+	return w.stream.Send(resp)
+}
+
+// receive handles the client's requests until the stream ends.
+func (w *etcdWatchStream) receive() error {
+	for {
+		req, err := w.stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch r := req.RequestUnion.(type) {
+		case *etcdpb.WatchRequest_CreateRequest:
+			err = w.create(r.CreateRequest)
+		case *etcdpb.WatchRequest_CancelRequest:
+			w.mu.Lock()
+			stop, ok := w.watches[r.CancelRequest.WatchId]
+			delete(w.watches, r.CancelRequest.WatchId)
+			w.mu.Unlock()
+			if ok {
+				stop()
+				err = w.send(&etcdpb.WatchResponse{
+					Header:       etcdHeader(w.store.Revision()),
+					WatchId:      r.CancelRequest.WatchId,
+					Canceled:     true,
+					CancelReason: "watch canceled by the client",
+				})
+			}
+		case *etcdpb.WatchRequest_ProgressRequest:
+			// A watch ID of -1 marks a progress notification for the
+			// whole stream.
+			err = w.send(&etcdpb.WatchResponse{Header: etcdHeader(w.store.Revision()), WatchId: -1})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// create starts a watch and confirms it, or refuses it with a response
+// that is both created and canceled, as etcd does.
+func (w *etcdWatchStream) create(req *etcdpb.WatchCreateRequest) error {
+	w.mu.Lock()
+	id := req.WatchId
+	if id == 0 {
+		for _, taken := w.watches[w.nextID]; taken; _, taken = w.watches[w.nextID] {
+			w.nextID++
+		}
+		id = w.nextID
+		w.nextID++
+	}
+	_, inUse := w.watches[id]
+	ctx, stop := context.WithCancel(w.ctx)
+	if !inUse {
+		w.watches[id] = stop
+	}
+	w.mu.Unlock()
+
+	refuse := func(reason string, compacted uint64) error {
+		stop()
+		if !inUse {
+			w.mu.Lock()
+			delete(w.watches, id)
+			w.mu.Unlock()
+		}
+		return w.send(&etcdpb.WatchResponse{
+			Header:          etcdHeader(w.store.Revision()),
+			WatchId:         id,
+			Created:         true,
+			Canceled:        true,
+			CancelReason:    reason,
+			CompactRevision: int64(compacted),
+		})
+	}
+	if inUse {
+		return refuse("watch id is already in use", 0)
+	}
+	if req.PrevKv {
+		return refuse("prev_kv is not supported", 0)
+	}
+
+	r := newKeyRange(req.Key, req.RangeEnd)
+	var events <-chan store.Event
+	var unwatch func()
+	start := uint64(max(req.StartRevision, 0))
+	if start > 0 && start-1 <= w.store.Revision() {
+		var err error
+		events, unwatch, err = w.store.WatchFrom(r.prefix(), start-1)
+		var lost *store.HistoryLostError
+		if errors.As(err, &lost) {
+			return refuse("required revision has been compacted", lost.Compacted)
+		}
+		if err != nil {
+			return refuse(err.Error(), 0)
+		}
+	} else {
+		events, unwatch = w.store.Watch(r.prefix())
+	}
+
+	if err := w.send(&etcdpb.WatchResponse{Header: etcdHeader(w.store.Revision()), WatchId: id, Created: true}); err != nil {
+		unwatch()
+		return err
+	}
+	go w.run(ctx, id, r, start, req.Filters, events, unwatch)
+	return nil
+}
+
+// run sends the events of one watch until it is canceled or the store
+// ends it.
+func (w *etcdWatchStream) run(ctx context.Context, id int64, r keyRange, start uint64, filters []etcdpb.WatchCreateRequest_FilterType, events <-chan store.Event, unwatch func()) {
+	defer unwatch()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				w.mu.Lock()
+				delete(w.watches, id)
+				w.mu.Unlock()
+				_ = w.send(&etcdpb.WatchResponse{
+					Header:       etcdHeader(w.store.Revision()),
+					WatchId:      id,
+					Canceled:     true,
+					CancelReason: "watch fell behind; create it again from the last revision received",
+				})
+				return
+			}
+			if !r.includes(event.Key) || event.Revision < start {
+				continue
+			}
+			e := &etcdpb.Event{Kv: etcdKeyValue(event.Key, event.Value, event.Revision)}
+			filter := etcdpb.WatchCreateRequest_NOPUT
+			if event.Type == store.OperationDelete {
+				e.Type = etcdpb.Event_DELETE
+				e.Kv = &etcdpb.KeyValue{Key: []byte(event.Key), ModRevision: int64(event.Revision)}
+				filter = etcdpb.WatchCreateRequest_NODELETE
+			}
+			if slices.Contains(filters, filter) {
+				continue
+			}
+			if err := w.send(&etcdpb.WatchResponse{Header: etcdHeader(event.Revision), WatchId: id, Events: []*etcdpb.Event{e}}); err != nil {
+				return
+			}
+		}
+	}
+}
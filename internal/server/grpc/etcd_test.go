@@ -0,0 +1,111 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+	"universe/pkg/proto/etcdpb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEtcdKV(t *testing.T) {
+	conn, _ := newTestConn(t, WithEtcd())
+	client := etcdpb.NewKVClient(conn)
+	ctx := context.Background()
+
+	for _, key := range []string{"app/a", "app/b", "app/c", "other"} {
+		if _, err := client.Put(ctx, &etcdpb.PutRequest{Key: []byte(key), Value: []byte("v-" + key)}); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+	put, err := client.Put(ctx, &etcdpb.PutRequest{Key: []byte("app/a"), Value: []byte("new"), PrevKv: true})
+	if err != nil || string(put.PrevKv.GetValue()) != "v-app/a" || put.Header.Revision != 5 {
+		t.Fatalf("unexpected put with prev_kv: %v %v", put, err)
+	}
+
+	got, err := client.Range(ctx, &etcdpb.RangeRequest{Key: []byte("app/a")})
+	if err != nil || len(got.Kvs) != 1 || string(got.Kvs[0].Value) != "new" || got.Kvs[0].ModRevision != 5 {
+		t.Fatalf("unexpected single key range: %v %v", got, err)
+	}
+	// A prefix is asked for as [prefix, prefix with its last byte + 1).
+	got, err = client.Range(ctx, &etcdpb.RangeRequest{Key: []byte("app/"), RangeEnd: []byte("app0"), Limit: 2})
+	if err != nil || len(got.Kvs) != 2 || string(got.Kvs[1].Key) != "app/b" || !got.More || got.Count != 3 {
+		t.Fatalf("unexpected prefix range: %v %v", got, err)
+	}
+	got, err = client.Range(ctx, &etcdpb.RangeRequest{Key: []byte{0}, RangeEnd: []byte{0}, CountOnly: true})
+	if err != nil || got.Count != 4 || len(got.Kvs) != 0 {
+		t.Fatalf("unexpected count of all keys: %v %v", got, err)
+	}
+	got, err = client.Range(ctx, &etcdpb.RangeRequest{Key: []byte("app/"), RangeEnd: []byte("app0"), SortTarget: etcdpb.RangeRequest_MOD, SortOrder: etcdpb.RangeRequest_DESCEND, KeysOnly: true})
+	if err != nil || string(got.Kvs[0].Key) != "app/a" || got.Kvs[0].Value != nil {
+		t.Fatalf("unexpected sorted range: %v %v", got, err)
+	}
+	if _, err := client.Range(ctx, &etcdpb.RangeRequest{Key: []byte("app/a"), Revision: 1}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("expected Unimplemented for a past revision, got %v", err)
+	}
+	if _, err := client.Put(ctx, &etcdpb.PutRequest{Key: []byte("x"), Lease: 7}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("expected Unimplemented for a lease, got %v", err)
+	}
+
+	deleted, err := client.DeleteRange(ctx, &etcdpb.DeleteRangeRequest{Key: []byte("app/"), RangeEnd: []byte("app0"), PrevKv: true})
+	if err != nil || deleted.Deleted != 3 || len(deleted.PrevKvs) != 3 || deleted.Header.Revision != 6 {
+		t.Fatalf("unexpected delete range: %v %v", deleted, err)
+	}
+	got, err = client.Range(ctx, &etcdpb.RangeRequest{Key: []byte{0}, RangeEnd: []byte{0}})
+	if err != nil || len(got.Kvs) != 1 || string(got.Kvs[0].Key) != "other" {
+		t.Fatalf("expected only other to remain: %v %v", got, err)
+	}
+}
+
+func TestEtcdWatch(t *testing.T) {
+	conn, kv := newTestConn(t, WithEtcd())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := kv.Set("w/a", []byte("1")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	stream, err := etcdpb.NewWatchClient(conn).Watch(ctx)
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	create := func(req *etcdpb.WatchCreateRequest) *etcdpb.WatchResponse {
+		t.Helper()
+		if err := stream.Send(&etcdpb.WatchRequest{RequestUnion: &etcdpb.WatchRequest_CreateRequest{CreateRequest: req}}); err != nil {
+			t.Fatalf("create watch: %v", err)
+		}
+		resp, err := stream.Recv()
+		if err != nil || !resp.Created {
+			t.Fatalf("expected the watch to be created: %v %v", resp, err)
+		}
+		return resp
+	}
+
+	// The replay from start_revision comes first.
+	created := create(&etcdpb.WatchCreateRequest{Key: []byte("w/"), RangeEnd: []byte("w0"), StartRevision: 1})
+	resp, err := stream.Recv()
+	if err != nil || len(resp.Events) != 1 || string(resp.Events[0].Kv.Key) != "w/a" || resp.WatchId != created.WatchId {
+		t.Fatalf("unexpected replayed event: %v %v", resp, err)
+	}
+
+	if err := kv.Set("wx", []byte("outside")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if _, err := kv.Delete("w/a"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	resp, err = stream.Recv()
+	if err != nil || len(resp.Events) != 1 || resp.Events[0].Type != etcdpb.Event_DELETE || string(resp.Events[0].Kv.Key) != "w/a" {
+		t.Fatalf("unexpected delete event: %v %v", resp, err)
+	}
+
+	if err := stream.Send(&etcdpb.WatchRequest{RequestUnion: &etcdpb.WatchRequest_CancelRequest{CancelRequest: &etcdpb.WatchCancelRequest{WatchId: created.WatchId}}}); err != nil {
+		t.Fatalf("cancel watch: %v", err)
+	}
+	resp, err = stream.Recv()
+	if err != nil || !resp.Canceled || resp.WatchId != created.WatchId {
+		t.Fatalf("expected the watch to be canceled: %v %v", resp, err)
+	}
+}
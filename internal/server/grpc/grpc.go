@@ -0,0 +1,193 @@
+// Package grpc serves the UniverseKV gRPC service defined in
+// pkg/proto/kv.proto from the same store as the HTTP API and, optionally,
+// the subset of the etcd v3 API in pkg/proto/etcd.proto.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"universe/internal/logging"
+	"universe/internal/store"
+	"universe/pkg/proto/etcdpb"
+	"universe/pkg/proto/kvpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var logger = logging.For(logging.CategoryGRPC)
+
+// DefaultPort is the port the gRPC API listens on when none is set.
+const DefaultPort = 9090
+
+// maxBatchOps caps the operations of one batch, as on the HTTP API.
+const maxBatchOps = 1000
+
+type GrpcServer interface {
+	kvpb.UniverseKVServer
+
+	Start() error
+	Listen() error
+	Stop(ctx context.Context) error
+}
+
+type grpcServer struct {
+	kvpb.UnimplementedUniverseKVServer
+
+	store  *store.Store
+	server *grpc.Server
+	addr   string
+	// bound is the listener bound by Listen for Start to serve.
+	bound net.Listener
+
+	// streams is cancelled when the server shuts down, ending watches
+	// that would otherwise hold up the drain.
+	streams    context.Context
+	endStreams context.CancelFunc
+}
+
+// Config sets where the gRPC API listens.
+type Config struct {
+	// Address is the host or IP to listen on; empty means all interfaces.
+	Address string
+	// Port is the port to listen on; zero means DefaultPort.
+	Port int
+}
+
+// Options configures a GrpcServer.
+type Options struct {
+	Config Config
+	// Etcd also serves the etcd v3 KV and Watch services of
+	// pkg/proto/etcd.proto, so tools written for etcd can use the store.
+	Etcd bool
+}
+
+// Option changes one setting of the Options NewServer starts from.
+type Option func(*Options)
+
+// WithConfig sets where the server listens.
+func WithConfig(config Config) Option {
+	return func(o *Options) { o.Config = config }
+}
+
+// WithEtcd also serves the etcd v3 compatibility services.
+func WithEtcd() Option {
+	return func(o *Options) { o.Etcd = true }
+}
+
+// NewServer returns a server for store configured by opts, applied in
+// order.
+func NewServer(store *store.Store, opts ...Option) GrpcServer {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return NewServerWithOptions(store, o)
+}
+
+// NewServerWithOptions is NewServer with explicit options.
+func NewServerWithOptions(store *store.Store, opts Options) GrpcServer {
+	port := opts.Config.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	s := &grpcServer{
+		store: store,
+		addr:  net.JoinHostPort(opts.Config.Address, strconv.Itoa(port)),
+	}
+	s.streams, s.endStreams = context.WithCancel(context.Background())
+	s.server = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(requestIDUnary, recoverUnary, s.recoveringUnary, s.sessionUnary),
+		grpc.ChainStreamInterceptor(requestIDStream, recoverStream, s.recoveringStream, s.sessionStream),
+	)
+	kvpb.RegisterUniverseKVServer(s.server, s)
+	if opts.Etcd {
+		etcdpb.RegisterKVServer(s.server, &etcdKV{store: store})
+		etcdpb.RegisterWatchServer(s.server, &etcdWatch{server: s})
+	}
+	return s
+}
+
+// Start serves until Stop is called, when it returns nil, or until the
+// listener fails.
+func (s *grpcServer) Start() error {
+	if s.bound == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+	return s.serve(s.bound)
+}
+
+// Listen binds the server's address ahead of Start, which then serves the
+// connections queued meanwhile. Start binds it itself if Listen was not
+// called.
+func (s *grpcServer) Listen() error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("grpc: listen: %w", err)
+	}
+	s.bound = ln
+	return nil
+}
+
+func (s *grpcServer) serve(ln net.Listener) error {
+	logger.Info("gRPC server starting", "addr", ln.Addr().String())
+	if err := s.server.Serve(ln); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return fmt.Errorf("grpc: serve: %w", err)
+	}
+	return nil
+}
+
+// Stop stops accepting connections, ends watch streams and waits for the
+// in-flight calls to finish. If ctx is done first the remaining connections
+// are closed and ctx's error returned. The store is left open for the
+// caller to close.
+func (s *grpcServer) Stop(ctx context.Context) error {
+	logger.Info("gRPC server stopping", "addr", s.addr)
+	s.endStreams()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		logger.Info("gRPC server stopped")
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return fmt.Errorf("grpc: drain calls: %w", ctx.Err())
+	}
+}
+
+// storeError converts a store error to a status: failed persistence is
+// unavailable, oversized values exhaust a resource, compacted revisions are
+// out of range, reserved keys are denied and keys outside a partial recovery
+// fail a precondition. Anything else is an invalid argument.
+func storeError(ctx context.Context, err error) error {
+	code := codes.InvalidArgument
+	switch {
+	case errors.Is(err, store.ErrWriteFailed), errors.Is(err, store.ErrClosed):
+		logger.ErrorContext(ctx, "store write failed", "error", err)
+		code = codes.Unavailable
+	case errors.Is(err, store.ErrValueTooLarge):
+		code = codes.ResourceExhausted
+	case errors.Is(err, store.ErrCompacted):
+		code = codes.OutOfRange
+	case errors.Is(err, store.ErrReservedKey):
+		code = codes.PermissionDenied
+	case errors.Is(err, store.ErrKeyNotServed):
+		code = codes.FailedPrecondition
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, err.Error())
+}
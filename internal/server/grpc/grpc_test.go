@@ -0,0 +1,238 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+	"universe/internal/store"
+	"universe/pkg/proto/kvpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func newTestClient(t *testing.T) (kvpb.UniverseKVClient, *store.Store) {
+	t.Helper()
+	conn, kv := newTestConn(t)
+	return kvpb.NewUniverseKVClient(conn), kv
+}
+
+// newTestConn serves a new store with opts over an in-memory listener and
+// returns a connection to it.
+func newTestConn(t *testing.T, opts ...Option) (*grpc.ClientConn, *store.Store) {
+	t.Helper()
+
+	kv, err := store.New(filepath.Join(t.TempDir(), "grpc.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+
+	s := NewServer(kv, opts...).(*grpcServer)
+	ln := bufconn.Listen(1 << 20)
+	go func() { _ = s.serve(ln) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.Stop(ctx)
+	})
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn, kv
+}
+
+func TestKV(t *testing.T) {
+	client, kv := newTestClient(t)
+	ctx := context.Background()
+
+	if _, err := client.Set(ctx, &kvpb.SetRequest{Key: "a", Value: []byte("1")}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	got, err := client.Get(ctx, &kvpb.GetRequest{Key: "a"})
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !got.Found || string(got.Value) != "1" || got.Revision == 0 {
+		t.Fatalf("get a = %v", got)
+	}
+	first := got.Revision
+
+	if _, err := client.Set(ctx, &kvpb.SetRequest{Key: "a", Value: []byte("2")}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	old, err := client.Get(ctx, &kvpb.GetRequest{Key: "a", Revision: &first})
+	if err != nil {
+		t.Fatalf("get at revision: %v", err)
+	}
+	if !old.Found || string(old.Value) != "1" {
+		t.Fatalf("get a at %d = %v, want 1", first, old)
+	}
+
+	if _, err := client.Set(ctx, &kvpb.SetRequest{Key: "t", Value: []byte("x"), Ttl: durationpb.New(time.Hour)}); err != nil {
+		t.Fatalf("set with ttl: %v", err)
+	}
+	if n := kv.ExpirationStats().TTLKeys; n != 1 {
+		t.Fatalf("keys with a ttl = %d, want 1", n)
+	}
+	_, err = client.Set(ctx, &kvpb.SetRequest{Key: "t", Ttl: durationpb.New(-time.Second)})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("set with negative ttl: %v, want InvalidArgument", err)
+	}
+	_, err = client.Set(ctx, &kvpb.SetRequest{Key: ""})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("set empty key: %v, want InvalidArgument", err)
+	}
+
+	deleted, err := client.Delete(ctx, &kvpb.DeleteRequest{Key: "a"})
+	if err != nil || !deleted.Existed {
+		t.Fatalf("delete a = %v, %v", deleted, err)
+	}
+	got, err = client.Get(ctx, &kvpb.GetRequest{Key: "a"})
+	if err != nil || got.Found {
+		t.Fatalf("get deleted a = %v, %v", got, err)
+	}
+}
+
+func TestBatchAndScan(t *testing.T) {
+	client, _ := newTestClient(t)
+	ctx := context.Background()
+
+	batch, err := client.Batch(ctx, &kvpb.BatchRequest{Ops: []*kvpb.BatchOp{
+		{Type: kvpb.BatchOp_TYPE_SET, Key: "p/1", Value: []byte("a")},
+		{Type: kvpb.BatchOp_TYPE_SET, Key: "p/2", Value: []byte("b")},
+		{Type: kvpb.BatchOp_TYPE_SET, Key: "p/3", Value: []byte("c")},
+		{Type: kvpb.BatchOp_TYPE_SET, Key: "q/1", Value: []byte("d")},
+	}})
+	if err != nil || batch.Revision == 0 {
+		t.Fatalf("batch = %v, %v", batch, err)
+	}
+	_, err = client.Batch(ctx, &kvpb.BatchRequest{Ops: []*kvpb.BatchOp{{Key: "x"}}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("batch without type: %v, want InvalidArgument", err)
+	}
+
+	stream, err := client.Scan(ctx, &kvpb.ScanRequest{Prefix: "p/", KeysOnly: true, Limit: 2})
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	var keys []string
+	for {
+		kv, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		if kv.Value != nil {
+			t.Fatalf("keys-only scan returned value %q for %s", kv.Value, kv.Key)
+		}
+		if kv.Revision != batch.Revision {
+			t.Fatalf("scan revision = %d, want %d", kv.Revision, batch.Revision)
+		}
+		keys = append(keys, kv.Key)
+	}
+	if len(keys) != 2 || keys[0] != "p/1" || keys[1] != "p/2" {
+		t.Fatalf("scan keys = %v, want [p/1 p/2]", keys)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	client, kv := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := kv.Set("w/a", []byte("1")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	_, after, _ := kv.GetVersion("w/a")
+	if err := kv.Set("w/b", []byte("2")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	stream, err := client.Watch(ctx, &kvpb.WatchRequest{Prefix: "w/", AfterRevision: &after})
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("receive replayed event: %v", err)
+	}
+	if event.Type != kvpb.WatchEvent_TYPE_SET || event.Key != "w/b" || string(event.Value) != "2" {
+		t.Fatalf("replayed event = %v, want set w/b", event)
+	}
+
+	if _, err := kv.Delete("w/b"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	event, err = stream.Recv()
+	if err != nil {
+		t.Fatalf("receive live event: %v", err)
+	}
+	if event.Type != kvpb.WatchEvent_TYPE_DELETE || event.Key != "w/b" {
+		t.Fatalf("live event = %v, want delete w/b", event)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), requestIDKey, "abc-123")
+	if _, err := client.Get(ctx, &kvpb.GetRequest{Key: "k"}, grpc.Header(&header)); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if ids := header.Get(requestIDKey); len(ids) != 1 || ids[0] != "abc-123" {
+		t.Fatalf("request id header = %v, want abc-123", ids)
+	}
+
+	header = nil
+	if _, err := client.Get(context.Background(), &kvpb.GetRequest{Key: "k"}, grpc.Header(&header)); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if ids := header.Get(requestIDKey); len(ids) != 1 || ids[0] == "" {
+		t.Fatalf("generated request id header = %v", ids)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	var header metadata.MD
+	if _, err := client.Set(context.Background(), &kvpb.SetRequest{Key: "a", Value: []byte("1")}, grpc.Header(&header)); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	tokens := header.Get(sessionKey)
+	if len(tokens) != 1 || tokens[0] == "" {
+		t.Fatalf("expected a session token from the write, got %v", tokens)
+	}
+
+	header = nil
+	ctx := metadata.AppendToOutgoingContext(context.Background(), sessionKey, tokens[0])
+	if _, err := client.Get(ctx, &kvpb.GetRequest{Key: "a"}, grpc.Header(&header)); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got := header.Get(sessionKey); len(got) != 1 || got[0] != tokens[0] {
+		t.Fatalf("expected the read to echo the token, got %v", got)
+	}
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), sessionKey, "99")
+	if _, err := client.Get(ctx, &kvpb.GetRequest{Key: "a"}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for a session ahead of the store, got %v", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(context.Background(), sessionKey, "bad")
+	if _, err := client.Get(ctx, &kvpb.GetRequest{Key: "a"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for a malformed token, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"universe/internal/store"
+	"universe/pkg/proto/kvpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *grpcServer) Get(ctx context.Context, req *kvpb.GetRequest) (*kvpb.GetResponse, error) {
+	if req.Revision != nil {
+		value, ok, err := s.store.GetAt(req.Key, req.GetRevision())
+		if err != nil {
+			return nil, storeError(ctx, err)
+		}
+		return &kvpb.GetResponse{Found: ok, Value: value}, nil
+	}
+
+	value, revision, ok := s.store.GetVersion(req.Key)
+	return &kvpb.GetResponse{Found: ok, Value: value, Revision: revision}, nil
+}
+
+func (s *grpcServer) Set(ctx context.Context, req *kvpb.SetRequest) (*kvpb.SetResponse, error) {
+	var err error
+	if req.Ttl != nil {
+		ttl := req.Ttl.AsDuration()
+		if ttl <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "ttl must be positive")
+		}
+		err = s.store.SetWithTTLTimed(ctx, req.Key, req.Value, ttl, nil)
+	} else {
+		err = s.store.SetTimed(ctx, req.Key, req.Value, nil)
+	}
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	return &kvpb.SetResponse{}, nil
+}
+
+func (s *grpcServer) Delete(ctx context.Context, req *kvpb.DeleteRequest) (*kvpb.DeleteResponse, error) {
+	existed, err := s.store.DeleteTimed(ctx, req.Key, nil)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	return &kvpb.DeleteResponse{Existed: existed}, nil
+}
+
+func (s *grpcServer) Batch(ctx context.Context, req *kvpb.BatchRequest) (*kvpb.BatchResponse, error) {
+	if len(req.Ops) > maxBatchOps {
+		return nil, status.Errorf(codes.InvalidArgument, "a batch holds at most %d operations", maxBatchOps)
+	}
+
+	var batch store.WriteBatch
+	for i, op := range req.Ops {
+		switch op.Type {
+		case kvpb.BatchOp_TYPE_SET:
+			batch.Set(op.Key, op.Value)
+		case kvpb.BatchOp_TYPE_DELETE:
+			batch.Delete(op.Key)
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "operation %d: type must be set or delete, not %v", i, op.Type)
+		}
+	}
+
+	revision, err := s.store.CheckAndWrite(nil, &batch)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	return &kvpb.BatchResponse{Revision: revision}, nil
+}
+
+func (s *grpcServer) Scan(req *kvpb.ScanRequest, stream grpc.ServerStreamingServer[kvpb.KeyValue]) error {
+	it := s.store.NewIterator(store.IteratorOptions{
+		Prefix:   req.Prefix,
+		Start:    req.Start,
+		End:      req.End,
+		KeysOnly: req.KeysOnly,
+	})
+	defer it.Close()
+
+	for sent := uint32(0); req.Limit == 0 || sent < req.Limit; sent++ {
+		if !it.Next() {
+			return nil
+		}
+		if err := stream.Send(&kvpb.KeyValue{Key: it.Key(), Value: it.Value(), Revision: it.Revision()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *grpcServer) Watch(req *kvpb.WatchRequest, stream grpc.ServerStreamingServer[kvpb.WatchEvent]) error {
+	ctx := stream.Context()
+
+	var events <-chan store.Event
+	var stop func()
+	if req.AfterRevision != nil {
+		var err error
+		events, stop, err = s.store.WatchFrom(req.Prefix, req.GetAfterRevision())
+		var lost *store.HistoryLostError
+		if errors.As(err, &lost) {
+			logger.InfoContext(ctx, "watch history lost", "prefix", req.Prefix, "requested", lost.Requested, "compacted", lost.Compacted)
+			return status.Errorf(codes.OutOfRange, "%v; read the keys again at revision %d or later and watch from there", err, lost.Revision)
+		}
+		if err != nil {
+			return storeError(ctx, err)
+		}
+	} else {
+		events, stop = s.store.Watch(req.Prefix)
+	}
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.streams.Done():
+			return status.Error(codes.Unavailable, "server shutting down")
+		case event, ok := <-events:
+			if !ok {
+				logger.InfoContext(ctx, "watch ended by store", "prefix", req.Prefix)
+				return status.Error(codes.Unavailable, "watch ended; resume with after_revision set to the last revision received")
+			}
+			if err := stream.Send(watchEvent(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func watchEvent(event store.Event) *kvpb.WatchEvent {
+	e := &kvpb.WatchEvent{Key: event.Key, Value: event.Value, Revision: event.Revision}
+	switch event.Type {
+	case store.OperationSet:
+		e.Type = kvpb.WatchEvent_TYPE_SET
+	case store.OperationDelete:
+		e.Type = kvpb.WatchEvent_TYPE_DELETE
+	}
+	return e
+}
@@ -0,0 +1,95 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"universe/internal/logging"
+	"universe/internal/panics"
+	"universe/internal/store"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDKey is the metadata key carrying the request ID, the gRPC
+// counterpart of the HTTP API's X-Request-ID header.
+const requestIDKey = "x-request-id"
+
+// withRequestID honors or generates the call's request ID, sends it back
+// in the response header and puts it on the returned context so that lines
+// logged with it carry the ID.
+func withRequestID(ctx context.Context) context.Context {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDKey); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if !logging.ValidRequestID(id) {
+		id = logging.NewRequestID()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+	return logging.WithRequestID(ctx, id)
+}
+
+func requestIDUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(withRequestID(ctx), req)
+}
+
+func requestIDStream(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, contextStream{ServerStream: stream, ctx: withRequestID(stream.Context())})
+}
+
+// contextStream is a stream with a replaced context.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context { return s.ctx }
+
+// recoverUnary answers panicking calls with Internal and hands the panic to
+// the process-wide panic policy.
+func recoverUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer recoverCall(ctx, info.FullMethod, &err)
+	return handler(ctx, req)
+}
+
+func recoverStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer recoverCall(stream.Context(), info.FullMethod, &err)
+	return handler(srv, stream)
+}
+
+func recoverCall(ctx context.Context, method string, err *error) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	panics.Handle("grpc "+method+" request "+logging.RequestID(ctx), value)
+	*err = status.Error(codes.Internal, "internal server error")
+}
+
+// recoveringUnary answers calls with Unavailable until the store has
+// recovered, since the store must not be used before.
+func (s *grpcServer) recoveringUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.recovering(); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *grpcServer) recoveringStream(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.recovering(); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+func (s *grpcServer) recovering() error {
+	if err := s.store.Ready(); errors.Is(err, store.ErrRecovering) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"universe/internal/store"
+	"universe/pkg/proto/etcdpb"
+	"universe/pkg/proto/kvpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// sessionKey is the metadata key carrying a client session's token, the
+// gRPC counterpart of the HTTP API's X-Session-Token header.
+const sessionKey = "x-session-token"
+
+// sessionWrites are the methods whose success advances the session token.
+var sessionWrites = map[string]bool{
+	kvpb.UniverseKV_Set_FullMethodName:    true,
+	kvpb.UniverseKV_Delete_FullMethodName: true,
+	kvpb.UniverseKV_Batch_FullMethodName:  true,
+	etcdpb.KV_Put_FullMethodName:          true,
+	etcdpb.KV_DeleteRange_FullMethodName:  true,
+}
+
+// checkSession returns the call's session token, failing with
+// FailedPrecondition when the store may not reflect the session's writes.
+func (s *grpcServer) checkSession(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(sessionKey)
+	if len(tokens) == 0 || tokens[0] == "" {
+		return "", nil
+	}
+	if err := s.store.CheckSession(tokens[0]); err != nil {
+		if errors.Is(err, store.ErrSessionAhead) {
+			return "", status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	return tokens[0], nil
+}
+
+// sessionUnary enforces the call's session token and sends the token back,
+// advanced past the call if it was a successful write.
+func (s *grpcServer) sessionUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	token, err := s.checkSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := handler(ctx, req)
+	if err == nil && sessionWrites[info.FullMethod] {
+		token = s.store.SessionToken(token)
+	}
+	if token != "" {
+		_ = grpc.SetHeader(ctx, metadata.Pairs(sessionKey, token))
+	}
+	return resp, err
+}
+
+// sessionStream enforces the session token of a streaming call.
+func (s *grpcServer) sessionStream(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if _, err := s.checkSession(stream.Context()); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
@@ -0,0 +1,308 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"universe/internal/store"
+)
+
+// SystemPrefix starts the keys the server keeps for itself. Reading them
+// needs admin permission and writing them admin permission on the whole
+// keyspace, and key listings and watches leave them out for everyone else.
+const SystemPrefix = store.SystemPrefix
+
+// ACLKeyPrefix starts the keys holding the grants: ACLKeyPrefix+principal
+// holds the principal's grants as a JSON array. Being store keys they are
+// written to the WAL and survive restarts.
+const ACLKeyPrefix = SystemPrefix + "acl/"
+
+// Permission is what a grant allows on the keys it covers. Each permission
+// includes the ones below it.
+type Permission int
+
+const (
+	PermissionNone Permission = iota
+	// PermissionRead allows getting, listing and watching keys and
+	// looking at locks.
+	PermissionRead
+	// PermissionWrite also allows setting and deleting keys and taking
+	// locks.
+	PermissionWrite
+	// PermissionAdmin also allows reading the system keys; on the whole
+	// keyspace it allows writing them and the /admin endpoints, including
+	// managing grants.
+	PermissionAdmin
+)
+
+// ParsePermission parses "read", "write" or "admin".
+func ParsePermission(s string) (Permission, error) {
+	switch s {
+	case "read":
+		return PermissionRead, nil
+	case "write":
+		return PermissionWrite, nil
+	case "admin":
+		return PermissionAdmin, nil
+	default:
+		return PermissionNone, fmt.Errorf("unknown permission %q", s)
+	}
+}
+
+func (p Permission) String() string {
+	switch p {
+	case PermissionNone:
+		return "none"
+	case PermissionRead:
+		return "read"
+	case PermissionWrite:
+		return "write"
+	case PermissionAdmin:
+		return "admin"
+	default:
+		return fmt.Sprintf("Permission(%d)", int(p))
+	}
+}
+
+func (p Permission) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Permission) UnmarshalText(text []byte) error {
+	parsed, err := ParsePermission(string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// Grant gives a principal a permission on every key starting with Prefix;
+// an empty prefix covers the whole keyspace.
+type Grant struct {
+	Prefix     string     `json:"prefix"`
+	Permission Permission `json:"permission" swaggertype:"string" enums:"read,write,admin"`
+}
+
+// ACLConfig enables access control. It needs authentication, which names
+// the principal each request is checked for.
+type ACLConfig struct {
+	Enabled bool
+	// Admins are principals with admin permission on the whole keyspace
+	// whatever their stored grants, so that grants can be managed before
+	// any exist.
+	Admins []string
+}
+
+// scope returns what a request touches: a key, or a prefix that the grant
+// must cover entirely.
+type scope func(r *http.Request) string
+
+func pathScope(name string) scope {
+	return func(r *http.Request) string { return r.PathValue(name) }
+}
+
+func queryScope(name string) scope {
+	return func(r *http.Request) string { return r.URL.Query().Get(name) }
+}
+
+// keyspace is the scope of the endpoints that are not about particular keys.
+func keyspace(*http.Request) string { return "" }
+
+// authorize wraps next so that it only runs when the request's principal
+// has at least need on the key or prefix returned by scope, and answers 403
+// otherwise. It returns next unchanged when access control is disabled.
+func (s *httpServer) authorize(need Permission, scope scope, next http.HandlerFunc) http.HandlerFunc {
+	if !s.acl.Enabled {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowed(w, r, scope(r), need) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// allowed reports whether the request may use key with permission need,
+// answering the request with 403 or 503 if not.
+func (s *httpServer) allowed(w http.ResponseWriter, r *http.Request, key string, need Permission) bool {
+	ok, err := s.permits(r, key, need)
+	if err != nil {
+		http.Error(w, "access control unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+	if !ok {
+		logger.DebugContext(r.Context(), "request denied", "principal", requestPrincipal(r), "key", key, "permission", need, "path", r.URL.Path)
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}
+	return ok
+}
+
+// permits reports whether the request's principal may use key with
+// permission need.
+func (s *httpServer) permits(r *http.Request, key string, need Permission) (bool, error) {
+	if !s.acl.Enabled {
+		return true, nil
+	}
+	if isSystemKey(key) {
+		// A grant on the system keys alone does not let a principal
+		// write its own grants.
+		if need >= PermissionWrite {
+			key = ""
+		}
+		need = PermissionAdmin
+	}
+
+	principal := requestPrincipal(r)
+	grants, err := s.grants(principal)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "read grants", "principal", principal, "error", err)
+		return false, err
+	}
+	for _, grant := range grants {
+		if grant.Permission >= need && strings.HasPrefix(key, grant.Prefix) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func isSystemKey(key string) bool {
+	return strings.HasPrefix(key, SystemPrefix)
+}
+
+// hidesSystem reports whether the system keys must be left out of what a
+// listing or watch returns to the request: a grant on a prefix of
+// SystemPrefix, such as the whole keyspace, lets the request through, but
+// only admins may see the system keys.
+func (s *httpServer) hidesSystem(r *http.Request) bool {
+	if !s.acl.Enabled {
+		return false
+	}
+	ok, err := s.permits(r, SystemPrefix, PermissionAdmin)
+	return err != nil || !ok
+}
+
+// grants returns the principal's stored grants, plus admin on the whole
+// keyspace for the configured admins.
+func (s *httpServer) grants(principal string) ([]Grant, error) {
+	var grants []Grant
+	for _, admin := range s.acl.Admins {
+		if principal == admin {
+			grants = append(grants, Grant{Permission: PermissionAdmin})
+		}
+	}
+	if principal == "" {
+		return grants, nil
+	}
+
+	stored, ok := s.store.Get(ACLKeyPrefix + principal)
+	if !ok {
+		return grants, nil
+	}
+	var more []Grant
+	if err := json.Unmarshal(stored, &more); err != nil {
+		return nil, fmt.Errorf("decode grants of %q: %w", principal, err)
+	}
+	return append(grants, more...), nil
+}
+
+// ACLEntry lists the grants stored for one principal.
+type ACLEntry struct {
+	Principal string  `json:"principal"`
+	Grants    []Grant `json:"grants"`
+}
+
+// @Summary List access grants
+// @Description List the stored grants of every principal. Admins named in the server configuration are not listed.
+// @Tags admin
+// @Produce json
+// @Success 200 {array} ACLEntry
+// @Failure 403 {string} string "forbidden"
+// @Router /admin/acl [get]
+func (s *httpServer) ListGrants(w http.ResponseWriter, r *http.Request) {
+	entries := []ACLEntry{}
+	for after := ""; ; {
+		keys, more := s.store.Keys(ACLKeyPrefix, after, maxKeysLimit)
+		for _, key := range keys {
+			value, ok := s.store.Get(key)
+			if !ok {
+				continue
+			}
+			entry := ACLEntry{Principal: strings.TrimPrefix(key, ACLKeyPrefix)}
+			if err := json.Unmarshal(value, &entry.Grants); err != nil {
+				http.Error(w, fmt.Sprintf("grants of %q are corrupt", entry.Principal), http.StatusInternalServerError)
+				return
+			}
+			entries = append(entries, entry)
+		}
+		if !more {
+			break
+		}
+		after = keys[len(keys)-1]
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
+// @Summary Replace access grants
+// @Description Replace the grants of a principal. Each grant allows read, write or admin on the keys starting with its prefix; an empty prefix covers every key.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param principal path string true "Token name or basic-auth user"
+// @Param grants body []Grant true "Grants"
+// @Param X-Audit-Context header string false "Opaque caller context recorded in the audit log"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "invalid grants"
+// @Failure 403 {string} string "forbidden"
+// @Failure 503 {string} string "write could not be persisted"
+// @Failure 413 {string} string "request body too large"
+// @Router /admin/acl/{principal} [put]
+func (s *httpServer) PutGrants(w http.ResponseWriter, r *http.Request) {
+	var grants []Grant
+	if !decodeJSON(w, r, &grants, "invalid grants") {
+		return
+	}
+	for _, grant := range grants {
+		if grant.Permission == PermissionNone {
+			http.Error(w, fmt.Sprintf("grant on %q needs a permission", grant.Prefix), http.StatusBadRequest)
+			return
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool { return grants[i].Prefix < grants[j].Prefix })
+
+	principal := r.PathValue("principal")
+	value, _ := json.Marshal(grants)
+	if err := s.store.Set(ACLKeyPrefix+principal, value); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	auditMutation(r, "grants replaced", "grantee", principal, "grants", string(value))
+	s.publishAdmin(r, "grants replaced", principal)
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+}
+
+// @Summary Revoke access grants
+// @Description Remove all stored grants of a principal.
+// @Tags admin
+// @Produce json
+// @Param principal path string true "Token name or basic-auth user"
+// @Param X-Audit-Context header string false "Opaque caller context recorded in the audit log"
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {string} string "forbidden"
+// @Failure 503 {string} string "write could not be persisted"
+// @Router /admin/acl/{principal} [delete]
+func (s *httpServer) DeleteGrants(w http.ResponseWriter, r *http.Request) {
+	principal := r.PathValue("principal")
+	existed, err := s.store.Delete(ACLKeyPrefix + principal)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	auditMutation(r, "grants revoked", "grantee", principal, "existed", existed)
+	s.publishAdmin(r, "grants revoked", principal)
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "existed": existed})
+}
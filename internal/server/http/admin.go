@@ -0,0 +1,248 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"universe/internal/logging"
+	"universe/internal/panics"
+	"universe/internal/store"
+
+	"github.com/google/pprof/profile"
+)
+
+const (
+	defaultProfileSeconds = 30
+	maxProfileSeconds     = 300
+
+	storePackagePrefix = "universe/internal/store."
+)
+
+var auditLogger = logging.For(logging.CategoryAudit)
+
+var profileTypes = map[string]bool{
+	"cpu": true, "heap": true, "allocs": true, "goroutine": true,
+	"block": true, "mutex": true, "threadcreate": true,
+}
+
+// @Summary Capture a runtime profile
+// @Description Capture a pprof profile (cpu, heap, allocs, goroutine, block, mutex, threadcreate) and stream it back. cpu, block and mutex profiles are sampled for the requested number of seconds, and hold only the events of that window; one such capture runs at a time.
+// @Tags admin
+// @Produce octet-stream
+// @Param type query string true "Profile type"
+// @Param seconds query int false "Sampling window in seconds (default 30, max 300)"
+// @Success 200 {file} binary
+// @Failure 400 {string} string "invalid profile request"
+// @Failure 409 {string} string "profile capture already running"
+// @Failure 500 {string} string "profile capture failed"
+// @Router /admin/profile [get]
+func (s *httpServer) Profile(w http.ResponseWriter, r *http.Request) {
+	profileType := r.URL.Query().Get("type")
+	if !profileTypes[profileType] {
+		http.Error(w, "unknown profile type", http.StatusBadRequest)
+		return
+	}
+
+	seconds := defaultProfileSeconds
+	if raw := r.URL.Query().Get("seconds"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 || parsed > maxProfileSeconds {
+			http.Error(w, fmt.Sprintf("seconds must be between 1 and %d", maxProfileSeconds), http.StatusBadRequest)
+			return
+		}
+		seconds = parsed
+	}
+	window := time.Duration(seconds) * time.Second
+
+	// Sampled captures change process-wide rates, so they take turns.
+	if profileType == "cpu" || profileType == "block" || profileType == "mutex" {
+		if !profileMu.TryLock() {
+			http.Error(w, "profile capture already running", http.StatusConflict)
+			return
+		}
+		defer profileMu.Unlock()
+	}
+
+	auditLogger.InfoContext(r.Context(), "profile requested", "type", profileType, "seconds", seconds, "remote", r.RemoteAddr)
+
+	// Sampling may take longer than the server's write timeout allows.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(window + time.Minute))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pprof"`, profileType))
+
+	switch profileType {
+	case "cpu":
+		if err := pprof.StartCPUProfile(w); err != nil {
+			http.Error(w, "cpu profile already running", http.StatusConflict)
+			return
+		}
+		sleepOrDone(r, window)
+		pprof.StopCPUProfile()
+	case "block", "mutex":
+		before, err := profileSnapshot(profileType)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "capture profile", "type", profileType, "error", err)
+			http.Error(w, "profile capture failed", http.StatusInternalServerError)
+			return
+		}
+		start := time.Now()
+		if profileType == "block" {
+			runtime.SetBlockProfileRate(1)
+			sleepOrDone(r, window)
+			runtime.SetBlockProfileRate(s.blockProfileRate)
+		} else {
+			previous := runtime.SetMutexProfileFraction(1)
+			sleepOrDone(r, window)
+			runtime.SetMutexProfileFraction(previous)
+		}
+		writeDeltaProfile(w, profileType, before, start)
+	default:
+		writeProfile(w, profileType)
+	}
+
+	auditLogger.InfoContext(r.Context(), "profile captured", "type", profileType, "remote", r.RemoteAddr)
+}
+
+func writeProfile(w http.ResponseWriter, name string) {
+	if err := pprof.Lookup(name).WriteTo(w, 0); err != nil {
+		logger.Error("write profile", "type", name, "error", err)
+	}
+}
+
+// writeDeltaProfile writes the events of the named profile since the
+// snapshot before, taken at start.
+func writeDeltaProfile(w http.ResponseWriter, name string, before *profile.Profile, start time.Time) {
+	after, err := profileSnapshot(name)
+	if err == nil {
+		err = writeProfileDelta(w, before, after, start, time.Since(start))
+	}
+	if err != nil {
+		logger.Error("write profile", "type", name, "error", err)
+	}
+}
+
+// sleepOrDone waits for the sampling window or until the client goes away.
+func sleepOrDone(r *http.Request, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+}
+
+// Contention aggregates sampled mutex contention for one store function.
+type Contention struct {
+	Function    string `json:"function"`
+	Contentions int64  `json:"contentions"`
+	DelayCycles int64  `json:"delay_cycles"`
+}
+
+// @Summary Runtime diagnostics
+// @Description Dump goroutine and recovered panic counts, store/WAL queue depths and sampled mutex contention on store locks. Contention is only collected while the mutex profile fraction is non-zero.
+// @Tags admin
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /admin/diagnostics [get]
+func (s *httpServer) Diagnostics(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"goroutines":             runtime.NumGoroutine(),
+		"panics":                 panics.Count(),
+		"mutex_profile_fraction": mutexProfileFraction(),
+		"store":                  s.store.Stats(),
+		"contention":             storeContention(),
+	})
+}
+
+// mutexProfileFraction returns the current mutex profile fraction; setting
+// a negative one only reads it.
+func mutexProfileFraction() int {
+	return runtime.SetMutexProfileFraction(-1)
+}
+
+// storeContention attributes mutex profile samples to the first store
+// function on the stack, i.e. the Store or WAL method that waited on a lock.
+func storeContention() []Contention {
+	records := make([]runtime.BlockProfileRecord, 64)
+	for {
+		n, ok := runtime.MutexProfile(records)
+		if ok {
+			records = records[:n]
+			break
+		}
+		records = make([]runtime.BlockProfileRecord, n+16)
+	}
+
+	byFunction := make(map[string]*Contention)
+	for _, record := range records {
+		frames := runtime.CallersFrames(record.Stack())
+		for {
+			frame, more := frames.Next()
+			if strings.HasPrefix(frame.Function, storePackagePrefix) {
+				c, ok := byFunction[frame.Function]
+				if !ok {
+					c = &Contention{Function: frame.Function}
+					byFunction[frame.Function] = c
+				}
+				c.Contentions += record.Count
+				c.DelayCycles += record.Cycles
+				break
+			}
+			if !more {
+				break
+			}
+		}
+	}
+
+	contention := make([]Contention, 0, len(byFunction))
+	for _, c := range byFunction {
+		contention = append(contention, *c)
+	}
+	sort.Slice(contention, func(i, j int) bool {
+		return contention[i].DelayCycles > contention[j].DelayCycles
+	})
+
+	return contention
+}
+
+// @Summary Keyspace analytics
+// @Description Scan the keyspace and report key counts and sizes grouped by prefix, plus a value size histogram.
+// @Tags admin
+// @Produce json
+// @Param delimiter query string false "Key segment delimiter (default \":\")"
+// @Param depth query int false "Number of segments forming a prefix (default 1)"
+// @Param top query int false "Number of prefixes to return, ranked by bytes (default 20)"
+// @Success 200 {object} store.Analytics
+// @Failure 400 {string} string "invalid analytics request"
+// @Router /admin/analytics [get]
+func (s *httpServer) Analytics(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := store.AnalyticsOptions{Delimiter: ":", Depth: 1, Top: 20}
+
+	if query.Has("delimiter") {
+		opts.Delimiter = query.Get("delimiter")
+	}
+	for name, target := range map[string]*int{"depth": &opts.Depth, "top": &opts.Top} {
+		raw := query.Get(name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 {
+			http.Error(w, name+" must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		*target = value
+	}
+
+	auditLogger.InfoContext(r.Context(), "analytics requested", "delimiter", opts.Delimiter, "depth", opts.Depth, "remote", r.RemoteAddr)
+	json.NewEncoder(w).Encode(s.store.Analyze(opts))
+}
@@ -0,0 +1,43 @@
+package http
+
+import (
+	"net/http"
+	"unicode/utf8"
+	"universe/internal/events"
+)
+
+const (
+	// auditContextHeader carries opaque caller context, such as a user or
+	// ticket id, that is recorded with every mutation the request makes.
+	auditContextHeader = "X-Audit-Context"
+
+	maxAuditContextBytes = 1024
+)
+
+// auditMutation writes an audit record for a mutation when the request
+// carries an X-Audit-Context header. Requests without one are not audited,
+// so the audit log stays proportional to the callers that opt in.
+func auditMutation(r *http.Request, action string, args ...any) {
+	context := r.Header.Get(auditContextHeader)
+	if context == "" {
+		return
+	}
+
+	if len(context) > maxAuditContextBytes {
+		context = context[:maxAuditContextBytes]
+		for !utf8.ValidString(context) {
+			context = context[:len(context)-1]
+		}
+	}
+
+	args = append(args, "context", context, "remote", r.RemoteAddr)
+	if principal := requestPrincipal(r); principal != "" {
+		args = append(args, "principal", principal)
+	}
+	auditLogger.InfoContext(r.Context(), action, args...)
+}
+
+// publishAdmin publishes an administrative action on the store's event bus.
+func (s *httpServer) publishAdmin(r *http.Request, action, target string) {
+	s.store.Events().Publish(events.TopicAdmin, events.AdminAction{Action: action, Principal: requestPrincipal(r), Target: target})
+}
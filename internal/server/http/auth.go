@@ -0,0 +1,118 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AuthConfig enables request authentication. With neither tokens nor users
+// set every request is allowed.
+type AuthConfig struct {
+	// Tokens maps the name of each client to the bearer token it sends in
+	// "Authorization: Bearer <token>".
+	Tokens map[string]string
+	// Users maps basic-auth user names to their passwords.
+	Users map[string]string
+}
+
+func (c AuthConfig) enabled() bool {
+	return len(c.Tokens) > 0 || len(c.Users) > 0
+}
+
+type principalKey struct{}
+
+// requestPrincipal returns the client name or user the request authenticated
+// as, or "" when authentication is disabled.
+func requestPrincipal(r *http.Request) string {
+	principal, _ := r.Context().Value(principalKey{}).(string)
+	return principal
+}
+
+// credential is a secret kept as its SHA-256 digest, so that comparisons
+// take the same time whatever the length of the secret presented.
+type credential struct {
+	name   string
+	digest [sha256.Size]byte
+}
+
+func (c credential) matches(secret string) bool {
+	digest := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(c.digest[:], digest[:]) == 1
+}
+
+// authenticator checks bearer tokens and basic-auth credentials.
+type authenticator struct {
+	tokens []credential
+	users  map[string]credential
+}
+
+func newAuthenticator(config AuthConfig) *authenticator {
+	a := &authenticator{users: make(map[string]credential, len(config.Users))}
+	for name, token := range config.Tokens {
+		a.tokens = append(a.tokens, credential{name: name, digest: sha256.Sum256([]byte(token))})
+	}
+	for user, password := range config.Users {
+		a.users[user] = credential{name: user, digest: sha256.Sum256([]byte(password))}
+	}
+	return a
+}
+
+// check returns the principal the request authenticates as.
+func (a *authenticator) check(r *http.Request) (string, bool) {
+	if user, password, ok := r.BasicAuth(); ok {
+		// Unknown users are checked against an empty digest so they
+		// take as long as a wrong password.
+		c, known := a.users[user]
+		return user, c.matches(password) && known
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	// Every token is compared so the time taken does not reveal which
+	// one, if any, matched.
+	principal := ""
+	for _, c := range a.tokens {
+		if c.matches(token) {
+			principal = c.name
+		}
+	}
+	return principal, principal != ""
+}
+
+// authenticate rejects requests without valid credentials with 401 and
+// records the principal of the others on the request and its connection.
+// Health probes are let through.
+func authenticate(config AuthConfig, next http.Handler) http.Handler {
+	if !config.enabled() {
+		return next
+	}
+	a := newAuthenticator(config)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, ok := a.check(r)
+		if !ok {
+			if len(a.tokens) > 0 {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="universe"`)
+			}
+			if len(a.users) > 0 {
+				w.Header().Add("WWW-Authenticate", `Basic realm="universe", charset="UTF-8"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if client, ok := r.Context().Value(clientConnKey{}).(*clientConn); ok {
+			client.principal.Store(principal)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
@@ -0,0 +1,140 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"universe/internal/store"
+)
+
+// maxBatchKeys caps the operations of one batch and the keys of one
+// multi-get.
+const maxBatchKeys = 1000
+
+// BatchOp is one operation of a batch: "set" with a value, or "delete".
+type BatchOp struct {
+	Op    string          `json:"op" enums:"set,delete"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty" swaggertype:"object"`
+}
+
+// BatchRequest is a list of operations applied atomically, in order.
+type BatchRequest struct {
+	Ops []BatchOp `json:"ops"`
+}
+
+// MGetRequest names the keys to read.
+type MGetRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// MGetItem is the result for one key of a multi-get. Value holds values that
+// are valid JSON; any other value is returned base64-encoded in Bytes.
+type MGetItem struct {
+	Key      string          `json:"key"`
+	Found    bool            `json:"found"`
+	Revision uint64          `json:"revision,omitempty"`
+	Value    json.RawMessage `json:"value,omitempty" swaggertype:"object"`
+	Bytes    []byte          `json:"bytes,omitempty"`
+}
+
+// @Summary Apply a batch of writes
+// @Description Apply the sets and deletes atomically and in order: after a crash either all of them are recovered or none. On success the response carries the revision of the write.
+// @Tags kv
+// @Accept json
+// @Produce json
+// @Param request body BatchRequest true "Operations"
+// @Param X-Audit-Context header string false "Opaque caller context recorded in the audit log"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "invalid request"
+// @Failure 403 {string} string "forbidden"
+// @Failure 413 {string} string "request body too large"
+// @Failure 421 {string} string "key not served by this node"
+// @Failure 503 {string} string "write could not be persisted"
+// @Router /v1/batch [post]
+func (s *httpServer) Batch(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if !decodeJSON(w, r, &req, "invalid json") {
+		return
+	}
+	if len(req.Ops) > maxBatchKeys {
+		http.Error(w, fmt.Sprintf("a batch holds at most %d operations", maxBatchKeys), http.StatusBadRequest)
+		return
+	}
+
+	var batch store.WriteBatch
+	sets, deletes := 0, 0
+	for i, op := range req.Ops {
+		switch op.Op {
+		case "set":
+			// Re-encode so values are stored compactly, as /set does.
+			var value any
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				http.Error(w, fmt.Sprintf("operation %d: invalid value for %q", i, op.Key), http.StatusBadRequest)
+				return
+			}
+			encoded, _ := json.Marshal(value)
+			batch.Set(op.Key, encoded)
+			sets++
+		case "delete":
+			batch.Delete(op.Key)
+			deletes++
+		default:
+			http.Error(w, fmt.Sprintf("operation %d: op must be set or delete, not %q", i, op.Op), http.StatusBadRequest)
+			return
+		}
+		if !s.allowed(w, r, op.Key, PermissionWrite) {
+			return
+		}
+	}
+
+	revision, err := s.store.CheckAndWrite(nil, &batch)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	auditMutation(r, "keys written in a batch", "set", sets, "delete", deletes, "revision", revision)
+
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "revision": revision})
+}
+
+// @Summary Get many keys
+// @Description Read many keys in one request. The results are in the order of the keys asked for; missing keys have found set to false.
+// @Tags kv
+// @Accept json
+// @Produce json
+// @Param request body MGetRequest true "Keys"
+// @Success 200 {array} MGetItem
+// @Failure 400 {string} string "invalid request"
+// @Failure 403 {string} string "forbidden"
+// @Failure 413 {string} string "request body too large"
+// @Router /v1/mget [post]
+func (s *httpServer) MultiGet(w http.ResponseWriter, r *http.Request) {
+	var req MGetRequest
+	if !decodeJSON(w, r, &req, "invalid json") {
+		return
+	}
+	if len(req.Keys) > maxBatchKeys {
+		http.Error(w, fmt.Sprintf("at most %d keys can be read at once", maxBatchKeys), http.StatusBadRequest)
+		return
+	}
+	for _, key := range req.Keys {
+		if !s.allowed(w, r, key, PermissionRead) {
+			return
+		}
+	}
+
+	items := make([]MGetItem, len(req.Keys))
+	for i, key := range req.Keys {
+		value, revision, ok := s.store.GetVersion(key)
+		items[i] = MGetItem{Key: key, Found: ok, Revision: revision}
+		switch {
+		case !ok:
+		case json.Valid(value):
+			items[i].Value = value
+		default:
+			items[i].Bytes = value
+		}
+	}
+	json.NewEncoder(w).Encode(items)
+}
@@ -0,0 +1,149 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"universe/internal/store"
+)
+
+// CASCondition is one requirement of a conditional write. Exactly one of
+// Exists, Revision and Value must be set.
+type CASCondition struct {
+	Key string `json:"key"`
+	// Exists requires the key to be present (true) or absent (false).
+	Exists *bool `json:"exists,omitempty"`
+	// Revision requires the key to have been last written at this
+	// revision, as returned by GET /get/{key}.
+	Revision uint64 `json:"revision,omitempty"`
+	// Value requires the key to hold exactly this JSON value.
+	Value json.RawMessage `json:"value,omitempty" swaggertype:"object"`
+}
+
+// CASRequest writes Set and Delete atomically if every condition in If
+// holds.
+type CASRequest struct {
+	If     []CASCondition `json:"if"`
+	Set    map[string]any `json:"set,omitempty"`
+	Delete []string       `json:"delete,omitempty"`
+}
+
+func (c CASCondition) condition() (store.Condition, error) {
+	if c.Key == "" {
+		return store.Condition{}, fmt.Errorf("condition key must not be empty")
+	}
+
+	var set int
+	for _, present := range []bool{c.Exists != nil, c.Revision != 0, c.Value != nil} {
+		if present {
+			set++
+		}
+	}
+	if set != 1 {
+		return store.Condition{}, fmt.Errorf("condition on %q must set exactly one of exists, revision and value", c.Key)
+	}
+
+	switch {
+	case c.Exists != nil && *c.Exists:
+		return store.IfExists(c.Key), nil
+	case c.Exists != nil:
+		return store.IfMissing(c.Key), nil
+	case c.Revision != 0:
+		return store.IfRevision(c.Key, c.Revision), nil
+	}
+
+	// Values are stored as their JSON encoding; re-encode so that the
+	// comparison ignores formatting.
+	var value any
+	if err := json.Unmarshal(c.Value, &value); err != nil {
+		return store.Condition{}, fmt.Errorf("invalid value in condition on %q", c.Key)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return store.Condition{}, fmt.Errorf("invalid value in condition on %q", c.Key)
+	}
+	return store.IfValue(c.Key, encoded), nil
+}
+
+// @Summary Conditional multi-key write
+// @Description Apply the sets and deletes atomically if every condition holds. Each condition checks one key for existence, its last-written revision or its value. On success the response carries the revision of the write.
+// @Tags kv
+// @Accept json
+// @Produce json
+// @Param request body CASRequest true "Conditions and writes"
+// @Param X-Audit-Context header string false "Opaque caller context recorded in the audit log"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "invalid request"
+// @Failure 403 {string} string "forbidden"
+// @Failure 409 {string} string "condition failed"
+// @Failure 421 {string} string "key not served by this node"
+// @Failure 503 {string} string "write could not be persisted"
+// @Failure 413 {string} string "request body too large"
+// @Router /v1/cas [post]
+func (s *httpServer) CheckAndWrite(w http.ResponseWriter, r *http.Request) {
+	var req CASRequest
+	if !decodeJSON(w, r, &req, "invalid json") {
+		return
+	}
+
+	conditions := make([]store.Condition, 0, len(req.If))
+	for _, c := range req.If {
+		condition, err := c.condition()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		conditions = append(conditions, condition)
+	}
+
+	// Apply the sets in key order so the WAL record does not depend on map
+	// iteration.
+	keys := make([]string, 0, len(req.Set))
+	for key := range req.Set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var batch store.WriteBatch
+	for _, key := range keys {
+		value, err := json.Marshal(req.Set[key])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid value for %q", key), http.StatusBadRequest)
+			return
+		}
+		batch.Set(key, value)
+	}
+	for _, key := range req.Delete {
+		if _, ok := req.Set[key]; ok {
+			http.Error(w, fmt.Sprintf("key %q is both set and deleted", key), http.StatusBadRequest)
+			return
+		}
+		batch.Delete(key)
+	}
+
+	for _, c := range req.If {
+		if !s.allowed(w, r, c.Key, PermissionRead) {
+			return
+		}
+	}
+	for _, key := range append(keys, req.Delete...) {
+		if !s.allowed(w, r, key, PermissionWrite) {
+			return
+		}
+	}
+
+	revision, err := s.store.CheckAndWrite(conditions, &batch)
+	if errors.Is(err, store.ErrConditionFailed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	auditMutation(r, "keys written conditionally", "set", len(req.Set), "delete", len(req.Delete), "revision", revision)
+
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "revision": revision})
+}
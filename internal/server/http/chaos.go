@@ -0,0 +1,162 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ChaosConfig describes faults injected into requests to test how clients
+// cope with a misbehaving server. It is meant for staging only. Passed to the
+// server with -chaos as a JSON file, e.g.
+//
+//	{"rules": [
+//		{"route": "/get/", "latency": "200ms", "jitter": "100ms"},
+//		{"route": "/set/", "methods": ["POST"], "error_rate": 0.05},
+//		{"route": "/watch/", "drop_event_rate": 0.1}
+//	]}
+type ChaosConfig struct {
+	Rules []ChaosRule `json:"rules"`
+}
+
+// ChaosRule injects faults into requests whose path starts with Route and,
+// if Methods is set, whose method is one of them. The first matching rule
+// applies.
+type ChaosRule struct {
+	Route   string   `json:"route"`
+	Methods []string `json:"methods,omitempty"`
+	// Latency delays each request by this duration plus a random amount
+	// up to Jitter.
+	Latency Duration `json:"latency,omitempty"`
+	Jitter  Duration `json:"jitter,omitempty"`
+	// ErrorRate is the fraction of requests, 0 to 1, answered with
+	// ErrorStatus (503 by default) instead of being served.
+	ErrorRate   float64 `json:"error_rate,omitempty"`
+	ErrorStatus int     `json:"error_status,omitempty"`
+	// DropEventRate is the fraction of watch events silently not sent.
+	DropEventRate float64 `json:"drop_event_rate,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "250ms" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("duration must be a string such as \"250ms\"")
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadChaosConfig reads a ChaosConfig from a JSON file.
+func LoadChaosConfig(path string) (ChaosConfig, error) {
+	var config ChaosConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("read chaos config: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("parse chaos config %s: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("chaos config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+func (c ChaosConfig) validate() error {
+	for i, rule := range c.Rules {
+		if !strings.HasPrefix(rule.Route, "/") {
+			return fmt.Errorf("rule %d: route must start with /", i)
+		}
+		if rule.Latency < 0 || rule.Jitter < 0 {
+			return fmt.Errorf("rule %d: latency and jitter must not be negative", i)
+		}
+		if rule.ErrorRate < 0 || rule.ErrorRate > 1 || rule.DropEventRate < 0 || rule.DropEventRate > 1 {
+			return fmt.Errorf("rule %d: rates must be between 0 and 1", i)
+		}
+		if rule.ErrorStatus != 0 && (rule.ErrorStatus < 400 || rule.ErrorStatus > 599) {
+			return fmt.Errorf("rule %d: error_status must be a 4xx or 5xx code", i)
+		}
+	}
+	return nil
+}
+
+// match returns the rule that applies to r.
+func (c ChaosConfig) match(r *http.Request) (ChaosRule, bool) {
+	for _, rule := range c.Rules {
+		if !strings.HasPrefix(r.URL.Path, rule.Route) {
+			continue
+		}
+		if len(rule.Methods) > 0 && !slices.ContainsFunc(rule.Methods, func(m string) bool { return strings.EqualFold(m, r.Method) }) {
+			continue
+		}
+		return rule, true
+	}
+	return ChaosRule{}, false
+}
+
+// dropEvent reports whether the chaos rules drop a watch event sent in
+// response to r.
+func (c ChaosConfig) dropEvent(r *http.Request) bool {
+	rule, ok := c.match(r)
+	return ok && rule.DropEventRate > 0 && rand.Float64() < rule.DropEventRate
+}
+
+// chaosMiddleware delays and fails requests as the config's rules say.
+// Affected responses carry an X-Chaos header naming the injected faults.
+func chaosMiddleware(config ChaosConfig, next http.Handler) http.Handler {
+	if len(config.Rules) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := config.match(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		delay := time.Duration(rule.Latency)
+		if rule.Jitter > 0 {
+			delay += rand.N(time.Duration(rule.Jitter))
+		}
+		if delay > 0 {
+			w.Header().Add("X-Chaos", "latency")
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		if rule.ErrorRate > 0 && rand.Float64() < rule.ErrorRate {
+			status := rule.ErrorStatus
+			if status == 0 {
+				status = http.StatusServiceUnavailable
+			}
+			w.Header().Add("X-Chaos", "error")
+			http.Error(w, "chaos: injected failure", status)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
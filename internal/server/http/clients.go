@@ -0,0 +1,203 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClientInfo describes one connected client, similar to Redis CLIENT LIST.
+type ClientInfo struct {
+	ID          uint64    `json:"id"`
+	Listener    string    `json:"listener"`
+	RemoteAddr  string    `json:"remote_addr"`
+	Principal   string    `json:"principal,omitempty"`
+	Ops         int64     `json:"ops"`
+	BytesIn     int64     `json:"bytes_in"`
+	BytesOut    int64     `json:"bytes_out"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+type clientConn struct {
+	id          uint64
+	conn        net.Conn
+	connectedAt time.Time
+
+	ops       atomic.Int64
+	bytesIn   atomic.Int64
+	bytesOut  atomic.Int64
+	principal atomic.Value
+}
+
+type clientConnKey struct{}
+
+// clientRegistry tracks the connections accepted by one listener.
+type clientRegistry struct {
+	listener string
+
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[net.Conn]*clientConn
+}
+
+func newClientRegistry(listener string) *clientRegistry {
+	return &clientRegistry{
+		listener: listener,
+		conns:    make(map[net.Conn]*clientConn),
+	}
+}
+
+// connContext registers a newly accepted connection; used as http.Server.ConnContext.
+func (c *clientRegistry) connContext(ctx context.Context, conn net.Conn) context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	client := &clientConn{id: c.nextID, conn: conn, connectedAt: time.Now()}
+	c.conns[conn] = client
+
+	return context.WithValue(ctx, clientConnKey{}, client)
+}
+
+// connState forgets closed connections; used as http.Server.ConnState.
+func (c *clientRegistry) connState(conn net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, conn)
+}
+
+func (c *clientRegistry) list() []ClientInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clients := make([]ClientInfo, 0, len(c.conns))
+	for _, client := range c.conns {
+		principal, _ := client.principal.Load().(string)
+		clients = append(clients, ClientInfo{
+			ID:          client.id,
+			Listener:    c.listener,
+			RemoteAddr:  client.conn.RemoteAddr().String(),
+			Principal:   principal,
+			Ops:         client.ops.Load(),
+			BytesIn:     client.bytesIn.Load(),
+			BytesOut:    client.bytesOut.Load(),
+			ConnectedAt: client.connectedAt,
+		})
+	}
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients
+}
+
+// kill closes the connection with the given id and reports whether it existed.
+func (c *clientRegistry) kill(id uint64) bool {
+	c.mu.Lock()
+	var target net.Conn
+	for conn, client := range c.conns {
+		if client.id == id {
+			target = conn
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	if target == nil {
+		return false
+	}
+	_ = target.Close()
+	return true
+}
+
+// trackClient counts operations and bytes per connection.
+func trackClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, ok := r.Context().Value(clientConnKey{}).(*clientConn)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client.ops.Add(1)
+		r.Body = &countingReader{ReadCloser: r.Body, n: &client.bytesIn}
+		next.ServeHTTP(&countingWriter{ResponseWriter: w, n: &client.bytesOut}, r)
+	})
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (c *countingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+func (c *countingWriter) Flush() {
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// @Summary List connected clients
+// @Description List open client connections with their remote address, operation and byte counters.
+// @Tags admin
+// @Produce json
+// @Success 200 {array} ClientInfo
+// @Router /admin/clients [get]
+func (s *httpServer) Clients(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(s.clients.list())
+}
+
+// @Summary Kill a client connection
+// @Description Close the connection with the given client id.
+// @Tags admin
+// @Produce json
+// @Param id path int true "Client id"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {string} string "client not found"
+// @Router /admin/clients/{id} [delete]
+func (s *httpServer) KillClient(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid client id", http.StatusBadRequest)
+		return
+	}
+
+	auditLogger.InfoContext(r.Context(), "client kill requested", "client_id", id, "remote", r.RemoteAddr)
+	if !s.clients.kill(id) {
+		http.Error(w, "client not found", http.StatusNotFound)
+		return
+	}
+	s.publishAdmin(r, "client killed", strconv.FormatUint(id, 10))
+
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+}
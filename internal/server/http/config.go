@@ -0,0 +1,129 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Defaults for the ServerConfig fields left zero.
+const (
+	DefaultPort              = 8080
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultReadTimeout       = 30 * time.Second
+	DefaultWriteTimeout      = 30 * time.Second
+	DefaultIdleTimeout       = 2 * time.Minute
+	DefaultMaxBodyBytes      = 4 << 20
+)
+
+// ServerConfig configures the HTTP listener. Zero fields take the defaults
+// above; a negative timeout or body limit disables it.
+type ServerConfig struct {
+	// Address is the host or IP to listen on; empty listens on every
+	// interface.
+	Address string
+	Port    int
+
+	// ReadHeaderTimeout bounds reading the request headers and
+	// ReadTimeout the whole request, body included.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	// WriteTimeout bounds writing the response. Watch streams and
+	// profiles run longer and are exempt.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection may wait for its
+	// next request.
+	IdleTimeout time.Duration
+
+	// MaxBodyBytes limits request bodies; larger ones are rejected with
+	// 413.
+	MaxBodyBytes int64
+
+	// CertFile and KeyFile are PEM files holding the server certificate
+	// chain and its private key. When both are set the API is served over
+	// HTTPS only.
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is a PEM bundle of the CAs that sign client
+	// certificates. When set, clients must present a certificate signed by
+	// one of them (mutual TLS); ClientCertOptional instead lets clients
+	// without one through and only verifies those that present one.
+	ClientCAFile       string
+	ClientCertOptional bool
+}
+
+// withDefaults fills in the zero fields and turns negative ones into the
+// zero values net/http treats as unlimited.
+func (c ServerConfig) withDefaults() ServerConfig {
+	if c.Port == 0 {
+		c.Port = DefaultPort
+	}
+	for _, d := range []struct {
+		value    *time.Duration
+		fallback time.Duration
+	}{
+		{&c.ReadHeaderTimeout, DefaultReadHeaderTimeout},
+		{&c.ReadTimeout, DefaultReadTimeout},
+		{&c.WriteTimeout, DefaultWriteTimeout},
+		{&c.IdleTimeout, DefaultIdleTimeout},
+	} {
+		switch {
+		case *d.value == 0:
+			*d.value = d.fallback
+		case *d.value < 0:
+			*d.value = 0
+		}
+	}
+	switch {
+	case c.MaxBodyBytes == 0:
+		c.MaxBodyBytes = DefaultMaxBodyBytes
+	case c.MaxBodyBytes < 0:
+		c.MaxBodyBytes = 0
+	}
+	return c
+}
+
+// addr returns the address to listen on, e.g. ":8080".
+func (c ServerConfig) addr() string {
+	return net.JoinHostPort(c.Address, strconv.Itoa(c.Port))
+}
+
+// tlsEnabled reports whether the listener serves HTTPS.
+func (c ServerConfig) tlsEnabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// tlsConfig builds the TLS configuration for the listener. The certificate
+// itself is loaded by ServeTLS.
+func (c ServerConfig) tlsConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("http: tls needs both a certificate and a key file")
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.ClientCAFile == "" {
+		if c.ClientCertOptional {
+			return nil, errors.New("http: optional client certificates need a client CA file")
+		}
+		return config, nil
+	}
+
+	bundle, err := os.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("http: read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("http: no certificates in client CA file %s", c.ClientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if c.ClientCertOptional {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
@@ -0,0 +1,111 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"universe/internal/events"
+	"universe/internal/store"
+)
+
+// adminEventBuffer is how many events an admin event stream queues for a
+// slow client before ending its stream.
+const adminEventBuffer = 256
+
+// AdminEvent is the data of an admin event on the admin event stream.
+type AdminEvent struct {
+	Action    string `json:"action"`
+	Principal string `json:"principal,omitempty"`
+	Target    string `json:"target,omitempty"`
+}
+
+// ExpirationEvent is the data of an expiration event on the admin event
+// stream.
+type ExpirationEvent struct {
+	Key      string `json:"key"`
+	Revision uint64 `json:"revision"`
+	// ExpiredAt is when the TTL passed, in Unix nanoseconds.
+	ExpiredAt int64 `json:"expired_at"`
+}
+
+// adminEventTopics are the topics the admin event stream offers, by the
+// name its topic parameter takes.
+var adminEventTopics = map[string]events.Topic{
+	"admin":      events.TopicAdmin,
+	"expiration": events.TopicExpiration,
+}
+
+// @Summary Stream admin events
+// @Description Stream administrative actions and TTL expirations as they happen, as server-sent events named admin, carrying an AdminEvent, and expiration, carrying an ExpirationEvent. The stream ends if the client falls too far behind.
+// @Tags admin
+// @Produce text/event-stream
+// @Param topic query string false "Stream only admin or only expiration events"
+// @Success 200 {object} AdminEvent
+// @Failure 400 {string} string "unknown topic"
+// @Router /admin/events [get]
+func (s *httpServer) AdminEvents(w http.ResponseWriter, r *http.Request) {
+	topics := []events.Topic{events.TopicAdmin, events.TopicExpiration}
+	if name := r.URL.Query().Get("topic"); name != "" {
+		topic, ok := adminEventTopics[name]
+		if !ok {
+			http.Error(w, "unknown topic", http.StatusBadRequest)
+			return
+		}
+		topics = []events.Topic{topic}
+	}
+
+	// Handlers run on the publisher's path, so they only queue.
+	queue := make(chan events.Event, adminEventBuffer)
+	overflow := make(chan struct{})
+	var once sync.Once
+	unsubscribe := s.store.Events().Subscribe(func(event events.Event) {
+		select {
+		case queue <- event:
+		default:
+			once.Do(func() { close(overflow) })
+		}
+	}, topics...)
+	defer unsubscribe()
+
+	rc, ok := openStream(w, r)
+	if !ok {
+		return
+	}
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streams.Done():
+			return
+		case <-overflow:
+			logger.InfoContext(r.Context(), "admin event stream fell behind")
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event := <-queue:
+			var data any
+			switch payload := event.Payload.(type) {
+			case events.AdminAction:
+				data = AdminEvent{Action: payload.Action, Principal: payload.Principal, Target: payload.Target}
+			case store.Expiration:
+				data = ExpirationEvent{Key: payload.Key, Revision: payload.Revision, ExpiredAt: payload.ExpiredAt}
+			default:
+				continue
+			}
+			encoded, _ := json.Marshal(data)
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Topic, encoded); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -1,53 +1,295 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
+	"universe/internal/logging"
 	"universe/internal/store"
 )
 
+var logger = logging.For(logging.CategoryHTTP)
+
 type HttpServer interface {
 	Start() error
-	Stop()
+	Listen() error
+	Stop(ctx context.Context) error
+	Handler() http.Handler
 
 	Set(w http.ResponseWriter, r *http.Request)
 	Get(w http.ResponseWriter, r *http.Request)
 	Delete(w http.ResponseWriter, r *http.Request)
+	PutKey(w http.ResponseWriter, r *http.Request)
+	GetKey(w http.ResponseWriter, r *http.Request)
+	DeleteKey(w http.ResponseWriter, r *http.Request)
+	Keys(w http.ResponseWriter, r *http.Request)
+	Watch(w http.ResponseWriter, r *http.Request)
+	CheckAndWrite(w http.ResponseWriter, r *http.Request)
+	Batch(w http.ResponseWriter, r *http.Request)
+	MultiGet(w http.ResponseWriter, r *http.Request)
+	NextID(w http.ResponseWriter, r *http.Request)
+	WriteStatus(w http.ResponseWriter, r *http.Request)
+
+	Healthz(w http.ResponseWriter, r *http.Request)
+	Readyz(w http.ResponseWriter, r *http.Request)
+
+	Profile(w http.ResponseWriter, r *http.Request)
+	Diagnostics(w http.ResponseWriter, r *http.Request)
+	Analytics(w http.ResponseWriter, r *http.Request)
+	AdminEvents(w http.ResponseWriter, r *http.Request)
+	Clients(w http.ResponseWriter, r *http.Request)
+	KillClient(w http.ResponseWriter, r *http.Request)
+	ListGrants(w http.ResponseWriter, r *http.Request)
+	PutGrants(w http.ResponseWriter, r *http.Request)
+	DeleteGrants(w http.ResponseWriter, r *http.Request)
+
+	AcquireLock(w http.ResponseWriter, r *http.Request)
+	RefreshLock(w http.ResponseWriter, r *http.Request)
+	ReleaseLock(w http.ResponseWriter, r *http.Request)
+	GetLock(w http.ResponseWriter, r *http.Request)
 }
 
 type httpServer struct {
-	store  *store.Store
-	router *http.ServeMux
+	store   *store.Store
+	router  *http.ServeMux
+	server  *http.Server
+	clients *clientRegistry
+	locks   *lockTable
+	chaos   ChaosConfig
+	config  ServerConfig
+	auth    AuthConfig
+	acl     ACLConfig
+	// asyncAcks allows writes to ask for ack=async.
+	asyncAcks bool
+	// blockProfileRate is restored after a block profile capture.
+	blockProfileRate int
+	// bound is the listener bound by Listen for Start to serve.
+	bound net.Listener
+
+	// streams is cancelled when the server shuts down, ending long-lived
+	// responses such as watches that would otherwise hold up the drain.
+	streams    context.Context
+	endStreams context.CancelFunc
+}
+
+// Options configures an HttpServer.
+type Options struct {
+	// Config sets the listen address, timeouts and body limit.
+	Config ServerConfig
+	// Auth requires bearer tokens or basic-auth credentials on every
+	// request; leave empty to allow all requests.
+	Auth AuthConfig
+	// ACL limits each authenticated principal to its grants.
+	ACL ACLConfig
+	// Chaos injects faults into matching requests; leave empty outside
+	// staging.
+	Chaos ChaosConfig
+	// AsyncAck lets PUT and DELETE /v1/kv/{key} ask with ack=async to be
+	// answered once the write is journaled in the WAL buffer, before it is
+	// durable, trading the guarantee for throughput.
+	AsyncAck bool
+	// BlockProfileRate is the block profile rate the process runs with,
+	// which the runtime cannot report; a block profile capture restores
+	// it afterwards.
+	BlockProfileRate int
 }
 
-func NewServer(store *store.Store) HttpServer {
+// Option changes one setting of the Options NewServer starts from.
+type Option func(*Options)
+
+// WithConfig sets the listen address, timeouts and body limit.
+func WithConfig(config ServerConfig) Option {
+	return func(o *Options) { o.Config = config }
+}
+
+// WithAuth requires the credentials of config on every request.
+func WithAuth(config AuthConfig) Option {
+	return func(o *Options) { o.Auth = config }
+}
+
+// WithACL limits each authenticated principal to its grants.
+func WithACL(config ACLConfig) Option {
+	return func(o *Options) { o.ACL = config }
+}
+
+// WithChaos injects the faults of config into matching requests.
+func WithChaos(config ChaosConfig) Option {
+	return func(o *Options) { o.Chaos = config }
+}
+
+// WithAsyncAck lets writes ask to be answered before they are durable.
+func WithAsyncAck() Option {
+	return func(o *Options) { o.AsyncAck = true }
+}
+
+// WithBlockProfileRate tells the server the block profile rate the process
+// runs with.
+func WithBlockProfileRate(rate int) Option {
+	return func(o *Options) { o.BlockProfileRate = rate }
+}
+
+// NewServer returns a server for store configured by opts, applied in
+// order.
+func NewServer(store *store.Store, opts ...Option) HttpServer {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return NewServerWithOptions(store, o)
+}
+
+// NewServerWithOptions is NewServer with explicit options.
+func NewServerWithOptions(store *store.Store, opts Options) HttpServer {
 	router := http.NewServeMux()
 	s := &httpServer{
-		store:  store,
-		router: router,
+		store:   store,
+		router:  router,
+		clients: newClientRegistry("http"),
+		locks:   newLockTable(),
+		chaos:   opts.Chaos,
+		auth:    opts.Auth,
+		acl:     opts.ACL,
+		config:  opts.Config.withDefaults(),
+
+		asyncAcks:        opts.AsyncAck,
+		blockProfileRate: opts.BlockProfileRate,
+	}
+	s.streams, s.endStreams = context.WithCancel(context.Background())
+	s.server = &http.Server{
+		Addr:              s.config.addr(),
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: s.config.ReadHeaderTimeout,
+		ReadTimeout:       s.config.ReadTimeout,
+		WriteTimeout:      s.config.WriteTimeout,
+		IdleTimeout:       s.config.IdleTimeout,
+		ConnContext:       s.clients.connContext,
+		ConnState:         s.clients.connState,
+		// Connection-level failures such as TLS handshake errors go to
+		// the http log rather than stderr.
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
 	}
+	s.server.RegisterOnShutdown(s.endStreams)
+	if len(opts.Chaos.Rules) > 0 {
+		logger.Warn("chaos mode enabled, injecting faults", "rules", len(opts.Chaos.Rules))
+	}
+
+	router.HandleFunc("/set/{key}", s.authorize(PermissionWrite, pathScope("key"), s.Set))
+	router.HandleFunc("/get/{key}", s.authorize(PermissionRead, pathScope("key"), s.Get))
+	router.HandleFunc("/delete/{key}", s.authorize(PermissionWrite, pathScope("key"), s.Delete))
+	// The resource-style routes enforce methods and take keys with
+	// slashes; GET also answers HEAD.
+	router.HandleFunc("PUT /v1/kv/{key...}", s.authorize(PermissionWrite, pathScope("key"), s.PutKey))
+	router.HandleFunc("GET /v1/kv/{key...}", s.authorize(PermissionRead, pathScope("key"), s.GetKey))
+	router.HandleFunc("DELETE /v1/kv/{key...}", s.authorize(PermissionWrite, pathScope("key"), s.DeleteKey))
+	router.HandleFunc("GET /keys", s.authorize(PermissionRead, queryScope("prefix"), s.Keys))
+	router.HandleFunc("GET /watch/{prefix...}", s.authorize(PermissionRead, pathScope("prefix"), s.Watch))
+	// The keys of these requests are in their bodies; the handlers check
+	// them.
+	router.HandleFunc("POST /v1/cas", s.CheckAndWrite)
+	router.HandleFunc("POST /v1/batch", s.Batch)
+	router.HandleFunc("POST /v1/mget", s.MultiGet)
+	// Write tokens name no key and cannot be guessed, so any authenticated
+	// client may check one.
+	router.HandleFunc("GET /v1/writes/{token}", s.WriteStatus)
+
+	// Sequence names are checked against the grant prefixes like keys.
+	router.HandleFunc("POST /v1/id/{sequence}", s.authorize(PermissionWrite, pathScope("sequence"), s.NextID))
 
-	router.HandleFunc("/set/{key}", s.Set)
-	router.HandleFunc("/get/{key}", s.Get)
-	router.HandleFunc("/delete/{key}", s.Delete)
+	router.HandleFunc("GET /healthz", s.Healthz)
+	router.HandleFunc("GET /readyz", s.Readyz)
+
+	router.HandleFunc("/admin/profile", s.authorize(PermissionAdmin, keyspace, s.Profile))
+	router.HandleFunc("/admin/diagnostics", s.authorize(PermissionAdmin, keyspace, s.Diagnostics))
+	router.HandleFunc("/admin/analytics", s.authorize(PermissionAdmin, keyspace, s.Analytics))
+	router.HandleFunc("GET /admin/events", s.authorize(PermissionAdmin, keyspace, s.AdminEvents))
+	router.HandleFunc("GET /admin/clients", s.authorize(PermissionAdmin, keyspace, s.Clients))
+	router.HandleFunc("DELETE /admin/clients/{id}", s.authorize(PermissionAdmin, keyspace, s.KillClient))
+	router.HandleFunc("POST /admin/relocate", s.authorize(PermissionAdmin, keyspace, s.Relocate))
+	router.HandleFunc("GET /admin/acl", s.authorize(PermissionAdmin, keyspace, s.ListGrants))
+	router.HandleFunc("PUT /admin/acl/{principal}", s.authorize(PermissionAdmin, keyspace, s.PutGrants))
+	router.HandleFunc("DELETE /admin/acl/{principal}", s.authorize(PermissionAdmin, keyspace, s.DeleteGrants))
+
+	// Lock names are checked against the grant prefixes like keys.
+	router.HandleFunc("POST /v1/lock/{name}", s.authorize(PermissionWrite, pathScope("name"), s.AcquireLock))
+	router.HandleFunc("PUT /v1/lock/{name}", s.authorize(PermissionWrite, pathScope("name"), s.RefreshLock))
+	router.HandleFunc("DELETE /v1/lock/{name}", s.authorize(PermissionWrite, pathScope("name"), s.ReleaseLock))
+	router.HandleFunc("GET /v1/lock/{name}", s.authorize(PermissionRead, pathScope("name"), s.GetLock))
 
 	return s
 }
 
+// Start serves until Stop is called, when it returns nil, or until the
+// listener fails.
 func (s *httpServer) Start() error {
-	slog.Info("HTTP server starting on :8080")
-	err := http.ListenAndServe(":8080", s.router)
+	if s.bound == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+	return s.serve(s.bound)
+}
+
+// Listen binds the server's address ahead of Start, which then serves the
+// connections queued meanwhile. Start binds it itself if Listen was not
+// called.
+func (s *httpServer) Listen() error {
+	ln, err := net.Listen("tcp", s.server.Addr)
 	if err != nil {
+		return fmt.Errorf("http: listen: %w", err)
+	}
+	s.bound = ln
+	return nil
+}
+
+// serve accepts connections on ln until the server is stopped, over TLS when
+// a certificate is configured.
+func (s *httpServer) serve(ln net.Listener) error {
+	var err error
+	if s.config.tlsEnabled() {
+		if s.server.TLSConfig, err = s.config.tlsConfig(); err != nil {
+			_ = ln.Close()
+			return err
+		}
+		logger.Info("HTTP server starting", "addr", ln.Addr().String(), "tls", true, "client_certs", s.server.TLSConfig.ClientAuth.String())
+		err = s.server.ServeTLS(ln, s.config.CertFile, s.config.KeyFile)
+	} else {
+		logger.Info("HTTP server starting", "addr", ln.Addr().String())
+		err = s.server.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 
 	return nil
 }
 
-func (s *httpServer) Stop() {
-	slog.Info("HTTP server stopping on :8080")
-	s.store.Close()
+// Handler returns the server's routes wrapped in its middleware, for serving
+// on a caller-provided listener such as httptest.
+func (s *httpServer) Handler() http.Handler {
+	return trackClient(requestID(recoverMiddleware(authenticate(s.auth, limitBody(s.config.MaxBodyBytes, whileRecovering(s.store, sessions(s.store, chaosMiddleware(s.chaos, s.router))))))))
+}
+
+// Stop stops accepting connections, ends watch streams and waits for the
+// in-flight requests to finish. If ctx is done first the remaining
+// connections are closed and ctx's error returned. The store is left open
+// for the caller to close.
+func (s *httpServer) Stop(ctx context.Context) error {
+	logger.Info("HTTP server stopping", "addr", s.server.Addr)
+	// Shutdown runs endStreams in the background; ending the streams
+	// here as well makes /readyz fail from now on.
+	s.endStreams()
+	if err := s.server.Shutdown(ctx); err != nil {
+		_ = s.server.Close()
+		return fmt.Errorf("http: drain requests: %w", err)
+	}
+	logger.Info("HTTP server stopped")
+	return nil
 }
 
 // @Summary Set key-value pair
@@ -57,12 +299,20 @@ func (s *httpServer) Stop() {
 // @Produce json
 // @Param key path string true "Key"
 // @Param value body SetBody true "Value"
+// @Param ttl query string false "Expire the key after this duration, e.g. 90s or 10m; a plain number is seconds"
+// @Param X-Audit-Context header string false "Opaque caller context recorded in the audit log"
+// @Param X-Debug-Timing header string false "Return a Server-Timing breakdown when set"
 // @Success 200 {object} map[string]interface{}
+// @Failure 421 {string} string "key not served by this node"
+// @Failure 503 {string} string "write could not be persisted"
+// @Failure 413 {string} string "request body too large"
 // @Router /set/{key} [post]
 func (s *httpServer) Set(w http.ResponseWriter, r *http.Request) {
+	timing := newServerTiming(r)
+	start := time.Now()
+
 	var body SetBody
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
+	if !decodeJSON(w, r, &body, "invalid json") {
 		return
 	}
 	defer r.Body.Close()
@@ -71,30 +321,74 @@ func (s *httpServer) Set(w http.ResponseWriter, r *http.Request) {
 	x, err := json.Marshal(body.Value)
 	if err != nil {
 		http.Error(w, "invalid json internally", http.StatusBadRequest)
+		return
+	}
+	rawTTL := body.TTL
+	if query := r.URL.Query().Get("ttl"); query != "" {
+		rawTTL = query
+	}
+	ttl, err := parseTTL(rawTTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	start = timing.since("decode", start)
 
-	s.store.Set(key, x)
+	if ttl > 0 {
+		err = s.store.SetWithTTLTimed(r.Context(), key, x, ttl, timing.storeTiming())
+	} else {
+		err = s.store.SetTimed(r.Context(), key, x, timing.storeTiming())
+	}
+	timing.since("store", start)
+	if err != nil {
+		timing.writeHeader(w, true)
+		writeStoreError(w, r, err)
+		return
+	}
+	auditMutation(r, "key set", "key", key, "ttl", ttl)
 
+	timing.writeHeader(w, true)
 	json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
 }
 
 // @Summary Get value by key
-// @Description Get the value for a given key
+// @Description Get the value for a given key and, unless rev is given, the revision it was last written at
 // @Tags kv
 // @Produce json
 // @Param key path string true "Key"
+// @Param rev query int false "Return the value as of this revision"
+// @Param X-Debug-Timing header string false "Return a Server-Timing breakdown when set"
 // @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "invalid or future revision"
 // @Failure 404 {string} string "key not found"
+// @Failure 410 {string} string "revision compacted"
 // @Router /get/{key} [get]
 func (s *httpServer) Get(w http.ResponseWriter, r *http.Request) {
+	timing := newServerTiming(r)
+	start := time.Now()
+
 	key := r.PathValue("key")
-	value, ok := s.store.Get(key)
+	value, revision, ok, err := s.lookup(r, key)
+	if err != nil {
+		writeLookupError(w, err)
+		return
+	}
+	start = timing.since("store", start)
 	if !ok {
+		timing.writeHeader(w, false)
 		http.Error(w, "key not found", http.StatusNotFound)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "value": string(value)})
+	body := map[string]any{"status": "ok", "value": string(value)}
+	if revision != 0 {
+		body["revision"] = revision
+	}
+	response, _ := json.Marshal(body)
+	timing.since("encode", start)
+
+	timing.writeHeader(w, false)
+	w.Write(append(response, '\n'))
 }
 
 // @Summary Delete key-value pair
@@ -102,11 +396,127 @@ func (s *httpServer) Get(w http.ResponseWriter, r *http.Request) {
 // @Tags kv
 // @Produce json
 // @Param key path string true "Key"
+// @Param X-Audit-Context header string false "Opaque caller context recorded in the audit log"
+// @Param X-Debug-Timing header string false "Return a Server-Timing breakdown when set"
 // @Success 200 {object} map[string]interface{}
+// @Failure 421 {string} string "key not served by this node"
+// @Failure 503 {string} string "write could not be persisted"
 // @Router /delete/{key} [delete]
 func (s *httpServer) Delete(w http.ResponseWriter, r *http.Request) {
+	timing := newServerTiming(r)
+	start := time.Now()
+
 	key := r.PathValue("key")
-	_, _ = s.store.Delete(key)
+	existed, err := s.store.DeleteTimed(r.Context(), key, timing.storeTiming())
+	timing.since("store", start)
+	if err != nil {
+		timing.writeHeader(w, true)
+		writeStoreError(w, r, err)
+		return
+	}
+	auditMutation(r, "key deleted", "key", key, "existed", existed)
 
+	timing.writeHeader(w, true)
 	json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
 }
+
+// parseTTL parses a TTL given as a Go duration or a number of seconds. An
+// empty string means no TTL.
+func parseTTL(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(raw)
+	if err != nil {
+		seconds, convErr := strconv.ParseInt(raw, 10, 64)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid ttl %q", raw)
+		}
+		ttl = time.Duration(seconds) * time.Second
+		if ttl/time.Second != time.Duration(seconds) {
+			return 0, fmt.Errorf("invalid ttl %q", raw)
+		}
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("ttl must be positive")
+	}
+	return ttl, nil
+}
+
+// lookup reads key, or with ?rev=N its value as of revision N, in which case
+// the returned revision is zero.
+func (s *httpServer) lookup(r *http.Request, key string) ([]byte, uint64, bool, error) {
+	raw := r.URL.Query().Get("rev")
+	if raw == "" {
+		value, revision, ok := s.store.GetVersion(key)
+		return value, revision, ok, nil
+	}
+
+	at, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return nil, 0, false, errInvalidRevision
+	}
+	value, ok, err := s.store.GetAt(key, at)
+	return value, 0, ok, err
+}
+
+var errInvalidRevision = errors.New("invalid revision")
+
+// writeLookupError answers a read at an unusable revision: 410 once it has
+// been compacted away, 400 otherwise.
+func writeLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrCompacted) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// decodeJSON decodes the request body into v. If it cannot, it answers the
+// request as writeBodyError does and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any, invalid string) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeBodyError(w, err, invalid)
+		return false
+	}
+	return true
+}
+
+// writeBodyError answers a request whose body could not be read: 413 when
+// the body is over the limit set by limitBody, and 400 with invalid and the
+// error otherwise.
+func writeBodyError(w http.ResponseWriter, err error, invalid string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, invalid+": "+err.Error(), http.StatusBadRequest)
+}
+
+// writeStoreError answers a failed store write. Persistence failures are
+// reported as 503 since the node no longer accepts writes, values over the
+// store's size limit as 413, keys the store reserves for itself as 403 and
+// keys outside a partially recovered node's prefixes as 421; anything else,
+// including a key that is too long, is a rejected request.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, store.ErrWriteFailed) || errors.Is(err, store.ErrClosed) {
+		logger.ErrorContext(r.Context(), "store write failed", "error", err)
+		http.Error(w, "write could not be persisted", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, store.ErrValueTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, store.ErrReservedKey) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, store.ErrKeyNotServed) {
+		http.Error(w, err.Error(), http.StatusMisdirectedRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,1611 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+	"universe/internal/events"
+	"universe/internal/logging"
+	"universe/internal/store"
+
+	"github.com/google/pprof/profile"
+)
+
+func newTestServer(t testing.TB) HttpServer {
+	t.Helper()
+
+	kv, err := store.New(filepath.Join(t.TempDir(), "http.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = kv.Close()
+	})
+
+	return NewServer(kv)
+}
+
+// doRequest serves a request with body and the given headers, passed as
+// name and value pairs, to handler and returns the recorded response.
+func doRequest(t testing.TB, handler http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// sendRequest is doRequest over the network, for responses that stream; the
+// response body is closed when the test ends.
+func sendRequest(t testing.TB, base, method, path, body string, headers ...string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, base+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// basicAuth returns the Authorization header value for basic authentication.
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+// serveTest serves server on a local port until the test ends and returns
+// the address it listens on.
+func serveTest(t testing.TB, server *httpServer) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go server.serve(ln)
+	t.Cleanup(func() { _ = server.Stop(context.Background()) })
+	return ln.Addr().String()
+}
+
+func FuzzSetHandler(f *testing.F) {
+	f.Add("key", `{"value":"hello"}`)
+	f.Add("key", `{"value":{"nested":[1,2,3]}}`)
+	f.Add("", `{"value":null}`)
+	f.Add("k", `{`)
+	f.Add("k", `[]`)
+
+	server := newTestServer(f)
+	handler := server.Handler()
+
+	f.Fuzz(func(t *testing.T, key, body string) {
+		req := httptest.NewRequest(http.MethodPost, "/set/"+url.PathEscape(key), strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code >= http.StatusInternalServerError {
+			t.Fatalf("set %q with body %q: status %d: %s", key, body, rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestLockLifecycle(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.Handler()
+	locks := server.(*httpServer).locks
+
+	now := time.Now()
+	locks.now = func() time.Time { return now }
+
+	rec := doRequest(t, handler, http.MethodPost, "/v1/lock/jobs", `{"owner":"worker-1","ttl_seconds":10}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("acquire: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var first LockInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil {
+		t.Fatalf("decode lock: %v", err)
+	}
+
+	if rec := doRequest(t, handler, http.MethodPost, "/v1/lock/jobs", `{"owner":"worker-2"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 while held, got %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, http.MethodPut, "/v1/lock/jobs", fmt.Sprintf(`{"token":%d}`, first.Token+1)); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 refreshing with a wrong token, got %d", rec.Code)
+	}
+
+	now = now.Add(11 * time.Second)
+	rec = doRequest(t, handler, http.MethodPost, "/v1/lock/jobs", `{"owner":"worker-2"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected expired lock to be acquirable, got %d", rec.Code)
+	}
+	var second LockInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &second); err != nil {
+		t.Fatalf("decode lock: %v", err)
+	}
+	if second.Token <= first.Token || second.Owner != "worker-2" {
+		t.Fatalf("expected a newer fencing token for worker-2, got %+v after %+v", second, first)
+	}
+
+	if rec := doRequest(t, handler, http.MethodDelete, fmt.Sprintf("/v1/lock/jobs?token=%d", first.Token), ""); rec.Code != http.StatusConflict {
+		t.Fatalf("expected stale holder release to fail, got %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, http.MethodDelete, fmt.Sprintf("/v1/lock/jobs?token=%d", second.Token), ""); rec.Code != http.StatusOK {
+		t.Fatalf("release: status %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/v1/lock/jobs", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected released lock to be gone, got %d", rec.Code)
+	}
+}
+
+func TestKeysPagination(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.Handler()
+	kv := server.(*httpServer).store
+
+	for _, key := range []string{"user:3", "user:1", "order:1", "user:2", "user:4"} {
+		if err := kv.Set(key, []byte("x")); err != nil {
+			t.Fatalf("set %s: %v", key, err)
+		}
+	}
+
+	var pages [][]string
+	cursor := ""
+	for {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/keys?prefix=user:&limit=3&cursor="+cursor, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list keys: status %d: %s", rec.Code, rec.Body.String())
+		}
+
+		var page KeysResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("decode page: %v", err)
+		}
+		pages = append(pages, page.Keys)
+		if page.Next == "" {
+			break
+		}
+		cursor = page.Next
+	}
+
+	if len(pages) != 2 || strings.Join(pages[0], ",") != "user:1,user:2,user:3" || strings.Join(pages[1], ",") != "user:4" {
+		t.Fatalf("unexpected pages: %v", pages)
+	}
+}
+
+func TestWatchStream(t *testing.T) {
+	server := newTestServer(t)
+	kv := server.(*httpServer).store
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+
+	resp, err := http.Get(ts.URL + "/watch/app/")
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	if err := kv.Set("other", []byte("x")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := kv.Set("app/name", []byte(`"universe"`)); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	var lines []string
+	for scanner.Scan() && scanner.Text() != "" {
+		lines = append(lines, scanner.Text())
+	}
+	want := []string{"id: 2", "event: set", `data: {"type":"set","key":"app/name","value":"\"universe\"","revision":2}`}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected event:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestWatchResume(t *testing.T) {
+	server := newTestServer(t)
+	kv := server.(*httpServer).store
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+
+	for _, key := range []string{"app/a", "app/b"} {
+		if err := kv.Set(key, []byte(`1`)); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+
+	// firstEvent reads the first event of a watch resumed after revision.
+	firstEvent := func(revision string) []string {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/watch/app/", nil)
+		req.Header.Set("Last-Event-ID", revision)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("watch: %v", err)
+		}
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		var lines []string
+		for scanner.Scan() && scanner.Text() != "" {
+			lines = append(lines, scanner.Text())
+		}
+		return lines
+	}
+
+	want := []string{"id: 2", "event: set", `data: {"type":"set","key":"app/b","value":"1","revision":2}`}
+	if got := firstEvent("1"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected resumed event:\n%s", strings.Join(got, "\n"))
+	}
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/watch/app/?rev=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid revision, got %d", rec.Code)
+	}
+}
+
+func TestAdminEvents(t *testing.T) {
+	kv, err := store.New(filepath.Join(t.TempDir(), "events.wal"), store.WithSweep(10*time.Millisecond, 0, 0))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+	ts := httptest.NewServer(NewServerWithOptions(kv, Options{
+		Auth: AuthConfig{Tokens: map[string]string{"root": "root-token", "app": "app-token"}},
+		ACL:  ACLConfig{Enabled: true, Admins: []string{"root"}},
+	}).Handler())
+	t.Cleanup(ts.Close)
+
+	if resp := sendRequest(t, ts.URL, http.MethodGet, "/admin/events", "", "Authorization", "Bearer app-token"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected the stream to need admin, got %d", resp.StatusCode)
+	}
+	if resp := sendRequest(t, ts.URL, http.MethodGet, "/admin/events?topic=writes", "", "Authorization", "Bearer root-token"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown topic, got %d", resp.StatusCode)
+	}
+
+	stream := sendRequest(t, ts.URL, http.MethodGet, "/admin/events", "", "Authorization", "Bearer root-token")
+	if stream.StatusCode != http.StatusOK || stream.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("open stream: %d %s", stream.StatusCode, stream.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(stream.Body)
+	next := func() (string, string) {
+		t.Helper()
+		var name, data string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read stream: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && data != "":
+				return name, data
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}
+
+	if resp := sendRequest(t, ts.URL, http.MethodPut, "/admin/acl/app", `[{"prefix":"app:","permission":"read"}]`, "Authorization", "Bearer root-token"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("put grants: %d", resp.StatusCode)
+	}
+	if name, data := next(); name != "admin" || data != `{"action":"grants replaced","principal":"root","target":"app"}` {
+		t.Fatalf("unexpected admin event %s %s", name, data)
+	}
+
+	if err := kv.SetWithTTL("app:session", []byte("1"), time.Millisecond); err != nil {
+		t.Fatalf("set with ttl: %v", err)
+	}
+	name, data := next()
+	var expiration ExpirationEvent
+	if err := json.Unmarshal([]byte(data), &expiration); err != nil || name != "expiration" {
+		t.Fatalf("unexpected expiration event %s %s: %v", name, data, err)
+	}
+	if expiration.Key != "app:session" || expiration.Revision == 0 || expiration.ExpiredAt == 0 {
+		t.Fatalf("unexpected expiration %+v", expiration)
+	}
+}
+
+func TestStopDrainsRequests(t *testing.T) {
+	server := newTestServer(t).(*httpServer)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.server.Serve(ln) }()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/watch/")
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Stop(ctx); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("expected the server to close, got %v", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatalf("expected the watch stream to end cleanly, got %v", err)
+	}
+	if err := server.store.Set("after", []byte("1")); err != nil {
+		t.Fatalf("expected the store to stay open after stop, got %v", err)
+	}
+}
+
+func TestClients(t *testing.T) {
+	kv, err := store.New(filepath.Join(t.TempDir(), "clients.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+	server := NewServerWithOptions(kv, Options{
+		Auth: AuthConfig{Tokens: map[string]string{"root": "root-token", "app": "app-token"}},
+		ACL:  ACLConfig{Enabled: true, Admins: []string{"root"}},
+	}).(*httpServer)
+	addr := serveTest(t, server)
+	base := "http://" + addr
+
+	// The tracked client keeps one raw connection open.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "GET /get/app:a HTTP/1.1\r\nHost: test\r\nAuthorization: Bearer app-token\r\n\r\n")
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	list := func() []ClientInfo {
+		t.Helper()
+		resp := sendRequest(t, base, http.MethodGet, "/admin/clients", "", "Authorization", "Bearer root-token")
+		var clients []ClientInfo
+		if err := json.NewDecoder(resp.Body).Decode(&clients); err != nil {
+			t.Fatalf("decode clients: %v", err)
+		}
+		return clients
+	}
+	find := func(clients []ClientInfo) *ClientInfo {
+		for i, c := range clients {
+			if c.RemoteAddr == conn.LocalAddr().String() {
+				return &clients[i]
+			}
+		}
+		return nil
+	}
+
+	client := find(list())
+	if client == nil {
+		t.Fatalf("expected the open connection in the client list")
+	}
+	if client.Principal != "app" || client.Listener != "http" || client.Ops != 1 || client.BytesOut == 0 {
+		t.Fatalf("unexpected client %+v", client)
+	}
+	id := strconv.FormatUint(client.ID, 10)
+
+	// Only admins may list or kill clients.
+	if resp := sendRequest(t, base, http.MethodGet, "/admin/clients", "", "Authorization", "Bearer app-token"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a non-admin listing to be forbidden, got %d", resp.StatusCode)
+	}
+	if resp := sendRequest(t, base, http.MethodDelete, "/admin/clients/"+id, "", "Authorization", "Bearer app-token"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a non-admin kill to be forbidden, got %d", resp.StatusCode)
+	}
+	if resp := sendRequest(t, base, http.MethodDelete, "/admin/clients/nope", "", "Authorization", "Bearer root-token"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid id, got %d", resp.StatusCode)
+	}
+	if resp := sendRequest(t, base, http.MethodDelete, "/admin/clients/999999", "", "Authorization", "Bearer root-token"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown id, got %d", resp.StatusCode)
+	}
+
+	if resp := sendRequest(t, base, http.MethodDelete, "/admin/clients/"+id, "", "Authorization", "Bearer root-token"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("kill: %d", resp.StatusCode)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := reader.ReadByte(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected the killed connection to be closed, got %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for find(list()) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the killed client to leave the list")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	config := ServerConfig{Address: "127.0.0.1", WriteTimeout: -1}.withDefaults()
+	if config.addr() != "127.0.0.1:8080" {
+		t.Fatalf("unexpected address %q", config.addr())
+	}
+	if config.ReadTimeout != DefaultReadTimeout || config.WriteTimeout != 0 || config.MaxBodyBytes != DefaultMaxBodyBytes {
+		t.Fatalf("unexpected defaults %+v", config)
+	}
+
+	kv, err := store.New(filepath.Join(t.TempDir(), "config.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+	server := NewServerWithOptions(kv, Options{Config: ServerConfig{
+		ReadTimeout:  50 * time.Millisecond,
+		WriteTimeout: 50 * time.Millisecond,
+		MaxBodyBytes: 32,
+	}}).(*httpServer)
+
+	// Bodies over the limit are refused up front when their length is
+	// known, and cut off while they are decoded otherwise.
+	large := `"` + strings.Repeat("x", 64) + `"`
+	for _, tc := range []struct{ method, target, body string }{
+		{http.MethodPost, "/set/key", `{"value":` + large + `}`},
+		{http.MethodPut, "/v1/kv/key", large},
+		{http.MethodPost, "/v1/batch", `{"ops":[{"op":"set","key":"a","value":` + large + `}]}`},
+		{http.MethodPost, "/v1/mget", `{"keys":[` + large + `]}`},
+		{http.MethodPost, "/v1/cas", `{"set":{"a":` + large + `}}`},
+		{http.MethodPost, "/v1/lock/job", `{"owner":` + large + `}`},
+		{http.MethodPut, "/v1/lock/job", `{"token":` + large + `}`},
+		{http.MethodPut, "/admin/acl/app", `[{"prefix":` + large + `,"permission":"read"}]`},
+		{http.MethodPost, "/admin/relocate", `{"dir":` + large + `}`},
+	} {
+		for _, body := range []io.Reader{strings.NewReader(tc.body), io.MultiReader(strings.NewReader(tc.body))} {
+			rec := httptest.NewRecorder()
+			server.Handler().ServeHTTP(rec, httptest.NewRequest(tc.method, tc.target, body))
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("%s %s: expected 413 for an oversized body, got %d %q", tc.method, tc.target, rec.Code, rec.Body)
+			}
+		}
+	}
+
+	// Watch streams outlive the read and write timeouts.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go server.server.Serve(ln)
+	t.Cleanup(func() { _ = server.Stop(context.Background()) })
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/watch/")
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer resp.Body.Close()
+	time.Sleep(150 * time.Millisecond)
+	if err := kv.Set("late", []byte("1")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "id: 1\n" {
+		t.Fatalf("expected the event after the timeouts, got %q, %v", line, err)
+	}
+}
+
+func TestGetAtRevision(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.Handler()
+	kv := server.(*httpServer).store
+
+	for _, value := range []string{"old", "new"} {
+		if err := kv.Set("key", []byte(value)); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+
+	for target, want := range map[string]int{
+		"/get/key?rev=1":   http.StatusOK,
+		"/get/key?rev=3":   http.StatusBadRequest,
+		"/get/key?rev=x":   http.StatusBadRequest,
+		"/get/other?rev=1": http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != want {
+			t.Fatalf("%s: expected %d, got %d: %s", target, want, rec.Code, rec.Body.String())
+		}
+		if target == "/get/key?rev=1" && !strings.Contains(rec.Body.String(), `"value":"old"`) {
+			t.Fatalf("expected the old value at revision 1, got %s", rec.Body.String())
+		}
+	}
+}
+
+func TestChaosRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chaos.json")
+	config := `{"rules": [
+		{"route": "/get/", "methods": ["get"], "error_rate": 1, "error_status": 500},
+		{"route": "/keys", "latency": "20ms"}
+	]}`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	chaos, err := LoadChaosConfig(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+
+	kv, err := store.New(filepath.Join(t.TempDir(), "chaos.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = kv.Close()
+	})
+	handler := NewServerWithOptions(kv, Options{Chaos: chaos}).Handler()
+
+	if rec := doRequest(t, handler, http.MethodPost, "/set/key", `{"value":"v"}`); rec.Code != http.StatusOK || rec.Header().Get("X-Chaos") != "" {
+		t.Fatalf("expected unmatched route to be served untouched, got %d %q", rec.Code, rec.Header().Get("X-Chaos"))
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/get/key", `{"value":"v"}`); rec.Code != http.StatusInternalServerError || rec.Header().Get("X-Chaos") != "error" {
+		t.Fatalf("expected an injected 500, got %d %q", rec.Code, rec.Header().Get("X-Chaos"))
+	}
+	start := time.Now()
+	if rec := doRequest(t, handler, http.MethodGet, "/keys", `{"value":"v"}`); rec.Code != http.StatusOK || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("expected a delayed 200, got %d after %v", rec.Code, time.Since(start))
+	}
+
+	if err := os.WriteFile(path, []byte(`{"rules": [{"route": "/get/", "error_rate": 2}]}`), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if _, err := LoadChaosConfig(path); err == nil {
+		t.Fatalf("expected an error rate above 1 to be rejected")
+	}
+}
+
+func TestCheckAndWriteHandler(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.Handler()
+
+	if rec := doRequest(t, handler, http.MethodPost, "/set/a", `{"value":{"n":1}}`); rec.Code != http.StatusOK {
+		t.Fatalf("set: status %d", rec.Code)
+	}
+	var got struct {
+		Revision uint64 `json:"revision"`
+	}
+	if err := json.Unmarshal(doRequest(t, handler, http.MethodGet, "/get/a", "").Body.Bytes(), &got); err != nil || got.Revision != 1 {
+		t.Fatalf("expected revision 1 from get, got %d (%v)", got.Revision, err)
+	}
+
+	body := `{"if":[{"key":"a","revision":1},{"key":"a","value":{ "n": 1 }},{"key":"b","exists":false}],"set":{"a":2,"b":2}}`
+	if rec := doRequest(t, handler, http.MethodPost, "/v1/cas", body); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"revision":2`) {
+		t.Fatalf("cas: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(t, handler, http.MethodPost, "/v1/cas", body); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 on a stale condition, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(t, handler, http.MethodPost, "/v1/cas", `{"if":[{"key":"a","revision":1,"exists":true}]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an ambiguous condition, got %d", rec.Code)
+	}
+}
+
+// writeCert creates a certificate for name, signed by parent (self-signed
+// when nil), writes it and its key as PEM files in dir and returns them.
+func writeCert(t *testing.T, dir, name string, parent *tls.Certificate) (tls.Certificate, string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, any(key)
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatalf("write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("load key pair: %v", err)
+	}
+	return cert, certFile, keyFile
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caFile, _ := writeCert(t, dir, "ca", nil)
+	_, certFile, keyFile := writeCert(t, dir, "server", &ca)
+	client, _, _ := writeCert(t, dir, "client", &ca)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+
+	get := func(t *testing.T, config ServerConfig, certs ...tls.Certificate) (*http.Response, error) {
+		t.Helper()
+
+		kv, err := store.New(filepath.Join(t.TempDir(), "tls.wal"))
+		if err != nil {
+			t.Fatalf("create store: %v", err)
+		}
+		t.Cleanup(func() { _ = kv.Close() })
+		addr := serveTest(t, NewServerWithOptions(kv, Options{Config: config}).(*httpServer))
+
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		defer httpClient.CloseIdleConnections()
+		return httpClient.Get("https://" + addr + "/keys")
+	}
+
+	t.Run("server only", func(t *testing.T) {
+		resp, err := get(t, ServerConfig{CertFile: certFile, KeyFile: keyFile})
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+			t.Fatalf("expected 200 over TLS, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("client certificate required", func(t *testing.T) {
+		config := ServerConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}
+		if _, err := get(t, config); err == nil {
+			t.Fatalf("expected a client without a certificate to be refused")
+		}
+		resp, err := get(t, config, client)
+		if err != nil {
+			t.Fatalf("get with client certificate: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("client certificate optional", func(t *testing.T) {
+		resp, err := get(t, ServerConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientCertOptional: true})
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+	})
+
+	for name, config := range map[string]ServerConfig{
+		"key missing":         {CertFile: certFile},
+		"bad client CA file":  {CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile},
+		"optional without CA": {CertFile: certFile, KeyFile: keyFile, ClientCertOptional: true},
+	} {
+		if _, err := config.tlsConfig(); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	kv, err := store.New(filepath.Join(t.TempDir(), "auth.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+	handler := NewServerWithOptions(kv, Options{Auth: AuthConfig{
+		Tokens: map[string]string{"deployer": "s3cret-token"},
+		Users:  map[string]string{"alice": "hunter2"},
+	}}).Handler()
+
+	for name, headers := range map[string][]string{
+		"no credentials": nil,
+		"wrong token":    {"Authorization", "Bearer nope"},
+		"empty token":    {"Authorization", "Bearer "},
+		"wrong password": {"Authorization", basicAuth("alice", "hunter3")},
+		"unknown user":   {"Authorization", basicAuth("mallory", "hunter2")},
+		"token as user":  {"Authorization", basicAuth("deployer", "s3cret-token")},
+	} {
+		rec := doRequest(t, handler, http.MethodGet, "/keys", "", headers...)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d", name, rec.Code)
+		}
+		if got := rec.Header().Values("WWW-Authenticate"); len(got) != 2 {
+			t.Fatalf("%s: expected bearer and basic challenges, got %q", name, got)
+		}
+	}
+
+	for name, authorization := range map[string]string{
+		"token": "Bearer s3cret-token",
+		"basic": basicAuth("alice", "hunter2"),
+	} {
+		if rec := doRequest(t, handler, http.MethodGet, "/keys", "", "Authorization", authorization); rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", name, rec.Code, rec.Body)
+		}
+	}
+
+	// The principal is recorded on the connection for /admin/clients.
+	server := NewServerWithOptions(kv, Options{Auth: AuthConfig{Tokens: map[string]string{"deployer": "s3cret-token"}}}).(*httpServer)
+	base := "http://" + serveTest(t, server)
+	if resp := sendRequest(t, base, http.MethodGet, "/keys", "", "Authorization", "Bearer s3cret-token"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("get: %d", resp.StatusCode)
+	}
+	clients := server.clients.list()
+	if len(clients) != 1 || clients[0].Principal != "deployer" {
+		t.Fatalf("expected one client authenticated as deployer, got %+v", clients)
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	kv, err := store.New(filepath.Join(t.TempDir(), "acl.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+	handler := NewServerWithOptions(kv, Options{
+		Auth: AuthConfig{Tokens: map[string]string{"root": "root-token", "app": "app-token", "reader": "reader-token", "ops": "ops-token"}},
+		ACL:  ACLConfig{Enabled: true, Admins: []string{"root"}},
+	}).Handler()
+
+	// Without grants only the configured admin gets anywhere.
+	if rec := doRequest(t, handler, http.MethodPost, "/set/app:a", `{"value":1}`, "Authorization", "Bearer app-token"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 before any grants, got %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, http.MethodPut, "/admin/acl/app", `[{"prefix":"app:","permission":"write"},{"prefix":"shared:","permission":"read"}]`, "Authorization", "Bearer root-token"); rec.Code != http.StatusOK {
+		t.Fatalf("put grants: %d %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(t, handler, http.MethodPut, "/admin/acl/reader", `[{"prefix":"","permission":"read"}]`, "Authorization", "Bearer root-token"); rec.Code != http.StatusOK {
+		t.Fatalf("put grants: %d %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(t, handler, http.MethodPut, "/admin/acl/ops", `[{"prefix":"","permission":"write"},{"prefix":"__system/","permission":"admin"}]`, "Authorization", "Bearer root-token"); rec.Code != http.StatusOK {
+		t.Fatalf("put grants: %d %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(t, handler, http.MethodPut, "/admin/acl/app", `[{"prefix":"x/","permission":"owner"}]`, "Authorization", "Bearer root-token"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown permission, got %d", rec.Code)
+	}
+
+	for _, tc := range []struct {
+		token, method, target, body string
+		want                        int
+	}{
+		{"app-token", http.MethodPost, "/set/app:a", `{"value":1}`, http.StatusOK},
+		{"app-token", http.MethodGet, "/get/app:a", "", http.StatusOK},
+		{"app-token", http.MethodGet, "/keys?prefix=app:", "", http.StatusOK},
+		{"app-token", http.MethodGet, "/keys", "", http.StatusForbidden},
+		{"app-token", http.MethodPost, "/set/shared:a", `{"value":1}`, http.StatusForbidden},
+		{"app-token", http.MethodGet, "/get/shared:a", "", http.StatusNotFound},
+		{"app-token", http.MethodPost, "/v1/lock/app:job", `{"owner":"w1"}`, http.StatusOK},
+		{"app-token", http.MethodGet, "/admin/clients", "", http.StatusForbidden},
+		{"app-token", http.MethodPost, "/v1/cas", `{"if":[{"key":"shared:a","exists":false}],"set":{"app:b":2}}`, http.StatusOK},
+		{"app-token", http.MethodPost, "/v1/cas", `{"set":{"app:c":3,"other:c":3}}`, http.StatusForbidden},
+		{"reader-token", http.MethodGet, "/keys", "", http.StatusOK},
+		{"reader-token", http.MethodDelete, "/delete/app:a", "", http.StatusForbidden},
+		// System keys need admin even under a grant that covers them.
+		{"reader-token", http.MethodGet, "/get/" + url.PathEscape(ACLKeyPrefix+"app"), "", http.StatusForbidden},
+		{"reader-token", http.MethodGet, "/watch/" + SystemPrefix, "", http.StatusForbidden},
+		// Writing them needs admin on the whole keyspace, whatever the
+		// grants on the system keys and writable prefixes.
+		{"ops-token", http.MethodGet, "/get/" + url.PathEscape(ACLKeyPrefix+"app"), "", http.StatusOK},
+		{"ops-token", http.MethodPost, "/set/" + url.PathEscape(ACLKeyPrefix+"ops"), `{"value":[{"prefix":"","permission":"admin"}]}`, http.StatusForbidden},
+		{"ops-token", http.MethodPut, "/v1/kv/" + ACLKeyPrefix + "ops", `[{"prefix":"","permission":"admin"}]`, http.StatusForbidden},
+		{"ops-token", http.MethodDelete, "/delete/" + url.PathEscape(ACLKeyPrefix+"app"), "", http.StatusForbidden},
+		{"ops-token", http.MethodPost, "/v1/batch", `{"ops":[{"op":"set","key":"` + ACLKeyPrefix + `ops","value":1}]}`, http.StatusForbidden},
+		{"ops-token", http.MethodPost, "/v1/cas", `{"set":{"` + ACLKeyPrefix + `ops":1}}`, http.StatusForbidden},
+		{"ops-token", http.MethodGet, "/admin/acl", "", http.StatusForbidden},
+		{"root-token", http.MethodGet, "/admin/acl", "", http.StatusOK},
+	} {
+		if rec := doRequest(t, handler, tc.method, tc.target, tc.body, "Authorization", "Bearer "+tc.token); rec.Code != tc.want {
+			t.Fatalf("%s %s as %s: expected %d, got %d: %s", tc.method, tc.target, tc.token, tc.want, rec.Code, rec.Body)
+		}
+	}
+	if _, ok := kv.Get("other:c"); ok {
+		t.Fatalf("a denied conditional write was applied")
+	}
+
+	var entries []ACLEntry
+	if err := json.NewDecoder(doRequest(t, handler, http.MethodGet, "/admin/acl", "", "Authorization", "Bearer root-token").Body).Decode(&entries); err != nil {
+		t.Fatalf("decode grants: %v", err)
+	}
+	if len(entries) != 3 || entries[0].Principal != "app" || entries[0].Grants[1] != (Grant{Prefix: "shared:", Permission: PermissionRead}) {
+		t.Fatalf("unexpected grants %+v", entries)
+	}
+
+	if rec := doRequest(t, handler, http.MethodDelete, "/admin/acl/app", "", "Authorization", "Bearer root-token"); rec.Code != http.StatusOK {
+		t.Fatalf("delete grants: %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/get/app:a", "", "Authorization", "Bearer app-token"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 after the grants were revoked, got %d", rec.Code)
+	}
+}
+
+func TestAccessControlAfterPartialRecovery(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "acl.wal")
+	opts := Options{
+		Auth: AuthConfig{Tokens: map[string]string{"root": "root-token", "app": "app-token"}},
+		ACL:  ACLConfig{Enabled: true, Admins: []string{"root"}},
+	}
+
+	kv, err := store.New(walPath)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	handler := NewServerWithOptions(kv, opts).Handler()
+	if rec := doRequest(t, handler, http.MethodPut, "/admin/acl/app", `[{"prefix":"app:","permission":"write"}]`, "Authorization", "Bearer root-token"); rec.Code != http.StatusOK {
+		t.Fatalf("put grants: %d", rec.Code)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// A node serving only some prefixes still knows the grants.
+	kv, err = store.NewWithOptions(walPath, store.Options{RecoverPrefixes: []string{"app:"}})
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+	handler = NewServerWithOptions(kv, opts).Handler()
+	if rec := doRequest(t, handler, http.MethodPost, "/set/app:a", `{"value":1}`, "Authorization", "Bearer app-token"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the recovered grant to allow the write, got %d", rec.Code)
+	}
+}
+
+func TestAccessControlHidesSystemKeys(t *testing.T) {
+	kv, err := store.New(filepath.Join(t.TempDir(), "acl.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+	ts := httptest.NewServer(NewServerWithOptions(kv, Options{
+		Auth: AuthConfig{Tokens: map[string]string{"root": "root-token", "reader": "reader-token"}},
+		ACL:  ACLConfig{Enabled: true, Admins: []string{"root"}},
+	}).Handler())
+	t.Cleanup(ts.Close)
+
+	listKeys := func(token string) []string {
+		t.Helper()
+		resp := sendRequest(t, ts.URL, http.MethodGet, "/keys", "", "Authorization", "Bearer "+token)
+		var page KeysResponse
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatalf("decode keys: %v", err)
+		}
+		return page.Keys
+	}
+
+	sendRequest(t, ts.URL, http.MethodPut, "/admin/acl/reader", `[{"prefix":"","permission":"read"}]`, "Authorization", "Bearer root-token")
+	if err := kv.Set("app", []byte("1")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	// A read grant on the whole keyspace lists every key but the system
+	// ones; an admin sees those too.
+	if keys := listKeys("reader-token"); strings.Join(keys, ",") != "app" {
+		t.Fatalf("reader listed %v", keys)
+	}
+	if keys := listKeys("root-token"); strings.Join(keys, ",") != ACLKeyPrefix+"reader,app" {
+		t.Fatalf("admin listed %v", keys)
+	}
+
+	// Watching the whole keyspace does not stream grant changes either.
+	watch := sendRequest(t, ts.URL, http.MethodGet, "/watch/", "", "Authorization", "Bearer reader-token")
+	if watch.StatusCode != http.StatusOK {
+		t.Fatalf("watch: %d", watch.StatusCode)
+	}
+	sendRequest(t, ts.URL, http.MethodPut, "/admin/acl/other", `[{"prefix":"x/","permission":"write"}]`, "Authorization", "Bearer root-token")
+	if err := kv.Set("app", []byte("2")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	scanner := bufio.NewScanner(watch.Body)
+	var lines []string
+	for scanner.Scan() && scanner.Text() != "" {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 3 || lines[1] != "event: set" || !strings.Contains(lines[2], `"key":"app"`) {
+		t.Fatalf("reader's first watch event:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestKVResource(t *testing.T) {
+	handler := newTestServer(t).Handler()
+	if rec := doRequest(t, handler, http.MethodPut, "/v1/kv/users/1", `{"name":"ada"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 for a new key, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(t, handler, http.MethodPut, "/v1/kv/users/1", `{"name": "grace"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a replaced key, got %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, http.MethodPut, "/v1/kv/users/1", `{`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid json, got %d", rec.Code)
+	}
+
+	rec := doRequest(t, handler, http.MethodGet, "/v1/kv/users/1", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"name":"grace"}`+"\n" || rec.Header().Get("ETag") != `"2"` {
+		t.Fatalf("unexpected get: %d %q %q", rec.Code, rec.Body, rec.Header().Get("ETag"))
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/v1/kv/users/1", "", "If-None-Match", `"2"`); rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for a matching etag, got %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/v1/kv/users/1?rev=1", ""); rec.Body.String() != `{"name":"ada"}`+"\n" {
+		t.Fatalf("unexpected value at revision 1: %q", rec.Body)
+	}
+	if rec := doRequest(t, handler, http.MethodHead, "/v1/kv/users/1", ""); rec.Code != http.StatusOK || rec.Header().Get("Content-Length") != "17" {
+		t.Fatalf("unexpected head: %d %q", rec.Code, rec.Header().Get("Content-Length"))
+	}
+
+	if rec := doRequest(t, handler, http.MethodPost, "/v1/kv/users/1", `{}`); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for POST, got %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, http.MethodDelete, "/v1/kv/users/1", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for a delete, got %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, http.MethodDelete, "/v1/kv/users/1", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing key, got %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/v1/kv/users/1", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after the delete, got %d", rec.Code)
+	}
+
+	// Raw bytes are stored and returned verbatim.
+	raw := "\x00\xffnot json"
+	if rec := doRequest(t, handler, http.MethodPut, "/v1/kv/blob", raw, "Content-Type", "application/octet-stream"); rec.Code != http.StatusCreated {
+		t.Fatalf("put raw: %d %s", rec.Code, rec.Body)
+	}
+	rec = doRequest(t, handler, http.MethodGet, "/v1/kv/blob", "")
+	if rec.Body.String() != raw || rec.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Fatalf("expected the raw bytes back, got %q as %q", rec.Body, rec.Header().Get("Content-Type"))
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/v1/kv/blob", "", "Accept", "application/json"); rec.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406 for raw bytes as JSON, got %d", rec.Code)
+	}
+	doRequest(t, handler, http.MethodPut, "/v1/kv/doc", `[1, 2]`)
+	if rec := doRequest(t, handler, http.MethodGet, "/v1/kv/doc", "", "Accept", "application/octet-stream"); rec.Body.String() != "[1,2]" || rec.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Fatalf("expected the JSON bytes as octet-stream, got %q as %q", rec.Body, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestBatchAndMultiGet(t *testing.T) {
+	server := newTestServer(t)
+	handler := server.Handler()
+	kv := server.(*httpServer).store
+
+	if err := kv.Set("old", []byte(`"x"`)); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	rec := doRequest(t, handler, http.MethodPost, "/v1/batch", `{"ops":[{"op":"set","key":"a","value":{"n": 1}},{"op":"set","key":"b","value":2},{"op":"delete","key":"old"}]}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"revision":2`) {
+		t.Fatalf("batch: %d %s", rec.Code, rec.Body)
+	}
+	for body, want := range map[string]int{
+		`{"ops":[{"op":"set","key":"c","value":1},{"op":"rename","key":"a"}]}`: http.StatusBadRequest,
+		`{"ops":[{"op":"set","key":"c"}]}`:                                     http.StatusBadRequest,
+		`{"ops":[{"op":"set","key":"c","value":1},{"op":"delete","key":""}]}`:  http.StatusBadRequest,
+	} {
+		if rec := doRequest(t, handler, http.MethodPost, "/v1/batch", body); rec.Code != want {
+			t.Fatalf("%s: expected %d, got %d", body, want, rec.Code)
+		}
+	}
+	if _, ok := kv.Get("c"); ok {
+		t.Fatalf("a rejected batch was partly applied")
+	}
+
+	if err := kv.Set("raw", []byte{0xff}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	rec = doRequest(t, handler, http.MethodPost, "/v1/mget", `{"keys":["a","old","b","raw"]}`)
+	var items []MGetItem
+	if err := json.NewDecoder(rec.Body).Decode(&items); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := []MGetItem{
+		{Key: "a", Found: true, Revision: 2, Value: json.RawMessage(`{"n":1}`)},
+		{Key: "old"},
+		{Key: "b", Found: true, Revision: 2, Value: json.RawMessage(`2`)},
+		{Key: "raw", Found: true, Revision: 3, Bytes: []byte{0xff}},
+	}
+	if fmt.Sprintf("%+v", items) != fmt.Sprintf("%+v", want) {
+		t.Fatalf("unexpected items\n got %+v\nwant %+v", items, want)
+	}
+}
+
+func TestSizeLimitErrors(t *testing.T) {
+	kv, err := store.NewWithOptions(filepath.Join(t.TempDir(), "limits.wal"), store.Options{MaxKeyLength: 8, MaxValueSize: 16})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+	handler := NewServer(kv).Handler()
+
+	for target, want := range map[string]int{
+		"/v1/kv/short":         http.StatusCreated,
+		"/v1/kv/much-too-long": http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, target, strings.NewReader(`"v"`)))
+		if rec.Code != want {
+			t.Fatalf("%s: expected %d, got %d", target, want, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/set/k", strings.NewReader(`{"value":"`+strings.Repeat("x", 32)+`"}`)))
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "value too large") {
+		t.Fatalf("expected 413 naming the limit, got %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	kv, err := store.New(filepath.Join(t.TempDir(), "probes.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	server := NewServerWithOptions(kv, Options{Auth: AuthConfig{Tokens: map[string]string{"app": "secret"}}}).(*httpServer)
+	handler := server.Handler()
+
+	// Probes need no credentials.
+	if rec := doRequest(t, handler, http.MethodGet, "/healthz", ""); rec.Code != http.StatusOK {
+		t.Fatalf("healthz: %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/readyz", ""); rec.Code != http.StatusOK {
+		t.Fatalf("readyz: %d %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/keys", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected other routes to still need credentials, got %d", rec.Code)
+	}
+
+	if err := kv.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/readyz", ""); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "closed") {
+		t.Fatalf("expected a closed store to be unready, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/healthz", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected liveness to ignore the store, got %d", rec.Code)
+	}
+
+	if err := server.Stop(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/readyz", ""); !strings.Contains(rec.Body.String(), "shutting down") {
+		t.Fatalf("expected a stopping server to be unready, got %s", rec.Body)
+	}
+}
+
+func TestProbesDuringRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recovery.wal")
+	kv, err := store.New(path, store.WithCheckpointOnClose(false))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	for i := range 3 {
+		if err := kv.Set(fmt.Sprintf("key%d", i), []byte("value")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+
+	// Recovery publishes the replayed writes, so a subscriber that blocks
+	// holds the replay up after its first entry.
+	bus := events.New()
+	replaying := make(chan struct{}, 1)
+	resume := make(chan struct{})
+	bus.Subscribe(func(events.Event) {
+		select {
+		case replaying <- struct{}{}:
+		default:
+		}
+		<-resume
+	}, events.TopicWrite)
+	kv, recovered, err := store.Open(path, store.Options{Events: bus})
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+	<-replaying
+
+	handler := NewServer(kv).Handler()
+	if rec := doRequest(t, handler, http.MethodGet, "/healthz", ""); rec.Code != http.StatusOK {
+		t.Fatalf("healthz during recovery: %d", rec.Code)
+	}
+	rec := doRequest(t, handler, http.MethodGet, "/readyz", "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz during recovery: %d %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Reason   string `json:"reason"`
+		Recovery struct {
+			Entries    int64  `json:"entries"`
+			Bytes      int64  `json:"bytes"`
+			TotalBytes int64  `json:"total_bytes"`
+			ETA        *int64 `json:"eta_ms"`
+		} `json:"recovery"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode readyz: %v", err)
+	}
+	if body.Reason != store.ErrRecovering.Error() || body.Recovery.TotalBytes == 0 || body.Recovery.Bytes > body.Recovery.TotalBytes || body.Recovery.ETA == nil {
+		t.Fatalf("unexpected readyz body %s", rec.Body)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/keys", ""); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected store routes to wait for recovery, got %d", rec.Code)
+	}
+
+	close(resume)
+	if err := <-recovered; err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/readyz", ""); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "recovery") {
+		t.Fatalf("readyz after recovery: %d %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/keys", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "key2") {
+		t.Fatalf("keys after recovery: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	handler := newTestServer(t).Handler()
+
+	for _, query := range []string{"type=nope", "type=heap&seconds=0", "type=heap&seconds=301"} {
+		if rec := doRequest(t, handler, http.MethodGet, "/admin/profile?"+query, ""); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/admin/profile?"+"type=heap", ""); rec.Code != http.StatusOK || !bytes.HasPrefix(rec.Body.Bytes(), []byte{0x1f, 0x8b}) {
+		t.Fatalf("heap: expected a gzipped profile, got %d", rec.Code)
+	}
+
+	// A mutex capture samples everything during its window and then
+	// restores the configured fraction.
+	previous := runtime.SetMutexProfileFraction(3)
+	defer runtime.SetMutexProfileFraction(previous)
+	rec := doRequest(t, handler, http.MethodGet, "/admin/profile?"+"type=mutex&seconds=1", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("mutex: %d %s", rec.Code, rec.Body)
+	}
+	if fraction := runtime.SetMutexProfileFraction(-1); fraction != 3 {
+		t.Fatalf("expected the mutex profile fraction restored to 3, got %d", fraction)
+	}
+	if !bytes.HasPrefix(rec.Body.Bytes(), []byte{0x1f, 0x8b}) {
+		t.Fatalf("mutex: expected a gzipped profile")
+	}
+	mutex, err := profile.Parse(rec.Body)
+	if err != nil {
+		t.Fatalf("decode mutex profile: %v", err)
+	}
+	if mutex.DurationNanos < time.Second.Nanoseconds() {
+		t.Fatalf("expected the profile to cover the window, got %v", time.Duration(mutex.DurationNanos))
+	}
+
+	// Sampled captures take turns.
+	profileMu.Lock()
+	rec = doRequest(t, handler, http.MethodGet, "/admin/profile?"+"type=block&seconds=1", "")
+	profileMu.Unlock()
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected a concurrent capture to conflict, got %d", rec.Code)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	server := newTestServer(t)
+	previous := runtime.SetMutexProfileFraction(5)
+	defer runtime.SetMutexProfileFraction(previous)
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/diagnostics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("diagnostics: %d %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Goroutines           int          `json:"goroutines"`
+		Panics               *int64       `json:"panics"`
+		MutexProfileFraction int          `json:"mutex_profile_fraction"`
+		Store                *store.Stats `json:"store"`
+		Contention           []Contention `json:"contention"`
+	}
+	decoder := json.NewDecoder(rec.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		t.Fatalf("decode diagnostics: %v", err)
+	}
+	if body.Goroutines == 0 || body.Panics == nil || body.Store == nil || body.Contention == nil {
+		t.Fatalf("unexpected diagnostics %+v", body)
+	}
+	if body.MutexProfileFraction != 5 {
+		t.Fatalf("expected mutex profile fraction 5, got %d", body.MutexProfileFraction)
+	}
+	if fraction := runtime.SetMutexProfileFraction(-1); fraction != 5 {
+		t.Fatalf("expected diagnostics to leave the fraction alone, got %d", fraction)
+	}
+	for _, c := range body.Contention {
+		if !strings.HasPrefix(c.Function, storePackagePrefix) {
+			t.Fatalf("expected contention attributed to store functions, got %q", c.Function)
+		}
+	}
+}
+
+func TestSubtractProfile(t *testing.T) {
+	snapshot := func() *profile.Profile {
+		t.Helper()
+		p, err := profileSnapshot("block")
+		if err != nil {
+			t.Fatalf("snapshot: %v", err)
+		}
+		return p
+	}
+
+	before := snapshot()
+	runtime.SetBlockProfileRate(1)
+	blocked := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(blocked)
+	}()
+	<-blocked
+	runtime.SetBlockProfileRate(0)
+	after := snapshot()
+
+	delta, err := subtractProfile(before, after)
+	if err != nil {
+		t.Fatalf("subtract: %v", err)
+	}
+	if len(delta.Sample) == 0 {
+		t.Fatalf("expected the blocking during the window in the delta")
+	}
+	if len(after.Sample) < len(delta.Sample) {
+		t.Fatalf("expected the delta to hold at most the samples of the later profile")
+	}
+	if delta, err = subtractProfile(after, after); err != nil || len(delta.Sample) != 0 {
+		t.Fatalf("expected no samples between equal profiles, got %v, %v", delta, err)
+	}
+
+	// Samples of the same stack with different labels are kept apart.
+	function := &profile.Function{ID: 1, Name: "universe/internal/store.(*Store).Set"}
+	location := &profile.Location{ID: 1, Address: 0x1000, Line: []profile.Line{{Function: function}}}
+	labelled := func(values map[string]int64) *profile.Profile {
+		p := &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "contentions", Unit: "count"}, {Type: "delay", Unit: "nanoseconds"}},
+			PeriodType: &profile.ValueType{Type: "contentions", Unit: "count"},
+			Period:     1,
+			Function:   []*profile.Function{function},
+			Location:   []*profile.Location{location},
+		}
+		for _, label := range []string{"a", "b"} {
+			if value, ok := values[label]; ok {
+				p.Sample = append(p.Sample, &profile.Sample{
+					Location: []*profile.Location{location},
+					Value:    []int64{value, value * 100},
+					Label:    map[string][]string{"request": {label}},
+				})
+			}
+		}
+		return p
+	}
+	delta, err = subtractProfile(labelled(map[string]int64{"a": 5}), labelled(map[string]int64{"a": 5, "b": 3}))
+	if err != nil {
+		t.Fatalf("subtract labelled: %v", err)
+	}
+	if len(delta.Sample) != 1 || delta.Sample[0].Label["request"][0] != "b" || delta.Sample[0].Value[0] != 3 || delta.Sample[0].Value[1] != 300 {
+		t.Fatalf("expected only the b sample's 3 contentions, got %v", delta)
+	}
+}
+
+func TestNextID(t *testing.T) {
+	handler := newTestServer(t).Handler()
+	next := func(target string) (int, map[string]any) {
+		rec := doRequest(t, handler, http.MethodPost, target, "")
+		var body map[string]any
+		_ = json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body
+	}
+
+	if code, body := next("/v1/id/orders"); code != http.StatusOK || body["id"] != float64(1) {
+		t.Fatalf("unexpected first id: %d %v", code, body)
+	}
+	if code, body := next("/v1/id/orders?count=10"); code != http.StatusOK || body["id"] != float64(2) || body["count"] != float64(10) {
+		t.Fatalf("unexpected range: %d %v", code, body)
+	}
+	if code, body := next("/v1/id/orders"); body["id"] != float64(12) {
+		t.Fatalf("expected the id after the range, got %d %v", code, body)
+	}
+	if code, _ := next("/v1/id/orders?count=0"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a zero count, got %d", code)
+	}
+
+	// Clients cannot move the high-water mark back.
+	if rec := doRequest(t, handler, http.MethodPost, "/set/"+url.PathEscape(store.SequencePrefix+"orders"), `{"value":"0"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a write to a sequence mark, got %d %q", rec.Code, rec.Body)
+	}
+	if code, body := next("/v1/id/orders"); body["id"] != float64(13) {
+		t.Fatalf("expected the sequence to continue, got %d %v", code, body)
+	}
+}
+
+func TestServerTiming(t *testing.T) {
+	handler := newTestServer(t).Handler()
+	metrics := func(method, target, body string, debug bool) []string {
+		t.Helper()
+		var headers []string
+		if debug {
+			headers = []string{debugTimingHeader, "1"}
+		}
+		rec := doRequest(t, handler, method, target, body, headers...)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: %d %s", method, target, rec.Code, rec.Body)
+		}
+		var names []string
+		for _, metric := range strings.Split(rec.Header().Get("Server-Timing"), ", ") {
+			if name, dur, ok := strings.Cut(metric, ";dur="); ok && dur != "" {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	if names := metrics(http.MethodPost, "/set/k", `{"value":1}`, true); !reflect.DeepEqual(names, []string{"decode", "store", "lock", "wal", "apply"}) {
+		t.Fatalf("unexpected write phases %v", names)
+	}
+	// Reads never take the write lock or touch the WAL.
+	if names := metrics(http.MethodGet, "/get/k", "", true); !reflect.DeepEqual(names, []string{"store", "encode"}) {
+		t.Fatalf("unexpected read phases %v", names)
+	}
+	if names := metrics(http.MethodPost, "/set/k", `{"value":2}`, false); len(names) != 0 {
+		t.Fatalf("expected no Server-Timing without %s, got %v", debugTimingHeader, names)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	logging.SetHandler(slog.NewTextHandler(&logs, nil))
+	t.Cleanup(func() { logging.SetHandler(nil) })
+
+	handler := newTestServer(t).(*httpServer).Handler()
+	rec := doRequest(t, handler, http.MethodPut, "/v1/kv/a", `1`, requestIDHeader, "trace-42", auditContextHeader, "test")
+	if rec.Code != http.StatusCreated || rec.Header().Get(requestIDHeader) != "trace-42" {
+		t.Fatalf("expected the request id to be echoed, got %d %q", rec.Code, rec.Header().Get(requestIDHeader))
+	}
+	if !strings.Contains(logs.String(), `msg="key set"`) || !strings.Contains(logs.String(), "request_id=trace-42") {
+		t.Fatalf("expected the audit record to carry the request id, got %q", logs.String())
+	}
+
+	// Error responses carry the ID too, and unusable ones are replaced.
+	for _, sent := range []string{"", "has space", strings.Repeat("x", logging.MaxRequestIDLength+1)} {
+		rec = doRequest(t, handler, http.MethodGet, "/v1/kv/missing", "", requestIDHeader, sent, auditContextHeader, "test")
+		got := rec.Header().Get(requestIDHeader)
+		if rec.Code != http.StatusNotFound || len(got) != 32 || got == sent {
+			t.Fatalf("sent %q: expected a generated id on the 404, got %d %q", sent, rec.Code, got)
+		}
+	}
+	if first, second := doRequest(t, handler, http.MethodGet, "/healthz", "", requestIDHeader, "", auditContextHeader, "test"), doRequest(t, handler, http.MethodGet, "/healthz", "", requestIDHeader, "", auditContextHeader, "test"); first.Header().Get(requestIDHeader) == second.Header().Get(requestIDHeader) {
+		t.Fatalf("expected generated ids to differ")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	var logs bytes.Buffer
+	logging.SetHandler(slog.NewJSONHandler(&logs, nil))
+	t.Cleanup(func() { logging.SetHandler(nil) })
+
+	kv, err := store.New(filepath.Join(t.TempDir(), "audit.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+	var actions []events.AdminAction
+	kv.Events().Subscribe(func(e events.Event) {
+		actions = append(actions, e.Payload.(events.AdminAction))
+	}, events.TopicAdmin)
+	handler := NewServerWithOptions(kv, Options{
+		Auth: AuthConfig{Tokens: map[string]string{"ops": "ops-token"}},
+		ACL:  ACLConfig{Enabled: true, Admins: []string{"ops"}},
+	}).Handler()
+	do := func(method, target, body, context string) {
+		t.Helper()
+		rec := doRequest(t, handler, method, target, body, "Authorization", "Bearer ops-token", requestIDHeader, "audit-1", auditContextHeader, context)
+		if rec.Code >= 300 {
+			t.Fatalf("%s %s: %d %s", method, target, rec.Code, rec.Body)
+		}
+	}
+	records := func(msg string) []map[string]any {
+		t.Helper()
+		var found []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var record map[string]any
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("decode log line %q: %v", line, err)
+			}
+			if record["msg"] == msg {
+				found = append(found, record)
+			}
+		}
+		return found
+	}
+
+	do(http.MethodPut, "/v1/kv/a", `1`, "ticket-7")
+	do(http.MethodPut, "/v1/kv/b", `1`, "")
+	set := records("key set")
+	if len(set) != 1 {
+		t.Fatalf("expected only the write with an audit context audited, got %v", set)
+	}
+	for field, want := range map[string]string{"principal": "ops", "request_id": "audit-1", "remote": "192.0.2.1:1234", "context": "ticket-7"} {
+		if set[0][field] != want {
+			t.Fatalf("expected %s %q in the audit record, got %v", field, want, set[0])
+		}
+	}
+
+	do(http.MethodPut, "/v1/kv/c", `1`, strings.Repeat("é", maxAuditContextBytes))
+	if context := records("key set")[1]["context"].(string); len(context) > maxAuditContextBytes || !utf8.ValidString(context) {
+		t.Fatalf("expected the context cut to %d bytes of valid UTF-8, got %d bytes", maxAuditContextBytes, len(context))
+	}
+
+	// Administrative actions are audited and published on the bus.
+	do(http.MethodPut, "/admin/acl/app", `[{"prefix":"app:","permission":"read"}]`, "ticket-8")
+	if granted := records("grants replaced"); len(granted) != 1 || granted[0]["principal"] != "ops" || granted[0]["grantee"] != "app" {
+		t.Fatalf("unexpected grant audit records %v", granted)
+	}
+	if want := []events.AdminAction{{Action: "grants replaced", Principal: "ops", Target: "app"}}; !reflect.DeepEqual(actions, want) {
+		t.Fatalf("expected %+v published, got %+v", want, actions)
+	}
+}
+
+func TestServerOptions(t *testing.T) {
+	kv, err := store.New(filepath.Join(t.TempDir(), "opts.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+
+	server := NewServer(kv,
+		WithConfig(ServerConfig{Port: 9123}),
+		WithAuth(AuthConfig{Tokens: map[string]string{"app": "secret"}}),
+	).(*httpServer)
+	if server.server.Addr != ":9123" {
+		t.Fatalf("expected the configured address, got %q", server.server.Addr)
+	}
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/keys", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected authentication to be required, got %d", rec.Code)
+	}
+}
+
+func TestAsyncAck(t *testing.T) {
+	kv, err := store.New(filepath.Join(t.TempDir(), "async.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+	if rec := doRequest(t, NewServer(kv).Handler(), http.MethodPut, "/v1/kv/a?ack=async", `1`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 while async acks are disabled, got %d", rec.Code)
+	}
+
+	handler := NewServer(kv, WithAsyncAck()).Handler()
+	if rec := doRequest(t, handler, http.MethodPut, "/v1/kv/a?ack=later", `1`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown ack, got %d", rec.Code)
+	}
+	rec := doRequest(t, handler, http.MethodPut, "/v1/kv/a?ack=async", `1`)
+	var accepted WriteAccepted
+	if err := json.NewDecoder(rec.Body).Decode(&accepted); err != nil || rec.Code != http.StatusAccepted || accepted.Token == "" {
+		t.Fatalf("unexpected async put: %d %+v %v", rec.Code, accepted, err)
+	}
+	if location := rec.Header().Get("Location"); location != "/v1/writes/"+accepted.Token {
+		t.Fatalf("unexpected location %q", location)
+	}
+	if err := kv.Sync(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	rec = doRequest(t, handler, http.MethodGet, "/v1/writes/"+accepted.Token, "")
+	var status WriteStatusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil || status.Status != "durable" {
+		t.Fatalf("unexpected write status: %d %+v %v", rec.Code, status, err)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/v1/writes/nope", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown token, got %d", rec.Code)
+	}
+
+	if rec := doRequest(t, handler, http.MethodDelete, "/v1/kv/a?ack=async", ""); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for an async delete, got %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, http.MethodDelete, "/v1/kv/a?ack=async", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an async delete of a missing key, got %d", rec.Code)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	handler := newTestServer(t).Handler()
+	rec := doRequest(t, handler, http.MethodPut, "/v1/kv/a", `1`)
+	token := rec.Header().Get(sessionHeader)
+	if rec.Code != http.StatusCreated || token == "" {
+		t.Fatalf("expected a session token from the write, got %d %q", rec.Code, token)
+	}
+	rec = doRequest(t, handler, http.MethodGet, "/v1/kv/a", "", sessionHeader, token)
+	if rec.Code != http.StatusOK || rec.Header().Get(sessionHeader) != token {
+		t.Fatalf("expected the read to echo the token, got %d %q", rec.Code, rec.Header().Get(sessionHeader))
+	}
+	rec = doRequest(t, handler, http.MethodPut, "/v1/kv/b", `2`, sessionHeader, token)
+	if next := rec.Header().Get(sessionHeader); next == "" || next == token {
+		t.Fatalf("expected the write to advance the token, got %q after %q", next, token)
+	}
+	if rec := doRequest(t, handler, http.MethodPut, "/v1/kv/c?ttl=bad", `3`, sessionHeader, token); rec.Header().Get(sessionHeader) != token {
+		t.Fatalf("expected a failed write to keep the token, got %q", rec.Header().Get(sessionHeader))
+	}
+
+	if rec := doRequest(t, handler, http.MethodGet, "/v1/kv/a", "", sessionHeader, "99"); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a session ahead of the store, got %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, http.MethodGet, "/v1/kv/a", "", sessionHeader, "bad"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a malformed token, got %d", rec.Code)
+	}
+}
+
+func TestRelocateHandler(t *testing.T) {
+	handler := newTestServer(t).Handler()
+	if rec := doRequest(t, handler, http.MethodPost, "/admin/relocate", `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a dir, got %d", rec.Code)
+	}
+	dir := filepath.Join(t.TempDir(), "moved")
+	target, _ := json.Marshal(RelocateRequest{Dir: dir})
+	rec := doRequest(t, handler, http.MethodPost, "/admin/relocate", string(target))
+	var result RelocateResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil || rec.Code != http.StatusOK || result.To != filepath.Join(dir, "http.wal") {
+		t.Fatalf("unexpected relocation: %d %+v %v", rec.Code, result, err)
+	}
+	if rec := doRequest(t, handler, http.MethodPost, "/admin/relocate", string(target)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for the current directory, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,40 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// maxIDCount caps the IDs one request can take.
+const maxIDCount = 1000
+
+// @Summary Issue unique IDs
+// @Description Issue count consecutive IDs, starting at the returned id, from the named sequence. IDs increase monotonically, also across restarts, but may have gaps.
+// @Tags kv
+// @Produce json
+// @Param sequence path string true "Sequence name"
+// @Param count query int false "How many IDs to issue (default 1, max 1000)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "invalid count"
+// @Failure 503 {string} string "reservation could not be persisted"
+// @Router /v1/id/{sequence} [post]
+func (s *httpServer) NextID(w http.ResponseWriter, r *http.Request) {
+	count := 1
+	if raw := r.URL.Query().Get("count"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 || parsed > maxIDCount {
+			http.Error(w, fmt.Sprintf("count must be between 1 and %d", maxIDCount), http.StatusBadRequest)
+			return
+		}
+		count = parsed
+	}
+
+	id, err := s.store.NextIDs(r.PathValue("sequence"), count)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "id": id, "count": count})
+}
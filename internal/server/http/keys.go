@@ -0,0 +1,66 @@
+package http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+const (
+	defaultKeysLimit = 100
+	maxKeysLimit     = 1000
+)
+
+// KeysResponse is one page of a key listing. Next is empty on the last page.
+type KeysResponse struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty"`
+}
+
+// @Summary List keys
+// @Description List keys in lexical order, one page at a time. Pass the returned next token as cursor to fetch the following page.
+// @Tags kv
+// @Produce json
+// @Param prefix query string false "Only list keys with this prefix"
+// @Param limit query int false "Page size (default 100, max 1000)"
+// @Param cursor query string false "Continuation token from the previous page"
+// @Success 200 {object} KeysResponse
+// @Failure 400 {string} string "invalid listing request"
+// @Router /keys [get]
+func (s *httpServer) Keys(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := defaultKeysLimit
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 || parsed > maxKeysLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxKeysLimit), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	// The cursor is the last key of the previous page, encoded so that
+	// clients treat it as opaque.
+	after, err := base64.RawURLEncoding.DecodeString(query.Get("cursor"))
+	if err != nil {
+		http.Error(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+
+	keys, more := s.store.Keys(query.Get("prefix"), string(after), limit)
+
+	response := KeysResponse{Keys: keys}
+	if more {
+		response.Next = base64.RawURLEncoding.EncodeToString([]byte(keys[len(keys)-1]))
+	}
+	if s.hidesSystem(r) {
+		// The cursor still continues after the last key read, so a page
+		// may come back short or even empty with a next token.
+		response.Keys = slices.DeleteFunc(keys, isSystemKey)
+	}
+	json.NewEncoder(w).Encode(response)
+}
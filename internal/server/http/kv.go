@@ -0,0 +1,258 @@
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	contentTypeJSON  = "application/json"
+	contentTypeBytes = "application/octet-stream"
+)
+
+// accepts reports whether the Accept header value allows mediaType. An
+// empty header accepts anything.
+func accepts(header, mediaType string) bool {
+	if header == "" {
+		return true
+	}
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, item := range strings.Split(header, ",") {
+		accepted, _, _ := strings.Cut(item, ";")
+		switch strings.TrimSpace(accepted) {
+		case mediaType, major + "/*", "*/*":
+			return true
+		}
+	}
+	return false
+}
+
+// responseType picks the content type to return value as: JSON when it is
+// valid JSON and the client accepts that, raw bytes otherwise. It returns ""
+// when the client accepts neither.
+func responseType(r *http.Request, value []byte) string {
+	accept := r.Header.Get("Accept")
+	if json.Valid(value) && accepts(accept, contentTypeJSON) {
+		return contentTypeJSON
+	}
+	if accepts(accept, contentTypeBytes) {
+		return contentTypeBytes
+	}
+	return ""
+}
+
+// readValue reads the value of a PUT: the body verbatim when it is sent as
+// application/octet-stream, and otherwise a JSON document, stored in its
+// compact encoding.
+func readValue(r *http.Request) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == contentTypeBytes {
+		return io.ReadAll(r.Body)
+	}
+
+	var value any
+	if err := json.NewDecoder(r.Body).Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// etag is the entity tag of a value written at revision.
+func etag(revision uint64) string {
+	return `"` + strconv.FormatUint(revision, 10) + `"`
+}
+
+// @Summary Put a key
+// @Description Store the request body as the value of key: verbatim when sent as application/octet-stream, otherwise as a JSON document. Keys may contain slashes.
+// @Tags kv
+// @Accept json
+// @Accept octet-stream
+// @Produce json
+// @Param key path string true "Key"
+// @Param value body object true "Value"
+// @Param ttl query string false "Expire the key after this duration, e.g. 90s or 10m; a plain number is seconds"
+// @Param ack query string false "async to be answered once the write is journaled, before it is durable; needs the server's async-ack mode"
+// @Param X-Audit-Context header string false "Opaque caller context recorded in the audit log"
+// @Param X-Debug-Timing header string false "Return a Server-Timing breakdown when set"
+// @Success 200 {object} map[string]interface{} "replaced"
+// @Success 201 {object} map[string]interface{} "created"
+// @Success 202 {object} WriteAccepted "journaled; confirm with the token"
+// @Failure 400 {string} string "invalid value, ttl or ack"
+// @Failure 413 {string} string "request body too large"
+// @Failure 421 {string} string "key not served by this node"
+// @Failure 503 {string} string "write could not be persisted"
+// @Router /v1/kv/{key} [put]
+func (s *httpServer) PutKey(w http.ResponseWriter, r *http.Request) {
+	timing := newServerTiming(r)
+	start := time.Now()
+
+	value, err := readValue(r)
+	if err != nil {
+		writeBodyError(w, err, "invalid value")
+		return
+	}
+	ttl, err := parseTTL(r.URL.Query().Get("ttl"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	async, ok := s.asyncAck(w, r)
+	if !ok {
+		return
+	}
+	start = timing.since("decode", start)
+
+	key := r.PathValue("key")
+	if async {
+		token, err := s.store.SetAsync(r.Context(), key, value, ttl, timing.storeTiming())
+		timing.since("store", start)
+		timing.writeHeader(w, true)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		auditMutation(r, "key set", "key", key, "ttl", ttl, "token", token)
+		writeAccepted(w, token)
+		return
+	}
+
+	// Whether the key existed only decides between 200 and 201, so a
+	// concurrent write slipping in between is harmless.
+	_, _, existed := s.store.GetVersion(key)
+	if ttl > 0 {
+		err = s.store.SetWithTTLTimed(r.Context(), key, value, ttl, timing.storeTiming())
+	} else {
+		err = s.store.SetTimed(r.Context(), key, value, timing.storeTiming())
+	}
+	timing.since("store", start)
+	timing.writeHeader(w, true)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	auditMutation(r, "key set", "key", key, "ttl", ttl)
+
+	status := http.StatusOK
+	if !existed {
+		status = http.StatusCreated
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+}
+
+// @Summary Get a key
+// @Description Return the value of key as the response body: as application/json when it is valid JSON and accepted, otherwise verbatim as application/octet-stream. The ETag is the revision the key was last written at; a matching If-None-Match gets 304. HEAD returns the headers only.
+// @Tags kv
+// @Produce json
+// @Produce octet-stream
+// @Param key path string true "Key"
+// @Param rev query int false "Return the value as of this revision"
+// @Param If-None-Match header string false "ETag from an earlier response"
+// @Param X-Debug-Timing header string false "Return a Server-Timing breakdown when set"
+// @Success 200 {object} object "the value"
+// @Success 304 "not modified"
+// @Failure 400 {string} string "invalid or future revision"
+// @Failure 404 {string} string "key not found"
+// @Failure 406 {string} string "value is not JSON and raw bytes are not accepted"
+// @Failure 410 {string} string "revision compacted"
+// @Router /v1/kv/{key} [get]
+func (s *httpServer) GetKey(w http.ResponseWriter, r *http.Request) {
+	timing := newServerTiming(r)
+	start := time.Now()
+
+	value, revision, ok, err := s.lookup(r, r.PathValue("key"))
+	timing.since("store", start)
+	timing.writeHeader(w, false)
+	if err != nil {
+		writeLookupError(w, err)
+		return
+	}
+	if !ok {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+
+	contentType := responseType(r, value)
+	if contentType == "" {
+		http.Error(w, "value is not JSON; accept "+contentTypeBytes, http.StatusNotAcceptable)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept")
+	if revision != 0 {
+		tag := etag(revision)
+		w.Header().Set("ETag", tag)
+		if r.Header.Get("If-None-Match") == tag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if contentType == contentTypeJSON {
+		value = append(value, '\n')
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	w.Write(value)
+}
+
+// @Summary Delete a key
+// @Description Delete key.
+// @Tags kv
+// @Param key path string true "Key"
+// @Param ack query string false "async to be answered once the delete is journaled, before it is durable; needs the server's async-ack mode"
+// @Param X-Audit-Context header string false "Opaque caller context recorded in the audit log"
+// @Param X-Debug-Timing header string false "Return a Server-Timing breakdown when set"
+// @Success 202 {object} WriteAccepted "journaled; confirm with the token"
+// @Success 204 "deleted"
+// @Failure 400 {string} string "invalid ack"
+// @Failure 404 {string} string "key not found"
+// @Failure 421 {string} string "key not served by this node"
+// @Failure 503 {string} string "write could not be persisted"
+// @Router /v1/kv/{key} [delete]
+func (s *httpServer) DeleteKey(w http.ResponseWriter, r *http.Request) {
+	timing := newServerTiming(r)
+	start := time.Now()
+
+	async, ok := s.asyncAck(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue("key")
+	var token string
+	var existed bool
+	var err error
+	if async {
+		token, existed, err = s.store.DeleteAsync(r.Context(), key, timing.storeTiming())
+	} else {
+		existed, err = s.store.DeleteTimed(r.Context(), key, timing.storeTiming())
+	}
+	timing.since("store", start)
+	timing.writeHeader(w, true)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	audit := []any{"key", key, "existed", existed}
+	if async {
+		audit = append(audit, "token", token)
+	}
+	auditMutation(r, "key deleted", audit...)
+
+	// Deleting a missing key changes nothing, so there is no write to
+	// confirm.
+	if !existed {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+	if async {
+		writeAccepted(w, token)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,240 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLockTTL = 30 * time.Second
+	maxLockTTL     = time.Hour
+)
+
+// LockRequest acquires or refreshes a lock. Token is required to refresh.
+type LockRequest struct {
+	Owner      string `json:"owner"`
+	Token      uint64 `json:"token,omitempty"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+}
+
+// LockInfo describes the current holder of a lock.
+type LockInfo struct {
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner"`
+	Token      uint64    `json:"token"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// lockTable holds the coordination locks. Locks live in memory only and are
+// released automatically when their TTL passes without a refresh.
+//
+// Fencing tokens increase with every acquisition. The counter starts at the
+// current Unix time in nanoseconds, so tokens keep increasing across
+// restarts as long as the clock does not go backwards.
+type lockTable struct {
+	mu        sync.Mutex
+	locks     map[string]*LockInfo
+	lastToken uint64
+	now       func() time.Time
+}
+
+func newLockTable() *lockTable {
+	return &lockTable{
+		locks:     make(map[string]*LockInfo),
+		lastToken: uint64(time.Now().UnixNano()),
+		now:       time.Now,
+	}
+}
+
+// get returns the live lock called name. Expired locks are dropped.
+func (t *lockTable) get(name string) (*LockInfo, bool) {
+	lock, ok := t.locks[name]
+	if !ok {
+		return nil, false
+	}
+	if !t.now().Before(lock.ExpiresAt) {
+		delete(t.locks, name)
+		return nil, false
+	}
+	return lock, true
+}
+
+// acquire grants the lock to owner unless someone else holds it, in which
+// case the current holder is returned with ok set to false.
+func (t *lockTable) acquire(name, owner string, ttl time.Duration) (LockInfo, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if lock, held := t.get(name); held {
+		return *lock, false
+	}
+
+	t.lastToken++
+	now := t.now()
+	lock := &LockInfo{Name: name, Owner: owner, Token: t.lastToken, AcquiredAt: now, ExpiresAt: now.Add(ttl)}
+	t.locks[name] = lock
+	return *lock, true
+}
+
+// refresh extends the lock if token still holds it.
+func (t *lockTable) refresh(name string, token uint64, ttl time.Duration) (LockInfo, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lock, held := t.get(name)
+	if !held || lock.Token != token {
+		return LockInfo{}, false
+	}
+	lock.ExpiresAt = t.now().Add(ttl)
+	return *lock, true
+}
+
+// release frees the lock if token still holds it.
+func (t *lockTable) release(name string, token uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lock, held := t.get(name)
+	if !held || lock.Token != token {
+		return false
+	}
+	delete(t.locks, name)
+	return true
+}
+
+func (t *lockTable) lookup(name string) (LockInfo, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lock, held := t.get(name)
+	if !held {
+		return LockInfo{}, false
+	}
+	return *lock, true
+}
+
+// decodeLockRequest decodes the body of a lock request and its TTL,
+// answering the request and returning false if either is invalid.
+func decodeLockRequest(w http.ResponseWriter, r *http.Request) (LockRequest, time.Duration, bool) {
+	var req LockRequest
+	if !decodeJSON(w, r, &req, "invalid json") {
+		return req, 0, false
+	}
+
+	ttl := defaultLockTTL
+	if req.TTLSeconds != 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+		if ttl <= 0 || ttl > maxLockTTL {
+			http.Error(w, fmt.Sprintf("ttl_seconds must be between 1 and %d", int(maxLockTTL.Seconds())), http.StatusBadRequest)
+			return req, 0, false
+		}
+	}
+	return req, ttl, true
+}
+
+// @Summary Acquire a lock
+// @Description Acquire the named lock for owner. The lock is released automatically after ttl_seconds (default 30, max 3600) unless refreshed. The returned fencing token increases with every acquisition; pass it to downstream systems to reject stale holders.
+// @Tags locks
+// @Accept json
+// @Produce json
+// @Param name path string true "Lock name"
+// @Param request body LockRequest true "Owner and TTL"
+// @Param X-Audit-Context header string false "Opaque caller context recorded in the audit log"
+// @Success 200 {object} LockInfo
+// @Failure 400 {string} string "invalid lock request"
+// @Failure 409 {object} LockInfo "lock held by another owner"
+// @Failure 413 {string} string "request body too large"
+// @Router /v1/lock/{name} [post]
+func (s *httpServer) AcquireLock(w http.ResponseWriter, r *http.Request) {
+	req, ttl, ok := decodeLockRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Owner == "" {
+		http.Error(w, "owner must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	lock, ok := s.locks.acquire(r.PathValue("name"), req.Owner, ttl)
+	if ok {
+		auditMutation(r, "lock acquired", "lock", lock.Name, "owner", lock.Owner, "token", lock.Token)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(w).Encode(lock)
+}
+
+// @Summary Refresh a lock
+// @Description Extend the named lock by ttl_seconds. Fails with 409 if the token no longer holds the lock.
+// @Tags locks
+// @Accept json
+// @Produce json
+// @Param name path string true "Lock name"
+// @Param request body LockRequest true "Token and TTL"
+// @Success 200 {object} LockInfo
+// @Failure 400 {string} string "invalid lock request"
+// @Failure 409 {string} string "lock not held"
+// @Failure 413 {string} string "request body too large"
+// @Router /v1/lock/{name} [put]
+func (s *httpServer) RefreshLock(w http.ResponseWriter, r *http.Request) {
+	req, ttl, ok := decodeLockRequest(w, r)
+	if !ok {
+		return
+	}
+
+	lock, ok := s.locks.refresh(r.PathValue("name"), req.Token, ttl)
+	if !ok {
+		http.Error(w, "lock not held", http.StatusConflict)
+		return
+	}
+	json.NewEncoder(w).Encode(lock)
+}
+
+// @Summary Release a lock
+// @Description Release the named lock. Fails with 409 if the token no longer holds the lock.
+// @Tags locks
+// @Produce json
+// @Param name path string true "Lock name"
+// @Param token query int true "Fencing token returned on acquire"
+// @Param X-Audit-Context header string false "Opaque caller context recorded in the audit log"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "invalid token"
+// @Failure 409 {string} string "lock not held"
+// @Router /v1/lock/{name} [delete]
+func (s *httpServer) ReleaseLock(w http.ResponseWriter, r *http.Request) {
+	token, err := strconv.ParseUint(r.URL.Query().Get("token"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusBadRequest)
+		return
+	}
+
+	if !s.locks.release(r.PathValue("name"), token) {
+		http.Error(w, "lock not held", http.StatusConflict)
+		return
+	}
+	auditMutation(r, "lock released", "lock", r.PathValue("name"), "token", token)
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+}
+
+// @Summary Inspect a lock
+// @Description Return the current holder of the named lock.
+// @Tags locks
+// @Produce json
+// @Param name path string true "Lock name"
+// @Success 200 {object} LockInfo
+// @Failure 404 {string} string "lock not held"
+// @Router /v1/lock/{name} [get]
+func (s *httpServer) GetLock(w http.ResponseWriter, r *http.Request) {
+	lock, ok := s.locks.lookup(r.PathValue("name"))
+	if !ok {
+		http.Error(w, "lock not held", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(lock)
+}
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"universe/internal/logging"
+	"universe/internal/panics"
+)
+
+// requestIDHeader carries the ID that correlates a request with the log
+// lines it causes. The ID a client sends is kept, and one is generated
+// otherwise; either way it is echoed on the response.
+const requestIDHeader = "X-Request-ID"
+
+// requestID honors or generates the request's X-Request-ID, sets it on the
+// response, including error responses, and puts it on the request context
+// so that lines logged with the context carry it.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// recoverMiddleware answers panicking requests with 500 and hands the panic
+// to the process-wide panic policy.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			if value == http.ErrAbortHandler {
+				panic(value)
+			}
+
+			panics.Handle("http "+r.Method+" "+r.URL.Path+" request "+logging.RequestID(r.Context()), value)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody rejects request bodies larger than max bytes with 413. Bodies of
+// unknown length are cut off at max, failing the handler's read. A max of
+// zero disables the limit.
+func limitBody(max int64, next http.Handler) http.Handler {
+	if max <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			http.Error(w, fmt.Sprintf("request body larger than %d bytes", max), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
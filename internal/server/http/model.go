@@ -7,6 +7,9 @@ type SetRequest struct {
 
 type SetBody struct {
 	Value any `json:"value"`
+	// TTL expires the key after a duration such as "90s" or "10m"; a plain
+	// number is read as seconds.
+	TTL string `json:"ttl,omitempty"`
 }
 
 type GetRequest struct {
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"universe/internal/store"
+)
+
+// probePaths are answered without authentication, since orchestrators
+// probe them without credentials.
+var probePaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// whileRecovering answers everything but the probes with 503 until the
+// store has recovered, since the store must not be used before.
+func whileRecovering(kv *store.Store, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !probePaths[r.URL.Path] && errors.Is(kv.Ready(), store.ErrRecovering) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, store.ErrRecovering.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// @Summary Liveness probe
+// @Description Report that the process is up and serving HTTP. It does not check the store.
+// @Tags health
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /healthz [get]
+func (s *httpServer) Healthz(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+}
+
+// @Summary Readiness probe
+// @Description Report whether the node should receive traffic: the store has replayed its WAL, the WAL accepts writes and the server is not shutting down. While the WAL is replayed the body reports the replay's progress under recovery.
+// @Tags health
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /readyz [get]
+func (s *httpServer) Readyz(w http.ResponseWriter, r *http.Request) {
+	body := map[string]any{}
+	if s.streams.Err() != nil {
+		body["reason"] = "shutting down"
+	} else if err := s.store.Ready(); err != nil {
+		body["reason"] = err.Error()
+		if errors.Is(err, store.ErrRecovering) {
+			p := s.store.RecoveryProgress()
+			body["recovery"] = map[string]any{
+				"entries":     p.Entries,
+				"bytes":       p.Bytes,
+				"total_bytes": p.TotalBytes,
+				"percent":     p.Percent(),
+				"eta_ms":      p.ETA().Milliseconds(),
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(body) > 0 {
+		body["status"] = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+}
@@ -0,0 +1,47 @@
+package http
+
+import (
+	"bytes"
+	"io"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// profileMu serializes the sampled profile captures, which change the
+// process-wide profiling rates and would restore each other's.
+var profileMu sync.Mutex
+
+// profileSnapshot returns the named profile.
+func profileSnapshot(name string) (*profile.Profile, error) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup(name).WriteTo(&buf, 0); err != nil {
+		return nil, err
+	}
+	return profile.Parse(&buf)
+}
+
+// writeProfileDelta writes the profile after less the samples of before,
+// covering the window from start, gzipped like pprof writes profiles.
+// block and mutex profiles count from process start, so this is what
+// limits them to the sampled window, as net/http/pprof does.
+func writeProfileDelta(w io.Writer, before, after *profile.Profile, start time.Time, window time.Duration) error {
+	delta, err := subtractProfile(before, after)
+	if err != nil {
+		return err
+	}
+	delta.TimeNanos = start.UnixNano()
+	delta.DurationNanos = window.Nanoseconds()
+	return delta.Write(w)
+}
+
+// subtractProfile returns after with the values of the matching samples of
+// before subtracted and the samples left with nothing dropped. Samples
+// match when their stacks and labels do.
+func subtractProfile(before, after *profile.Profile) (*profile.Profile, error) {
+	base := before.Copy()
+	base.Scale(-1)
+	return profile.Merge([]*profile.Profile{base, after})
+}
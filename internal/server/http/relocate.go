@@ -0,0 +1,79 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"universe/internal/store"
+)
+
+// RelocateRequest names the directory to move the data to.
+type RelocateRequest struct {
+	Dir string `json:"dir"`
+}
+
+// RelocateResponse reports a finished relocation.
+type RelocateResponse struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+	// PauseMS is how long writes were held up.
+	PauseMS   int64 `json:"pause_ms"`
+	ElapsedMS int64 `json:"elapsed_ms"`
+}
+
+// @Summary Move the data directory
+// @Description Move the WAL, checkpoint and manifest to another directory, e.g. on a new volume, while serving. Sealed files are copied first; writes pause only while the active segment is copied and appends switch over. The old path is left with a redirect to the new one, but the configured path should be updated before the next restart.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body RelocateRequest true "Target directory"
+// @Param X-Audit-Context header string false "Opaque caller context recorded in the audit log"
+// @Success 200 {object} RelocateResponse
+// @Failure 400 {string} string "invalid target directory"
+// @Failure 409 {string} string "relocation already in progress"
+// @Failure 500 {string} string "relocation failed; the data stays where it was"
+// @Failure 503 {string} string "store closed or failing writes"
+// @Failure 413 {string} string "request body too large"
+// @Router /admin/relocate [post]
+func (s *httpServer) Relocate(w http.ResponseWriter, r *http.Request) {
+	var req RelocateRequest
+	if !decodeJSON(w, r, &req, "invalid json") {
+		return
+	}
+	if req.Dir == "" {
+		http.Error(w, "body must name the target dir", http.StatusBadRequest)
+		return
+	}
+
+	auditLogger.InfoContext(r.Context(), "relocation requested", "dir", req.Dir, "remote", r.RemoteAddr)
+	result, err := s.store.Relocate(r.Context(), req.Dir)
+	switch {
+	case errors.Is(err, store.ErrRelocating):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, store.ErrRelocationTarget):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, store.ErrClosed), errors.Is(err, store.ErrWriteFailed):
+		writeStoreError(w, r, err)
+		return
+	case err != nil:
+		logger.ErrorContext(r.Context(), "relocation failed", "dir", req.Dir, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auditMutation(r, "data relocated", "from", result.From, "to", result.To)
+	s.publishAdmin(r, "data relocated", result.To)
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(RelocateResponse{
+		From:      result.From,
+		To:        result.To,
+		Files:     result.Files,
+		Bytes:     result.Bytes,
+		PauseMS:   result.Pause.Milliseconds(),
+		ElapsedMS: result.Elapsed.Milliseconds(),
+	})
+}
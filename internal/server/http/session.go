@@ -0,0 +1,77 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"universe/internal/store"
+)
+
+// sessionHeader carries a client session's token. Requests sending it are
+// refused with 409 unless the store reflects the session's writes, and
+// successful writes answer with the token advanced past them, so a client
+// that keeps sending the latest token it got reads its own writes.
+const sessionHeader = "X-Session-Token"
+
+// sessions enforces and issues the session tokens of sessionHeader.
+func sessions(kv *store.Store, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(sessionHeader)
+		if token != "" {
+			if err := kv.CheckSession(token); err != nil {
+				if errors.Is(err, store.ErrSessionAhead) {
+					http.Error(w, err.Error(), http.StatusConflict)
+				} else {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				}
+				return
+			}
+		}
+		write := r.Method != http.MethodGet && r.Method != http.MethodHead
+		next.ServeHTTP(&sessionWriter{ResponseWriter: w, store: kv, token: token, write: write}, r)
+	})
+}
+
+// sessionWriter sets the session token on the response when its header is
+// written: advanced for a successful write, echoed otherwise.
+type sessionWriter struct {
+	http.ResponseWriter
+	store       *store.Store
+	token       string
+	write       bool
+	wroteHeader bool
+}
+
+func (s *sessionWriter) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.wroteHeader = true
+		token := s.token
+		if s.write && status < http.StatusBadRequest {
+			token = s.store.SessionToken(token)
+		}
+		if token != "" {
+			s.Header().Set(sessionHeader, token)
+		}
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *sessionWriter) Write(p []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *sessionWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *sessionWriter) Flush() {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,63 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"universe/internal/store"
+)
+
+// debugTimingHeader opts a request into a Server-Timing response header.
+const debugTimingHeader = "X-Debug-Timing"
+
+// serverTiming collects Server-Timing metrics for one request. A nil
+// *serverTiming is valid and records nothing.
+type serverTiming struct {
+	metrics []string
+	store   store.Timing
+}
+
+func newServerTiming(r *http.Request) *serverTiming {
+	if r.Header.Get(debugTimingHeader) == "" {
+		return nil
+	}
+	return &serverTiming{}
+}
+
+// since records the time elapsed since start under name and returns now.
+func (t *serverTiming) since(name string, start time.Time) time.Time {
+	now := time.Now()
+	if t != nil {
+		t.add(name, now.Sub(start))
+	}
+	return now
+}
+
+func (t *serverTiming) add(name string, d time.Duration) {
+	t.metrics = append(t.metrics, fmt.Sprintf("%s;dur=%.3f", name, float64(d)/float64(time.Millisecond)))
+}
+
+// storeTiming returns the breakdown to pass to the store's *Timed calls.
+func (t *serverTiming) storeTiming() *store.Timing {
+	if t == nil {
+		return nil
+	}
+	return &t.store
+}
+
+// writeHeader adds the collected metrics, including the store breakdown, to w.
+func (t *serverTiming) writeHeader(w http.ResponseWriter, withStore bool) {
+	if t == nil {
+		return
+	}
+	if withStore {
+		t.add("lock", t.store.LockWait)
+		t.add("wal", t.store.WALAppend)
+		t.add("apply", t.store.Apply)
+		if t.store.Sync > 0 {
+			t.add("sync", t.store.Sync)
+		}
+	}
+	w.Header().Set("Server-Timing", strings.Join(t.metrics, ", "))
+}
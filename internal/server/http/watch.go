@@ -0,0 +1,146 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"universe/internal/store"
+)
+
+// watchKeepAlive is how often an idle watch stream sends a comment so that
+// proxies do not time it out.
+const watchKeepAlive = 15 * time.Second
+
+// WatchEvent is the data of one server-sent event on a watch stream.
+type WatchEvent struct {
+	Type     string `json:"type"`
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	Revision uint64 `json:"revision"`
+}
+
+// HistoryLostEvent is the data of the history-lost event that ends a watch
+// resumed from a compacted revision. Re-read the keys at Revision or later
+// and watch again from there.
+type HistoryLostEvent struct {
+	Compacted uint64 `json:"compacted_revision"`
+	Revision  uint64 `json:"revision"`
+}
+
+// @Summary Watch keys
+// @Description Stream changes to keys with the given prefix as server-sent events. Each event is named after the change (set or delete), carries the revision as its id and a WatchEvent as data. The stream ends if the client falls too far behind; resume it by passing the last id seen as rev or in the Last-Event-ID header. If the changes since that revision have been compacted, the stream sends a single history-lost event carrying a HistoryLostEvent and ends; re-read the keys before watching again.
+// @Tags kv
+// @Produce text/event-stream
+// @Param prefix path string false "Key prefix; empty watches every key"
+// @Param rev query int false "Resume after this revision"
+// @Param Last-Event-ID header int false "Resume after this revision, as sent by EventSource on reconnect"
+// @Success 200 {object} WatchEvent
+// @Failure 400 {string} string "invalid revision"
+// @Failure 500 {string} string "streaming unsupported"
+// @Router /watch/{prefix} [get]
+func (s *httpServer) Watch(w http.ResponseWriter, r *http.Request) {
+	prefix := r.PathValue("prefix")
+
+	var lost *store.HistoryLostError
+	events, stop, err := s.startWatch(r, prefix)
+	switch {
+	case errors.As(err, &lost):
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	default:
+		defer stop()
+	}
+
+	rc, ok := openStream(w, r)
+	if !ok {
+		return
+	}
+
+	if lost != nil {
+		logger.InfoContext(r.Context(), "watch history lost", "prefix", prefix, "requested", lost.Requested, "compacted", lost.Compacted)
+		data, _ := json.Marshal(HistoryLostEvent{Compacted: lost.Compacted, Revision: lost.Revision})
+		fmt.Fprintf(w, "event: history-lost\ndata: %s\n\n", data)
+		rc.Flush()
+		return
+	}
+
+	hideSystem := s.hidesSystem(r)
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streams.Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				logger.InfoContext(r.Context(), "watch ended by store", "prefix", prefix)
+				return
+			}
+			if hideSystem && isSystemKey(event.Key) {
+				continue
+			}
+			if s.chaos.dropEvent(r) {
+				continue
+			}
+			data, _ := json.Marshal(WatchEvent{
+				Type:     string(event.Type),
+				Key:      event.Key,
+				Value:    string(event.Value),
+				Revision: event.Revision,
+			})
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Revision, event.Type, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// openStream starts a server-sent event stream on w, reporting false if w
+// cannot stream.
+func openStream(w http.ResponseWriter, r *http.Request) (*http.ResponseController, bool) {
+	// The stream outlives the server's read and write timeouts.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		logger.WarnContext(r.Context(), "event stream unsupported", "path", r.URL.Path, "error", err)
+		return nil, false
+	}
+	return rc, true
+}
+
+// startWatch watches prefix, resuming after the revision in the rev query
+// parameter or the Last-Event-ID header if either is set.
+func (s *httpServer) startWatch(r *http.Request, prefix string) (<-chan store.Event, func(), error) {
+	resume := r.URL.Query().Get("rev")
+	if resume == "" {
+		resume = r.Header.Get("Last-Event-ID")
+	}
+	if resume == "" {
+		events, stop := s.store.Watch(prefix)
+		return events, stop, nil
+	}
+
+	revision, err := strconv.ParseUint(resume, 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid revision %q", resume)
+	}
+	return s.store.WatchFrom(prefix, revision)
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"universe/internal/store"
+)
+
+// WriteAccepted answers a write acknowledged before it was durable.
+type WriteAccepted struct {
+	Status string `json:"status"`
+	// Token is passed to GET /v1/writes/{token} to confirm durability.
+	Token string `json:"token"`
+}
+
+// WriteStatusResponse reports the progress of an asynchronously
+// acknowledged write: pending, durable or failed.
+type WriteStatusResponse struct {
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+// asyncAck reports whether the request asks, with ack=async, to be answered
+// once its write is journaled rather than durable. It answers the request
+// with 400 and returns ok false when the mode is disabled or ack is not
+// async or sync.
+func (s *httpServer) asyncAck(w http.ResponseWriter, r *http.Request) (async, ok bool) {
+	switch r.URL.Query().Get("ack") {
+	case "", "sync":
+		return false, true
+	case "async":
+		if !s.asyncAcks {
+			http.Error(w, "async acknowledgment is disabled", http.StatusBadRequest)
+			return false, false
+		}
+		return true, true
+	default:
+		http.Error(w, "ack must be sync or async", http.StatusBadRequest)
+		return false, false
+	}
+}
+
+// writeAccepted answers an asynchronously acknowledged write with 202 and
+// its token.
+func writeAccepted(w http.ResponseWriter, token string) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Location", "/v1/writes/"+url.PathEscape(token))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(WriteAccepted{Status: "accepted", Token: token})
+}
+
+// @Summary Check a write
+// @Description Report whether a write acknowledged with ack=async is still pending, durable on disk or failed. Tokens do not survive a restart; after one, read the key back instead.
+// @Tags kv
+// @Produce json
+// @Param token path string true "Token returned by the write"
+// @Success 200 {object} WriteStatusResponse
+// @Failure 404 {string} string "unknown token"
+// @Router /v1/writes/{token} [get]
+func (s *httpServer) WriteStatus(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	state, err := s.store.WriteStatus(token)
+	if errors.Is(err, store.ErrUnknownToken) {
+		http.Error(w, "unknown token", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(WriteStatusResponse{Token: token, Status: state.String()})
+}
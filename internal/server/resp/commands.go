@@ -0,0 +1,257 @@
+package resp
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"universe/internal/logging"
+	"universe/internal/store"
+)
+
+// command is one supported command. arity counts the command name like
+// Redis does: positive is exact, negative a minimum.
+type command struct {
+	arity int
+	run   func(s *respServer, ctx context.Context, w replyWriter, args [][]byte)
+}
+
+var commands = map[string]command{
+	"PING":    {-1, (*respServer).ping},
+	"ECHO":    {2, (*respServer).echo},
+	"SELECT":  {2, (*respServer).selectDB},
+	"COMMAND": {-1, (*respServer).command},
+	"GET":     {2, (*respServer).get},
+	"SET":     {-3, (*respServer).set},
+	"DEL":     {-2, (*respServer).del},
+	"EXISTS":  {-2, (*respServer).exists},
+	"TTL":     {2, (*respServer).ttl},
+	"PTTL":    {2, (*respServer).pttl},
+	"EXPIRE":  {3, (*respServer).expire},
+	"KEYS":    {2, (*respServer).keys},
+}
+
+// dispatch runs the command in args and reports whether the client asked
+// to close the connection.
+func (s *respServer) dispatch(w replyWriter, args [][]byte) (quit bool) {
+	name := strings.ToUpper(string(args[0]))
+	if name == "QUIT" {
+		w.status("OK")
+		return true
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		w.error("ERR unknown command '" + string(args[0]) + "'")
+		return false
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		w.error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return false
+	}
+
+	// Redis answers LOADING while it loads its data set, too.
+	if err := s.store.Ready(); errors.Is(err, store.ErrRecovering) {
+		w.error("LOADING " + err.Error())
+		return false
+	}
+
+	ctx := logging.WithRequestID(context.Background(), logging.NewRequestID())
+	defer recoverCommand(ctx, w, name)
+	cmd.run(s, ctx, w, args)
+	return false
+}
+
+func (s *respServer) ping(_ context.Context, w replyWriter, args [][]byte) {
+	switch len(args) {
+	case 1:
+		w.status("PONG")
+	case 2:
+		w.bulk(args[1])
+	default:
+		w.error("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func (s *respServer) echo(_ context.Context, w replyWriter, args [][]byte) {
+	w.bulk(args[1])
+}
+
+// selectDB accepts database 0, the only one, so that clients configured
+// with it connect.
+func (s *respServer) selectDB(_ context.Context, w replyWriter, args [][]byte) {
+	if string(args[1]) != "0" {
+		w.error("ERR DB index is out of range")
+		return
+	}
+	w.status("OK")
+}
+
+// command answers COMMAND, which redis-cli sends for its hints, with no
+// command documentation.
+func (s *respServer) command(_ context.Context, w replyWriter, _ [][]byte) {
+	w.array(0)
+}
+
+func (s *respServer) get(_ context.Context, w replyWriter, args [][]byte) {
+	value, ok := s.store.Get(string(args[1]))
+	if !ok {
+		w.null()
+		return
+	}
+	w.bulk(value)
+}
+
+// set supports the EX and PX expiry options; the conditional and KEEPTTL
+// options are refused rather than ignored.
+func (s *respServer) set(ctx context.Context, w replyWriter, args [][]byte) {
+	var ttl time.Duration
+	for i := 3; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch option {
+		case "EX", "PX":
+			if ttl != 0 || i+1 == len(args) {
+				w.error("ERR syntax error")
+				return
+			}
+			unit := time.Second
+			if option == "PX" {
+				unit = time.Millisecond
+			}
+			i++
+			var ok bool
+			if ttl, ok = parseTTL(args[i], unit); !ok || ttl <= 0 {
+				w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+		case "NX", "XX", "GET", "KEEPTTL", "EXAT", "PXAT":
+			w.error("ERR SET option " + option + " is not supported")
+			return
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	var err error
+	if ttl > 0 {
+		err = s.store.SetWithTTLTimed(ctx, string(args[1]), args[2], ttl, nil)
+	} else {
+		err = s.store.SetTimed(ctx, string(args[1]), args[2], nil)
+	}
+	if err != nil {
+		replyStoreError(ctx, w, err)
+		return
+	}
+	w.status("OK")
+}
+
+func (s *respServer) del(ctx context.Context, w replyWriter, args [][]byte) {
+	var deleted int64
+	for _, key := range args[1:] {
+		existed, err := s.store.DeleteTimed(ctx, string(key), nil)
+		if err != nil {
+			replyStoreError(ctx, w, err)
+			return
+		}
+		if existed {
+			deleted++
+		}
+	}
+	w.integer(deleted)
+}
+
+// exists counts the keys that exist, a key named twice counting twice.
+func (s *respServer) exists(_ context.Context, w replyWriter, args [][]byte) {
+	var found int64
+	for _, key := range args[1:] {
+		// TTL tells whether the key exists without copying its value.
+		if _, ok := s.store.TTL(string(key)); ok {
+			found++
+		}
+	}
+	w.integer(found)
+}
+
+func (s *respServer) ttl(_ context.Context, w replyWriter, args [][]byte) {
+	s.replyTTL(w, string(args[1]), time.Second)
+}
+
+func (s *respServer) pttl(_ context.Context, w replyWriter, args [][]byte) {
+	s.replyTTL(w, string(args[1]), time.Millisecond)
+}
+
+// replyTTL answers with the time key has left in unit, rounded, or -2 for
+// a missing key and -1 for a key without a TTL.
+func (s *respServer) replyTTL(w replyWriter, key string, unit time.Duration) {
+	ttl, ok := s.store.TTL(key)
+	switch {
+	case !ok:
+		w.integer(-2)
+	case ttl == 0:
+		w.integer(-1)
+	default:
+		w.integer(int64((ttl + unit/2) / unit))
+	}
+}
+
+// expire sets a key's TTL in seconds; like Redis, a TTL that is not
+// positive deletes the key.
+func (s *respServer) expire(ctx context.Context, w replyWriter, args [][]byte) {
+	key := string(args[1])
+	seconds, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		w.error("ERR value is not an integer or out of range")
+		return
+	}
+
+	var existed bool
+	if seconds <= 0 {
+		existed, err = s.store.DeleteTimed(ctx, key, nil)
+	} else if ttl, ok := parseTTL(args[2], time.Second); ok {
+		existed, err = s.store.Expire(key, ttl)
+	} else {
+		w.error("ERR invalid expire time in 'expire' command")
+		return
+	}
+	if err != nil {
+		replyStoreError(ctx, w, err)
+		return
+	}
+	if existed {
+		w.integer(1)
+	} else {
+		w.integer(0)
+	}
+}
+
+// keys lists the keys matching a glob pattern in lexical order, from one
+// snapshot of the store.
+func (s *respServer) keys(_ context.Context, w replyWriter, args [][]byte) {
+	pattern := string(args[1])
+	it := s.store.NewIterator(store.IteratorOptions{Prefix: literalPrefix(pattern), KeysOnly: true})
+	defer it.Close()
+
+	var keys []string
+	for it.Next() {
+		if match(pattern, it.Key()) {
+			keys = append(keys, it.Key())
+		}
+	}
+	w.array(len(keys))
+	for _, key := range keys {
+		w.bulk([]byte(key))
+	}
+}
+
+// parseTTL parses a whole number of units, reporting false when it is not
+// a number or the duration overflows.
+func parseTTL(raw []byte, unit time.Duration) (time.Duration, bool) {
+	n, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
@@ -0,0 +1,96 @@
+package resp
+
+import "strings"
+
+// literalPrefix returns the part of a KEYS pattern before its first special
+// character, which every matching key starts with.
+func literalPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// match reports whether key matches a Redis glob pattern: * matches any run
+// of bytes, ? any one byte, [abc], [a-z] and [^a] one byte of a class, and
+// a backslash escapes the next byte. On a mismatch after a * the match
+// resumes one byte further from that *, so it runs in time proportional to
+// the product of the lengths at worst.
+func match(pattern, key string) bool {
+	p, k := 0, 0
+	star, starKey := -1, 0
+	for k < len(key) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				star, starKey = p, k
+				p++
+				continue
+			case '?':
+				p++
+				k++
+				continue
+			case '[':
+				if end, ok := matchClass(pattern, p, key[k]); ok {
+					p = end
+					k++
+					continue
+				}
+			default:
+				literal := pattern[p]
+				next := p + 1
+				if literal == '\\' && next < len(pattern) {
+					literal = pattern[next]
+					next++
+				}
+				if literal == key[k] {
+					p = next
+					k++
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starKey++
+		p, k = star+1, starKey
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the class starting at pattern[start], '[',
+// and returns the index after the class. An unterminated class runs to the
+// end of the pattern.
+func matchClass(pattern string, start int, c byte) (int, bool) {
+	i := start + 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	for i < len(pattern) && pattern[i] != ']' {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			matched = matched || pattern[i+1] == c
+			i += 2
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= c && c <= hi)
+			i += 3
+		default:
+			matched = matched || pattern[i] == c
+			i++
+		}
+	}
+	if i < len(pattern) {
+		i++ // the closing ]
+	}
+	return i, matched != negate
+}
@@ -0,0 +1,113 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	// maxArgs caps the arguments of one command.
+	maxArgs = 1 << 20
+	// maxBulkLength caps one argument, well above the store's value limit
+	// so that oversized values get the store's error rather than a
+	// protocol error.
+	maxBulkLength = 64 << 20
+	// readBufferSize bounds inline commands and protocol lines.
+	readBufferSize = 16 << 10
+)
+
+// protocolError is a malformed request; the connection is answered with
+// the error and closed, as Redis does.
+type protocolError string
+
+func (e protocolError) Error() string { return "Protocol error: " + string(e) }
+
+// readCommand reads one command: an array of bulk strings as clients send
+// them, or an inline command typed into telnet. It returns no arguments for
+// an empty line.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, protocolError("invalid multibulk length")
+	}
+	args := make([][]byte, 0, max(n, 0))
+	for range n {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError(fmt.Sprintf("expected '$', got '%s'", line[:min(len(line), 1)]))
+		}
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil || length < 0 || length > maxBulkLength {
+			return nil, protocolError("invalid bulk length")
+		}
+		arg := make([]byte, length+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(arg, []byte("\r\n")) {
+			return nil, protocolError("bulk string not terminated by CRLF")
+		}
+		args = append(args, arg[:length])
+	}
+	return args, nil
+}
+
+// readLine reads a line and strips its CRLF, or a bare LF from telnet.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, protocolError("too big inline request")
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	return bytes.Clone(line), nil
+}
+
+// replyWriter encodes RESP2 replies.
+type replyWriter struct {
+	*bufio.Writer
+}
+
+func (w replyWriter) status(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+// error replies with message, which starts with an error code such as ERR.
+func (w replyWriter) error(message string) {
+	w.WriteString("-" + message + "\r\n")
+}
+
+func (w replyWriter) integer(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w replyWriter) bulk(b []byte) {
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w replyWriter) null() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w replyWriter) array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
@@ -0,0 +1,255 @@
+// Package resp serves a subset of the Redis protocol (RESP2) from the
+// store, so that redis-cli and Redis client libraries can read and write
+// keys: GET, SET, DEL, EXISTS, TTL, PTTL, EXPIRE and KEYS, plus the
+// connection commands clients send on their own.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"universe/internal/logging"
+	"universe/internal/panics"
+	"universe/internal/store"
+)
+
+var logger = logging.For(logging.CategoryRESP)
+
+// DefaultPort is the port the RESP server listens on when none is set, the
+// Redis default.
+const DefaultPort = 6379
+
+type RespServer interface {
+	Start() error
+	Listen() error
+	Stop(ctx context.Context) error
+}
+
+type respServer struct {
+	store *store.Store
+	addr  string
+	// bound is the listener bound by Listen for Start to serve.
+	bound net.Listener
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closing  bool
+	wg       sync.WaitGroup
+}
+
+// Config sets where the RESP server listens.
+type Config struct {
+	// Address is the host or IP to listen on; empty means all interfaces.
+	Address string
+	// Port is the port to listen on; zero means DefaultPort.
+	Port int
+}
+
+// Options configures a RespServer.
+type Options struct {
+	Config Config
+}
+
+// Option changes one setting of the Options NewServer starts from.
+type Option func(*Options)
+
+// WithConfig sets where the server listens.
+func WithConfig(config Config) Option {
+	return func(o *Options) { o.Config = config }
+}
+
+// NewServer returns a server for store configured by opts, applied in
+// order.
+func NewServer(store *store.Store, opts ...Option) RespServer {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return NewServerWithOptions(store, o)
+}
+
+// NewServerWithOptions is NewServer with explicit options.
+func NewServerWithOptions(store *store.Store, opts Options) RespServer {
+	port := opts.Config.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	return &respServer{
+		store: store,
+		addr:  net.JoinHostPort(opts.Config.Address, strconv.Itoa(port)),
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// Start serves until Stop is called, when it returns nil, or until the
+// listener fails.
+func (s *respServer) Start() error {
+	if s.bound == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+	return s.serve(s.bound)
+}
+
+// Listen binds the server's address ahead of Start, which then serves the
+// connections queued meanwhile. Start binds it itself if Listen was not
+// called.
+func (s *respServer) Listen() error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("resp: listen: %w", err)
+	}
+	s.bound = ln
+	return nil
+}
+
+func (s *respServer) serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		_ = ln.Close()
+		return nil
+	}
+	s.listener = ln
+	s.mu.Unlock()
+
+	logger.Info("RESP server starting", "addr", ln.Addr().String())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return nil
+			}
+			return fmt.Errorf("resp: accept: %w", err)
+		}
+		if !s.track(conn) {
+			_ = conn.Close()
+			continue
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// track registers conn for Stop, reporting false once the server is
+// closing.
+func (s *respServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *respServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// Stop stops accepting connections and lets every connection finish the
+// commands it has already received. If ctx is done first the remaining
+// connections are closed and ctx's error returned. The store is left open
+// for the caller to close.
+func (s *respServer) Stop(ctx context.Context) error {
+	logger.Info("RESP server stopping", "addr", s.addr)
+	s.mu.Lock()
+	s.closing = true
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	// Reads waiting for the next command return at once; commands already
+	// buffered are still answered.
+	for conn := range s.conns {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		logger.Info("RESP server stopped")
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+		s.mu.Unlock()
+		return fmt.Errorf("resp: drain connections: %w", ctx.Err())
+	}
+}
+
+// serveConn answers the commands of one connection in order. Replies are
+// flushed once no further pipelined command is buffered.
+func (s *respServer) serveConn(conn net.Conn) {
+	defer s.untrack(conn)
+	defer conn.Close()
+
+	r := bufio.NewReaderSize(conn, readBufferSize)
+	w := replyWriter{bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			var protocolErr protocolError
+			if errors.As(err, &protocolErr) {
+				logger.Debug("protocol error", "remote", conn.RemoteAddr().String(), "error", err)
+				w.error("ERR " + protocolErr.Error())
+				_ = w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := s.dispatch(w, args)
+		if quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// replyStoreError answers a failed store call. Failed persistence is
+// logged; the store's message is returned either way.
+func replyStoreError(ctx context.Context, w replyWriter, err error) {
+	if errors.Is(err, store.ErrWriteFailed) || errors.Is(err, store.ErrClosed) {
+		logger.ErrorContext(ctx, "store write failed", "error", err)
+		w.error("ERR write could not be persisted")
+		return
+	}
+	w.error("ERR " + err.Error())
+}
+
+// recoverCommand answers a panicking command with an error and hands the
+// panic to the process-wide panic policy.
+func recoverCommand(ctx context.Context, w replyWriter, name string) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	panics.Handle("resp "+name+" request "+logging.RequestID(ctx), value)
+	w.error("ERR internal error")
+}
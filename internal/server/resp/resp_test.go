@@ -0,0 +1,208 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"universe/internal/store"
+)
+
+// client sends commands as arrays of bulk strings and reads replies as
+// their raw protocol text.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newTestClient(t *testing.T) *client {
+	t.Helper()
+
+	kv, err := store.New(filepath.Join(t.TempDir(), "resp.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = kv.Close() })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := NewServer(kv).(*respServer)
+	go func() { _ = s.serve(ln) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.Stop(ctx)
+	})
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *client) send(args ...string) {
+	c.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		c.t.Fatalf("send %v: %v", args, err)
+	}
+}
+
+// reply reads one reply, nested arrays included, as protocol text without
+// line endings, e.g. "*2 $1 a $1 b".
+func (c *client) reply() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("read reply: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	var n int
+	switch line[0] {
+	case '$':
+		fmt.Sscanf(line[1:], "%d", &n)
+		if n < 0 {
+			return line
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			c.t.Fatalf("read bulk: %v", err)
+		}
+		return line + " " + string(data[:n])
+	case '*':
+		fmt.Sscanf(line[1:], "%d", &n)
+		parts := []string{line}
+		for range n {
+			parts = append(parts, c.reply())
+		}
+		return strings.Join(parts, " ")
+	}
+	return line
+}
+
+func (c *client) do(want string, args ...string) {
+	c.t.Helper()
+	c.send(args...)
+	if got := c.reply(); got != want {
+		c.t.Fatalf("%v = %q, want %q", args, got, want)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	c := newTestClient(t)
+
+	c.do("+PONG", "PING")
+	c.do("$2 hi", "ping", "hi")
+	c.do("+OK", "SELECT", "0")
+	c.do("-ERR DB index is out of range", "SELECT", "1")
+
+	c.do("$-1", "GET", "a")
+	c.do("+OK", "SET", "a", "1")
+	c.do("$1 1", "GET", "a")
+	c.do(":-1", "TTL", "a")
+	c.do(":-2", "TTL", "missing")
+	c.do(":1", "EXPIRE", "a", "100")
+	c.do(":100", "TTL", "a")
+	c.do(":0", "EXPIRE", "missing", "100")
+
+	c.do("+OK", "SET", "b", "2", "PX", "5000")
+	c.do(":5", "TTL", "b")
+	c.do("-ERR invalid expire time in 'set' command", "SET", "b", "2", "EX", "0")
+	c.do("-ERR SET option NX is not supported", "SET", "b", "2", "NX")
+	c.do("-ERR syntax error", "SET", "b", "2", "EX")
+
+	c.do(":3", "EXISTS", "a", "b", "a", "missing")
+	c.do("*2 $1 a $1 b", "KEYS", "*")
+	c.do("+OK", "SET", "user:1", "x")
+	c.do("+OK", "SET", "user:22", "y")
+	c.do("*1 $6 user:1", "KEYS", "user:?")
+	c.do("*2 $6 user:1 $7 user:22", "KEYS", "user:[12]*")
+
+	c.do(":2", "DEL", "a", "b", "missing")
+	c.do("$-1", "GET", "a")
+	c.do(":1", "EXPIRE", "user:1", "-1")
+	c.do(":0", "EXISTS", "user:1")
+
+	c.do("-ERR wrong number of arguments for 'get' command", "GET")
+	c.do("-ERR unknown command 'FLUSHALL'", "FLUSHALL")
+	c.do("+OK", "QUIT")
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Fatalf("expected the connection to be closed after QUIT")
+	}
+}
+
+func TestPipelineAndInline(t *testing.T) {
+	c := newTestClient(t)
+
+	// Pipelined commands are answered in order.
+	c.send("SET", "k", "v")
+	c.send("GET", "k")
+	c.send("DEL", "k")
+	for _, want := range []string{"+OK", "$1 v", ":1"} {
+		if got := c.reply(); got != want {
+			t.Fatalf("pipelined reply = %q, want %q", got, want)
+		}
+	}
+
+	if _, err := c.conn.Write([]byte("SET inline value\r\nGET inline\n")); err != nil {
+		t.Fatalf("send inline: %v", err)
+	}
+	if got := c.reply(); got != "+OK" {
+		t.Fatalf("inline set = %q", got)
+	}
+	if got := c.reply(); got != "$5 value" {
+		t.Fatalf("inline get = %q", got)
+	}
+
+	if _, err := c.conn.Write([]byte("*1\r\n+PING\r\n")); err != nil {
+		t.Fatalf("send malformed: %v", err)
+	}
+	if got := c.reply(); !strings.HasPrefix(got, "-ERR Protocol error") {
+		t.Fatalf("malformed request = %q, want a protocol error", got)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+	} {
+		if got := match(tc.pattern, tc.key); got != tc.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tc.pattern, tc.key, got, tc.want)
+		}
+	}
+	if prefix := literalPrefix("user:[12]*"); prefix != "user:" {
+		t.Errorf("literal prefix = %q", prefix)
+	}
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnknownToken is returned by WriteStatus for a token this store did not
+// issue since it was opened.
+var ErrUnknownToken = errors.New("store: unknown write token")
+
+// WriteState is how far a write acknowledged by SetAsync or DeleteAsync has
+// got.
+type WriteState int
+
+const (
+	// WritePending is a write journaled in the WAL buffer but not yet
+	// durable. It is visible to reads but lost if the process crashes.
+	WritePending WriteState = iota
+	// WriteDurable is a write the WAL has written and fsynced. Under
+	// SyncNever that only happens on Sync and Close.
+	WriteDurable
+	// WriteFailed is a write the WAL failed to persist; it is lost on
+	// restart.
+	WriteFailed
+)
+
+func (s WriteState) String() string {
+	switch s {
+	case WritePending:
+		return "pending"
+	case WriteDurable:
+		return "durable"
+	case WriteFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("WriteState(%d)", int(s))
+	}
+}
+
+// newRunID returns the ID that ties write tokens to one opening of a store.
+// Revisions lost in a crash are reused after recovery, so a token from an
+// earlier run cannot be answered.
+func newRunID() string {
+	return strconv.FormatUint(rand.Uint64(), 36)
+}
+
+// SetAsync is SetTimed that returns as soon as the write is journaled in
+// the WAL buffer, without waiting for the fsync SyncAlways would, together
+// with a token WriteStatus confirms its durability by. A ttl of zero keeps
+// the key until it is deleted.
+func (s *Store) SetAsync(ctx context.Context, key string, value []byte, ttl time.Duration, timing *Timing) (string, error) {
+	if ttl < 0 {
+		return "", fmt.Errorf("store: ttl must not be negative")
+	}
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = s.now().Add(s.jitter(ttl)).UnixNano()
+	}
+	revision, err := s.set(ctx, key, value, expiresAt, timing, true)
+	if err != nil {
+		return "", err
+	}
+	return s.writeToken(revision), nil
+}
+
+// DeleteAsync is DeleteTimed that returns like SetAsync. Deleting a missing
+// key is journaled as well, so it has a token too.
+func (s *Store) DeleteAsync(ctx context.Context, key string, timing *Timing) (string, bool, error) {
+	revision, existed, err := s.delete(ctx, key, timing, true)
+	if err != nil {
+		return "", false, err
+	}
+	return s.writeToken(revision), existed, nil
+}
+
+func (s *Store) writeToken(revision uint64) string {
+	return s.runID + "." + strconv.FormatUint(revision, 10)
+}
+
+// WriteStatus reports how far the write token stands for has got. Tokens
+// only live as long as the store: after a restart every write that
+// survived recovery can be read back, and WriteStatus returns
+// ErrUnknownToken.
+func (s *Store) WriteStatus(token string) (WriteState, error) {
+	run, rawRevision, ok := strings.Cut(token, ".")
+	if !ok || run != s.runID {
+		return 0, ErrUnknownToken
+	}
+	revision, err := strconv.ParseUint(rawRevision, 10, 64)
+	if err != nil || revision == 0 || revision > s.revision.Load() {
+		return 0, ErrUnknownToken
+	}
+	return s.wal.revisionState(revision), nil
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// AnalyticsOptions controls how keys are grouped into prefixes.
+type AnalyticsOptions struct {
+	// Delimiter separates key segments, e.g. ":" for "user:123".
+	Delimiter string
+	// Depth is the number of leading segments that form a prefix.
+	Depth int
+	// Top limits the number of prefixes returned.
+	Top int
+}
+
+// PrefixUsage aggregates the keys sharing a prefix.
+type PrefixUsage struct {
+	Prefix     string `json:"prefix"`
+	Keys       int    `json:"keys"`
+	KeyBytes   int64  `json:"key_bytes"`
+	ValueBytes int64  `json:"value_bytes"`
+}
+
+// SizeBucket counts values whose size falls in [Min, Max].
+type SizeBucket struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Count int `json:"count"`
+}
+
+// Analytics describes the distribution of keys and values in the store.
+type Analytics struct {
+	Keys        int           `json:"keys"`
+	KeyBytes    int64         `json:"key_bytes"`
+	ValueBytes  int64         `json:"value_bytes"`
+	Prefixes    int           `json:"prefixes"`
+	TopPrefixes []PrefixUsage `json:"top_prefixes"`
+	ValueSizes  []SizeBucket  `json:"value_size_histogram"`
+}
+
+// Analyze walks the whole keyspace and aggregates key counts and sizes by
+// prefix together with a power-of-two histogram of value sizes. Prefixes are
+// ranked by total bytes. It is a full scan and should not run on hot paths.
+func (s *Store) Analyze(opts AnalyticsOptions) Analytics {
+	if opts.Depth <= 0 {
+		opts.Depth = 1
+	}
+
+	var result Analytics
+	prefixes := make(map[string]*PrefixUsage)
+	var buckets [64]int
+
+	s.data.Range(func(key string, value []byte) bool {
+		result.Keys++
+		result.KeyBytes += int64(len(key))
+		result.ValueBytes += int64(len(value))
+		buckets[bits.Len(uint(len(value)))]++
+
+		prefix := keyPrefix(key, opts.Delimiter, opts.Depth)
+		usage, ok := prefixes[prefix]
+		if !ok {
+			usage = &PrefixUsage{Prefix: prefix}
+			prefixes[prefix] = usage
+		}
+		usage.Keys++
+		usage.KeyBytes += int64(len(key))
+		usage.ValueBytes += int64(len(value))
+		return false
+	})
+
+	result.Prefixes = len(prefixes)
+	result.TopPrefixes = make([]PrefixUsage, 0, len(prefixes))
+	for _, usage := range prefixes {
+		result.TopPrefixes = append(result.TopPrefixes, *usage)
+	}
+	sort.Slice(result.TopPrefixes, func(i, j int) bool {
+		a, b := result.TopPrefixes[i], result.TopPrefixes[j]
+		if a.KeyBytes+a.ValueBytes != b.KeyBytes+b.ValueBytes {
+			return a.KeyBytes+a.ValueBytes > b.KeyBytes+b.ValueBytes
+		}
+		return a.Prefix < b.Prefix
+	})
+	if opts.Top > 0 && len(result.TopPrefixes) > opts.Top {
+		result.TopPrefixes = result.TopPrefixes[:opts.Top]
+	}
+
+	result.ValueSizes = make([]SizeBucket, 0)
+	for i, count := range buckets {
+		if count == 0 {
+			continue
+		}
+		bucket := SizeBucket{Count: count}
+		if i > 0 {
+			bucket.Min = 1 << (i - 1)
+			bucket.Max = 1<<i - 1
+		}
+		result.ValueSizes = append(result.ValueSizes, bucket)
+	}
+
+	return result
+}
+
+// keyPrefix returns the first depth segments of key. Keys with fewer
+// segments are their own prefix.
+func keyPrefix(key, delimiter string, depth int) string {
+	if delimiter == "" {
+		return key
+	}
+
+	end := 0
+	for i := 0; i < depth; i++ {
+		next := strings.Index(key[end:], delimiter)
+		if next < 0 {
+			return key
+		}
+		end += next + len(delimiter)
+	}
+	return key[:end]
+}
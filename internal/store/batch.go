@@ -0,0 +1,94 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// WriteBatch collects sets and deletes that Store.Write persists as a single
+// WAL record, so after a crash either all of them are recovered or none. The
+// zero value is an empty batch ready to use.
+type WriteBatch struct {
+	ops []WALEntry
+}
+
+// Set adds a write of value to key. The value is copied.
+func (b *WriteBatch) Set(key string, value []byte) {
+	b.ops = append(b.ops, WALEntry{Type: OperationSet, Key: key, Value: bytes.Clone(value)})
+}
+
+// Delete adds a removal of key.
+func (b *WriteBatch) Delete(key string) {
+	b.ops = append(b.ops, WALEntry{Type: OperationDelete, Key: key})
+}
+
+// Len returns the number of operations in the batch.
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Reset empties the batch so it can be reused.
+func (b *WriteBatch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// Write appends the batch to the WAL as one record and applies its operations
+// in order. Either every operation is persisted or, if the batch is invalid,
+// none is. Concurrent readers may observe the operations being applied one
+// by one; other writers cannot interleave with them.
+func (s *Store) Write(b *WriteBatch) error {
+	_, err := s.writeOps(b.ops, nil)
+	return err
+}
+
+// writeOps persists ops as one batch record and returns its revision, or
+// zero if there was nothing to write. check, when non-nil, runs under the
+// write lock before anything is appended and aborts the write if it returns
+// an error.
+func (s *Store) writeOps(ops []WALEntry, check func() error) (uint64, error) {
+	for i, op := range ops {
+		if op.Key == "" {
+			return 0, fmt.Errorf("store: batch operation %d: key must not be empty", i)
+		}
+		if op.Type == OperationSet {
+			if err := s.limits.checkSet(op.Key, op.Value); err != nil {
+				return 0, fmt.Errorf("store: batch operation %d: %w", i, err)
+			}
+		}
+		if err := s.checkWrite(op.Key); err != nil {
+			return 0, fmt.Errorf("store: batch operation %d: %w", i, err)
+		}
+		s.warmup.wait(op.Key)
+	}
+	return s.commitOps(ops, check)
+}
+
+// commitOps is writeOps without checking the keys, for the keys the store
+// writes itself.
+func (s *Store) commitOps(ops []WALEntry, check func() error) (uint64, error) {
+	entry := WALEntry{Type: OperationBatch, Batch: append([]WALEntry(nil), ops...)}
+
+	s.mu.Lock()
+	if check != nil {
+		if err := check(); err != nil {
+			s.mu.Unlock()
+			return 0, err
+		}
+	}
+	if len(ops) == 0 {
+		s.mu.Unlock()
+		return 0, nil
+	}
+
+	entry.Revision = s.revision.Load() + 1
+	seq, err := s.wal.enqueue(entry)
+	if err != nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	s.applyEntry(entry)
+	s.mu.Unlock()
+
+	_, err = s.wal.waitDurable(seq)
+	return entry.Revision, err
+}
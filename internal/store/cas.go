@@ -0,0 +1,123 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrConditionFailed is returned by CheckAndWrite when a condition does not
+// hold; nothing is written.
+var ErrConditionFailed = errors.New("store: condition failed")
+
+type conditionKind int
+
+const (
+	conditionExists conditionKind = iota
+	conditionMissing
+	conditionRevision
+	conditionValue
+)
+
+// Condition is a requirement on the current state of one key, checked by
+// CheckAndWrite. Build one with IfExists, IfMissing, IfRevision or IfValue.
+type Condition struct {
+	Key      string
+	kind     conditionKind
+	revision uint64
+	value    []byte
+}
+
+// IfExists requires key to be present.
+func IfExists(key string) Condition {
+	return Condition{Key: key, kind: conditionExists}
+}
+
+// IfMissing requires key to be absent.
+func IfMissing(key string) Condition {
+	return Condition{Key: key, kind: conditionMissing}
+}
+
+// IfRevision requires key to be present and last written at revision, as
+// reported by GetVersion.
+func IfRevision(key string, revision uint64) Condition {
+	return Condition{Key: key, kind: conditionRevision, revision: revision}
+}
+
+// IfValue requires key to be present with exactly value.
+func IfValue(key string, value []byte) Condition {
+	return Condition{Key: key, kind: conditionValue, value: bytes.Clone(value)}
+}
+
+func (c Condition) String() string {
+	switch c.kind {
+	case conditionExists:
+		return fmt.Sprintf("%q exists", c.Key)
+	case conditionMissing:
+		return fmt.Sprintf("%q is missing", c.Key)
+	case conditionRevision:
+		return fmt.Sprintf("%q is at revision %d", c.Key, c.revision)
+	default:
+		return fmt.Sprintf("%q has the expected value", c.Key)
+	}
+}
+
+// GetVersion returns a copy of the value of key and the revision it was last
+// written at.
+func (s *Store) GetVersion(key string) ([]byte, uint64, bool) {
+	s.warmup.wait(key)
+	if s.expiredOnRead(key) {
+		return nil, 0, false
+	}
+	value, revision, ok := s.current(key)
+	if !ok {
+		return nil, 0, false
+	}
+	return bytes.Clone(value), revision, true
+}
+
+// current reads key's latest version, hiding deleted and expired keys.
+func (s *Store) current(key string) ([]byte, uint64, bool) {
+	versions, ok := s.history.Load(key)
+	if !ok {
+		return nil, 0, false
+	}
+	latest := versions[len(versions)-1]
+	if latest.deleted || s.expired(key) {
+		return nil, 0, false
+	}
+	return latest.value, latest.revision, true
+}
+
+func (s *Store) holds(c Condition) bool {
+	value, revision, ok := s.current(c.Key)
+	switch c.kind {
+	case conditionExists:
+		return ok
+	case conditionMissing:
+		return !ok
+	case conditionRevision:
+		return ok && revision == c.revision
+	default:
+		return ok && bytes.Equal(value, c.value)
+	}
+}
+
+// CheckAndWrite applies b as one atomic batch if every condition holds, and
+// returns the revision it was written at, or zero if b is empty. If a
+// condition fails it returns ErrConditionFailed naming the condition and
+// writes nothing. No other write can happen between the check and the
+// write.
+func (s *Store) CheckAndWrite(conditions []Condition, b *WriteBatch) (uint64, error) {
+	for _, c := range conditions {
+		s.warmup.wait(c.Key)
+	}
+	return s.writeOps(b.ops, func() error {
+		for _, c := range conditions {
+			if !s.holds(c) {
+				return fmt.Errorf("%w: %s", ErrConditionFailed, c)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ComponentCheckpoint names the checkpoint file format in the manifest.
+const ComponentCheckpoint = "checkpoint"
+
+// A checkpoint is a full copy of the live keys written when the store closes.
+// It is named <wal path>.checkpoint.NNNNNN after the first segment it does not
+// cover: recovery loads the newest checkpoint and replays only the segments
+// from that number on. Its records use the WAL framing: a set entry for each
+// key at the revision it was last written, followed by an empty batch at the
+// store revision so that recovery resumes numbering where it stopped.
+
+func checkpointPath(walPath string, segment int) string {
+	return fmt.Sprintf("%s.checkpoint.%06d", walPath, segment)
+}
+
+// listCheckpoints returns the segment numbers of the existing checkpoints of
+// the WAL at path in ascending order.
+func listCheckpoints(path string) ([]int, error) {
+	checkpoints, err := listNumbered(path + ".checkpoint.")
+	if err != nil {
+		return nil, fmt.Errorf("store: list checkpoints: %w", err)
+	}
+	return checkpoints, nil
+}
+
+// writeCheckpoint writes the live keys to a checkpoint that hands over to a
+// new, empty segment, then removes the segments and checkpoints it
+// supersedes. The WAL must be closed.
+func (s *Store) writeCheckpoint() error {
+	start := time.Now()
+	walPath := s.wal.path
+	next := int(s.wal.segment.Load()) + 1
+
+	// Create the segment first so the WAL never reopens a covered one.
+	file, _, err := openSegment(walPath, next)
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("store: create wal segment: %w", err)
+	}
+
+	keys, err := s.writeCheckpointFile(checkpointPath(walPath, next))
+	if err != nil {
+		return err
+	}
+
+	segments, err := listSegments(walPath)
+	if err != nil {
+		return err
+	}
+	checkpoints, err := listCheckpoints(walPath)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if segment < next {
+			s.removeCovered(segmentPath(walPath, segment))
+		}
+	}
+	for _, checkpoint := range checkpoints {
+		if checkpoint < next {
+			s.removeCovered(checkpointPath(walPath, checkpoint))
+		}
+	}
+
+	s.log.Info("checkpoint written", "keys", keys, "segment", next, "elapsed", time.Since(start))
+	return nil
+}
+
+// removeCovered deletes a file made redundant by a newer checkpoint. Failing
+// to do so only wastes space, since recovery skips it.
+func (s *Store) removeCovered(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.log.Warn("remove file covered by checkpoint", "path", path, "error", err)
+	}
+}
+
+// writeCheckpointFile atomically writes every live key to path.
+func (s *Store) writeCheckpointFile(path string) (int, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return 0, fmt.Errorf("store: create checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	now := s.now().UnixNano()
+	keys := 0
+
+	// The range always runs to completion; stopping a csmap range early
+	// leaks its goroutines. After an error the remaining keys are skipped.
+	s.data.Range(func(key string, value []byte) bool {
+		expiresAt, _ := s.expiry.Load(key)
+		if err != nil || (expiresAt != 0 && expiresAt <= now) {
+			return false
+		}
+		entry := WALEntry{Type: OperationSet, Key: key, Value: value, ExpiresAt: expiresAt}
+		if versions, ok := s.history.Load(key); ok {
+			entry.Revision = versions[len(versions)-1].revision
+		}
+		if _, err = writeRecord(writer, entry, s.wal.opts.Compression); err == nil {
+			keys++
+		}
+		return false
+	})
+	if err == nil {
+		_, err = writeRecord(writer, WALEntry{Type: OperationBatch, Revision: s.revision.Load()}, CompressionNone)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("store: write checkpoint: %w", err)
+	}
+
+	if err := os.Chmod(tmp.Name(), walFileMode); err != nil {
+		return 0, fmt.Errorf("store: write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("store: install checkpoint: %w", err)
+	}
+	return keys, syncDir(filepath.Dir(path))
+}
+
+// checkpointSize returns the size of the checkpoint recovery starts from, or
+// zero when there is none.
+func (s *Store) checkpointSize() (int64, error) {
+	if s.wal.first == 0 {
+		return 0, nil
+	}
+
+	info, err := os.Stat(checkpointPath(s.wal.path, s.wal.first))
+	if err != nil {
+		return 0, fmt.Errorf("store: stat checkpoint: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// loadCheckpoint streams the entries of the checkpoint recovery starts from
+// to fn.
+func (s *Store) loadCheckpoint(fn func(entry WALEntry, size int64) error) error {
+	if s.wal.first == 0 {
+		return nil
+	}
+	return replaySegment(checkpointPath(s.wal.path, s.wal.first), fn)
+}
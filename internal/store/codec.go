@@ -0,0 +1,198 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+// codec encodes WAL entries for one record version.
+type codec interface {
+	version() byte
+	// append encodes entry onto dst.
+	append(dst []byte, entry WALEntry) ([]byte, error)
+	decode(data []byte) (WALEntry, error)
+}
+
+// gobCodec encodes entries with encoding/gob. It is kept to read records
+// written before the binary encoding.
+type gobCodec struct{ v byte }
+
+func (c gobCodec) version() byte { return c.v }
+
+func (gobCodec) append(dst []byte, entry WALEntry) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	if err := gob.NewEncoder(buf).Encode(entry); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) decode(data []byte) (WALEntry, error) {
+	var entry WALEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return WALEntry{}, err
+	}
+	return entry, nil
+}
+
+// binaryCodec lays an entry out as
+//
+//	op (1) | revision (uvarint) | key length (uvarint) | key |
+//	value length (uvarint) | value | expires at (varint) |
+//	batch length (uvarint) | batch entries, each in this layout
+//
+// Nested entries may not be batches themselves. Decoded keys are copied but
+// values alias the decoded data.
+type binaryCodec struct{}
+
+const (
+	opSet    byte = 1
+	opDelete byte = 2
+	opBatch  byte = 3
+)
+
+// minBinaryEntry is the size of an entry with an empty key and value and
+// all numbers zero, used to bound batch lengths before allocating.
+const minBinaryEntry = 6
+
+var errBinaryEntry = errors.New("malformed binary entry")
+
+func (binaryCodec) version() byte { return recordVersionBinary }
+
+func (c binaryCodec) append(dst []byte, entry WALEntry) ([]byte, error) {
+	return c.appendEntry(dst, entry, true)
+}
+
+func (c binaryCodec) appendEntry(dst []byte, entry WALEntry, top bool) ([]byte, error) {
+	var op byte
+	switch entry.Type {
+	case OperationSet:
+		op = opSet
+	case OperationDelete:
+		op = opDelete
+	case OperationBatch:
+		if !top {
+			return nil, fmt.Errorf("nested batch")
+		}
+		op = opBatch
+	default:
+		return nil, fmt.Errorf("unknown operation %q", entry.Type)
+	}
+
+	dst = append(dst, op)
+	dst = binary.AppendUvarint(dst, entry.Revision)
+	dst = binary.AppendUvarint(dst, uint64(len(entry.Key)))
+	dst = append(dst, entry.Key...)
+	dst = binary.AppendUvarint(dst, uint64(len(entry.Value)))
+	dst = append(dst, entry.Value...)
+	dst = binary.AppendVarint(dst, entry.ExpiresAt)
+	dst = binary.AppendUvarint(dst, uint64(len(entry.Batch)))
+
+	var err error
+	for _, op := range entry.Batch {
+		if dst, err = c.appendEntry(dst, op, false); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+func (c binaryCodec) decode(data []byte) (WALEntry, error) {
+	d := binaryDecoder{data: data}
+	entry := d.entry(true)
+	if d.err == nil && len(d.data) > 0 {
+		d.err = fmt.Errorf("%w: %d trailing bytes", errBinaryEntry, len(d.data))
+	}
+	return entry, d.err
+}
+
+// binaryDecoder reads the binary layout, remembering the first error.
+type binaryDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *binaryDecoder) fail(what string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: bad %s", errBinaryEntry, what)
+	}
+	d.data = nil
+}
+
+func (d *binaryDecoder) uvarint(what string) uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail(what)
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *binaryDecoder) varint(what string) int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail(what)
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *binaryDecoder) bytes(what string) []byte {
+	n := d.uvarint(what + " length")
+	if n > uint64(len(d.data)) {
+		d.fail(what)
+		return nil
+	}
+	b := d.data[:n:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *binaryDecoder) entry(top bool) WALEntry {
+	if len(d.data) == 0 {
+		d.fail("operation")
+		return WALEntry{}
+	}
+	op := d.data[0]
+	d.data = d.data[1:]
+
+	var entry WALEntry
+	switch {
+	case op == opSet:
+		entry.Type = OperationSet
+	case op == opDelete:
+		entry.Type = OperationDelete
+	case op == opBatch && top:
+		entry.Type = OperationBatch
+	default:
+		d.fail("operation")
+		return WALEntry{}
+	}
+
+	entry.Revision = d.uvarint("revision")
+	entry.Key = string(d.bytes("key"))
+	if value := d.bytes("value"); len(value) > 0 {
+		entry.Value = value
+	}
+	entry.ExpiresAt = d.varint("expiration")
+
+	count := d.uvarint("batch length")
+	if count > uint64(len(d.data)/minBinaryEntry) {
+		d.fail("batch length")
+	}
+	if d.err != nil {
+		return WALEntry{}
+	}
+	if count > 0 {
+		entry.Batch = make([]WALEntry, 0, count)
+		for i := uint64(0); i < count && d.err == nil; i++ {
+			entry.Batch = append(entry.Batch, d.entry(false))
+		}
+	}
+	return entry
+}
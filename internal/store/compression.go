@@ -0,0 +1,135 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression selects how WAL and checkpoint records are compressed.
+type Compression int
+
+const (
+	// CompressionNone writes records uncompressed.
+	CompressionNone Compression = iota
+	// CompressionSnappy compresses records with snappy: fast, moderate
+	// ratio.
+	CompressionSnappy
+	// CompressionZstd compresses records with zstd: slower, better ratio.
+	CompressionZstd
+)
+
+const (
+	// compressMinSize is the smallest encoded entry worth compressing;
+	// below it the compression framing outweighs the savings.
+	compressMinSize = 128
+	// maxDecompressedSize bounds the memory a corrupt record can make
+	// decompression allocate.
+	maxDecompressedSize = 256 << 20
+)
+
+// ParseCompression parses "none", "snappy" or "zstd".
+func ParseCompression(s string) (Compression, error) {
+	switch s {
+	case "none":
+		return CompressionNone, nil
+	case "snappy":
+		return CompressionSnappy, nil
+	case "zstd":
+		return CompressionZstd, nil
+	default:
+		return 0, fmt.Errorf("store: unknown compression %q", s)
+	}
+}
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Compression(%d)", int(c))
+	}
+}
+
+// compressedCodec wraps binaryCodec output in a compression format.
+type compressedCodec struct {
+	v          byte
+	compress   func(src []byte) []byte
+	decompress func(src []byte) ([]byte, error)
+}
+
+func (c compressedCodec) version() byte { return c.v }
+
+func (c compressedCodec) append(dst []byte, entry WALEntry) ([]byte, error) {
+	data, err := binaryCodec{}.append(nil, entry)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, c.compress(data)...), nil
+}
+
+func (c compressedCodec) decode(data []byte) (WALEntry, error) {
+	raw, err := c.decompress(data)
+	if err != nil {
+		return WALEntry{}, fmt.Errorf("decompress: %w", err)
+	}
+	return binaryCodec{}.decode(raw)
+}
+
+var snappyCodec = compressedCodec{
+	v: recordVersionSnappy,
+	compress: func(src []byte) []byte {
+		return snappy.Encode(nil, src)
+	},
+	decompress: func(src []byte) ([]byte, error) {
+		n, err := snappy.DecodedLen(src)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxDecompressedSize {
+			return nil, fmt.Errorf("decompressed size %d too large", n)
+		}
+		return snappy.Decode(nil, src)
+	},
+}
+
+var zstdCodec = compressedCodec{
+	v: recordVersionZstd,
+	compress: func(src []byte) []byte {
+		return zstdEncoder().EncodeAll(src, nil)
+	},
+	decompress: func(src []byte) ([]byte, error) {
+		return zstdDecoder().DecodeAll(src, nil)
+	},
+}
+
+// The zstd encoder and decoder are safe for concurrent EncodeAll and
+// DecodeAll calls and expensive to create, so one of each is shared.
+var (
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			panic(fmt.Sprintf("store: create zstd encoder: %v", err))
+		}
+		return enc
+	})
+	zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+		dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxDecompressedSize))
+		if err != nil {
+			panic(fmt.Sprintf("store: create zstd decoder: %v", err))
+		}
+		return dec
+	})
+)
+
+// compressionCodecs maps each compression to the codec it writes with.
+var compressionCodecs = map[Compression]compressedCodec{
+	CompressionSnappy: snappyCodec,
+	CompressionZstd:   zstdCodec,
+}
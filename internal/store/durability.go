@@ -0,0 +1,90 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// SyncPolicy decides when WAL writes are fsynced and when Append returns.
+type SyncPolicy int
+
+const (
+	// SyncInterval buffers appends and writes and fsyncs them every
+	// FlushInterval or once the buffer fills. Append returns immediately, so
+	// up to one interval of acknowledged writes can be lost on a crash.
+	SyncInterval SyncPolicy = iota
+	// SyncAlways makes Append block until its entry has been written and
+	// fsynced. Concurrent appends share one fsync.
+	SyncAlways
+	// SyncNever writes buffered appends to the OS on the interval but never
+	// fsyncs until Close, leaving durability to the page cache.
+	SyncNever
+)
+
+// DefaultFlushInterval is how often buffered WAL entries are written when
+// WALOptions.FlushInterval is zero.
+const DefaultFlushInterval = time.Second
+
+// ParseSyncPolicy parses "always", "interval" or "never".
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "interval":
+		return SyncInterval, nil
+	case "always":
+		return SyncAlways, nil
+	case "never":
+		return SyncNever, nil
+	default:
+		return 0, fmt.Errorf("store: unknown sync policy %q", s)
+	}
+}
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncInterval:
+		return "interval"
+	case SyncAlways:
+		return "always"
+	case SyncNever:
+		return "never"
+	default:
+		return fmt.Sprintf("SyncPolicy(%d)", int(p))
+	}
+}
+
+// RecoveryMode decides what replay does when it reaches a corrupt record.
+type RecoveryMode int
+
+const (
+	// RecoveryStrict fails replay, and so opening the store, with
+	// ErrCorruptWAL.
+	RecoveryStrict RecoveryMode = iota
+	// RecoveryTruncate cuts the WAL off at the first corrupt record,
+	// discarding it and everything written after it, and carries on. A
+	// record torn by a crash mid-write is the common case; it was never
+	// acknowledged under SyncAlways.
+	RecoveryTruncate
+)
+
+// ParseRecoveryMode parses "strict" or "truncate".
+func ParseRecoveryMode(s string) (RecoveryMode, error) {
+	switch s {
+	case "strict":
+		return RecoveryStrict, nil
+	case "truncate":
+		return RecoveryTruncate, nil
+	default:
+		return 0, fmt.Errorf("store: unknown recovery mode %q", s)
+	}
+}
+
+func (m RecoveryMode) String() string {
+	switch m {
+	case RecoveryStrict:
+		return "strict"
+	case RecoveryTruncate:
+		return "truncate"
+	default:
+		return fmt.Sprintf("RecoveryMode(%d)", int(m))
+	}
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SystemPrefix starts the keys the server keeps for itself, such as the
+// sequence high-water marks. A partial recovery always serves them.
+const SystemPrefix = "__system/"
+
+// ErrKeyNotServed is returned when writing a key outside the prefixes a
+// partially recovered store serves.
+var ErrKeyNotServed = errors.New("store: key not served by this node")
+
+// ErrReservedKey is returned when writing a key the store maintains itself.
+var ErrReservedKey = errors.New("store: key is reserved")
+
+// keyFilter limits a store to keys with one of its prefixes and the system
+// keys. An empty filter serves every key.
+type keyFilter []string
+
+func (f keyFilter) serves(key string) bool {
+	if len(f) == 0 || strings.HasPrefix(key, SystemPrefix) {
+		return true
+	}
+	for _, prefix := range f {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// check fails for keys the store does not serve.
+func (f keyFilter) check(key string) error {
+	if !f.serves(key) {
+		return fmt.Errorf("%w: %q", ErrKeyNotServed, key)
+	}
+	return nil
+}
+
+// checkWrite fails for keys the store does not serve and for the keys it
+// maintains itself, which only it may write.
+func (s *Store) checkWrite(key string) error {
+	if strings.HasPrefix(key, SequencePrefix) {
+		return fmt.Errorf("%w: %q", ErrReservedKey, key)
+	}
+	return s.filter.check(key)
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"testing"
+)
+
+func encodeRecord(t testing.TB, entry WALEntry) []byte {
+	t.Helper()
+
+	var record bytes.Buffer
+	writer := bufio.NewWriter(&record)
+	if _, err := writeRecord(writer, entry, CompressionNone); err != nil {
+		t.Fatalf("encode entry: %v", err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("encode entry: %v", err)
+	}
+	return record.Bytes()
+}
+
+// encodeLegacyRecord frames entry the way WALs did before records carried
+// a version byte.
+func encodeLegacyRecord(t testing.TB, entry WALEntry) []byte {
+	t.Helper()
+
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(entry); err != nil {
+		t.Fatalf("encode entry: %v", err)
+	}
+
+	record := make([]byte, lengthPrefix+checksumSize, lengthPrefix+checksumSize+payload.Len())
+	binary.BigEndian.PutUint32(record[:lengthPrefix], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(record[lengthPrefix:], crc32.ChecksumIEEE(payload.Bytes()))
+	return append(record, payload.Bytes()...)
+}
+
+func compressedRecord(t testing.TB, compression Compression) []byte {
+	t.Helper()
+
+	var record bytes.Buffer
+	writer := bufio.NewWriter(&record)
+	entry := WALEntry{Type: OperationSet, Key: "compressed", Value: bytes.Repeat([]byte("abc"), 100)}
+	if _, err := writeRecord(writer, entry, compression); err != nil {
+		t.Fatalf("encode entry: %v", err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("encode entry: %v", err)
+	}
+	return record.Bytes()
+}
+
+func FuzzDecodeRecords(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(encodeRecord(f, WALEntry{Type: OperationSet, Key: "alpha", Value: []byte("value")}))
+	f.Add(append(
+		encodeRecord(f, WALEntry{Type: OperationSet, Key: "a", Value: []byte("1")}),
+		encodeRecord(f, WALEntry{Type: OperationDelete, Key: "a"})...,
+	))
+	f.Add(encodeLegacyRecord(f, WALEntry{Type: OperationSet, Key: "legacy", Value: []byte("value")}))
+	f.Add(compressedRecord(f, CompressionSnappy))
+	f.Add(compressedRecord(f, CompressionZstd))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var total int64
+		err := decodeRecords(bytes.NewReader(data), func(entry WALEntry, size int64) error {
+			total += size
+			return nil
+		})
+		if err == nil && total != int64(len(data)) {
+			t.Fatalf("decoded %d bytes without error from %d byte input", total, len(data))
+		}
+	})
+}
+
+func TestDecodeLegacyRecords(t *testing.T) {
+	var data []byte
+	data = append(data, encodeLegacyRecord(t, WALEntry{Type: OperationSet, Key: "a", Value: []byte("1")})...)
+	data = append(data, encodeRecord(t, WALEntry{Type: OperationSet, Key: "b", Value: []byte("2"), Revision: 2})...)
+	data = append(data, encodeLegacyRecord(t, WALEntry{Type: OperationDelete, Key: "a"})...)
+
+	var keys []string
+	var total int64
+	err := decodeRecords(bytes.NewReader(data), func(entry WALEntry, size int64) error {
+		keys = append(keys, string(entry.Type)+" "+entry.Key)
+		total += size
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("decode mixed records: %v", err)
+	}
+	if got := fmt.Sprint(keys); got != "[set a set b delete a]" || total != int64(len(data)) {
+		t.Fatalf("unexpected records %s from %d of %d bytes", got, total, len(data))
+	}
+
+	unknown := encodeRecord(t, WALEntry{Type: OperationSet, Key: "c"})
+	unknown[lengthPrefix+checksumSize] = 0xff
+	binary.BigEndian.PutUint32(unknown[lengthPrefix:], crc32.ChecksumIEEE(unknown[lengthPrefix+checksumSize:]))
+	err = decodeRecords(bytes.NewReader(unknown), func(WALEntry, int64) error { return nil })
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat for an unknown record version, got %v", err)
+	}
+}
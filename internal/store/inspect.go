@@ -0,0 +1,252 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// WALRecord describes one record of a WAL segment, or one corrupt stretch of
+// it, as found by InspectWAL.
+type WALRecord struct {
+	Segment int
+	Offset  int64
+	Size    int64
+	// Version is the record version byte; zero for records written before
+	// records were versioned.
+	Version byte
+	Entry   WALEntry
+	// Err is set when the bytes at Offset are not a valid record. Size then
+	// covers the bad record, or for a broken frame the bytes up to the next
+	// intact record or the end of the segment.
+	Err error
+}
+
+// RepairMode decides how RepairWAL deals with corrupt records.
+type RepairMode int
+
+const (
+	// RepairTruncate cuts the WAL off at the first corrupt record, like
+	// RecoveryTruncate.
+	RepairTruncate RepairMode = iota
+	// RepairSkip removes only the corrupt stretches and keeps the valid
+	// records after them.
+	RepairSkip
+)
+
+// ParseRepairMode parses "truncate" or "skip".
+func ParseRepairMode(s string) (RepairMode, error) {
+	switch s {
+	case "truncate":
+		return RepairTruncate, nil
+	case "skip":
+		return RepairSkip, nil
+	default:
+		return 0, fmt.Errorf("store: unknown repair mode %q", s)
+	}
+}
+
+func (m RepairMode) String() string {
+	switch m {
+	case RepairTruncate:
+		return "truncate"
+	case RepairSkip:
+		return "skip"
+	default:
+		return fmt.Sprintf("RepairMode(%d)", int(m))
+	}
+}
+
+// RepairResult summarises what RepairWAL discarded.
+type RepairResult struct {
+	Corrupt        int   `json:"corrupt"`
+	DiscardedBytes int64 `json:"discarded_bytes"`
+}
+
+// InspectWAL passes every record of every segment of the WAL at path to fn,
+// in order, without modifying anything. Corrupt stretches are reported as
+// records with Err set, and reading resumes at the next valid record.
+func InspectWAL(path string, fn func(WALRecord) error) error {
+	segments, err := listSegments(path)
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return fmt.Errorf("store: no wal at %s", path)
+	}
+
+	for _, segment := range segments {
+		data, err := os.ReadFile(segmentPath(path, segment))
+		if err != nil {
+			return fmt.Errorf("store: read wal segment: %w", err)
+		}
+		for _, record := range scanSegment(segment, data) {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RepairWAL removes the corrupt records of the WAL at path as selected by
+// mode. The store must not be open while it runs.
+func RepairWAL(path string, mode RepairMode) (RepairResult, error) {
+	var result RepairResult
+
+	segments, err := listSegments(path)
+	if err != nil {
+		return result, err
+	}
+
+	for i, segment := range segments {
+		file := segmentPath(path, segment)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return result, fmt.Errorf("store: read wal segment: %w", err)
+		}
+
+		kept := make([]byte, 0, len(data))
+		for _, record := range scanSegment(segment, data) {
+			if errors.Is(record.Err, ErrUnsupportedFormat) {
+				return result, fmt.Errorf("store: segment %d offset %d: %w; refusing to repair a wal written by a newer version", segment, record.Offset, record.Err)
+			}
+			if record.Err == nil {
+				kept = append(kept, data[record.Offset:record.Offset+record.Size]...)
+				continue
+			}
+			if mode == RepairTruncate {
+				break
+			}
+			result.Corrupt++
+			result.DiscardedBytes += record.Size
+		}
+		if len(kept) == len(data) {
+			continue
+		}
+
+		if mode == RepairTruncate {
+			// Everything after the first corrupt record goes, including
+			// the later segments.
+			result.Corrupt = 1
+			result.DiscardedBytes = int64(len(data) - len(kept))
+			for _, later := range segments[i+1:] {
+				info, err := os.Stat(segmentPath(path, later))
+				if err != nil {
+					return result, fmt.Errorf("store: stat wal segment: %w", err)
+				}
+				if err := os.Remove(segmentPath(path, later)); err != nil {
+					return result, fmt.Errorf("store: remove wal segment: %w", err)
+				}
+				result.DiscardedBytes += info.Size()
+			}
+		}
+		if err := rewriteSegment(file, kept); err != nil {
+			return result, err
+		}
+		if mode == RepairTruncate {
+			break
+		}
+	}
+
+	if result.Corrupt > 0 {
+		if err := syncDir(filepath.Dir(path)); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// rewriteSegment atomically replaces the segment at path with data.
+func rewriteSegment(path string, data []byte) error {
+	tmp := path + ".repair"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, walFileMode)
+	if err != nil {
+		return fmt.Errorf("store: create repaired segment: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmp)
+		return fmt.Errorf("store: write repaired segment: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmp)
+		return fmt.Errorf("store: sync repaired segment: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("store: close repaired segment: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("store: replace segment: %w", err)
+	}
+	return nil
+}
+
+// scanSegment splits the contents of a segment into records and corrupt
+// stretches.
+func scanSegment(segment int, data []byte) []WALRecord {
+	var records []WALRecord
+	for offset := 0; offset < len(data); {
+		version, entry, size, err := parseRecord(data[offset:])
+		if size > 0 {
+			records = append(records, WALRecord{Segment: segment, Offset: int64(offset), Size: int64(size), Version: version, Entry: entry, Err: err})
+			offset += size
+			continue
+		}
+
+		// Resynchronise at the next offset that holds an intact record.
+		next := offset + 1
+		for ; next < len(data); next++ {
+			if _, _, size, _ := parseRecord(data[next:]); size > 0 {
+				break
+			}
+		}
+		records = append(records, WALRecord{Segment: segment, Offset: int64(offset), Size: int64(next - offset), Err: err})
+		offset = next
+	}
+	return records
+}
+
+// parseRecord decodes the record at the start of data and returns its
+// version, entry and size. It checks the same things as decodeRecords. The
+// size is zero unless the framing and checksum are intact; a record with an
+// intact frame can still fail to decode, e.g. with ErrUnsupportedFormat.
+func parseRecord(data []byte) (byte, WALEntry, int, error) {
+	const header = lengthPrefix + checksumSize
+	if len(data) < header {
+		return 0, WALEntry{}, 0, fmt.Errorf("torn record header: %w", ErrCorruptWAL)
+	}
+
+	length := binary.BigEndian.Uint32(data)
+	versioned := length&versionedFlag != 0
+	length &^= versionedFlag
+	if length == 0 {
+		return 0, WALEntry{}, 0, fmt.Errorf("empty record: %w", ErrCorruptWAL)
+	}
+	if uint64(length) > uint64(len(data)-header) {
+		return 0, WALEntry{}, 0, fmt.Errorf("record of %d bytes runs past the end of the segment: %w", length, ErrCorruptWAL)
+	}
+
+	payload := data[header : header+int(length)]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[lengthPrefix:]) {
+		return 0, WALEntry{}, 0, fmt.Errorf("checksum mismatch: %w", ErrCorruptWAL)
+	}
+
+	var version byte
+	if versioned {
+		version = payload[0]
+	}
+	size := header + int(length)
+
+	entry, err := decodePayload(payload, versioned)
+	if err != nil && !errors.Is(err, ErrCorruptWAL) && !errors.Is(err, ErrUnsupportedFormat) {
+		err = fmt.Errorf("%w: %w", ErrCorruptWAL, err)
+	}
+	return version, entry, size, err
+}
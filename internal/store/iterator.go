@@ -0,0 +1,178 @@
+package store
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// IteratorOptions bounds the keys an Iterator visits.
+type IteratorOptions struct {
+	// Prefix limits the iteration to keys starting with it.
+	Prefix string
+	// Start is the first key visited, if present; keys before it are
+	// skipped.
+	Start string
+	// End, when set, stops the iteration before the first key at or after
+	// it.
+	End string
+	// KeysOnly skips copying values; Value then returns nil.
+	KeysOnly bool
+}
+
+func (o IteratorOptions) includes(key string) bool {
+	return strings.HasPrefix(key, o.Prefix) && key >= o.Start && (o.End == "" || key < o.End)
+}
+
+// Iterator walks the keys of a store in lexical order as they were at the
+// revision it was created at. Writes made meanwhile are neither blocked nor
+// seen, and the operations of a batch are seen all or not at all. While it
+// is open the history it reads is kept from compaction, so a long pass
+// should not outlive its use: call Close when done.
+//
+// Like GetAt, an Iterator does not take expiration into account until the
+// sweeper has deleted a key. It is not safe for concurrent use.
+type Iterator struct {
+	store    *Store
+	revision uint64
+	keysOnly bool
+
+	keys        []string
+	next        int
+	key         string
+	value       []byte
+	modRevision uint64
+
+	closeOnce sync.Once
+}
+
+// NewIterator returns an iterator over the keys within opts as of the
+// current revision. It is positioned before the first key; call Next to
+// advance it.
+func (s *Store) NewIterator(opts IteratorOptions) *Iterator {
+	s.warmup.waitPrefix(opts.Prefix)
+	// Taking the write lock waits out an entry being applied, so no half
+	// applied batch is at or before the revision.
+	s.mu.Lock()
+	revision := s.snapshots.pin(s.revision.Load())
+	s.mu.Unlock()
+
+	var keys []string
+	s.history.Range(func(key string, _ []version) bool {
+		if opts.includes(key) {
+			keys = append(keys, key)
+		}
+		return false
+	})
+	sort.Strings(keys)
+
+	return &Iterator{store: s, revision: revision, keysOnly: opts.KeysOnly, keys: keys}
+}
+
+// Revision returns the revision the iterator reads at.
+func (it *Iterator) Revision() uint64 {
+	return it.revision
+}
+
+// Next advances to the next key that existed at the iterator's revision and
+// reports whether there is one.
+func (it *Iterator) Next() bool {
+	for it.next < len(it.keys) {
+		key := it.keys[it.next]
+		it.next++
+
+		versions, ok := it.store.history.Load(key)
+		if !ok {
+			continue
+		}
+		// i is the first version written after the revision.
+		i := sort.Search(len(versions), func(i int) bool { return versions[i].revision > it.revision })
+		if i == 0 || versions[i-1].deleted {
+			continue
+		}
+
+		it.key = key
+		it.modRevision = versions[i-1].revision
+		it.value = nil
+		if !it.keysOnly {
+			it.value = bytes.Clone(versions[i-1].value)
+		}
+		return true
+	}
+	it.key, it.value, it.modRevision = "", nil, 0
+	return false
+}
+
+// Key returns the key the iterator is at.
+func (it *Iterator) Key() string {
+	return it.key
+}
+
+// ModRevision returns the revision the current key was last written at as
+// of the iterator's revision.
+func (it *Iterator) ModRevision() uint64 {
+	return it.modRevision
+}
+
+// Value returns a copy of the value of the current key, or nil in key-only
+// mode.
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Close releases the iterator's hold on the history. Next reports no more
+// keys once it is closed.
+func (it *Iterator) Close() {
+	it.closeOnce.Do(func() {
+		it.store.snapshots.unpin(it.revision)
+		it.next = len(it.keys)
+		it.key, it.value = "", nil
+	})
+}
+
+// snapshots counts the open iterators at each revision, which history
+// compaction must not pass.
+type snapshots struct {
+	mu     sync.Mutex
+	pinned map[uint64]int
+}
+
+func (p *snapshots) pin(revision uint64) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pinned == nil {
+		p.pinned = make(map[uint64]int)
+	}
+	p.pinned[revision]++
+	return revision
+}
+
+func (p *snapshots) unpin(revision uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pinned[revision]--; p.pinned[revision] <= 0 {
+		delete(p.pinned, revision)
+	}
+}
+
+// compact publishes horizon, lowered to the oldest pinned revision, as the
+// compacted revision and returns it, unless that would not advance it.
+// Holding the lock while publishing keeps a revision from being pinned
+// after compaction has passed it.
+func (p *snapshots) compact(compacted *atomic.Uint64, horizon uint64) (uint64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for revision := range p.pinned {
+		horizon = min(horizon, revision)
+	}
+	if horizon <= compacted.Load() {
+		return 0, false
+	}
+	compacted.Store(horizon)
+	return horizon, true
+}
@@ -0,0 +1,38 @@
+package store
+
+import (
+	"sort"
+	"strings"
+)
+
+// Keys returns up to limit live keys that start with prefix and sort after
+// the key after, in lexical order, and reports whether more keys follow.
+// Passing the last returned key as after resumes the listing; the cursor
+// stays valid however the store changes in between, although keys written
+// meanwhile may or may not be seen.
+//
+// Each call scans and sorts the matching keys, so it is meant for
+// inspection rather than hot paths.
+func (s *Store) Keys(prefix, after string, limit int) ([]string, bool) {
+	s.warmup.waitPrefix(prefix)
+	var keys []string
+	s.data.Range(func(key string, _ []byte) bool {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+		return false
+	})
+	sort.Strings(keys)
+
+	live := make([]string, 0, min(len(keys), max(limit, 0)+1))
+	for _, key := range keys {
+		if _, ok := s.load(key); !ok {
+			continue
+		}
+		live = append(live, key)
+		if limit > 0 && len(live) > limit {
+			return live[:limit], true
+		}
+	}
+	return live, false
+}
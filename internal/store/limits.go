@@ -0,0 +1,59 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Defaults for the size limits left zero in Options.
+const (
+	DefaultMaxKeyLength = 4096
+	DefaultMaxValueSize = 4 << 20
+)
+
+// ErrKeyTooLong is returned when writing a key longer than
+// Options.MaxKeyLength.
+var ErrKeyTooLong = errors.New("store: key too long")
+
+// ErrValueTooLarge is returned when writing a value larger than
+// Options.MaxValueSize.
+var ErrValueTooLarge = errors.New("store: value too large")
+
+// sizeLimits bounds the keys and values a write may carry; a zero limit
+// is disabled.
+type sizeLimits struct {
+	maxKey   int
+	maxValue int
+}
+
+func newSizeLimits(opts Options) sizeLimits {
+	l := sizeLimits{maxKey: opts.MaxKeyLength, maxValue: opts.MaxValueSize}
+	for _, d := range []struct {
+		value    *int
+		fallback int
+	}{
+		{&l.maxKey, DefaultMaxKeyLength},
+		{&l.maxValue, DefaultMaxValueSize},
+	} {
+		switch {
+		case *d.value == 0:
+			*d.value = d.fallback
+		case *d.value < 0:
+			*d.value = 0
+		}
+	}
+	return l
+}
+
+// checkSet fails for a write of value to key that breaks the limits.
+// Deletes are not checked, so keys written before a limit was lowered can
+// still be removed.
+func (l sizeLimits) checkSet(key string, value []byte) error {
+	if l.maxKey > 0 && len(key) > l.maxKey {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrKeyTooLong, len(key), l.maxKey)
+	}
+	if l.maxValue > 0 && len(value) > l.maxValue {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrValueTooLarge, len(value), l.maxValue)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ComponentWAL names the WAL record format in the manifest.
+const ComponentWAL = "wal"
+
+// currentFormats are the on-disk format versions written by this build.
+//
+// WAL versions:
+//  1. set and delete records
+//  2. adds batch records
+//  3. adds expiration times to set records
+//  4. adds a version byte to every record
+//  5. writes records in the binary encoding
+//  6. adds snappy and zstd compressed records
+//
+// Checkpoint versions:
+//  1. WAL-framed set records, in the WAL record encodings
+//
+// Record revisions were added without a version change: older builds ignore
+// them and entries without one are numbered in replay order.
+var currentFormats = map[string]int{
+	ComponentWAL:        6,
+	ComponentCheckpoint: 1,
+}
+
+// ErrUnsupportedFormat is returned when the data on disk uses a format
+// version this build cannot read or migrate.
+var ErrUnsupportedFormat = errors.New("store: unsupported on-disk format")
+
+// Manifest records the format version of every on-disk component. It is
+// stored next to the WAL as <wal path>.manifest.
+type Manifest struct {
+	Formats map[string]int `json:"formats"`
+}
+
+// Migration upgrades one component of the data at walPath from version From
+// to From+1.
+type Migration struct {
+	Component string
+	From      int
+	Apply     func(walPath string) error
+}
+
+var (
+	migrationsMu sync.RWMutex
+	migrations   = make(map[string]map[int]Migration)
+)
+
+// RegisterMigration makes m available to upgrade older data on open.
+// Registering two migrations for the same component and version panics.
+func RegisterMigration(m Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+
+	byVersion, ok := migrations[m.Component]
+	if !ok {
+		byVersion = make(map[int]Migration)
+		migrations[m.Component] = byVersion
+	}
+	if _, exists := byVersion[m.From]; exists {
+		panic(fmt.Sprintf("store: duplicate migration for %s version %d", m.Component, m.From))
+	}
+	byVersion[m.From] = m
+}
+
+func init() {
+	// Version 2 only adds a record type, so version 1 logs are valid as is.
+	// The bump keeps older builds from silently skipping batch records.
+	RegisterMigration(Migration{Component: ComponentWAL, From: 1, Apply: func(string) error { return nil }})
+	// Likewise version 3 only adds a field; older builds would ignore it and
+	// never expire keys.
+	RegisterMigration(Migration{Component: ComponentWAL, From: 2, Apply: func(string) error { return nil }})
+	// Version 4 readers still decode unversioned records, so older logs
+	// replay as they are; older builds would take the flagged lengths for
+	// corruption.
+	RegisterMigration(Migration{Component: ComponentWAL, From: 3, Apply: func(string) error { return nil }})
+	// Version 5 still reads gob records; older builds cannot read binary
+	// ones.
+	RegisterMigration(Migration{Component: ComponentWAL, From: 4, Apply: func(string) error { return nil }})
+	// Likewise version 6 adds compressed records next to the plain ones.
+	RegisterMigration(Migration{Component: ComponentWAL, From: 5, Apply: func(string) error { return nil }})
+}
+
+func manifestPath(walPath string) string {
+	return walPath + ".manifest"
+}
+
+// openManifest reads the manifest for walPath, upgrading older components
+// with the registered migrations and refusing versions it cannot handle. A
+// missing manifest is created: at the current versions for a new WAL, and at
+// version 1 for existing data, which predates the manifest.
+func openManifest(walPath string, log *slog.Logger) error {
+	manifest, err := readManifest(walPath)
+	if errors.Is(err, os.ErrNotExist) {
+		existing, err := hasData(walPath)
+		if err != nil {
+			return err
+		}
+		if !existing {
+			return writeManifest(walPath, Manifest{Formats: maps.Clone(currentFormats)})
+		}
+		manifest = Manifest{Formats: map[string]int{ComponentWAL: 1}}
+	} else if err != nil {
+		return err
+	}
+
+	components := make([]string, 0, len(currentFormats))
+	for component := range currentFormats {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	for _, component := range components {
+		current := currentFormats[component]
+		version, ok := manifest.Formats[component]
+		if !ok {
+			manifest.Formats[component] = current
+			continue
+		}
+		if version > current {
+			return fmt.Errorf("%w: %s format version %d was written by a newer release (this build supports up to %d)", ErrUnsupportedFormat, component, version, current)
+		}
+
+		for version < current {
+			migration, ok := lookupMigration(component, version)
+			if !ok {
+				return fmt.Errorf("%w: no migration for %s format version %d to %d", ErrUnsupportedFormat, component, version, version+1)
+			}
+
+			log.Info("migrating on-disk format", "component", component, "from", version, "to", version+1)
+			if err := migration.Apply(walPath); err != nil {
+				return fmt.Errorf("store: migrate %s format %d to %d: %w", component, version, version+1, err)
+			}
+
+			version++
+			manifest.Formats[component] = version
+			// Persist after every step so an interrupted upgrade resumes
+			// from the last completed migration.
+			if err := writeManifest(walPath, manifest); err != nil {
+				return err
+			}
+		}
+	}
+
+	return writeManifest(walPath, manifest)
+}
+
+// hasData reports whether any segment or checkpoint of the WAL at walPath
+// exists.
+func hasData(walPath string) (bool, error) {
+	segments, err := listSegments(walPath)
+	if err != nil {
+		return false, err
+	}
+	checkpoints, err := listCheckpoints(walPath)
+	if err != nil {
+		return false, err
+	}
+	return len(segments) > 0 || len(checkpoints) > 0, nil
+}
+
+func lookupMigration(component string, from int) (Migration, bool) {
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+
+	m, ok := migrations[component][from]
+	return m, ok
+}
+
+func readManifest(walPath string) (Manifest, error) {
+	data, err := os.ReadFile(manifestPath(walPath))
+	if err != nil {
+		return Manifest{}, fmt.Errorf("store: read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("store: parse manifest: %w", err)
+	}
+	if manifest.Formats == nil {
+		manifest.Formats = make(map[string]int)
+	}
+	return manifest, nil
+}
+
+// writeManifest replaces the manifest atomically.
+func writeManifest(walPath string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("store: encode manifest: %w", err)
+	}
+
+	path := manifestPath(walPath)
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("store: write manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("store: write manifest: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("store: sync manifest: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("store: write manifest: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), walFileMode); err != nil {
+		return fmt.Errorf("store: write manifest: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("store: replace manifest: %w", err)
+	}
+	return nil
+}
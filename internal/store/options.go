@@ -0,0 +1,132 @@
+package store
+
+import (
+	"log/slog"
+	"path/filepath"
+	"time"
+	"universe/internal/events"
+)
+
+// Option changes one setting of the Options that New and NewWAL start from.
+// Options compose in order, later ones overriding earlier ones; NewWAL
+// ignores the settings that only concern a Store.
+type Option func(*Options)
+
+// newOptions applies opts to the defaults of New: WAL segments rotate at
+// DefaultSegmentSize and a checkpoint is written on close.
+func newOptions(opts []Option) Options {
+	o := Options{
+		WAL:               WALOptions{SegmentSize: DefaultSegmentSize},
+		CheckpointOnClose: true,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// resolve returns path, placed in DataDir when it is relative.
+func (o Options) resolve(path string) string {
+	if o.DataDir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(o.DataDir, path)
+}
+
+// loggerOr returns log, or fallback when log is nil.
+func loggerOr(log, fallback *slog.Logger) *slog.Logger {
+	if log == nil {
+		return fallback
+	}
+	return log
+}
+
+// WithDataDir resolves a relative WAL path in dir.
+func WithDataDir(dir string) Option {
+	return func(o *Options) { o.DataDir = dir }
+}
+
+// WithLogger sends the logs of the store and its WAL to log instead of the
+// shared category loggers.
+func WithLogger(log *slog.Logger) Option {
+	return func(o *Options) {
+		o.Logger = log
+		o.WAL.Logger = log
+	}
+}
+
+// WithClock sets the clock TTLs are set and expired by, e.g. a fake one in
+// tests.
+func WithClock(now func() time.Time) Option {
+	return func(o *Options) { o.Clock = now }
+}
+
+// WithEvents publishes the store's writes and expirations on bus, shared
+// with other subsystems.
+func WithEvents(bus *events.Bus) Option {
+	return func(o *Options) { o.Events = bus }
+}
+
+// WithLimits sets the largest key and value, in bytes, that writes may
+// carry; see Options.MaxKeyLength.
+func WithLimits(maxKeyLength, maxValueSize int) Option {
+	return func(o *Options) {
+		o.MaxKeyLength = maxKeyLength
+		o.MaxValueSize = maxValueSize
+	}
+}
+
+// WithWAL replaces the WAL settings. A logger set by WithLogger is kept
+// unless wal sets its own.
+func WithWAL(wal WALOptions) Option {
+	return func(o *Options) {
+		if wal.Logger == nil {
+			wal.Logger = o.WAL.Logger
+		}
+		o.WAL = wal
+	}
+}
+
+// WithHistoryRevisions sets how many revisions of past values are kept.
+func WithHistoryRevisions(revisions int) Option {
+	return func(o *Options) { o.HistoryRevisions = revisions }
+}
+
+// WithRecoverPrefixes makes the store load and serve only keys with one of
+// prefixes.
+func WithRecoverPrefixes(prefixes ...string) Option {
+	return func(o *Options) { o.RecoverPrefixes = prefixes }
+}
+
+// WithWarmPrefixes makes recovery load keys with one of prefixes before
+// the store opens and the other keys in the background after.
+func WithWarmPrefixes(prefixes ...string) Option {
+	return func(o *Options) { o.WarmPrefixes = prefixes }
+}
+
+// WithCheckpointOnClose sets whether a checkpoint is written on close.
+func WithCheckpointOnClose(enabled bool) Option {
+	return func(o *Options) { o.CheckpointOnClose = enabled }
+}
+
+// WithTTLJitter spreads out the expirations of keys written with the same
+// TTL; see Options.TTLJitter.
+func WithTTLJitter(jitter float64) Option {
+	return func(o *Options) { o.TTLJitter = jitter }
+}
+
+// WithSweep tunes the deletion of expired keys; zero values keep the
+// defaults.
+func WithSweep(interval time.Duration, batchSize int, maxShare float64) Option {
+	return func(o *Options) {
+		o.SweepInterval = interval
+		o.SweepBatchSize = batchSize
+		o.SweepMaxShare = maxShare
+	}
+}
+
+// WithSlowWriteThreshold sets how long a write may take before it is
+// logged.
+func WithSlowWriteThreshold(threshold time.Duration) Option {
+	return func(o *Options) { o.SlowWriteThreshold = threshold }
+}
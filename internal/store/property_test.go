@@ -0,0 +1,254 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// model is the reference the store is checked against: a plain map.
+type model map[string][]byte
+
+func (m model) clone() model {
+	c := make(model, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// matches reports the first difference between the store and m.
+func (m model) matches(s *Store) error {
+	if got := s.data.Count(); got != len(m) {
+		return fmt.Errorf("store has %d keys, model has %d", got, len(m))
+	}
+	for key, want := range m {
+		got, ok := s.Get(key)
+		if !ok {
+			return fmt.Errorf("key %q missing", key)
+		}
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("key %q = %q, want %q", key, got, want)
+		}
+	}
+	return nil
+}
+
+// crashCopy copies the WAL segments and checkpoints of s as they are on disk into dir,
+// which is what a restarted process would find after a crash. Buffered,
+// unflushed entries are not part of the copy.
+func crashCopy(t *testing.T, s *Store, walPath, dir string) string {
+	t.Helper()
+
+	s.wal.flushMu.Lock()
+	defer s.wal.flushMu.Unlock()
+
+	segments, err := listSegments(walPath)
+	if err != nil {
+		t.Fatalf("list segments: %v", err)
+	}
+
+	checkpoints, err := listCheckpoints(walPath)
+	if err != nil {
+		t.Fatalf("list checkpoints: %v", err)
+	}
+
+	target := filepath.Join(dir, filepath.Base(walPath))
+	for _, segment := range segments {
+		copyFile(t, segmentPath(walPath, segment), segmentPath(target, segment))
+	}
+	for _, checkpoint := range checkpoints {
+		copyFile(t, checkpointPath(walPath, checkpoint), checkpointPath(target, checkpoint))
+	}
+	return target
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatalf("open %s: %v", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatalf("create %s: %v", dst, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		t.Fatalf("copy %s: %v", src, err)
+	}
+}
+
+// TestStoreMatchesModel runs random sequences of sets, deletes, batches, flushes,
+// clean restarts and crashes against the store and a map model. After a clean
+// restart the store must equal the model; after a crash it must equal the
+// model as of some point between the last flush and the crash.
+func TestStoreMatchesModel(t *testing.T) {
+	const (
+		sequences = 20
+		steps     = 300
+	)
+
+	for seq := 0; seq < sequences; seq++ {
+		seed := int64(seq)
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			runModelSequence(t, rand.New(rand.NewSource(seed)), steps)
+		})
+	}
+}
+
+func runModelSequence(t *testing.T, rng *rand.Rand, steps int) {
+	walPath := filepath.Join(t.TempDir(), "model.wal")
+	opts := Options{WAL: WALOptions{SegmentSize: 1 << 10}, CheckpointOnClose: rng.Intn(2) == 0}
+
+	store, err := NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	want := model{}
+	// durable holds the model states any of which a crash may recover to:
+	// the state at the last flush and every state after it.
+	durable := []model{want.clone()}
+
+	for step := 0; step < steps; step++ {
+		key := fmt.Sprintf("key-%d", rng.Intn(16))
+
+		switch op := rng.Intn(100); {
+		case op < 50:
+			value := []byte(fmt.Sprintf("value-%d", rng.Intn(1000)))
+			if err := store.Set(key, value); err != nil {
+				t.Fatalf("step %d: set %s: %v", step, key, err)
+			}
+			want[key] = value
+			durable = append(durable, want.clone())
+
+		case op < 70:
+			_, existed := want[key]
+			deleted, err := store.Delete(key)
+			if err != nil {
+				t.Fatalf("step %d: delete %s: %v", step, key, err)
+			}
+			if deleted != existed {
+				t.Fatalf("step %d: delete %s reported %v, model says %v", step, key, deleted, existed)
+			}
+			delete(want, key)
+			durable = append(durable, want.clone())
+
+		case op < 80:
+			var batch WriteBatch
+			for i := rng.Intn(4); i >= 0; i-- {
+				key := fmt.Sprintf("key-%d", rng.Intn(16))
+				if rng.Intn(3) == 0 {
+					batch.Delete(key)
+					delete(want, key)
+					continue
+				}
+				value := []byte(fmt.Sprintf("batch-%d", rng.Intn(1000)))
+				batch.Set(key, value)
+				want[key] = value
+			}
+			if err := store.Write(&batch); err != nil {
+				t.Fatalf("step %d: write batch: %v", step, err)
+			}
+			// A batch is one record: a crash recovers all of it or none.
+			durable = append(durable, want.clone())
+
+		case op < 90:
+			store.wal.flushBuffer()
+			durable = []model{want.clone()}
+
+		case op < 95:
+			if err := store.Close(); err != nil {
+				t.Fatalf("step %d: close: %v", step, err)
+			}
+			store, err = NewWithOptions(walPath, opts)
+			if err != nil {
+				t.Fatalf("step %d: reopen: %v", step, err)
+			}
+			if err := want.matches(store); err != nil {
+				t.Fatalf("step %d: after restart: %v", step, err)
+			}
+			durable = []model{want.clone()}
+
+		default:
+			crashed := crashCopy(t, store, walPath, t.TempDir())
+			if err := store.Close(); err != nil {
+				t.Fatalf("step %d: close: %v", step, err)
+			}
+			walPath = crashed
+			store, err = NewWithOptions(walPath, opts)
+			if err != nil {
+				t.Fatalf("step %d: recover after crash: %v", step, err)
+			}
+
+			recovered := false
+			for i := len(durable) - 1; i >= 0; i-- {
+				if durable[i].matches(store) == nil {
+					want = durable[i]
+					recovered = true
+					break
+				}
+			}
+			if !recovered {
+				t.Fatalf("step %d: state after crash matches no flushed prefix: %v", step, durable[0].matches(store))
+			}
+			durable = []model{want.clone()}
+		}
+
+		if err := want.matches(store); err != nil {
+			t.Fatalf("step %d: %v", step, err)
+		}
+	}
+}
+
+func TestSyncAlwaysSurvivesCrash(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "always.wal")
+	opts := Options{WAL: WALOptions{Sync: SyncAlways, FlushInterval: time.Hour}}
+
+	store, err := NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := store.Set(fmt.Sprintf("key-%d", i), []byte("value")); err != nil {
+				t.Errorf("set: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Without a flush, only fsynced entries are in the copy.
+	crashed, err := NewWithOptions(crashCopy(t, store, walPath, t.TempDir()), opts)
+	if err != nil {
+		t.Fatalf("recover after crash: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = crashed.Close()
+	})
+
+	if got := crashed.data.Count(); got != 20 {
+		t.Fatalf("expected 20 acknowledged keys after crash, got %d", got)
+	}
+}
@@ -0,0 +1,79 @@
+package store
+
+import "fmt"
+
+// Every record payload starts with a version byte naming the encoding of the
+// entry that follows. Records written before versions existed carry no
+// version byte; their length prefix has the versionedFlag bit clear and
+// their payload is a bare gob-encoded WALEntry.
+const (
+	// versionedFlag marks a length prefix whose payload starts with a
+	// record version byte. Payloads are far smaller than 2 GiB, so the high
+	// bit of the length is free.
+	versionedFlag = 1 << 31
+
+	// recordVersionLegacy is the implied version of unversioned records.
+	recordVersionLegacy byte = 0
+	// recordVersionGob is a gob-encoded WALEntry after the version byte.
+	recordVersionGob byte = 1
+	// recordVersionBinary is the binaryCodec layout after the version byte.
+	recordVersionBinary byte = 2
+	// recordVersionSnappy and recordVersionZstd are the binaryCodec layout
+	// compressed with snappy and zstd.
+	recordVersionSnappy byte = 3
+	recordVersionZstd   byte = 4
+)
+
+// recordCodec encodes new records.
+var recordCodec codec = binaryCodec{}
+
+// recordCodecs decode each known record version into the current WALEntry.
+// A new encoding adds its codec here so that logs written by older builds
+// stay readable.
+var recordCodecs = map[byte]codec{
+	recordVersionLegacy: gobCodec{v: recordVersionLegacy},
+	recordVersionGob:    gobCodec{v: recordVersionGob},
+	recordVersionBinary: binaryCodec{},
+	recordVersionSnappy: snappyCodec,
+	recordVersionZstd:   zstdCodec,
+}
+
+// encodePayload encodes entry as a record payload with recordCodec. Large
+// entries are compressed as configured when that makes them smaller;
+// readers tell the two apart by the version byte.
+func encodePayload(entry WALEntry, compression Compression) ([]byte, error) {
+	data, err := recordCodec.append([]byte{recordCodec.version()}, entry)
+	if err != nil {
+		return nil, fmt.Errorf("store: encode wal entry %q: %w", entry.Key, err)
+	}
+
+	if c, ok := compressionCodecs[compression]; ok && len(data) > compressMinSize {
+		compressed := append([]byte{c.version()}, c.compress(data[1:])...)
+		if len(compressed) < len(data) {
+			return compressed, nil
+		}
+	}
+	return data, nil
+}
+
+// decodePayload decodes a record payload. versioned reports whether the
+// record's length prefix had versionedFlag set.
+func decodePayload(payload []byte, versioned bool) (WALEntry, error) {
+	version := recordVersionLegacy
+	if versioned {
+		if len(payload) == 0 {
+			return WALEntry{}, fmt.Errorf("store: wal record without version: %w", ErrCorruptWAL)
+		}
+		version, payload = payload[0], payload[1:]
+	}
+
+	codec, ok := recordCodecs[version]
+	if !ok {
+		return WALEntry{}, fmt.Errorf("%w: wal record version %d", ErrUnsupportedFormat, version)
+	}
+	entry, err := codec.decode(payload)
+	if err != nil {
+		return WALEntry{}, fmt.Errorf("store: decode wal entry (record version %d): %w", version, err)
+	}
+	return entry, nil
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+	"universe/internal/logging"
+)
+
+const recoveryLogInterval = 5 * time.Second
+
+var storeLogger = logging.For(logging.CategoryStore)
+
+// ErrRecovering is returned by Ready while a store opened with Open is
+// still replaying its WAL.
+var ErrRecovering = errors.New("store: recovery in progress")
+
+// RecoveryProgress describes how far WAL replay has progressed.
+type RecoveryProgress struct {
+	Entries    int64         `json:"entries"`
+	Bytes      int64         `json:"bytes"`
+	TotalBytes int64         `json:"total_bytes"`
+	Elapsed    time.Duration `json:"elapsed_ns"`
+	Done       bool          `json:"done"`
+	// Deferred counts the replayed entries outside Options.WarmPrefixes
+	// still to be applied after the store opened.
+	Deferred int64 `json:"deferred_entries"`
+}
+
+// ETA estimates the remaining replay time from the throughput so far. It
+// returns zero when recovery is done or no bytes were processed yet.
+func (p RecoveryProgress) ETA() time.Duration {
+	if p.Done || p.Bytes == 0 || p.TotalBytes <= p.Bytes {
+		return 0
+	}
+	perByte := float64(p.Elapsed) / float64(p.Bytes)
+	return time.Duration(perByte * float64(p.TotalBytes-p.Bytes))
+}
+
+// Percent returns the share of the WAL replayed so far.
+func (p RecoveryProgress) Percent() float64 {
+	if p.TotalBytes == 0 {
+		return 100
+	}
+	return float64(p.Bytes) / float64(p.TotalBytes) * 100
+}
+
+// recoveryTracker is updated by the replay loop and read concurrently by
+// progress reporters.
+type recoveryTracker struct {
+	// started is when replay started, in Unix nanoseconds.
+	started    atomic.Int64
+	finished   atomic.Int64
+	entries    atomic.Int64
+	bytes      atomic.Int64
+	totalBytes atomic.Int64
+	deferred   atomic.Int64
+}
+
+func (t *recoveryTracker) start(totalBytes int64) {
+	t.finished.Store(0)
+	t.entries.Store(0)
+	t.bytes.Store(0)
+	t.totalBytes.Store(totalBytes)
+	t.started.Store(time.Now().UnixNano())
+}
+
+func (t *recoveryTracker) advance(size int64) {
+	t.entries.Add(1)
+	t.bytes.Add(size)
+}
+
+func (t *recoveryTracker) finish() {
+	t.finished.Store(time.Now().UnixNano())
+}
+
+func (t *recoveryTracker) snapshot() RecoveryProgress {
+	p := RecoveryProgress{
+		Entries:    t.entries.Load(),
+		Bytes:      t.bytes.Load(),
+		TotalBytes: t.totalBytes.Load(),
+		Deferred:   t.deferred.Load(),
+	}
+
+	started := t.started.Load()
+	if finished := t.finished.Load(); finished != 0 {
+		p.Done = true
+		p.Elapsed = time.Duration(finished - started)
+	} else if started != 0 {
+		p.Elapsed = time.Since(time.Unix(0, started))
+	}
+
+	return p
+}
+
+// logUntil logs replay progress every interval until done is closed.
+func (t *recoveryTracker) logUntil(log *slog.Logger, done <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			p := t.snapshot()
+			log.Info("recovery in progress",
+				"entries", p.Entries,
+				"bytes", p.Bytes,
+				"total_bytes", p.TotalBytes,
+				"percent", int(p.Percent()),
+				"eta", p.ETA().Round(time.Second))
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrRelocating is returned by Relocate while another relocation runs.
+var ErrRelocating = errors.New("store: relocation already in progress")
+
+// ErrRelocationTarget is returned by Relocate for a directory the WAL cannot
+// be moved to.
+var ErrRelocationTarget = errors.New("store: invalid relocation target")
+
+// maxRedirects bounds how many relocation redirects opening a WAL follows,
+// so a redirect loop fails instead of hanging.
+const maxRedirects = 8
+
+// Relocation reports a finished Relocate.
+type Relocation struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+	// Pause is how long writes were held up while the active segment was
+	// copied and appends switched over.
+	Pause   time.Duration `json:"pause_ns"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+}
+
+// redirectPath is where a relocated WAL leaves the path it moved to.
+func redirectPath(walPath string) string {
+	return walPath + ".moved"
+}
+
+// Relocate moves the WAL, its checkpoint and manifest to dir, which may be
+// on another volume, while the store keeps serving. It seals the active
+// segment and copies the sealed files first, then pauses writes only to
+// copy what was appended meanwhile and to switch appends to dir. The old
+// files are removed, leaving a redirect, written before appends resume,
+// that makes opening the old path open the new one; the configured path
+// should still be updated.
+//
+// dir must not already hold the WAL's files. If ctx is done before the
+// switch, the copies are removed and the store carries on where it was.
+func (s *Store) Relocate(ctx context.Context, dir string) (Relocation, error) {
+	if !s.relocating.CompareAndSwap(false, true) {
+		return Relocation{}, ErrRelocating
+	}
+	defer s.relocating.Store(false)
+
+	start := time.Now()
+	w := s.wal
+	oldPath := w.currentPath()
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return Relocation{}, fmt.Errorf("store: relocate: %w", err)
+	}
+	newPath := filepath.Join(dir, filepath.Base(oldPath))
+	result := Relocation{From: oldPath, To: newPath}
+	if err := checkRelocationTarget(oldPath, newPath); err != nil {
+		return result, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return result, fmt.Errorf("store: relocate: create directory: %w", err)
+	}
+
+	// Copies are removed again unless appends end up switched to them, or
+	// a redirect to them could not be taken back.
+	var copied, moved []string
+	keepCopies := false
+	defer func() {
+		if !keepCopies {
+			for _, path := range copied {
+				_ = os.Remove(path)
+			}
+		}
+	}()
+	copyOne := func(src, dst string) error {
+		n, err := copyWALFile(src, dst)
+		if err != nil {
+			return err
+		}
+		copied = append(copied, dst)
+		moved = append(moved, src)
+		result.Files++
+		result.Bytes += n
+		return nil
+	}
+
+	// Sealing starts a new segment, so that only what is appended from now
+	// on is copied with writes paused.
+	active, err := w.seal()
+	if err != nil {
+		return result, err
+	}
+	sealed := []string{manifestPath(oldPath)}
+	if w.first > 0 {
+		sealed = append(sealed, checkpointPath(oldPath, w.first))
+	}
+	segments, err := listSegments(oldPath)
+	if err != nil {
+		return result, err
+	}
+	for _, segment := range segments {
+		if segment >= w.first && segment < active {
+			sealed = append(sealed, segmentPath(oldPath, segment))
+		}
+	}
+	for _, src := range sealed {
+		if err := ctx.Err(); err != nil {
+			return result, fmt.Errorf("store: relocate: %w", err)
+		}
+		if err := copyOne(src, filepath.Join(dir, filepath.Base(src))); err != nil {
+			return result, err
+		}
+	}
+
+	// Appends resume only once the redirect is durable: a write
+	// acknowledged from the new location must not be lost to a restart
+	// that opens the old one.
+	pause := time.Now()
+	s.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		s.mu.Unlock()
+		return result, fmt.Errorf("store: relocate: %w", err)
+	}
+	err = w.relocate(newPath, active, copyOne, func() error {
+		return commitRelocation(oldPath, newPath)
+	})
+	s.mu.Unlock()
+	result.Pause = time.Since(pause)
+	if err != nil {
+		// A redirect left by a half-done commit would point a restart at
+		// the copies about to be removed.
+		if rmErr := os.Remove(redirectPath(oldPath)); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+			s.log.Error("remove redirect of a failed relocation; keeping the copies it points to", "path", redirectPath(oldPath), "error", rmErr)
+			keepCopies = true
+		}
+		return result, err
+	}
+	keepCopies = true
+
+	// From here on the store runs from dir; failing to clean up the old
+	// location only costs its space.
+	for _, path := range moved {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.log.Warn("remove relocated file", "path", path, "error", err)
+		}
+	}
+
+	result.Elapsed = time.Since(start)
+	s.log.Info("data directory relocated", "from", oldPath, "to", newPath, "files", result.Files, "bytes", result.Bytes, "pause", result.Pause, "elapsed", result.Elapsed)
+	return result, nil
+}
+
+// commitRelocation makes the copies at newPath the WAL a restart opens:
+// their directory is synced and oldPath redirected to them. A redirect left
+// at newPath by an earlier move away from it now points the wrong way and
+// is removed last, so that a failure up to then leaves it in force; a
+// crash just before its removal leaves a redirect loop, which fails the
+// restart rather than opening either copy.
+func commitRelocation(oldPath, newPath string) error {
+	dir := filepath.Dir(newPath)
+	if err := syncDir(dir); err != nil {
+		return fmt.Errorf("store: relocate: sync %s: %w", dir, err)
+	}
+	if err := writeRedirect(oldPath, newPath); err != nil {
+		return fmt.Errorf("store: relocate: write redirect: %w", err)
+	}
+	if err := os.Remove(redirectPath(newPath)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("store: relocate: remove stale redirect: %w", err)
+	}
+	// The copies must stay whether or not this sync succeeds: without it a
+	// crash can at worst bring the stale redirect back, into a loop.
+	_ = syncDir(dir)
+	return nil
+}
+
+// checkRelocationTarget refuses a target that is the current location or
+// already holds files of a WAL with the same name, other than the redirect
+// of a WAL moved away from it.
+func checkRelocationTarget(oldPath, newPath string) error {
+	if filepath.Dir(oldPath) == filepath.Dir(newPath) {
+		return fmt.Errorf("%w: the wal is already in %s", ErrRelocationTarget, filepath.Dir(newPath))
+	}
+	matches, err := filepath.Glob(escapeGlob(newPath) + "*")
+	if err != nil {
+		return fmt.Errorf("store: relocate: %w", err)
+	}
+	for _, match := range matches {
+		if match != redirectPath(newPath) {
+			return fmt.Errorf("%w: %s already holds wal files such as %s", ErrRelocationTarget, filepath.Dir(newPath), match)
+		}
+	}
+	return nil
+}
+
+func escapeGlob(path string) string {
+	return strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `\`, `\\`).Replace(path)
+}
+
+// copyWALFile copies src to the new file dst and fsyncs it, returning the
+// bytes copied.
+func copyWALFile(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, fmt.Errorf("store: relocate: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, walFileMode)
+	if err != nil {
+		return 0, fmt.Errorf("store: relocate: %w", err)
+	}
+	n, err := io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return 0, fmt.Errorf("store: relocate: copy %s: %w", src, err)
+	}
+	return n, nil
+}
+
+// writeRedirect atomically records at oldPath's redirect that the WAL now
+// lives at newPath.
+func writeRedirect(oldPath, newPath string) error {
+	tmp := redirectPath(oldPath) + ".tmp"
+	if err := os.WriteFile(tmp, []byte(newPath+"\n"), walFileMode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, redirectPath(oldPath)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(oldPath))
+}
+
+// followRedirects returns the path a WAL at path was relocated to, or path
+// itself if it was not.
+func followRedirects(path string, log *slog.Logger) (string, error) {
+	for range maxRedirects {
+		data, err := os.ReadFile(redirectPath(path))
+		if errors.Is(err, os.ErrNotExist) {
+			return path, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("store: read relocation redirect: %w", err)
+		}
+		next := strings.TrimSpace(string(data))
+		log.Warn("wal was relocated; update the configured path", "path", path, "relocated_to", next)
+		path = next
+	}
+	return "", fmt.Errorf("store: more than %d relocation redirects from %s", maxRedirects, path)
+}
+
+// currentPath returns the WAL's path, which Relocate may change.
+func (w *WAL) currentPath() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.path
+}
+
+// seal writes out the buffered entries and starts a new segment, so that
+// every earlier segment is complete, and returns the new segment's number.
+func (w *WAL) seal() (int, error) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	if err := w.writable(); err != nil {
+		return 0, err
+	}
+	w.flushLocked()
+	if err := w.writable(); err != nil {
+		return 0, err
+	}
+	if err := w.writer.Flush(); err != nil {
+		return 0, fmt.Errorf("store: flush wal buffer: %w", err)
+	}
+	if err := w.rotate(); err != nil {
+		return 0, err
+	}
+	return int(w.segment.Load()), nil
+}
+
+// relocate copies the segments from segment from on to newPath with
+// copyOne, runs commit and, if that succeeds, switches appends to newPath.
+// The caller keeps appends out, so the segments copied are complete.
+func (w *WAL) relocate(newPath string, from int, copyOne func(src, dst string) error, commit func() error) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	if err := w.writable(); err != nil {
+		return err
+	}
+	w.flushLocked()
+	if err := w.writable(); err != nil {
+		return err
+	}
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("store: flush wal buffer: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("store: sync wal: %w", err)
+	}
+	w.markSynced()
+
+	active := int(w.segment.Load())
+	for segment := from; segment <= active; segment++ {
+		if err := copyOne(segmentPath(w.path, segment), segmentPath(newPath, segment)); err != nil {
+			return err
+		}
+	}
+	file, size, err := openSegment(newPath, active)
+	if err != nil {
+		return err
+	}
+	if err := commit(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		w.log.Warn("close wal segment", "path", w.file.Name(), "error", err)
+	}
+
+	w.mu.Lock()
+	w.path = newPath
+	w.mu.Unlock()
+	w.file = file
+	w.writer.Reset(file)
+	w.segmentBytes.Store(size)
+	return nil
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// DefaultHistoryRevisions is how many revisions of history are kept when
+// Options.HistoryRevisions is zero.
+const DefaultHistoryRevisions = 10000
+
+// ErrCompacted is returned when reading at a revision whose history has
+// already been discarded.
+var ErrCompacted = errors.New("store: revision compacted")
+
+// ErrFutureRevision is returned when reading at a revision not written yet.
+var ErrFutureRevision = errors.New("store: revision not yet written")
+
+// version is a key's value as of a revision. A deleted version marks the
+// revision at which the key was removed.
+type version struct {
+	revision uint64
+	value    []byte
+	deleted  bool
+}
+
+// Revision returns the revision of the latest change applied to the store.
+func (s *Store) Revision() uint64 {
+	return s.revision.Load()
+}
+
+// CompactedRevision returns the oldest revision GetAt can still read.
+func (s *Store) CompactedRevision() uint64 {
+	return s.compacted.Load()
+}
+
+// GetAt returns a copy of the value key had as of revision. It fails with
+// ErrCompacted for revisions older than CompactedRevision and with
+// ErrFutureRevision for revisions newer than Revision. Expiration is not
+// taken into account until the sweeper has deleted a key.
+func (s *Store) GetAt(key string, revision uint64) ([]byte, bool, error) {
+	s.warmup.wait(key)
+	if current := s.revision.Load(); revision > current {
+		return nil, false, fmt.Errorf("%w: %d is after %d", ErrFutureRevision, revision, current)
+	}
+	if compacted := s.compacted.Load(); revision < compacted {
+		return nil, false, fmt.Errorf("%w: %d is before %d", ErrCompacted, revision, compacted)
+	}
+
+	versions, ok := s.history.Load(key)
+	if !ok {
+		return nil, false, nil
+	}
+	// i is the first version written after revision.
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].revision > revision })
+	if i == 0 || versions[i-1].deleted {
+		return nil, false, nil
+	}
+	return bytes.Clone(versions[i-1].value), true, nil
+}
+
+// record appends a version of key. Versions are never modified once
+// published, so readers may use a loaded slice without locking.
+func (s *Store) record(key string, v version) {
+	versions, _ := s.history.Load(key)
+	s.history.Store(key, append(versions, v))
+}
+
+// compactHistory discards versions superseded more than historyRevisions
+// revisions ago.
+func (s *Store) compactHistory() {
+	current := s.revision.Load()
+	if current <= s.historyRevisions {
+		return
+	}
+	// Publish the horizon first so readers stop asking for what is about
+	// to be removed. Open iterators hold it back.
+	horizon, ok := s.snapshots.compact(&s.compacted, current-s.historyRevisions)
+	if !ok {
+		return
+	}
+
+	var keys []string
+	s.history.Range(func(key string, versions []version) bool {
+		if len(versions) > 1 || versions[0].deleted {
+			keys = append(keys, key)
+		}
+		return false
+	})
+
+	for _, key := range keys {
+		s.compactKey(key, horizon)
+	}
+}
+
+// compactKey drops the versions of key that no read at or after horizon can
+// see: everything before the last version at or before horizon, and that
+// version too if it is a deletion.
+func (s *Store) compactKey(key string, horizon uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, ok := s.history.Load(key)
+	if !ok {
+		return
+	}
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].revision > horizon }) - 1
+	if i >= 0 && versions[i].deleted {
+		i++
+	}
+	if i <= 0 {
+		return
+	}
+	if i == len(versions) {
+		s.history.Delete(key)
+		return
+	}
+	// Copy rather than reslice in place: readers may hold the old slice.
+	s.history.Store(key, append([]version(nil), versions[i:]...))
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// SequencePrefix starts the keys holding the high-water marks of the ID
+// sequences. Only the store writes them; writes and deletes through the
+// store's API fail with ErrReservedKey.
+const SequencePrefix = SystemPrefix + "seq/"
+
+// idBlockSize is how many IDs a sequence reserves with one WAL write.
+const idBlockSize = 1000
+
+// sequences hands out IDs from blocks reserved in the WAL.
+type sequences struct {
+	mu     sync.Mutex
+	blocks map[string]*idBlock
+}
+
+// idBlock is the unissued part of a reserved block: next up to and
+// including limit.
+type idBlock struct {
+	next, limit uint64
+}
+
+// NextIDs issues count consecutive IDs from sequence and returns the first.
+// IDs start at 1 and increase monotonically, also across restarts; IDs
+// reserved but not issued before a restart are skipped, so sequences can
+// have gaps.
+func (s *Store) NextIDs(sequence string, count int) (uint64, error) {
+	if sequence == "" {
+		return 0, fmt.Errorf("store: sequence name must not be empty")
+	}
+	if count <= 0 {
+		return 0, fmt.Errorf("store: id count must be positive")
+	}
+	n := uint64(count)
+
+	s.sequences.mu.Lock()
+	defer s.sequences.mu.Unlock()
+
+	if s.sequences.blocks == nil {
+		s.sequences.blocks = make(map[string]*idBlock)
+	}
+	block := s.sequences.blocks[sequence]
+	if block == nil || block.limit-block.next+1 < n {
+		start, err := s.reserveIDs(sequence, max(n, idBlockSize))
+		if err != nil {
+			return 0, err
+		}
+		block = &idBlock{next: start, limit: start + max(n, idBlockSize) - 1}
+		s.sequences.blocks[sequence] = block
+	}
+
+	first := block.next
+	block.next += n
+	return first, nil
+}
+
+// reserveIDs moves the high-water mark of sequence up by size and returns
+// the first ID of the reserved block once the reservation is durable, so
+// that no ID is issued twice after a crash.
+func (s *Store) reserveIDs(sequence string, size uint64) (uint64, error) {
+	key := SequencePrefix + sequence
+	if err := s.limits.checkSet(key, nil); err != nil {
+		return 0, err
+	}
+	s.warmup.wait(key)
+	for {
+		var (
+			high      uint64
+			condition = IfMissing(key)
+		)
+		if value, revision, ok := s.GetVersion(key); ok {
+			parsed, err := strconv.ParseUint(string(value), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("store: sequence %q has a corrupt high-water mark: %w", sequence, err)
+			}
+			high, condition = parsed, IfRevision(key, revision)
+		}
+		if high > ^uint64(0)-size {
+			return 0, fmt.Errorf("store: sequence %q is exhausted", sequence)
+		}
+
+		mark := WALEntry{Type: OperationSet, Key: key, Value: []byte(strconv.FormatUint(high+size, 10))}
+		_, err := s.commitOps([]WALEntry{mark}, func() error {
+			if !s.holds(condition) {
+				return fmt.Errorf("%w: %s", ErrConditionFailed, condition)
+			}
+			return nil
+		})
+		if errors.Is(err, ErrConditionFailed) {
+			// The mark was written behind our back; read it again.
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := s.Sync(); err != nil {
+			return 0, err
+		}
+		return high + 1, nil
+	}
+}
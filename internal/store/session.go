@@ -0,0 +1,54 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrInvalidSession is returned for a session token the store cannot parse.
+var ErrInvalidSession = errors.New("store: invalid session token")
+
+// ErrSessionAhead is returned for a session that has written at a revision
+// this store does not have, so reading here could miss the session's own
+// writes. On a single node that means the writes were lost in a crash.
+var ErrSessionAhead = errors.New("store: session is ahead of the store")
+
+// SessionToken returns the token a client session carries after a write:
+// it covers everything written so far, including the session's earlier
+// writes covered by token, which may be empty. Tokens are opaque to
+// clients; they hold the revision the session has seen writes up to.
+func (s *Store) SessionToken(token string) string {
+	revision := s.revision.Load()
+	if seen, err := parseSessionToken(token); err == nil && seen > revision {
+		revision = seen
+	}
+	return strconv.FormatUint(revision, 10)
+}
+
+// CheckSession reports whether reads from the store reflect every write of
+// the session token was issued to. It fails with ErrSessionAhead when they
+// may not and with ErrInvalidSession for a malformed token.
+//
+// Tokens stay valid across restarts as far as acknowledged writes survive
+// them, which under SyncNever they may not: revisions lost in a crash are
+// reused, so a token issued before it is not told apart from one issued
+// after.
+func (s *Store) CheckSession(token string) error {
+	seen, err := parseSessionToken(token)
+	if err != nil {
+		return err
+	}
+	if current := s.revision.Load(); seen > current {
+		return fmt.Errorf("%w: session has seen revision %d, store is at %d", ErrSessionAhead, seen, current)
+	}
+	return nil
+}
+
+func parseSessionToken(token string) (uint64, error) {
+	revision, err := strconv.ParseUint(token, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidSession, token)
+	}
+	return revision, nil
+}
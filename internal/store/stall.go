@@ -0,0 +1,76 @@
+package store
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultStallThreshold = 500 * time.Millisecond
+	defaultStallFlushes   = 3
+	defaultThrottleDelay  = 5 * time.Millisecond
+)
+
+// stallDetector watches WAL flush latency. Once flushes exceed threshold for
+// several flushes in a row the WAL is considered stalled and appends are
+// throttled until a flush completes under the threshold again.
+type stallDetector struct {
+	log       *slog.Logger
+	threshold time.Duration
+	flushes   int
+	delay     time.Duration
+
+	stalled atomic.Bool
+
+	mu         sync.Mutex
+	slowInARow int
+	stalls     int
+	lastFlush  time.Duration
+}
+
+func newStallDetector(log *slog.Logger) *stallDetector {
+	return &stallDetector{
+		log:       log,
+		threshold: defaultStallThreshold,
+		flushes:   defaultStallFlushes,
+		delay:     defaultThrottleDelay,
+	}
+}
+
+// record registers a flush latency and updates the stall state.
+func (d *stallDetector) record(latency time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lastFlush = latency
+
+	if latency <= d.threshold {
+		d.slowInARow = 0
+		if d.stalled.CompareAndSwap(true, false) {
+			d.log.Info("write stall cleared, throttling disabled", "flush_latency", latency)
+		}
+		return
+	}
+
+	d.slowInARow++
+	if d.slowInARow >= d.flushes && d.stalled.CompareAndSwap(false, true) {
+		d.stalls++
+		d.log.Warn("write stall detected, throttling appends",
+			"flush_latency", latency, "threshold", d.threshold, "slow_flushes", d.slowInARow)
+	}
+}
+
+// throttle delays the caller while the WAL is stalled.
+func (d *stallDetector) throttle() {
+	if d.stalled.Load() {
+		time.Sleep(d.delay)
+	}
+}
+
+func (d *stallDetector) stats() (stalled bool, stalls int, lastFlush time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stalled.Load(), d.stalls, d.lastFlush
+}
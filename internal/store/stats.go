@@ -0,0 +1,75 @@
+package store
+
+import "time"
+
+// Stats is a point-in-time view of the store's internal state.
+type Stats struct {
+	Keys     int    `json:"keys"`
+	Revision uint64 `json:"revision"`
+	// Compacted is the oldest revision whose values can still be read.
+	Compacted  uint64           `json:"compacted_revision"`
+	Watchers   int              `json:"watchers"`
+	WAL        WALStats         `json:"wal"`
+	Recovery   RecoveryProgress `json:"recovery"`
+	Expiration ExpirationStats  `json:"expiration"`
+}
+
+// WALStats reports the depth of the WAL's in-memory queues.
+type WALStats struct {
+	// ActiveEntries are buffered appends not yet handed to the flusher.
+	ActiveEntries int `json:"active_entries"`
+	// PendingEntries are entries currently being written by the flusher.
+	PendingEntries int `json:"pending_entries"`
+	// FlushSignals is the backlog of flush requests queued for the flusher.
+	FlushSignals int `json:"flush_signals"`
+	// Stalled reports whether appends are currently throttled.
+	Stalled bool `json:"stalled"`
+	// Stalls counts write stalls detected since the WAL was opened.
+	Stalls int `json:"stalls"`
+	// LastFlush is the duration of the most recent flush.
+	LastFlush time.Duration `json:"last_flush_ns"`
+	// Segment is the sequence number of the active segment.
+	Segment int `json:"segment"`
+	// SegmentBytes is the size of the active segment.
+	SegmentBytes int64 `json:"segment_bytes"`
+	// Error is the write failure that stopped the WAL accepting appends.
+	Error string `json:"error,omitempty"`
+}
+
+// Stats returns the current store statistics.
+func (s *Store) Stats() Stats {
+	return Stats{
+		Keys:       s.data.Count(),
+		Revision:   s.Revision(),
+		Compacted:  s.CompactedRevision(),
+		Watchers:   s.watchers.count(),
+		WAL:        s.wal.Stats(),
+		Recovery:   s.RecoveryProgress(),
+		Expiration: s.ExpirationStats(),
+	}
+}
+
+// Stats returns the current WAL queue depths and active segment.
+func (w *WAL) Stats() WALStats {
+	stalled, stalls, lastFlush := w.stall.stats()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var walErr string
+	if w.err != nil {
+		walErr = w.err.Error()
+	}
+
+	return WALStats{
+		ActiveEntries:  len(w.activeBuffer),
+		PendingEntries: len(w.pendingBuffer),
+		FlushSignals:   len(w.flushChan),
+		Stalled:        stalled,
+		Stalls:         stalls,
+		LastFlush:      lastFlush,
+		Segment:        int(w.segment.Load()),
+		SegmentBytes:   w.segmentBytes.Load(),
+		Error:          walErr,
+	}
+}
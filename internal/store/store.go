@@ -3,8 +3,13 @@ package store
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
+	"universe/internal/events"
 
 	csmap "github.com/mhmtszr/concurrent-swiss-map"
 )
@@ -14,46 +19,289 @@ type Store struct {
 	wal  *WAL
 	data *csmap.CsMap[string, []byte]
 	mu   sync.Mutex
+	log  *slog.Logger
+	// now is the clock TTLs are set and expired by.
+	now func() time.Time
+
+	// expiry holds the expiration time, in Unix nanoseconds, of keys set
+	// with a TTL.
+	expiry *csmap.CsMap[string, int64]
+	// ttlJitter is the fraction by which TTLs are randomly shortened or
+	// lengthened.
+	ttlJitter float64
+	sweeper   *sweeper
+
+	recovery recoveryTracker
+	// opened is set once recovery has finished and the store serves.
+	opened atomic.Bool
+
+	// revision is the revision of the latest applied WAL entry; every entry
+	// is written with the next one.
+	revision atomic.Uint64
+	watchers watchers
+	// events carries the store's writes and expirations to its
+	// subscribers, watchers among them.
+	events             *events.Bus
+	unsubscribeWatches func()
+
+	// history holds each key's versions in revision order, back to the
+	// compacted revision.
+	history          *csmap.CsMap[string, []version]
+	compacted        atomic.Uint64
+	historyRevisions uint64
+	// snapshots holds compaction back for open iterators.
+	snapshots snapshots
+
+	// filter is the set of key prefixes a partially recovered store
+	// serves.
+	filter keyFilter
+	warmup *warmup
+	limits sizeLimits
+	// slowWrite is how long a write may take before it is logged; zero
+	// disables the log.
+	slowWrite time.Duration
+
+	sequences sequences
+	// runID prefixes the tokens of asynchronously acknowledged writes.
+	runID string
+
+	checkpointOnClose bool
+	// relocating is set while Relocate runs.
+	relocating atomic.Bool
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Options configures a Store.
+type Options struct {
+	WAL WALOptions
+	// SweepInterval is how often expired keys are deleted; zero means
+	// DefaultSweepInterval.
+	SweepInterval time.Duration
+	// SweepBatchSize caps the keys one sweep deletes; zero means
+	// DefaultSweepBatchSize.
+	SweepBatchSize int
+	// SweepMaxShare is the fraction of SweepInterval one sweep may spend
+	// deleting keys, bounding the sweeper's CPU use; zero means
+	// DefaultSweepMaxShare.
+	SweepMaxShare float64
+	// CheckpointOnClose writes a checkpoint of all keys when the store is
+	// closed, so the next start replays no WAL entries.
+	CheckpointOnClose bool
+	// HistoryRevisions is how many revisions of past values GetAt can read;
+	// zero means DefaultHistoryRevisions.
+	HistoryRevisions int
+	// WarmPrefixes, when set, makes recovery load the keys with these
+	// prefixes first: the store opens once they are loaded, and the other
+	// keys are loaded in the background. Until then reads and writes of
+	// other keys, and listings and watches that may include them, wait.
+	WarmPrefixes []string
+	// RecoverPrefixes, when set, makes the store load and serve only keys
+	// with one of these prefixes, and the SystemPrefix keys: other WAL
+	// entries are skipped during recovery and writes to other keys fail
+	// with ErrKeyNotServed. No checkpoint is written on close, since it
+	// would drop the skipped keys.
+	RecoverPrefixes []string
+	// TTLJitter spreads out expirations of keys written with the same TTL:
+	// each TTL is scaled by a random factor between 1-TTLJitter and
+	// 1+TTLJitter when the key is written. It must be in [0, 1); zero
+	// keeps TTLs exact.
+	TTLJitter float64
+	// MaxKeyLength and MaxValueSize bound the keys and values, in bytes,
+	// that writes may carry; larger ones fail with ErrKeyTooLong and
+	// ErrValueTooLarge. Zero means DefaultMaxKeyLength and
+	// DefaultMaxValueSize, a negative limit disables it.
+	MaxKeyLength int
+	MaxValueSize int
+	// SlowWriteThreshold is how long a write may take before it is logged
+	// as slow, together with the request ID carried by its context; zero
+	// means DefaultSlowWriteThreshold, a negative threshold disables the
+	// log.
+	SlowWriteThreshold time.Duration
+	// DataDir, when set, is the directory a relative WAL path is resolved
+	// in.
+	DataDir string
+	// Logger receives the store's logs, and the WAL's unless WAL.Logger is
+	// set; nil means the store and wal category loggers.
+	Logger *slog.Logger
+	// Events is the bus writes and expirations are published on; nil
+	// means a bus of the store's own. Subscribers registered before the
+	// store is created also see the writes replayed by recovery.
+	Events *events.Bus
+	// Clock tells the time that TTLs are set and expired by; nil means
+	// time.Now. Latencies are always measured with the real clock.
+	Clock func() time.Time
 }
 
-// New creates a store backed by the provided WAL file path and runs recovery.
-func New(walPath string) (*Store, error) {
-	wal, err := NewWAL(walPath)
+// New creates a store backed by the provided WAL file path and runs
+// recovery. Without opts the WAL rotates at DefaultSegmentSize and a
+// checkpoint is written on close.
+func New(walPath string, opts ...Option) (*Store, error) {
+	return NewWithOptions(walPath, newOptions(opts))
+}
+
+// NewWithOptions is New with explicit options.
+func NewWithOptions(walPath string, opts Options) (*Store, error) {
+	s, err := newStore(walPath, opts)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.open(); err != nil {
+		s.unsubscribeWatches()
+		_ = s.wal.Close()
+		return nil, err
+	}
+	return s, nil
+}
 
-	s := &Store{
-		wal:  wal,
-		data: csmap.Create[string, []byte](),
+// Open is NewWithOptions that runs recovery in the background, so that a
+// server can answer health probes during a long replay. Until the returned
+// channel yields nil only Ready, RecoveryProgress and Close may be called;
+// Ready reports ErrRecovering meanwhile. If recovery fails, or Close stops
+// it, the channel yields the error instead and the store must be closed.
+func Open(walPath string, opts Options) (*Store, <-chan error, error) {
+	s, err := newStore(walPath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	recovered := make(chan error, 1)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		recovered <- s.open()
+	}()
+	return s, recovered, nil
+}
+
+// newStore creates the store and its WAL without recovering it.
+func newStore(walPath string, opts Options) (*Store, error) {
+	if opts.TTLJitter < 0 || opts.TTLJitter >= 1 {
+		return nil, fmt.Errorf("store: ttl jitter %v must be at least 0 and below 1", opts.TTLJitter)
 	}
 
-	if err := s.Recover(); err != nil {
-		_ = wal.Close()
+	if opts.WAL.Logger == nil {
+		opts.WAL.Logger = opts.Logger
+	}
+	wal, err := NewWALWithOptions(opts.resolve(walPath), opts.WAL)
+	if err != nil {
 		return nil, err
 	}
 
+	s := &Store{
+		wal:    wal,
+		data:   csmap.Create[string, []byte](),
+		expiry: csmap.Create[string, int64](),
+		done:   make(chan struct{}),
+		log:    loggerOr(opts.Logger, storeLogger),
+		now:    opts.Clock,
+
+		ttlJitter: opts.TTLJitter,
+		sweeper:   newSweeper(opts),
+
+		history:          csmap.Create[string, []version](),
+		historyRevisions: DefaultHistoryRevisions,
+
+		filter:            keyFilter(opts.RecoverPrefixes),
+		warmup:            newWarmup(opts.WarmPrefixes),
+		limits:            newSizeLimits(opts),
+		slowWrite:         slowWriteThreshold(opts.SlowWriteThreshold),
+		checkpointOnClose: opts.CheckpointOnClose && len(opts.RecoverPrefixes) == 0,
+		runID:             newRunID(),
+	}
+	if s.now == nil {
+		s.now = time.Now
+	}
+	s.watchers.log = s.log
+	s.events = opts.Events
+	if s.events == nil {
+		s.events = events.New()
+	}
+	s.unsubscribeWatches = s.events.Subscribe(s.watchers.handle, events.TopicWrite)
+	if opts.HistoryRevisions > 0 {
+		s.historyRevisions = uint64(opts.HistoryRevisions)
+	}
+
 	return s, nil
 }
 
-// Recover replays the WAL to reconstruct in-memory state.
+// open recovers the store and starts serving it.
+func (s *Store) open() error {
+	if err := s.Recover(); err != nil {
+		return err
+	}
+	s.finishWarmup()
+	s.startSweeper()
+	s.opened.Store(true)
+	return nil
+}
+
+// Recover loads the latest checkpoint, if any, and replays the WAL written
+// after it to reconstruct in-memory state, logging progress periodically for
+// long replays.
 func (s *Store) Recover() error {
-	entries, err := s.wal.ReadAll()
+	walBytes, err := s.wal.Size()
 	if err != nil {
 		return fmt.Errorf("store: recover wal: %w", err)
 	}
+	checkpointBytes, err := s.checkpointSize()
+	if err != nil {
+		return fmt.Errorf("store: recover checkpoint: %w", err)
+	}
+
+	if len(s.filter) > 0 {
+		s.log.Info("recovering only keys with prefixes", "prefixes", []string(s.filter))
+	}
+	if len(s.warmup.prefixes) > 0 {
+		s.log.Info("recovering keys with warm prefixes first", "prefixes", []string(s.warmup.prefixes))
+	}
+	s.recovery.start(checkpointBytes + walBytes)
+	done := make(chan struct{})
+	go s.recovery.logUntil(s.log, done, recoveryLogInterval)
+	defer close(done)
 
-	for _, entry := range entries {
-		s.applyEntry(entry)
+	apply := func(entry WALEntry, size int64) error {
+		select {
+		case <-s.done:
+			return ErrClosed
+		default:
+		}
+		now, later := s.warmup.split(entry)
+		s.applyEntry(now)
+		if later.Type != "" {
+			s.warmup.deferred = append(s.warmup.deferred, later)
+		}
+		s.recovery.advance(size)
+		return nil
+	}
+	if err := s.loadCheckpoint(apply); err != nil {
+		return fmt.Errorf("store: recover checkpoint: %w", err)
+	}
+	// A checkpoint keeps only the latest version of each key.
+	s.compacted.Store(s.revision.Load())
+	if err := s.wal.Replay(apply); err != nil {
+		return fmt.Errorf("store: recover wal: %w", err)
 	}
 
+	s.recovery.finish()
+	p := s.recovery.snapshot()
+	s.log.Info("recovery complete", "entries", p.Entries, "bytes", p.Bytes, "elapsed", p.Elapsed)
+
 	return nil
 }
 
+// RecoveryProgress reports the progress of the most recent WAL replay.
+func (s *Store) RecoveryProgress() RecoveryProgress {
+	return s.recovery.snapshot()
+}
+
 // Get returns a copy of the stored value for the key.
 func (s *Store) Get(key string) ([]byte, bool) {
+	s.warmup.wait(key)
 	value, ok := s.data.Load(key)
-	if !ok {
+	if !ok || s.expiredOnRead(key) {
 		return nil, false
 	}
 
@@ -62,57 +310,228 @@ func (s *Store) Get(key string) ([]byte, bool) {
 }
 
 // Set writes the value for the provided key and persists the mutation to the WAL.
+// If the WAL write fails (reported directly under SyncAlways, otherwise by
+// later writes and Sync) the value may stay visible in memory until restart,
+// but no further writes are accepted.
 func (s *Store) Set(key string, value []byte) error {
+	return s.SetTimed(context.Background(), key, value, nil)
+}
+
+// SetTimed is Set that also records a latency breakdown into timing when it
+// is non-nil. A write whose context is already done is not made, and slow
+// writes are logged with ctx so they can be matched to the request.
+func (s *Store) SetTimed(ctx context.Context, key string, value []byte, timing *Timing) error {
+	_, err := s.set(ctx, key, value, 0, timing, false)
+	return err
+}
+
+// set stores value under key, expiring it at expiresAt (Unix nanoseconds)
+// unless that is zero, and returns the revision it was written at. Unless
+// async is set it waits for the write to be durable as the sync policy
+// demands.
+func (s *Store) set(ctx context.Context, key string, value []byte, expiresAt int64, timing *Timing, async bool) (uint64, error) {
 	if key == "" {
-		return fmt.Errorf("store: key must not be empty")
+		return 0, fmt.Errorf("store: key must not be empty")
+	}
+	if err := s.limits.checkSet(key, value); err != nil {
+		return 0, err
+	}
+	if err := s.checkWrite(key); err != nil {
+		return 0, err
 	}
+	s.warmup.wait(key)
 
 	valueCopy := bytes.Clone(value)
 
-	entry := WALEntry{Type: OperationSet, Key: key, Value: valueCopy}
+	entry := WALEntry{Type: OperationSet, Key: key, Value: valueCopy, ExpiresAt: expiresAt}
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("store: set %q: %w", key, err)
+	}
+
+	var t Timing
+	defer t.copyTo(timing)
 
+	start := time.Now()
+	defer s.logSlowWrite(ctx, "set", key, &t, start)
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	start = t.lap(&t.LockWait, start)
 
-	if err := s.wal.Append(entry); err != nil {
-		return err
+	entry.Revision = s.revision.Load() + 1
+
+	seq, err := s.wal.enqueue(entry)
+	if err != nil {
+		s.mu.Unlock()
+		return 0, err
 	}
+	start = t.lap(&t.WALAppend, start)
 
-	s.data.Store(key, valueCopy)
-	return nil
+	s.applyEntry(entry)
+	start = t.lap(&t.Apply, start)
+	s.mu.Unlock()
+
+	if async {
+		return entry.Revision, nil
+	}
+	// Wait for durability outside the lock so concurrent writers share an
+	// fsync under SyncAlways.
+	waited, err := s.wal.waitDurable(seq)
+	if waited {
+		t.lap(&t.Sync, start)
+	}
+	return entry.Revision, err
 }
 
 // Delete removes the key from the store and records the mutation.
 func (s *Store) Delete(key string) (bool, error) {
+	return s.DeleteTimed(context.Background(), key, nil)
+}
+
+// DeleteTimed is Delete that also records a latency breakdown into timing
+// when it is non-nil. Like SetTimed it honors and logs with ctx.
+func (s *Store) DeleteTimed(ctx context.Context, key string, timing *Timing) (bool, error) {
+	_, existed, err := s.delete(ctx, key, timing, false)
+	return existed, err
+}
+
+// delete removes key, returning the revision of the delete and whether the
+// key existed. Like set it waits for durability unless async is set.
+func (s *Store) delete(ctx context.Context, key string, timing *Timing, async bool) (uint64, bool, error) {
 	if key == "" {
-		return false, fmt.Errorf("store: key must not be empty")
+		return 0, false, fmt.Errorf("store: key must not be empty")
+	}
+	if err := s.checkWrite(key); err != nil {
+		return 0, false, err
 	}
+	s.warmup.wait(key)
 
 	entry := WALEntry{Type: OperationDelete, Key: key}
+	if err := ctx.Err(); err != nil {
+		return 0, false, fmt.Errorf("store: delete %q: %w", key, err)
+	}
 
+	var t Timing
+	defer t.copyTo(timing)
+
+	start := time.Now()
+	defer s.logSlowWrite(ctx, "delete", key, &t, start)
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	start = t.lap(&t.LockWait, start)
+
+	entry.Revision = s.revision.Load() + 1
 
-	if err := s.wal.Append(entry); err != nil {
-		return false, err
+	seq, err := s.wal.enqueue(entry)
+	if err != nil {
+		s.mu.Unlock()
+		return 0, false, err
 	}
+	start = t.lap(&t.WALAppend, start)
 
-	existed := s.data.Delete(key)
-	return existed, nil
+	_, existed := s.load(key)
+	s.applyEntry(entry)
+	start = t.lap(&t.Apply, start)
+	s.mu.Unlock()
+
+	if async {
+		return entry.Revision, existed, nil
+	}
+	waited, err := s.wal.waitDurable(seq)
+	if waited {
+		t.lap(&t.Sync, start)
+	}
+	return entry.Revision, existed, err
+}
+
+// Sync blocks until every write so far is durable on disk and returns the
+// WAL write failure if any of them was lost.
+func (s *Store) Sync() error {
+	return s.wal.Sync()
+}
+
+// Ready returns nil once the store has finished recovery while its WAL
+// accepts writes, and otherwise the reason it cannot serve.
+func (s *Store) Ready() error {
+	if !s.opened.Load() {
+		return ErrRecovering
+	}
+	return s.wal.writable()
+}
+
+// Events returns the bus the store publishes its writes and expirations
+// on. Event values are shared with the store and must not be modified.
+func (s *Store) Events() *events.Bus {
+	return s.events
 }
 
-// Close finishes pending writes and closes the WAL file.
+// Close stops the expiry sweeper, finishes pending writes, closes the WAL
+// file and, if enabled, writes a checkpoint. Later calls return the result of
+// the first.
 func (s *Store) Close() error {
-	return s.wal.Close()
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+		s.unsubscribeWatches()
+		s.watchers.close()
+
+		s.closeErr = s.wal.Close()
+		// A store closed before it recovered holds only part of the data.
+		if s.closeErr == nil && s.checkpointOnClose && s.opened.Load() {
+			s.closeErr = s.writeCheckpoint()
+		}
+	})
+	return s.closeErr
 }
 
+// applyEntry applies entry at its revision, records the new versions and
+// publishes the changes. The operations of a batch share one revision.
 func (s *Store) applyEntry(entry WALEntry) {
+	switch entry.Type {
+	case OperationSet, OperationDelete, OperationBatch:
+	default:
+		// Unknown entries are ignored to keep recovery tolerant.
+		return
+	}
+
+	revision := entry.Revision
+	if revision == 0 {
+		// Written before revisions were recorded.
+		revision = s.revision.Load() + 1
+	}
+	// Checkpoint records carry each key's own revision, so recovery may
+	// apply them out of order.
+	if revision > s.revision.Load() {
+		s.revision.Store(revision)
+	}
+	s.apply(entry, revision)
+}
+
+func (s *Store) apply(entry WALEntry, revision uint64) {
+	if entry.Type != OperationBatch && !s.filter.serves(entry.Key) {
+		// Skipped by a partial recovery; the revision still counts.
+		return
+	}
+
 	switch entry.Type {
 	case OperationSet:
 		s.data.Store(entry.Key, entry.Value)
+		if entry.ExpiresAt != 0 {
+			s.expiry.Store(entry.Key, entry.ExpiresAt)
+		} else {
+			s.expiry.Delete(entry.Key)
+		}
+		s.record(entry.Key, version{revision: revision, value: entry.Value})
+		s.events.Publish(events.TopicWrite, Event{Type: OperationSet, Key: entry.Key, Value: entry.Value, Revision: revision})
 	case OperationDelete:
-		s.data.Delete(entry.Key)
-	default:
-		// Unknown entries are ignored to keep recovery tolerant.
+		existed := s.data.Delete(entry.Key)
+		s.expiry.Delete(entry.Key)
+		if existed {
+			s.record(entry.Key, version{revision: revision, deleted: true})
+			s.events.Publish(events.TopicWrite, Event{Type: OperationDelete, Key: entry.Key, Revision: revision})
+		}
+	case OperationBatch:
+		for _, op := range entry.Batch {
+			if op.Type != OperationBatch {
+				s.apply(op, revision)
+			}
+		}
 	}
 }
@@ -2,9 +2,21 @@ package store
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"universe/internal/events"
+	"universe/internal/logging"
+	"universe/internal/panics"
 )
 
 func TestWALAppendAndReadAll(t *testing.T) {
@@ -195,3 +207,1805 @@ func BenchmarkStoreGet(b *testing.B) {
 		}
 	}
 }
+
+func TestStallDetectorThrottlesAndRecovers(t *testing.T) {
+	d := newStallDetector(walLogger)
+
+	for i := 0; i < d.flushes-1; i++ {
+		d.record(d.threshold + time.Millisecond)
+	}
+	if stalled, _, _ := d.stats(); stalled {
+		t.Fatalf("expected no stall before %d slow flushes", d.flushes)
+	}
+
+	d.record(d.threshold + time.Millisecond)
+	stalled, stalls, _ := d.stats()
+	if !stalled || stalls != 1 {
+		t.Fatalf("expected stall to be detected, got stalled=%v stalls=%d", stalled, stalls)
+	}
+
+	start := time.Now()
+	d.throttle()
+	if elapsed := time.Since(start); elapsed < d.delay {
+		t.Fatalf("expected throttle to delay at least %v, got %v", d.delay, elapsed)
+	}
+
+	d.record(time.Millisecond)
+	if stalled, _, _ := d.stats(); stalled {
+		t.Fatalf("expected stall to clear after a fast flush")
+	}
+}
+
+func TestWALSegmentRotation(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "segments.wal")
+
+	store, err := NewWithOptions(walPath, Options{WAL: WALOptions{SegmentSize: 256}})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := store.Set(fmt.Sprintf("key-%d", i), []byte("value")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+		if i%10 == 9 {
+			store.wal.flushBuffer()
+		}
+	}
+	if _, err := store.Delete("key-0"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+
+	segments, err := listSegments(walPath)
+	if err != nil {
+		t.Fatalf("list segments: %v", err)
+	}
+	if len(segments) < 2 {
+		t.Fatalf("expected the wal to rotate, got segments %v", segments)
+	}
+
+	store, err = NewWithOptions(walPath, Options{WAL: WALOptions{SegmentSize: 256}})
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	if _, ok := store.Get("key-0"); ok {
+		t.Fatalf("expected key-0 to stay deleted")
+	}
+	if got, ok := store.Get("key-49"); !ok || string(got) != "value" {
+		t.Fatalf("expected key-49 after replaying all segments, got %q", got)
+	}
+	if stats := store.Stats(); stats.Keys != 49 || stats.WAL.Segment != segments[len(segments)-1] {
+		t.Fatalf("unexpected stats after reopen: %+v", stats)
+	}
+}
+
+func TestOpenRecoversInBackground(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "open.wal")
+	kv, err := New(path, WithCheckpointOnClose(false))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	for i := range 3 {
+		if err := kv.Set(fmt.Sprintf("key%d", i), []byte("value")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+
+	// A subscriber that blocks holds the replay up after its first entry.
+	bus := events.New()
+	replaying := make(chan struct{}, 1)
+	resume := make(chan struct{})
+	bus.Subscribe(func(events.Event) {
+		select {
+		case replaying <- struct{}{}:
+		default:
+		}
+		<-resume
+	}, events.TopicWrite)
+	kv, recovered, err := Open(path, Options{Events: bus, CheckpointOnClose: true})
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	<-replaying
+	if err := kv.Ready(); !errors.Is(err, ErrRecovering) {
+		t.Fatalf("expected ErrRecovering during replay, got %v", err)
+	}
+	if p := kv.RecoveryProgress(); p.Done || p.TotalBytes == 0 {
+		t.Fatalf("unexpected progress during replay: %+v", p)
+	}
+
+	// Closing stops the replay, and must not checkpoint the part of the
+	// data recovered so far.
+	closed := make(chan error, 1)
+	go func() { closed <- kv.Close() }()
+	time.Sleep(10 * time.Millisecond)
+	close(resume)
+	if err := <-recovered; !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected the replay to stop with ErrClosed, got %v", err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatalf("close during recovery: %v", err)
+	}
+
+	kv, recovered, err = Open(path, Options{})
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	defer kv.Close()
+	if err := <-recovered; err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	if err := kv.Ready(); err != nil {
+		t.Fatalf("expected a recovered store to be ready, got %v", err)
+	}
+	for i := range 3 {
+		if _, ok := kv.Get(fmt.Sprintf("key%d", i)); !ok {
+			t.Fatalf("key%d lost by closing during recovery", i)
+		}
+	}
+}
+
+func TestRecoveryTruncatesTornWrites(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "torn.wal")
+	opts := Options{WAL: WALOptions{SegmentSize: 256}}
+
+	store, err := NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	for i := 0; i < 30; i++ {
+		if err := store.Set(fmt.Sprintf("key-%d", i), []byte("value")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+		if i%10 == 9 {
+			store.wal.flushBuffer()
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+	segments, err := listSegments(walPath)
+	if err != nil || len(segments) < 3 {
+		t.Fatalf("expected several segments, got %v, %v", segments, err)
+	}
+
+	// Tear the last record of the second segment, as a crash mid-write
+	// would, and corrupt nothing else.
+	torn := segmentPath(walPath, segments[1])
+	info, err := os.Stat(torn)
+	if err != nil {
+		t.Fatalf("stat segment: %v", err)
+	}
+	if err := os.Truncate(torn, info.Size()-3); err != nil {
+		t.Fatalf("tear segment: %v", err)
+	}
+
+	if _, err := NewWithOptions(walPath, opts); !errors.Is(err, ErrCorruptWAL) {
+		t.Fatalf("expected strict recovery to fail with ErrCorruptWAL, got %v", err)
+	}
+
+	opts.WAL.Recovery = RecoveryTruncate
+	store, err = NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("recover with truncation: %v", err)
+	}
+	if _, ok := store.Get("key-0"); !ok {
+		t.Fatalf("expected the records before the torn one to survive")
+	}
+	if _, ok := store.Get("key-29"); ok {
+		t.Fatalf("expected the records after the torn one to be discarded")
+	}
+	if got, err := listSegments(walPath); err != nil || len(got) != 2 {
+		t.Fatalf("expected the later segments to be removed, got %v, %v", got, err)
+	}
+	if err := store.Set("after", []byte("1")); err != nil {
+		t.Fatalf("set after truncation: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+
+	// The truncated log is clean again, even for strict recovery.
+	opts.WAL.Recovery = RecoveryStrict
+	store, err = NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("reopen truncated wal: %v", err)
+	}
+	defer store.Close()
+	if got, ok := store.Get("after"); !ok || string(got) != "1" {
+		t.Fatalf("expected the write after truncation to survive, got %q", got)
+	}
+}
+
+func TestInspectAndRepairWAL(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "repair.wal")
+	wal, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("create wal: %v", err)
+	}
+	for i := 1; i <= 4; i++ {
+		if err := wal.Append(WALEntry{Type: OperationSet, Key: fmt.Sprintf("key-%d", i), Value: []byte("v"), Revision: uint64(i)}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatalf("close wal: %v", err)
+	}
+
+	inspect := func() (keys []string, corrupt int) {
+		t.Helper()
+		err := InspectWAL(walPath, func(record WALRecord) error {
+			if record.Err != nil {
+				corrupt++
+			} else {
+				keys = append(keys, record.Entry.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("inspect: %v", err)
+		}
+		return keys, corrupt
+	}
+
+	// Flip a byte in the second record and tear a record onto the end.
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("read wal: %v", err)
+	}
+	size := len(data) / 4
+	data[size+lengthPrefix+checksumSize] ^= 0xff
+	data = append(data, 0x80, 0, 0)
+	if err := os.WriteFile(walPath, data, 0o600); err != nil {
+		t.Fatalf("write wal: %v", err)
+	}
+
+	keys, corrupt := inspect()
+	if strings.Join(keys, ",") != "key-1,key-3,key-4" || corrupt != 2 {
+		t.Fatalf("unexpected inspection: keys %v, %d corrupt", keys, corrupt)
+	}
+
+	result, err := RepairWAL(walPath, RepairSkip)
+	if err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if result.Corrupt != 2 || result.DiscardedBytes != int64(size+3) {
+		t.Fatalf("unexpected repair result %+v", result)
+	}
+	if keys, corrupt := inspect(); strings.Join(keys, ",") != "key-1,key-3,key-4" || corrupt != 0 {
+		t.Fatalf("unexpected inspection after skip: keys %v, %d corrupt", keys, corrupt)
+	}
+
+	if err := os.WriteFile(walPath, data, 0o600); err != nil {
+		t.Fatalf("write wal: %v", err)
+	}
+	if result, err = RepairWAL(walPath, RepairTruncate); err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if result.DiscardedBytes != int64(3*size+3) {
+		t.Fatalf("unexpected repair result %+v", result)
+	}
+	if keys, corrupt := inspect(); strings.Join(keys, ",") != "key-1" || corrupt != 0 {
+		t.Fatalf("unexpected inspection after truncate: keys %v, %d corrupt", keys, corrupt)
+	}
+}
+
+func TestWALReportsWriteFailures(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncInterval} {
+		t.Run(policy.String(), func(t *testing.T) {
+			wal, err := NewWALWithOptions(filepath.Join(t.TempDir(), "failing.wal"), WALOptions{Sync: policy})
+			if err != nil {
+				t.Fatalf("create wal: %v", err)
+			}
+			t.Cleanup(func() {
+				_ = wal.Close()
+			})
+
+			if err := wal.Append(WALEntry{Type: OperationSet, Key: "ok", Value: []byte("1")}); err != nil {
+				t.Fatalf("append before failure: %v", err)
+			}
+			if err := wal.Sync(); err != nil {
+				t.Fatalf("sync before failure: %v", err)
+			}
+
+			// Closing the segment underneath the WAL makes the next write fail.
+			_ = wal.file.Close()
+
+			err = wal.Append(WALEntry{Type: OperationSet, Key: "lost", Value: []byte("2")})
+			if policy == SyncAlways && !errors.Is(err, ErrWriteFailed) {
+				t.Fatalf("expected ErrWriteFailed from append, got %v", err)
+			}
+			if err := wal.Sync(); !errors.Is(err, ErrWriteFailed) {
+				t.Fatalf("expected ErrWriteFailed from sync, got %v", err)
+			}
+			if err := wal.Append(WALEntry{Type: OperationSet, Key: "after", Value: []byte("3")}); !errors.Is(err, ErrWriteFailed) {
+				t.Fatalf("expected appends after a failure to be rejected, got %v", err)
+			}
+			if stats := wal.Stats(); stats.Error == "" {
+				t.Fatalf("expected the failure in stats")
+			}
+		})
+	}
+}
+
+func TestWALFlusherPanic(t *testing.T) {
+	wal, err := NewWALWithOptions(filepath.Join(t.TempDir(), "panic.wal"), WALOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Fatalf("create wal: %v", err)
+	}
+	if err := wal.Append(WALEntry{Type: OperationSet, Key: "ok", Value: []byte("1")}); err != nil {
+		t.Fatalf("append before panic: %v", err)
+	}
+
+	// Without a writer the flusher panics on its next write.
+	panics.SetPolicy(panics.PolicyRestart)
+	reported := panics.Count()
+	wal.flushMu.Lock()
+	wal.writer = nil
+	wal.flushMu.Unlock()
+
+	if err := wal.Append(WALEntry{Type: OperationSet, Key: "lost", Value: []byte("2")}); !errors.Is(err, ErrWriteFailed) {
+		t.Fatalf("expected ErrWriteFailed from the append the flusher panicked on, got %v", err)
+	}
+	if panics.Count() != reported+1 {
+		t.Fatalf("expected the panic to be reported")
+	}
+	if err := wal.writable(); !errors.Is(err, ErrWriteFailed) {
+		t.Fatalf("expected a poisoned wal, got %v", err)
+	}
+	if err := wal.Append(WALEntry{Type: OperationSet, Key: "after", Value: []byte("3")}); !errors.Is(err, ErrWriteFailed) {
+		t.Fatalf("expected appends after the panic to be rejected, got %v", err)
+	}
+	if err := wal.Close(); !errors.Is(err, ErrWriteFailed) {
+		t.Fatalf("expected close to report the panic, got %v", err)
+	}
+}
+
+func TestStoreWriteBatch(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "batch.wal")
+
+	// Without a checkpoint, the reopened store replays the WAL.
+	store, err := NewWithOptions(walPath, Options{})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	if err := store.Set("stale", []byte("x")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	var invalid WriteBatch
+	invalid.Set("a", []byte("1"))
+	invalid.Set("", []byte("2"))
+	if err := store.Write(&invalid); err == nil {
+		t.Fatalf("expected a batch with an empty key to be rejected")
+	}
+	if _, ok := store.Get("a"); ok {
+		t.Fatalf("expected no operation of a rejected batch to be applied")
+	}
+
+	var batch WriteBatch
+	batch.Set("a", []byte("1"))
+	batch.Set("b", []byte("2"))
+	batch.Delete("stale")
+	batch.Set("a", []byte("3"))
+	if err := store.Write(&batch); err != nil {
+		t.Fatalf("write batch: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+
+	store, err = NewWithOptions(walPath, Options{})
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	if got, _ := store.Get("a"); string(got) != "3" {
+		t.Fatalf("expected the last write in the batch to win, got %q", got)
+	}
+	if got, _ := store.Get("b"); string(got) != "2" {
+		t.Fatalf("expected b=2, got %q", got)
+	}
+	if _, ok := store.Get("stale"); ok {
+		t.Fatalf("expected stale to be deleted by the batch")
+	}
+	if p := store.RecoveryProgress(); p.Entries != 2 {
+		t.Fatalf("expected the batch to be one wal record, replayed %d", p.Entries)
+	}
+}
+
+func TestTxnCommitAndConflict(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "txn.wal")
+
+	store, err := New(walPath)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	if err := store.Set("alice", []byte("100")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	transfer := store.Begin()
+	if _, ok := transfer.Get("alice"); !ok {
+		t.Fatalf("expected alice to exist")
+	}
+	if err := transfer.Set("alice", []byte("70")); err != nil {
+		t.Fatalf("txn set: %v", err)
+	}
+	if err := transfer.Set("bob", []byte("30")); err != nil {
+		t.Fatalf("txn set: %v", err)
+	}
+	if got, _ := transfer.Get("alice"); string(got) != "70" {
+		t.Fatalf("expected to read own write, got %q", got)
+	}
+	if _, ok := store.Get("bob"); ok {
+		t.Fatalf("expected uncommitted writes to be invisible")
+	}
+
+	stale := store.Begin()
+	stale.Get("alice")
+	stale.Set("alice", []byte("0"))
+
+	if err := transfer.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if err := stale.Commit(); !errors.Is(err, ErrTxnConflict) {
+		t.Fatalf("expected ErrTxnConflict, got %v", err)
+	}
+	if err := transfer.Commit(); !errors.Is(err, ErrTxnDone) {
+		t.Fatalf("expected ErrTxnDone on second commit, got %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+	store, err = New(walPath)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	alice, _ := store.Get("alice")
+	bob, _ := store.Get("bob")
+	if string(alice) != "70" || string(bob) != "30" {
+		t.Fatalf("unexpected balances after recovery: alice=%q bob=%q", alice, bob)
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "ttl.wal")
+
+	store, err := NewWithOptions(walPath, Options{SweepInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+
+	if err := store.SetWithTTL("session", []byte("abc"), 50*time.Millisecond); err != nil {
+		t.Fatalf("set with ttl: %v", err)
+	}
+	if err := store.SetWithTTL("renewed", []byte("old"), 50*time.Millisecond); err != nil {
+		t.Fatalf("set with ttl: %v", err)
+	}
+	if err := store.Set("renewed", []byte("new")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if _, ok := store.Get("session"); !ok {
+		t.Fatalf("expected session before its ttl")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := store.Get("session"); ok {
+		t.Fatalf("expected session to be hidden after its ttl")
+	}
+	if removed := store.sweep(time.Now()); removed != 1 {
+		t.Fatalf("expected the sweep to expire 1 key, got %d", removed)
+	}
+	if got, ok := store.Get("renewed"); !ok || string(got) != "new" {
+		t.Fatalf("expected a plain set to clear the ttl, got %q", got)
+	}
+
+	if err := store.SetWithTTL("pending", []byte("x"), 50*time.Millisecond); err != nil {
+		t.Fatalf("set with ttl: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	store, err = NewWithOptions(walPath, Options{SweepInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	if _, ok := store.Get("pending"); ok {
+		t.Fatalf("expected a key that expired while closed to stay expired")
+	}
+	if store.data.Count() != 2 {
+		t.Fatalf("expected renewed and the unswept pending key in the map, got %d", store.data.Count())
+	}
+}
+
+func TestTTLJitter(t *testing.T) {
+	if _, err := NewWithOptions(filepath.Join(t.TempDir(), "bad.wal"), Options{TTLJitter: 1}); err == nil {
+		t.Fatalf("expected a jitter of 1 to be rejected")
+	}
+
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "jitter.wal"), Options{SweepInterval: time.Hour, TTLJitter: 0.2})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	const ttl = time.Hour
+	before := time.Now()
+	expirations := make(map[int64]bool)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("cache:%d", i)
+		if err := store.SetWithTTL(key, []byte("v"), ttl); err != nil {
+			t.Fatalf("set with ttl: %v", err)
+		}
+		expiresAt, _ := store.expiry.Load(key)
+		lifetime := time.Duration(expiresAt - before.UnixNano())
+		if lifetime < ttl*8/10 || lifetime > ttl*12/10+time.Second {
+			t.Fatalf("%s expires after %v, outside 20%% of %v", key, lifetime, ttl)
+		}
+		expirations[expiresAt] = true
+	}
+	if len(expirations) < 40 {
+		t.Fatalf("expected the expirations to be spread out, got %d distinct", len(expirations))
+	}
+}
+
+func TestSweeperLimits(t *testing.T) {
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "sweep.wal"), Options{SweepInterval: time.Hour, SweepBatchSize: 3})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	for i := 0; i < 5; i++ {
+		if err := store.SetWithTTL(fmt.Sprintf("k%d", i), []byte("v"), time.Millisecond); err != nil {
+			t.Fatalf("set with ttl: %v", err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := store.Get("k0"); ok {
+		t.Fatalf("expected an expired key to be hidden before the sweep")
+	}
+
+	if removed := store.sweep(time.Now()); removed != 3 {
+		t.Fatalf("expected the batch size to cap the sweep at 3, got %d", removed)
+	}
+	stats := store.ExpirationStats()
+	if stats.Expired != 3 || stats.Overdue != 2 || stats.TTLKeys != 2 || stats.LazyExpirations == 0 {
+		t.Fatalf("unexpected stats after the first sweep: %+v", stats)
+	}
+	if removed := store.sweep(time.Now()); removed != 2 {
+		t.Fatalf("expected the second sweep to finish the backlog, got %d", removed)
+	}
+	if stats := store.ExpirationStats(); stats.Expired != 5 || stats.Overdue != 0 || stats.ExpiredPerSecond <= 0 {
+		t.Fatalf("unexpected stats after the second sweep: %+v", stats)
+	}
+
+	// Only reads count the expired keys they hide.
+	if err := store.SetWithTTL("lazy", []byte("v"), time.Millisecond); err != nil {
+		t.Fatalf("set with ttl: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	lazy := store.ExpirationStats().LazyExpirations
+	store.Keys("", "", 10)
+	store.TTL("lazy")
+	if _, err := store.Delete("k0"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if got := store.ExpirationStats().LazyExpirations; got != lazy {
+		t.Fatalf("expected listings and writes not to count lazy expirations, got %d after %d", got, lazy)
+	}
+	store.Get("lazy")
+	store.GetVersion("lazy")
+	if got := store.ExpirationStats().LazyExpirations; got != lazy+2 {
+		t.Fatalf("expected two lazy expirations from the reads, got %d after %d", got, lazy)
+	}
+
+	// A sweep stops once its deletes have used their share of the
+	// interval, but always deletes a key, however long collecting them
+	// took.
+	starved, err := NewWithOptions(filepath.Join(t.TempDir(), "starved.wal"), Options{SweepInterval: time.Hour, SweepMaxShare: 1e-15})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = starved.Close() })
+	for i := 0; i < 3; i++ {
+		if err := starved.SetWithTTL(fmt.Sprintf("k%d", i), []byte("v"), time.Millisecond); err != nil {
+			t.Fatalf("set with ttl: %v", err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	if removed := starved.sweep(time.Now()); removed != 1 || starved.ExpirationStats().Overdue != 2 {
+		t.Fatalf("expected the time budget to stop the sweep after one key, removed %d", removed)
+	}
+	for i := 0; i < 2; i++ {
+		starved.sweep(time.Now())
+	}
+	if stats := starved.ExpirationStats(); stats.Expired != 3 || stats.Overdue != 0 {
+		t.Fatalf("expected starved sweeps to work through the backlog, got %+v", stats)
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "limits.wal"), Options{MaxKeyLength: 8, MaxValueSize: 16})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	if err := store.Set("12345678", bytes.Repeat([]byte("v"), 16)); err != nil {
+		t.Fatalf("expected a write at the limits to pass: %v", err)
+	}
+	if err := store.Set("123456789", []byte("v")); !errors.Is(err, ErrKeyTooLong) {
+		t.Fatalf("expected ErrKeyTooLong, got %v", err)
+	}
+	if err := store.SetWithTTL("k", bytes.Repeat([]byte("v"), 17), time.Minute); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+
+	var batch WriteBatch
+	batch.Set("ok", []byte("v"))
+	batch.Set("big", bytes.Repeat([]byte("v"), 17))
+	if err := store.Write(&batch); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected the batch to fail with ErrValueTooLarge, got %v", err)
+	}
+	if _, ok := store.Get("ok"); ok {
+		t.Fatalf("a rejected batch was partly applied")
+	}
+
+	// Deletes are not limited, so keys written under a higher limit can
+	// still be removed.
+	if _, err := store.Delete("123456789"); err != nil {
+		t.Fatalf("delete of a long key: %v", err)
+	}
+
+	unlimited, err := NewWithOptions(filepath.Join(t.TempDir(), "unlimited.wal"), Options{MaxValueSize: -1})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = unlimited.Close() })
+	if err := unlimited.Set("big", make([]byte, DefaultMaxValueSize+1)); err != nil {
+		t.Fatalf("expected a negative limit to disable the check: %v", err)
+	}
+}
+
+func TestNextIDs(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "ids.wal")
+	store, err := NewWithOptions(walPath, Options{})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+
+	if id, err := store.NextIDs("orders", 1); err != nil || id != 1 {
+		t.Fatalf("expected the first id to be 1, got %d, %v", id, err)
+	}
+	if id, err := store.NextIDs("orders", 5); err != nil || id != 2 {
+		t.Fatalf("expected a range starting at 2, got %d, %v", id, err)
+	}
+	if id, _ := store.NextIDs("invoices", 1); id != 1 {
+		t.Fatalf("expected sequences to be independent, got %d", id)
+	}
+	// A request larger than a block reserves a block of its own.
+	big, err := store.NextIDs("orders", 2*idBlockSize)
+	if err != nil || big != idBlockSize+1 {
+		t.Fatalf("expected the large range to start at %d, got %d, %v", idBlockSize+1, big, err)
+	}
+
+	var (
+		mu   sync.Mutex
+		seen = make(map[uint64]bool)
+		wg   sync.WaitGroup
+	)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 300; i++ {
+				id, err := store.NextIDs("orders", 1)
+				if err != nil {
+					t.Errorf("next id: %v", err)
+					return
+				}
+				mu.Lock()
+				if seen[id] || id < big+2*idBlockSize {
+					t.Errorf("id %d issued twice or out of order", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	store, err = NewWithOptions(walPath, Options{})
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	id, err := store.NextIDs("orders", 1)
+	if err != nil {
+		t.Fatalf("next id: %v", err)
+	}
+	for issued := range seen {
+		if id <= issued {
+			t.Fatalf("id %d after restart is not above %d issued before", id, issued)
+		}
+	}
+	if _, err := store.NextIDs("", 1); err == nil {
+		t.Fatalf("expected an empty sequence name to be rejected")
+	}
+}
+
+func TestSequenceMarksReserved(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "reserved.wal")
+	store, err := New(walPath)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	issued, err := store.NextIDs("orders", 1)
+	if err != nil {
+		t.Fatalf("next id: %v", err)
+	}
+
+	// Only the store moves the high-water marks.
+	mark := SequencePrefix + "orders"
+	var batch WriteBatch
+	batch.Set(mark, []byte("0"))
+	txn := store.Begin()
+	for name, write := range map[string]func() error{
+		"set":    func() error { return store.Set(mark, []byte("0")) },
+		"delete": func() error { _, err := store.Delete(mark); return err },
+		"expire": func() error { _, err := store.Expire(mark, time.Second); return err },
+		"batch":  func() error { return store.Write(&batch) },
+		"cas":    func() error { _, err := store.CheckAndWrite(nil, &batch); return err },
+		"txn": func() error {
+			if err := txn.Set(mark, []byte("0")); err != nil {
+				return err
+			}
+			return txn.Commit()
+		},
+	} {
+		if err := write(); !errors.Is(err, ErrReservedKey) {
+			t.Fatalf("%s: expected ErrReservedKey, got %v", name, err)
+		}
+	}
+	if err := store.Set("orders", []byte("not a mark")); err != nil {
+		t.Fatalf("set a key named like the sequence: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// A partial recovery still recovers the marks.
+	store, err = NewWithOptions(walPath, Options{RecoverPrefixes: []string{"users/"}})
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	if _, ok := store.Get(mark); !ok {
+		t.Fatalf("expected the partial recovery to keep the high-water mark")
+	}
+	if id, err := store.NextIDs("orders", 1); err != nil || id <= issued {
+		t.Fatalf("expected an id above %d after a partial recovery, got %d, %v", issued, id, err)
+	}
+}
+
+func TestManifestVersions(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "manifest.wal")
+
+	if err := writeManifest(walPath, Manifest{Formats: map[string]int{ComponentWAL: 99}}); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	if _, err := New(walPath); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat for a newer format, got %v", err)
+	}
+
+	migrated := false
+	RegisterMigration(Migration{Component: ComponentWAL, From: 0, Apply: func(string) error {
+		migrated = true
+		return nil
+	}})
+	if err := writeManifest(walPath, Manifest{Formats: map[string]int{ComponentWAL: 0}}); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+
+	store, err := New(walPath)
+	if err != nil {
+		t.Fatalf("open store with older format: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	if !migrated {
+		t.Fatalf("expected the registered migration to run")
+	}
+	manifest, err := readManifest(walPath)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	if manifest.Formats[ComponentWAL] != currentFormats[ComponentWAL] {
+		t.Fatalf("expected manifest at version %d, got %+v", currentFormats[ComponentWAL], manifest)
+	}
+}
+
+func TestManifestForNewWAL(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "new.wal")
+
+	// A new WAL starts at the current formats without migrating.
+	var logs bytes.Buffer
+	store, err := New(walPath, WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	if err := store.Set("key", []byte("value")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if strings.Contains(logs.String(), "migrating") {
+		t.Fatalf("expected no migrations for a new wal, got logs:\n%s", logs.String())
+	}
+	manifest, err := readManifest(walPath)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	if !reflect.DeepEqual(manifest.Formats, currentFormats) {
+		t.Fatalf("expected the current formats %v, got %v", currentFormats, manifest.Formats)
+	}
+
+	// Data without a manifest predates it and is migrated from version 1.
+	if err := os.Remove(manifestPath(walPath)); err != nil {
+		t.Fatalf("remove manifest: %v", err)
+	}
+	logs.Reset()
+	store, err = New(walPath, WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	if !strings.Contains(logs.String(), "from=1 to=2") {
+		t.Fatalf("expected existing data to be migrated from version 1, got logs:\n%s", logs.String())
+	}
+	if value, ok := store.Get("key"); !ok || string(value) != "value" {
+		t.Fatalf("expected the key to survive the migration, got %q, %v", value, ok)
+	}
+}
+
+func TestStoreRecoveryProgress(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "progress.wal")
+
+	store, err := New(walPath)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := store.Set(fmt.Sprintf("key-%d", i), []byte("value")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+
+	store, err = New(walPath)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	p := store.RecoveryProgress()
+	if !p.Done {
+		t.Fatalf("expected recovery to be done")
+	}
+	// Ten keys and the checkpoint's revision record.
+	if p.Entries != 11 {
+		t.Fatalf("expected 11 replayed entries, got %d", p.Entries)
+	}
+	if p.Bytes != p.TotalBytes || p.TotalBytes == 0 {
+		t.Fatalf("expected all %d bytes replayed, got %d", p.TotalBytes, p.Bytes)
+	}
+	if p.ETA() != 0 {
+		t.Fatalf("expected zero ETA after recovery, got %v", p.ETA())
+	}
+}
+
+func TestSyncDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := syncDir(dir); err != nil {
+		t.Fatalf("sync directory: %v", err)
+	}
+	if err := syncDir(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist for a missing directory, got %v", err)
+	}
+}
+
+func TestCheckpointOnClose(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "checkpoint.wal")
+	opts := Options{WAL: WALOptions{SegmentSize: 256}, CheckpointOnClose: true}
+
+	store, err := NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := store.Set(fmt.Sprintf("key-%d", i), []byte("value")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	if _, err := store.Delete("key-0"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+
+	checkpoints, err := listCheckpoints(walPath)
+	if err != nil {
+		t.Fatalf("list checkpoints: %v", err)
+	}
+	segments, err := listSegments(walPath)
+	if err != nil {
+		t.Fatalf("list segments: %v", err)
+	}
+	if len(checkpoints) != 1 || len(segments) != 1 || segments[0] != checkpoints[0] {
+		t.Fatalf("expected one checkpoint and one empty segment after it, got checkpoints %v, segments %v", checkpoints, segments)
+	}
+
+	store, err = NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	if p := store.RecoveryProgress(); p.Entries != 20 || p.Bytes != p.TotalBytes {
+		t.Fatalf("expected 19 checkpointed keys, a revision record and no wal entries, got %+v", p)
+	}
+	if rev := store.Revision(); rev != 21 {
+		t.Fatalf("expected revision 21 after the checkpoint, got %d", rev)
+	}
+	if _, ok := store.Get("key-0"); ok {
+		t.Fatalf("expected key-0 to stay deleted")
+	}
+	if err := store.Set("key-20", []byte("value")); err != nil {
+		t.Fatalf("set after checkpoint: %v", err)
+	}
+	// Without a new checkpoint, the entries after the old one are replayed.
+	store.checkpointOnClose = false
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+
+	store, err = NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	if got := store.data.Count(); got != 20 {
+		t.Fatalf("expected 20 keys from checkpoint and wal, got %d", got)
+	}
+}
+
+func TestStoreAnalyze(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(filepath.Join(dir, "analytics.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	values := map[string]string{
+		"user:1:name":    "ada",
+		"user:2:name":    "grace",
+		"session:abc":    "0123456789",
+		"config":         "x",
+		"user:1:profile": "",
+	}
+	for key, value := range values {
+		if err := store.Set(key, []byte(value)); err != nil {
+			t.Fatalf("set %s: %v", key, err)
+		}
+	}
+
+	got := store.Analyze(AnalyticsOptions{Delimiter: ":", Depth: 1, Top: 2})
+	if got.Keys != len(values) || got.Prefixes != 3 {
+		t.Fatalf("unexpected totals: keys=%d prefixes=%d", got.Keys, got.Prefixes)
+	}
+	if len(got.TopPrefixes) != 2 || got.TopPrefixes[0].Prefix != "user:" || got.TopPrefixes[0].Keys != 3 {
+		t.Fatalf("unexpected top prefixes: %+v", got.TopPrefixes)
+	}
+
+	histogramTotal := 0
+	for _, bucket := range got.ValueSizes {
+		histogramTotal += bucket.Count
+	}
+	if histogramTotal != len(values) {
+		t.Fatalf("histogram covers %d values, expected %d", histogramTotal, len(values))
+	}
+
+	deep := store.Analyze(AnalyticsOptions{Delimiter: ":", Depth: 2})
+	if deep.Prefixes != 4 {
+		t.Fatalf("expected 4 prefixes at depth 2, got %d: %+v", deep.Prefixes, deep.TopPrefixes)
+	}
+}
+
+func TestStoreWatch(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "watch.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+
+	events, stop := store.Watch("app/")
+	defer stop()
+
+	var batch WriteBatch
+	batch.Set("app/a", []byte("1"))
+	batch.Set("app/b", []byte("2"))
+	if err := store.Set("other", []byte("x")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := store.Write(&batch); err != nil {
+		t.Fatalf("write batch: %v", err)
+	}
+	if _, err := store.Delete("app/missing"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := store.Delete("app/a"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	want := []Event{
+		{Type: OperationSet, Key: "app/a", Value: []byte("1"), Revision: 2},
+		{Type: OperationSet, Key: "app/b", Value: []byte("2"), Revision: 2},
+		{Type: OperationDelete, Key: "app/a", Revision: 4},
+	}
+	for _, w := range want {
+		got := <-events
+		if got.Type != w.Type || got.Key != w.Key || !bytes.Equal(got.Value, w.Value) || got.Revision != w.Revision {
+			t.Fatalf("expected event %+v, got %+v", w, got)
+		}
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+	if _, ok := <-events; ok {
+		t.Fatalf("expected the watch to end when the store closes")
+	}
+}
+
+func TestStoreWatchFrom(t *testing.T) {
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "resume.wal"), Options{HistoryRevisions: 3})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	defer store.Close()
+
+	for _, key := range []string{"app/a", "other", "app/b"} {
+		if err := store.Set(key, []byte("v")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	if _, err := store.Delete("app/a"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	events, stop, err := store.WatchFrom("app/", 1)
+	if err != nil {
+		t.Fatalf("watch from 1: %v", err)
+	}
+	defer stop()
+	if err := store.Set("app/c", []byte("v")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	want := []Event{
+		{Type: OperationSet, Key: "app/b", Value: []byte("v"), Revision: 3},
+		{Type: OperationDelete, Key: "app/a", Revision: 4},
+		{Type: OperationSet, Key: "app/c", Value: []byte("v"), Revision: 5},
+	}
+	for _, w := range want {
+		got := <-events
+		if got.Type != w.Type || got.Key != w.Key || !bytes.Equal(got.Value, w.Value) || got.Revision != w.Revision {
+			t.Fatalf("expected event %+v, got %+v", w, got)
+		}
+	}
+
+	if _, _, err := store.WatchFrom("app/", 6); !errors.Is(err, ErrFutureRevision) {
+		t.Fatalf("expected ErrFutureRevision, got %v", err)
+	}
+
+	store.compactHistory()
+	var lost *HistoryLostError
+	if _, _, err := store.WatchFrom("app/", 1); !errors.As(err, &lost) || !errors.Is(err, ErrCompacted) {
+		t.Fatalf("expected a HistoryLostError, got %v", err)
+	}
+	if lost.Requested != 1 || lost.Compacted != 2 || lost.Revision != 5 {
+		t.Fatalf("unexpected history lost error %+v", lost)
+	}
+	if _, stop, err := store.WatchFrom("app/", 2); err != nil {
+		t.Fatalf("watch from the compacted revision: %v", err)
+	} else {
+		stop()
+	}
+}
+
+func TestStoreGetAtRevision(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "revisions.wal")
+	opts := Options{HistoryRevisions: 3, CheckpointOnClose: true}
+
+	store, err := NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	for _, value := range []string{"v1", "v2", "v3"} {
+		if err := store.Set("key", []byte(value)); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	if _, err := store.Delete("key"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	check := func(revision uint64, want string) {
+		t.Helper()
+		got, ok, err := store.GetAt("key", revision)
+		if err != nil {
+			t.Fatalf("get at %d: %v", revision, err)
+		}
+		if want == "" && ok {
+			t.Fatalf("expected no value at %d, got %q", revision, got)
+		}
+		if want != "" && string(got) != want {
+			t.Fatalf("expected %q at %d, got %q (found %v)", want, revision, got, ok)
+		}
+	}
+	check(1, "v1")
+	check(2, "v2")
+	check(3, "v3")
+	check(4, "")
+	if _, _, err := store.GetAt("key", 5); !errors.Is(err, ErrFutureRevision) {
+		t.Fatalf("expected ErrFutureRevision, got %v", err)
+	}
+
+	if err := store.Set("other", []byte("x")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	store.compactHistory()
+	if _, _, err := store.GetAt("key", 1); !errors.Is(err, ErrCompacted) {
+		t.Fatalf("expected ErrCompacted, got %v", err)
+	}
+	check(2, "v2")
+	check(4, "")
+
+	// Revisions continue across a checkpointed restart.
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+	store, err = NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	if store.Revision() != 5 || store.CompactedRevision() != 5 {
+		t.Fatalf("expected revision and compacted revision 5, got %d and %d", store.Revision(), store.CompactedRevision())
+	}
+	if err := store.Set("key", []byte("v4")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	check(5, "")
+	check(6, "v4")
+}
+
+func TestPartialRecovery(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "partial.wal")
+
+	store, err := New(walPath)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	var batch WriteBatch
+	batch.Set("sessions/1", []byte("a"))
+	batch.Set("users/1", []byte("b"))
+	if err := store.Write(&batch); err != nil {
+		t.Fatalf("write batch: %v", err)
+	}
+	if err := store.Set("sessions/2", []byte("c")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+
+	opts := Options{RecoverPrefixes: []string{"sessions/"}, CheckpointOnClose: true}
+	store, err = NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	if got := store.data.Count(); got != 2 {
+		t.Fatalf("expected only the 2 session keys, got %d", got)
+	}
+	if store.Revision() != 2 {
+		t.Fatalf("expected skipped entries to keep their revisions, got %d", store.Revision())
+	}
+	if err := store.Set("users/2", []byte("d")); !errors.Is(err, ErrKeyNotServed) {
+		t.Fatalf("expected ErrKeyNotServed, got %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+
+	// The partial node must not have checkpointed the other keys away.
+	store, err = New(walPath)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	if got, _ := store.Get("users/1"); string(got) != "b" {
+		t.Fatalf("expected users/1 after a full recovery, got %q", got)
+	}
+}
+
+func TestWarmPrefixes(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "warm.wal")
+
+	store, err := New(walPath)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	var batch WriteBatch
+	batch.Set("sessions/1", []byte("a"))
+	batch.Set("users/1", []byte("b"))
+	if err := store.Write(&batch); err != nil {
+		t.Fatalf("write batch: %v", err)
+	}
+	if err := store.Set("users/1", []byte("c")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := store.Set("sessions/2", []byte("d")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+
+	store, err = NewWithOptions(walPath, Options{WarmPrefixes: []string{"sessions/"}})
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	if store.Revision() != 3 {
+		t.Fatalf("expected deferred entries to keep their revisions, got %d", store.Revision())
+	}
+	if got, _ := store.Get("sessions/2"); string(got) != "d" {
+		t.Fatalf("expected warm key sessions/2, got %q", got)
+	}
+	// Reading a cold key waits for the background load.
+	if got, _ := store.Get("users/1"); string(got) != "c" {
+		t.Fatalf("expected users/1 after the warm-up, got %q", got)
+	}
+	if _, revision, _ := store.GetVersion("users/1"); revision != 2 {
+		t.Fatalf("expected users/1 at revision 2, got %d", revision)
+	}
+	if progress := store.RecoveryProgress(); progress.Deferred != 0 {
+		t.Fatalf("expected no deferred entries left, got %d", progress.Deferred)
+	}
+	if keys, _ := store.Keys("", "", 0); len(keys) != 3 {
+		t.Fatalf("expected 3 keys, got %v", keys)
+	}
+}
+
+func TestCheckAndWrite(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "cas.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	if err := store.Set("user", []byte("alice")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	_, revision, ok := store.GetVersion("user")
+	if !ok || revision != 1 {
+		t.Fatalf("expected user at revision 1, got %d (found %v)", revision, ok)
+	}
+
+	var pair WriteBatch
+	pair.Set("user", []byte("bob"))
+	pair.Set("user-index/bob", []byte("user"))
+
+	failing := []Condition{IfRevision("user", revision), IfExists("user-index/alice")}
+	if _, err := store.CheckAndWrite(failing, &pair); !errors.Is(err, ErrConditionFailed) {
+		t.Fatalf("expected ErrConditionFailed, got %v", err)
+	}
+	if got, _ := store.Get("user"); string(got) != "alice" {
+		t.Fatalf("expected a failed check to write nothing, got %q", got)
+	}
+
+	conditions := []Condition{IfRevision("user", revision), IfValue("user", []byte("alice")), IfMissing("user-index/bob")}
+	written, err := store.CheckAndWrite(conditions, &pair)
+	if err != nil {
+		t.Fatalf("check and write: %v", err)
+	}
+	if value, revision, _ := store.GetVersion("user-index/bob"); string(value) != "user" || revision != written {
+		t.Fatalf("expected user-index/bob written at %d, got %q at %d", written, value, revision)
+	}
+
+	// The old revision no longer matches.
+	if _, err := store.CheckAndWrite(conditions, &pair); !errors.Is(err, ErrConditionFailed) {
+		t.Fatalf("expected a stale revision to fail, got %v", err)
+	}
+}
+
+func TestWriteContext(t *testing.T) {
+	var logs bytes.Buffer
+	logging.SetHandler(slog.NewTextHandler(&logs, nil))
+	t.Cleanup(func() { logging.SetHandler(nil) })
+
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "ctx.wal"), Options{SlowWriteThreshold: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	ctx := logging.WithRequestID(context.Background(), "req-7")
+	if err := store.SetTimed(ctx, "a", []byte("1"), nil); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if !strings.Contains(logs.String(), "op=set key=a") || !strings.Contains(logs.String(), "request_id=req-7") {
+		t.Fatalf("expected the slow write to be logged with its request id, got %q", logs.String())
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := store.SetTimed(canceled, "b", []byte("2"), nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a canceled set to fail, got %v", err)
+	}
+	if _, err := store.DeleteTimed(canceled, "a", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a canceled delete to fail, got %v", err)
+	}
+	if _, ok := store.Get("a"); !ok {
+		t.Fatalf("expected the canceled delete not to be applied")
+	}
+	if _, ok := store.Get("b"); ok {
+		t.Fatalf("expected the canceled set not to be applied")
+	}
+}
+
+func TestIterator(t *testing.T) {
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "iter.wal"), Options{HistoryRevisions: 1})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	for _, key := range []string{"a/1", "a/2", "a/3", "b/1"} {
+		if err := store.Set(key, []byte("old-"+key)); err != nil {
+			t.Fatalf("set %s: %v", key, err)
+		}
+	}
+	collect := func(it *Iterator) []string {
+		var got []string
+		for it.Next() {
+			got = append(got, it.Key()+"="+string(it.Value()))
+		}
+		return got
+	}
+
+	it := store.NewIterator(IteratorOptions{Prefix: "a/"})
+	defer it.Close()
+
+	var batch WriteBatch
+	batch.Set("a/1", []byte("new"))
+	batch.Delete("a/2")
+	batch.Set("a/0", []byte("new"))
+	if _, err := store.CheckAndWrite(nil, &batch); err != nil {
+		t.Fatalf("write batch: %v", err)
+	}
+	store.compactHistory()
+	if store.CompactedRevision() > it.Revision() {
+		t.Fatalf("expected compaction to stop at the open iterator's revision %d, got %d", it.Revision(), store.CompactedRevision())
+	}
+
+	want := []string{"a/1=old-a/1", "a/2=old-a/2", "a/3=old-a/3"}
+	if got := collect(it); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the snapshot %q, got %q", want, got)
+	}
+	it.Close()
+	if err := store.Set("c", []byte("new")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	store.compactHistory()
+	if store.CompactedRevision() <= it.Revision() {
+		t.Fatalf("expected compaction to resume once the iterator closed, got %d", store.CompactedRevision())
+	}
+
+	bounded := store.NewIterator(IteratorOptions{Start: "a/1", End: "b/1", KeysOnly: true})
+	defer bounded.Close()
+	if got, want := collect(bounded), []string{"a/1=", "a/3="}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected keys %q, got %q", want, got)
+	}
+
+	single := store.NewIterator(IteratorOptions{Prefix: "a/3"})
+	defer single.Close()
+	if !single.Next() || single.ModRevision() != 3 {
+		t.Fatalf("expected a/3 last written at revision 3, got %d", single.ModRevision())
+	}
+}
+
+func TestFunctionalOptions(t *testing.T) {
+	dir := t.TempDir()
+	var logs bytes.Buffer
+	// The sweeper reads the clock too.
+	var now atomic.Int64
+	now.Store(1_000_000)
+	store, err := New("opts.wal",
+		WithDataDir(dir),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		WithClock(func() time.Time { return time.Unix(now.Load(), 0) }),
+		WithLimits(8, 4),
+	)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	if _, err := os.Stat(filepath.Join(dir, "opts.wal")); err != nil {
+		t.Fatalf("expected the wal in the data directory: %v", err)
+	}
+	if !strings.Contains(logs.String(), "recovery complete") {
+		t.Fatalf("expected the store to log to its own logger, got %q", logs.String())
+	}
+	if err := store.Set("key", []byte("large")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected the value limit to apply, got %v", err)
+	}
+
+	if err := store.SetWithTTL("ttl", []byte("1"), time.Minute); err != nil {
+		t.Fatalf("set with ttl: %v", err)
+	}
+	if _, ok := store.Get("ttl"); !ok {
+		t.Fatalf("expected the key to live until the clock passes its ttl")
+	}
+	now.Add(60)
+	if _, ok := store.Get("ttl"); ok {
+		t.Fatalf("expected the key to expire by the store's clock")
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "events.wal")
+	store, err := New(walPath)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	if err := store.Set("a", []byte("1")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// A subscriber registered before the store sees recovery rebuild it.
+	var mu sync.Mutex
+	var got []string
+	bus := events.New()
+	bus.Subscribe(func(e events.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch p := e.Payload.(type) {
+		case Event:
+			got = append(got, "write "+p.Key)
+		case Expiration:
+			got = append(got, "expire "+p.Key)
+		}
+	})
+	now := time.Now()
+	store, err = New(walPath, WithEvents(bus), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	if err := store.SetWithTTL("b", []byte("2"), time.Second); err != nil {
+		t.Fatalf("set with ttl: %v", err)
+	}
+	store.sweep(now.Add(time.Minute))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"write a", "write b", "write b", "expire b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected events %q, got %q", want, got)
+	}
+}
+
+func TestAsyncWrites(t *testing.T) {
+	store, err := NewWithOptions(filepath.Join(t.TempDir(), "async.wal"), Options{WAL: WALOptions{Sync: SyncNever}})
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	ctx := context.Background()
+
+	set, err := store.SetAsync(ctx, "a", []byte("1"), time.Hour, nil)
+	if err != nil {
+		t.Fatalf("async set: %v", err)
+	}
+	if value, ok := store.Get("a"); !ok || string(value) != "1" {
+		t.Fatalf("expected an async set to be visible at once, got %q, %v", value, ok)
+	}
+	// SyncNever only fsyncs on Sync and Close.
+	if state, err := store.WriteStatus(set); err != nil || state != WritePending {
+		t.Fatalf("status before sync = %v, %v; want pending", state, err)
+	}
+	deleted, existed, err := store.DeleteAsync(ctx, "a", nil)
+	if err != nil || !existed {
+		t.Fatalf("async delete = %v, %v", existed, err)
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	for _, token := range []string{set, deleted} {
+		if state, err := store.WriteStatus(token); err != nil || state != WriteDurable {
+			t.Fatalf("status of %s after sync = %v, %v; want durable", token, state, err)
+		}
+	}
+
+	run, _, _ := strings.Cut(set, ".")
+	for _, token := range []string{"", "x", "other.1", run + ".0", run + ".99", run + ".x"} {
+		if _, err := store.WriteStatus(token); !errors.Is(err, ErrUnknownToken) {
+			t.Fatalf("status of %q = %v, want ErrUnknownToken", token, err)
+		}
+	}
+
+	// Closing the segment underneath the WAL makes the next write fail.
+	_ = store.wal.file.Close()
+	lost, err := store.SetAsync(ctx, "b", []byte("2"), 0, nil)
+	if err != nil {
+		t.Fatalf("async set before the failure is noticed: %v", err)
+	}
+	if err := store.Sync(); !errors.Is(err, ErrWriteFailed) {
+		t.Fatalf("expected ErrWriteFailed from sync, got %v", err)
+	}
+	if state, err := store.WriteStatus(lost); err != nil || state != WriteFailed {
+		t.Fatalf("status of a lost write = %v, %v; want failed", state, err)
+	}
+	if state, _ := store.WriteStatus(set); state != WriteDurable {
+		t.Fatalf("status of a durable write after a failure = %v", state)
+	}
+}
+
+func TestTTLAndExpire(t *testing.T) {
+	var now atomic.Int64
+	now.Store(time.Unix(1000, 0).UnixNano())
+	store, err := New(filepath.Join(t.TempDir(), "expire.wal"), WithClock(func() time.Time { return time.Unix(0, now.Load()) }))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	if _, ok := store.TTL("a"); ok {
+		t.Fatalf("expected a missing key to have no TTL")
+	}
+	if existed, err := store.Expire("a", time.Minute); err != nil || existed {
+		t.Fatalf("expire missing key = %v, %v", existed, err)
+	}
+	if err := store.Set("a", []byte("1")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if ttl, ok := store.TTL("a"); !ok || ttl != 0 {
+		t.Fatalf("ttl without expiry = %v, %v", ttl, ok)
+	}
+	if existed, err := store.Expire("a", time.Minute); err != nil || !existed {
+		t.Fatalf("expire = %v, %v", existed, err)
+	}
+	now.Add(int64(20 * time.Second))
+	if ttl, ok := store.TTL("a"); !ok || ttl != 40*time.Second {
+		t.Fatalf("ttl after 20s = %v, %v; want 40s", ttl, ok)
+	}
+	if value, ok := store.Get("a"); !ok || string(value) != "1" {
+		t.Fatalf("expected expire to keep the value, got %q, %v", value, ok)
+	}
+	now.Add(int64(time.Minute))
+	if _, ok := store.TTL("a"); ok {
+		t.Fatalf("expected the key to be gone once its TTL passed")
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	oldDir, newDir := t.TempDir(), filepath.Join(t.TempDir(), "moved")
+	walPath := filepath.Join(oldDir, "relocate.wal")
+	opts := Options{WAL: WALOptions{SegmentSize: 256}, CheckpointOnClose: true}
+
+	// A checkpoint, and several segments written after it.
+	store, err := NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	if err := store.Set("before", []byte("checkpoint")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+	store, err = NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	for i := range 20 {
+		if err := store.Set(fmt.Sprintf("key-%02d", i), bytes.Repeat([]byte("v"), 32)); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+
+	if _, err := store.Relocate(context.Background(), oldDir); err == nil {
+		t.Fatalf("expected relocating into the current directory to fail")
+	}
+	result, err := store.Relocate(context.Background(), newDir)
+	if err != nil {
+		t.Fatalf("relocate: %v", err)
+	}
+	if result.To != filepath.Join(newDir, "relocate.wal") || result.Files < 4 {
+		t.Fatalf("unexpected relocation %+v", result)
+	}
+	if err := store.Set("after", []byte("moved")); err != nil {
+		t.Fatalf("set after relocating: %v", err)
+	}
+	left, _ := filepath.Glob(filepath.Join(oldDir, "*"))
+	if len(left) != 1 || left[0] != walPath+".moved" {
+		t.Fatalf("expected only the redirect to be left behind, got %v", left)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close store: %v", err)
+	}
+
+	// Opening the old path follows the redirect.
+	store, err = NewWithOptions(walPath, opts)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	for _, key := range []string{"before", "key-19", "after"} {
+		if _, ok := store.Get(key); !ok {
+			t.Fatalf("expected %s after relocating", key)
+		}
+	}
+
+	// Moving back replaces the redirect rather than looping.
+	if _, err := store.Relocate(context.Background(), oldDir); err != nil {
+		t.Fatalf("relocate back: %v", err)
+	}
+	if _, err := os.Stat(walPath + ".moved"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the stale redirect to be removed, got %v", err)
+	}
+	if err := store.Set("back", []byte("home")); err != nil {
+		t.Fatalf("set after moving back: %v", err)
+	}
+}
+
+func TestRelocateCrash(t *testing.T) {
+	opts := Options{WAL: WALOptions{Sync: SyncAlways}}
+
+	t.Run("after switching", func(t *testing.T) {
+		walPath := filepath.Join(t.TempDir(), "crash.wal")
+		store, err := NewWithOptions(walPath, opts)
+		if err != nil {
+			t.Fatalf("create store: %v", err)
+		}
+		t.Cleanup(func() { _ = store.Close() })
+		if err := store.Set("before", []byte("1")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+		if _, err := store.Relocate(context.Background(), filepath.Join(t.TempDir(), "moved")); err != nil {
+			t.Fatalf("relocate: %v", err)
+		}
+		if err := store.Set("after", []byte("2")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+
+		// Opening the configured path without closing the store, as a
+		// restart after a crash would, finds the write acknowledged from
+		// the new location.
+		restarted, err := NewWithOptions(walPath, opts)
+		if err != nil {
+			t.Fatalf("reopen store: %v", err)
+		}
+		defer restarted.Close()
+		for _, key := range []string{"before", "after"} {
+			if _, ok := restarted.Get(key); !ok {
+				t.Fatalf("expected %s after the crash", key)
+			}
+		}
+	})
+
+	t.Run("redirect fails", func(t *testing.T) {
+		walPath := filepath.Join(t.TempDir(), "crash.wal")
+		newDir := filepath.Join(t.TempDir(), "moved")
+		store, err := NewWithOptions(walPath, opts)
+		if err != nil {
+			t.Fatalf("create store: %v", err)
+		}
+		t.Cleanup(func() { _ = store.Close() })
+		if err := store.Set("before", []byte("1")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+
+		// A directory where the redirect is staged makes writing it fail
+		// after the copies are complete.
+		if err := os.Mkdir(redirectPath(walPath)+".tmp", 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if _, err := store.Relocate(context.Background(), newDir); err == nil {
+			t.Fatalf("expected relocating without a redirect to fail")
+		}
+		if err := store.Set("after", []byte("2")); err != nil {
+			t.Fatalf("set after the failed relocation: %v", err)
+		}
+		if copies, _ := filepath.Glob(filepath.Join(newDir, "*")); len(copies) != 0 {
+			t.Fatalf("expected the copies to be removed, got %v", copies)
+		}
+		if _, err := os.Stat(redirectPath(walPath)); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected no redirect, got %v", err)
+		}
+
+		// The store carried on where it was, which a restart opens.
+		restarted, err := NewWithOptions(walPath, opts)
+		if err != nil {
+			t.Fatalf("reopen store: %v", err)
+		}
+		defer restarted.Close()
+		for _, key := range []string{"before", "after"} {
+			if _, ok := restarted.Get(key); !ok {
+				t.Fatalf("expected %s after the failed relocation", key)
+			}
+		}
+	})
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package store
+
+import (
+	"fmt"
+	"os"
+)
+
+// syncDir fsyncs a directory so that a rename in it is durable.
+//
+// Files are synced with (*os.File).Sync, which the runtime already maps to
+// the strongest primitive of the platform: fsync on Linux, F_FULLFSYNC on
+// macOS, where fsync only reaches the drive cache, and FlushFileBuffers on
+// Windows. Directories need the platform split here.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("store: open directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("store: sync directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package store
+
+import (
+	"fmt"
+	"os"
+)
+
+// syncDir checks that dir exists. Windows cannot flush a directory:
+// FlushFileBuffers needs a handle opened for writing, which directories do
+// not give, and NTFS journals renames as they happen.
+func syncDir(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("store: open directory: %w", err)
+	}
+	return nil
+}
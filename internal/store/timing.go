@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultSlowWriteThreshold is the slow write threshold used when
+// Options.SlowWriteThreshold is zero.
+const DefaultSlowWriteThreshold = 500 * time.Millisecond
+
+func slowWriteThreshold(threshold time.Duration) time.Duration {
+	switch {
+	case threshold == 0:
+		return DefaultSlowWriteThreshold
+	case threshold < 0:
+		return 0
+	default:
+		return threshold
+	}
+}
+
+// Timing breaks down where a mutating operation spent its time. Unless the
+// WAL uses SyncAlways it is flushed and synced in the background, so fsync
+// latency is not part of a single operation; see WALStats.LastFlush for it.
+type Timing struct {
+	// LockWait is the time spent waiting for the store's write lock.
+	LockWait time.Duration
+	// WALAppend is the time spent handing the entry to the WAL.
+	WALAppend time.Duration
+	// Apply is the time spent updating the in-memory map.
+	Apply time.Duration
+	// Sync is the time spent waiting for the entry to be fsynced, which is
+	// only non-zero under SyncAlways.
+	Sync time.Duration
+}
+
+// lap stores the time elapsed since start into field and returns now.
+func (t *Timing) lap(field *time.Duration, start time.Time) time.Time {
+	now := time.Now()
+	*field = now.Sub(start)
+	return now
+}
+
+func (t *Timing) copyTo(dst *Timing) {
+	if dst != nil {
+		*dst = *t
+	}
+}
+
+// logSlowWrite logs a write that started at start and took longer than the
+// slow write threshold, with its breakdown. Logging with ctx attaches the
+// request ID of the request that made the write, if any.
+func (s *Store) logSlowWrite(ctx context.Context, op, key string, t *Timing, start time.Time) {
+	elapsed := time.Since(start)
+	if s.slowWrite == 0 || elapsed < s.slowWrite {
+		return
+	}
+	s.log.WarnContext(ctx, "slow write", "op", op, "key", key, "elapsed", elapsed,
+		"lock_wait", t.LockWait, "wal_append", t.WALAppend, "apply", t.Apply, "sync", t.Sync)
+}
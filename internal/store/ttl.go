@@ -0,0 +1,304 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+	"universe/internal/events"
+	"universe/internal/panics"
+)
+
+// Defaults for the sweeper options left zero.
+const (
+	// DefaultSweepInterval is how often expired keys are deleted.
+	DefaultSweepInterval = time.Second
+	// DefaultSweepBatchSize is how many expired keys one sweep deletes at
+	// most.
+	DefaultSweepBatchSize = 10000
+	// DefaultSweepMaxShare is the fraction of the sweep interval one sweep
+	// may run for.
+	DefaultSweepMaxShare = 0.25
+)
+
+// ExpirationStats reports how well the sweeper keeps up with expiring keys.
+type ExpirationStats struct {
+	// TTLKeys is the number of keys with a TTL.
+	TTLKeys int `json:"ttl_keys"`
+	// Expired counts the keys the sweeper has deleted.
+	Expired uint64 `json:"expired"`
+	// ExpiredPerSecond is the rate of the most recent sweep.
+	ExpiredPerSecond float64 `json:"expired_per_second"`
+	// Overdue is the number of keys past their TTL that the most recent
+	// sweep left for the next one, because of the batch size or time
+	// budget.
+	Overdue int `json:"overdue"`
+	// LazyExpirations counts the Get and GetVersion reads that found a key
+	// past its TTL that had not been swept yet; they see the key as
+	// missing. Listings and writes do not count.
+	LazyExpirations uint64 `json:"lazy_expirations"`
+	// LastSweep is how long the most recent sweep took.
+	LastSweep time.Duration `json:"last_sweep_ns"`
+}
+
+// sweeper holds the sweep limits and the statistics of past sweeps.
+type sweeper struct {
+	interval time.Duration
+	batch    int
+	budget   time.Duration
+
+	lazy atomic.Uint64
+
+	mu      sync.Mutex
+	expired uint64
+	rate    float64
+	overdue int
+	last    time.Duration
+	lastAt  time.Time
+}
+
+func newSweeper(opts Options) *sweeper {
+	sw := &sweeper{interval: opts.SweepInterval, batch: opts.SweepBatchSize}
+	if sw.interval <= 0 {
+		sw.interval = DefaultSweepInterval
+	}
+	if sw.batch <= 0 {
+		sw.batch = DefaultSweepBatchSize
+	}
+	share := opts.SweepMaxShare
+	if share <= 0 || share > 1 {
+		share = DefaultSweepMaxShare
+	}
+	sw.budget = time.Duration(float64(sw.interval) * share)
+	return sw
+}
+
+// record notes the outcome of a sweep that started at start.
+func (sw *sweeper) record(start time.Time, removed, overdue int) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	sw.expired += uint64(removed)
+	sw.overdue = overdue
+	sw.last = time.Since(start)
+	if !sw.lastAt.IsZero() {
+		if elapsed := start.Sub(sw.lastAt); elapsed > 0 {
+			sw.rate = float64(removed) / elapsed.Seconds()
+		}
+	}
+	sw.lastAt = start
+}
+
+// ExpirationStats returns the sweeper statistics.
+func (s *Store) ExpirationStats() ExpirationStats {
+	sw := s.sweeper
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	return ExpirationStats{
+		TTLKeys:          s.expiry.Count(),
+		Expired:          sw.expired,
+		ExpiredPerSecond: sw.rate,
+		Overdue:          sw.overdue,
+		LazyExpirations:  sw.lazy.Load(),
+		LastSweep:        sw.last,
+	}
+}
+
+// SetWithTTL is Set for a key that expires after ttl, give or take
+// Options.TTLJitter. The expiration time is persisted, so a key whose TTL
+// passes while the server is down is gone after recovery.
+func (s *Store) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	return s.SetWithTTLTimed(context.Background(), key, value, ttl, nil)
+}
+
+// SetWithTTLTimed is SetWithTTL that also records a latency breakdown into
+// timing when it is non-nil. Like SetTimed it honors and logs with ctx.
+func (s *Store) SetWithTTLTimed(ctx context.Context, key string, value []byte, ttl time.Duration, timing *Timing) error {
+	if ttl <= 0 {
+		return fmt.Errorf("store: ttl must be positive")
+	}
+	_, err := s.set(ctx, key, value, s.now().Add(s.jitter(ttl)).UnixNano(), timing, false)
+	return err
+}
+
+// TTL returns how long key has left to live, or zero when it has no TTL.
+// ok is false when the key does not exist.
+func (s *Store) TTL(key string) (ttl time.Duration, ok bool) {
+	s.warmup.wait(key)
+	if _, ok := s.load(key); !ok {
+		return 0, false
+	}
+	expiresAt, ok := s.expiry.Load(key)
+	if !ok {
+		return 0, true
+	}
+	return max(time.Duration(expiresAt-s.now().UnixNano()), 1), true
+}
+
+// Expire makes an existing key expire after ttl, give or take
+// Options.TTLJitter, keeping its value. It reports whether the key existed;
+// a missing key is left alone.
+func (s *Store) Expire(key string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return false, fmt.Errorf("store: ttl must be positive")
+	}
+	if err := s.checkWrite(key); err != nil {
+		return false, err
+	}
+	s.warmup.wait(key)
+
+	s.mu.Lock()
+	value, ok := s.load(key)
+	if !ok {
+		s.mu.Unlock()
+		return false, nil
+	}
+	entry := WALEntry{
+		Type:      OperationSet,
+		Key:       key,
+		Value:     value,
+		ExpiresAt: s.now().Add(s.jitter(ttl)).UnixNano(),
+		Revision:  s.revision.Load() + 1,
+	}
+	seq, err := s.wal.enqueue(entry)
+	if err != nil {
+		s.mu.Unlock()
+		return false, err
+	}
+	s.applyEntry(entry)
+	s.mu.Unlock()
+
+	_, err = s.wal.waitDurable(seq)
+	return true, err
+}
+
+// Expiration is published on the event bus when the sweeper deletes a key
+// whose TTL passed.
+type Expiration struct {
+	Key string
+	// Revision is the revision of the delete.
+	Revision uint64
+	// ExpiredAt is when the TTL passed, in Unix nanoseconds.
+	ExpiredAt int64
+}
+
+// jitter scales ttl by a random factor within Options.TTLJitter of 1, so
+// that keys written together with the same TTL do not all expire in the
+// same sweep. The result is at least a nanosecond.
+func (s *Store) jitter(ttl time.Duration) time.Duration {
+	if s.ttlJitter == 0 {
+		return ttl
+	}
+	factor := 1 + s.ttlJitter*(2*rand.Float64()-1)
+	return max(time.Duration(float64(ttl)*factor), 1)
+}
+
+// load returns the stored value for key, hiding it once it has expired even
+// if the sweeper has not deleted it yet.
+func (s *Store) load(key string) ([]byte, bool) {
+	value, ok := s.data.Load(key)
+	if !ok || s.expired(key) {
+		return nil, false
+	}
+	return value, true
+}
+
+// expired reports whether key has a TTL that has passed.
+func (s *Store) expired(key string) bool {
+	expiresAt, ok := s.expiry.Load(key)
+	return ok && expiresAt <= s.now().UnixNano()
+}
+
+// expiredOnRead is expired for the client reads, which count the expired
+// keys they hide as lazy expirations.
+func (s *Store) expiredOnRead(key string) bool {
+	if !s.expired(key) {
+		return false
+	}
+	s.sweeper.lazy.Add(1)
+	return true
+}
+
+// startSweeper deletes expired keys and compacts the revision history every
+// sweep interval.
+func (s *Store) startSweeper() {
+	panics.Go("ttl-sweeper", &s.wg, func() {
+		ticker := time.NewTicker(s.sweeper.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.sweep(s.now())
+				s.compactHistory()
+			}
+		}
+	})
+}
+
+// sweep deletes the keys that expired by now, logging a delete entry for each
+// so that replicas and recovery see the expiration as an ordinary delete. It
+// deletes at most the batch size and stops once the deletes have spent the
+// time budget, after deleting at least one key so that a sweep always makes
+// progress; the rest are left for the next sweep and hidden from reads
+// meanwhile.
+func (s *Store) sweep(now time.Time) int {
+	start := time.Now()
+	deadline := now.UnixNano()
+
+	var expired []string
+	overdue := 0
+	s.expiry.Range(func(key string, expiresAt int64) bool {
+		if expiresAt <= deadline {
+			overdue++
+			if len(expired) < s.sweeper.batch {
+				expired = append(expired, key)
+			}
+		}
+		return false
+	})
+
+	removed := 0
+	deleting := time.Now()
+	for _, key := range expired {
+		if removed > 0 && time.Since(deleting) > s.sweeper.budget {
+			break
+		}
+		if s.expire(key, deadline) {
+			removed++
+		}
+	}
+
+	overdue -= removed
+	s.sweeper.record(start, removed, overdue)
+	if removed > 0 {
+		s.log.Debug("expired keys", "count", removed, "overdue", overdue)
+	}
+	return removed
+}
+
+// expire deletes key if it is still expired at deadline; it may have been
+// set again since the sweep collected it.
+func (s *Store) expire(key string, deadline int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt, ok := s.expiry.Load(key)
+	if !ok || expiresAt > deadline {
+		return false
+	}
+
+	entry := WALEntry{Type: OperationDelete, Key: key, Revision: s.revision.Load() + 1}
+	if _, err := s.wal.enqueue(entry); err != nil {
+		s.log.Warn("expire key", "key", key, "error", err)
+		return false
+	}
+	s.applyEntry(entry)
+	s.events.Publish(events.TopicExpiration, Expiration{Key: key, Revision: entry.Revision, ExpiredAt: expiresAt})
+	return true
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrTxnConflict is returned by Txn.Commit when a key the transaction read
+// was changed by another writer before the commit.
+var ErrTxnConflict = errors.New("store: transaction conflict")
+
+// ErrTxnDone is returned when using a transaction after Commit or Rollback.
+var ErrTxnDone = errors.New("store: transaction already finished")
+
+// Txn is an optimistic multi-key transaction. Writes are buffered and
+// visible to the transaction's own reads; nothing reaches the store until
+// Commit, which persists them as a single WAL record. A Txn is not safe for
+// concurrent use.
+type Txn struct {
+	store *Store
+	done  bool
+
+	ops    []WALEntry
+	writes map[string]int // key -> index of its latest op
+
+	// reads remembers what each key looked like when first read, so Commit
+	// can detect a concurrent change.
+	reads map[string]txnRead
+}
+
+type txnRead struct {
+	value  []byte
+	exists bool
+}
+
+// Begin starts a transaction.
+func (s *Store) Begin() *Txn {
+	return &Txn{
+		store:  s,
+		writes: make(map[string]int),
+		reads:  make(map[string]txnRead),
+	}
+}
+
+// Get returns the value of key as seen by the transaction: its own pending
+// write if there is one, the stored value otherwise.
+func (t *Txn) Get(key string) ([]byte, bool) {
+	if i, ok := t.writes[key]; ok {
+		op := t.ops[i]
+		if op.Type == OperationDelete {
+			return nil, false
+		}
+		return bytes.Clone(op.Value), true
+	}
+
+	value, ok := t.store.Get(key)
+	if _, seen := t.reads[key]; !seen {
+		t.reads[key] = txnRead{value: bytes.Clone(value), exists: ok}
+	}
+	return value, ok
+}
+
+// Set buffers a write of value to key. The value is copied.
+func (t *Txn) Set(key string, value []byte) error {
+	return t.buffer(WALEntry{Type: OperationSet, Key: key, Value: bytes.Clone(value)})
+}
+
+// Delete buffers a removal of key.
+func (t *Txn) Delete(key string) error {
+	return t.buffer(WALEntry{Type: OperationDelete, Key: key})
+}
+
+func (t *Txn) buffer(op WALEntry) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if op.Key == "" {
+		return fmt.Errorf("store: key must not be empty")
+	}
+
+	t.ops = append(t.ops, op)
+	t.writes[op.Key] = len(t.ops) - 1
+	return nil
+}
+
+// Commit atomically persists and applies the buffered writes. It fails with
+// ErrTxnConflict, writing nothing, if any key the transaction read has
+// changed since. Conflicts are detected by comparing values, so a key
+// changed and then restored to the value that was read does not conflict.
+func (t *Txn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+
+	_, err := t.store.writeOps(t.ops, func() error {
+		for key, read := range t.reads {
+			value, ok := t.store.load(key)
+			if ok != read.exists || !bytes.Equal(value, read.value) {
+				return fmt.Errorf("%w: key %q changed", ErrTxnConflict, key)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// Rollback discards the transaction.
+func (t *Txn) Rollback() {
+	t.done = true
+	t.ops = nil
+}
@@ -5,18 +5,24 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"universe/internal/logging"
+	"universe/internal/panics"
 )
 
-// TODO: Add log rotation and compaction
+// TODO: Add compaction
 // TODO: is append ok?
 
 type OperationType string
@@ -24,31 +30,99 @@ type OperationType string
 const (
 	OperationSet    OperationType = "set"
 	OperationDelete OperationType = "delete"
+	// OperationBatch groups the operations in WALEntry.Batch into one
+	// record that is replayed entirely or not at all.
+	OperationBatch OperationType = "batch"
 )
 
 var ErrCorruptWAL = errors.New("store: wal file is corrupted")
 
+// ErrClosed is returned when appending to a closed WAL.
+var ErrClosed = errors.New("store: wal is closed")
+
+// ErrWriteFailed wraps the error of a failed WAL write. Once a write fails
+// the WAL rejects all further appends, since the segment may end in a torn
+// record.
+var ErrWriteFailed = errors.New("store: wal write failed")
+
+var walLogger = logging.For(logging.CategoryWAL)
+
 type WALEntry struct {
 	Type  OperationType
 	Key   string
 	Value []byte
+	Batch []WALEntry
+	// ExpiresAt is the Unix time in nanoseconds at which a set expires;
+	// zero means never.
+	ExpiresAt int64
+	// Revision is the store revision the entry was applied at; zero in
+	// entries written before revisions were recorded and in batch members.
+	Revision uint64
 }
 
 const (
 	walFileMode  = 0o644
 	lengthPrefix = 4
 	checksumSize = 4
-	bufferSize   = 100
+
+	// maxPayloadPrealloc caps the buffer allocated from a record's length
+	// prefix before its payload has actually been read.
+	maxPayloadPrealloc = 1 << 20
+
+	// DefaultSegmentSize is the segment size used by NewWAL.
+	DefaultSegmentSize = 64 << 20
+	// DefaultBufferSize is how many appends are buffered before a flush is
+	// forced when WALOptions.BufferSize is zero.
+	DefaultBufferSize = 100
 )
 
+// WALOptions configures a WAL.
+type WALOptions struct {
+	// SegmentSize is the size in bytes after which the active segment is
+	// closed and a new one started. Zero disables rotation.
+	SegmentSize int64
+	// Sync selects when appends are fsynced.
+	Sync SyncPolicy
+	// FlushInterval is how often buffered appends are written out; zero
+	// means DefaultFlushInterval.
+	FlushInterval time.Duration
+	// BufferSize is how many appends are buffered before a flush is
+	// forced; zero means DefaultBufferSize.
+	BufferSize int
+	// Compression compresses large records. Segments may mix compressed
+	// and uncompressed records, so it can be changed between restarts.
+	Compression Compression
+	// Recovery decides what Replay does with a corrupt record.
+	Recovery RecoveryMode
+	// Logger receives the WAL's logs; nil means the wal category logger.
+	Logger *slog.Logger
+}
+
 // WAL entry format: [4-byte length][4-byte checksum][payload]
-// The checksum is CRC32 of the payload data
+// The checksum is CRC32 of the payload data. The high bit of the length
+// marks a payload that starts with a record version byte (see record.go).
+
+// The WAL is split into segments. The first segment lives at the configured
+// path; later ones append a zero-padded sequence number, e.g.
+// universe.wal, universe.wal.000001, universe.wal.000002.
 
 type WAL struct {
 	mu     sync.Mutex
 	path   string
 	file   *os.File
 	writer *bufio.Writer
+	opts   WALOptions
+	log    *slog.Logger
+
+	// segment is the sequence number of the active segment and segmentBytes
+	// its current size. Both are written under flushMu and atomic so Stats
+	// does not wait for a slow flush.
+	segment      atomic.Int64
+	segmentBytes atomic.Int64
+
+	// first is the first segment not covered by a checkpoint; older
+	// segments are skipped on replay.
+	first int
 
 	flushChan chan struct{}
 	doneChan  chan struct{}
@@ -57,64 +131,494 @@ type WAL struct {
 	pendingBuffer []WALEntry
 	flushMu       sync.Mutex
 
+	// appendSeq numbers appended entries, pendingSeq is the last entry in
+	// pendingBuffer and flushedSeq the last one written out. flushed is
+	// broadcast whenever flushedSeq advances. All are guarded by mu.
+	appendSeq  uint64
+	pendingSeq uint64
+	flushedSeq uint64
+	flushed    *sync.Cond
+	closed     bool
+
+	// err is the first write failure and errSeq the first entry it lost.
+	// Guarded by mu.
+	err    error
+	errSeq uint64
+
+	// writtenRevision is the revision of the latest entry written out and
+	// durableRevision that of the latest one also fsynced; they differ
+	// only under SyncNever. Guarded by mu.
+	writtenRevision uint64
+	durableRevision uint64
+
 	wg     sync.WaitGroup
 	ticker *time.Ticker
+
+	stall *stallDetector
 }
 
-func NewWAL(path string) (*WAL, error) {
+// NewWAL opens the WAL at path with DefaultSegmentSize rotation, changed by
+// the WAL settings among opts. A relative path is resolved in the
+// WithDataDir directory.
+func NewWAL(path string, opts ...Option) (*WAL, error) {
+	o := newOptions(opts)
+	return NewWALWithOptions(o.resolve(path), o.WAL)
+}
+
+// NewWALWithOptions opens the WAL at path, appending to its newest segment.
+// A WAL moved away by Store.Relocate is opened where it was moved to.
+func NewWALWithOptions(path string, opts WALOptions) (*WAL, error) {
+	path, err := followRedirects(path, loggerOr(opts.Logger, walLogger))
+	if err != nil {
+		return nil, err
+	}
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil && !errors.Is(err, os.ErrExist) {
 		return nil, fmt.Errorf("store: create wal directory: %w", err)
 	}
 
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, walFileMode)
+	if err := openManifest(path, loggerOr(opts.Logger, storeLogger)); err != nil {
+		return nil, err
+	}
+
+	segments, err := listSegments(path)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints, err := listCheckpoints(path)
 	if err != nil {
-		return nil, fmt.Errorf("store: open wal: %w", err)
+		return nil, err
 	}
 
+	// Appends go to the newest segment, which is never older than the
+	// segment the latest checkpoint hands over to.
+	first := 0
+	if len(checkpoints) > 0 {
+		first = checkpoints[len(checkpoints)-1]
+	}
+	segment := first
+	if len(segments) > 0 {
+		segment = max(segment, segments[len(segments)-1])
+	}
+
+	file, size, err := openSegment(path, segment)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	log := loggerOr(opts.Logger, walLogger)
+
 	wal := &WAL{
 		path:   path,
 		file:   file,
 		writer: bufio.NewWriter(file),
+		opts:   opts,
+		log:    log,
+		first:  first,
 
 		flushChan: make(chan struct{}, 1),
 		doneChan:  make(chan struct{}),
 
-		activeBuffer:  make([]WALEntry, 0, bufferSize),
-		pendingBuffer: make([]WALEntry, 0, bufferSize),
+		activeBuffer:  make([]WALEntry, 0, opts.BufferSize),
+		pendingBuffer: make([]WALEntry, 0, opts.BufferSize),
+
+		stall: newStallDetector(log),
 	}
+	wal.flushed = sync.NewCond(&wal.mu)
+	wal.segment.Store(int64(segment))
+	wal.segmentBytes.Store(size)
 
+	interval := opts.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	wal.ticker = time.NewTicker(interval)
+	// Not panics.Go: a flusher restarted after a panic could write after
+	// a torn record.
 	wal.wg.Add(1)
-	wal.ticker = time.NewTicker(1 * time.Second)
 	go func() {
 		defer wal.wg.Done()
+		defer wal.recoverFlusher()
 		wal.asyncFlush(wal.ticker)
 	}()
 
 	return wal, nil
 }
 
+// Append buffers entry for the flusher. Under SyncAlways it blocks until the
+// entry has been written and fsynced and returns the write error, if any.
+// Under the other policies a failed background write is returned by the
+// following appends and by Sync.
 func (w *WAL) Append(entry WALEntry) error {
+	seq, err := w.enqueue(entry)
+	if err != nil {
+		return err
+	}
+	_, err = w.waitDurable(seq)
+	return err
+}
+
+// enqueue buffers entry and returns its sequence number without waiting for
+// it to be written.
+func (w *WAL) enqueue(entry WALEntry) (uint64, error) {
+	w.stall.throttle()
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
 	w.activeBuffer = append(w.activeBuffer, entry)
-	if len(w.activeBuffer) >= bufferSize {
-		w.flushChan <- struct{}{}
+	w.appendSeq++
+
+	if w.opts.Sync == SyncAlways || len(w.activeBuffer) >= w.opts.BufferSize {
+		w.requestFlush()
+	}
+
+	return w.appendSeq, nil
+}
+
+// waitDurable blocks under SyncAlways until the entry with sequence number
+// seq has been written and fsynced, reporting whether it waited and the
+// write error; under other policies it returns at once.
+func (w *WAL) waitDurable(seq uint64) (bool, error) {
+	if w.opts.Sync != SyncAlways {
+		return false, nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.flushedSeq < seq {
+		w.flushed.Wait()
+	}
+	if w.err != nil && seq >= w.errSeq {
+		return true, w.err
+	}
+	return true, nil
+}
+
+// writable returns the error an append would fail with right now: ErrClosed
+// or the write failure that stopped the WAL.
+func (w *WAL) writable() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+	return w.err
+}
+
+// Sync writes and fsyncs every entry appended so far, regardless of the sync
+// policy, and returns the first write failure if any entry was lost.
+func (w *WAL) Sync() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	closed := w.closed
+	w.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	w.flushLocked()
+
+	w.mu.Lock()
+	err := w.err
+	w.mu.Unlock()
+	if err != nil {
+		return err
 	}
 
+	// The other policies already fsynced as part of the flush.
+	if w.opts.Sync == SyncNever {
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("store: sync wal: %w", err)
+		}
+		w.markSynced()
+	}
 	return nil
 }
 
-func (w *WAL) ReadAll() ([]WALEntry, error) {
-	w.flushBuffer()
+// markSynced records that everything written so far has been fsynced.
+func (w *WAL) markSynced() {
+	w.mu.Lock()
+	w.durableRevision = w.writtenRevision
+	w.mu.Unlock()
+}
+
+// revisionState reports how far the entry written at revision has got.
+func (w *WAL) revisionState(revision uint64) WriteState {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("store: seek wal start: %w", err)
+
+	switch {
+	case revision <= w.durableRevision:
+		return WriteDurable
+	case w.err != nil:
+		// Nothing more is written after a failure.
+		return WriteFailed
+	default:
+		return WritePending
 	}
+}
+
+// requestFlush wakes the flusher. A flush is already queued when the channel
+// is full; blocking here while holding mu would deadlock against swapBuffers.
+func (w *WAL) requestFlush() {
+	select {
+	case w.flushChan <- struct{}{}:
+	default:
+	}
+}
 
-	reader := bufio.NewReader(w.file)
+func (w *WAL) ReadAll() ([]WALEntry, error) {
 	entries := make([]WALEntry, 0)
+	err := w.Replay(func(entry WALEntry, _ int64) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Size returns the total size of the WAL segments on disk that are not
+// covered by a checkpoint.
+func (w *WAL) Size() (int64, error) {
+	path := w.currentPath()
+	segments, err := listSegments(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, segment := range segments {
+		if segment < w.first {
+			continue
+		}
+		info, err := os.Stat(segmentPath(path, segment))
+		if err != nil {
+			return 0, fmt.Errorf("store: stat wal segment: %w", err)
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// Replay flushes buffered entries and streams every WAL entry to fn in order,
+// across all segments not covered by a checkpoint, together with the entry's
+// size on disk. fn must not append to the WAL. Under RecoveryTruncate a
+// corrupt record ends the replay without an error, and the WAL is truncated
+// before it.
+func (w *WAL) Replay(fn func(entry WALEntry, size int64) error) error {
+	// Holding flushMu keeps the flusher from writing or rotating while the
+	// segments are read.
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.flushLocked()
+
+	segments, err := listSegments(w.path)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if segment < w.first {
+			continue
+		}
+		// offset is where the last good record of the segment ends.
+		var offset int64
+		var fnErr error
+		err := replaySegment(segmentPath(w.path, segment), func(entry WALEntry, size int64) error {
+			if fnErr = fn(entry, size); fnErr != nil {
+				return fnErr
+			}
+			offset += size
+			return nil
+		})
+		if err != nil && fnErr == nil && w.opts.Recovery == RecoveryTruncate && errors.Is(err, ErrCorruptWAL) {
+			return w.truncate(segment, offset, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// truncate cuts the WAL off at offset in segment, removing the later
+// segments, and makes segment the active one. It must be called with
+// flushMu held and nothing buffered.
+func (w *WAL) truncate(segment int, offset int64, cause error) error {
+	segments, err := listSegments(w.path)
+	if err != nil {
+		return err
+	}
+
+	var discarded int64
+	for _, s := range segments {
+		if s < segment {
+			continue
+		}
+		path := segmentPath(w.path, s)
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("store: stat wal segment: %w", err)
+		}
+		if s == segment {
+			discarded += info.Size() - offset
+			if err := os.Truncate(path, offset); err != nil {
+				return fmt.Errorf("store: truncate wal segment: %w", err)
+			}
+			continue
+		}
+		discarded += info.Size()
+		if s == int(w.segment.Load()) {
+			// Appends must not go to a segment that is about to vanish.
+			file, _, err := openSegment(w.path, segment)
+			if err != nil {
+				return err
+			}
+			if err := w.file.Close(); err != nil {
+				w.log.Warn("close wal segment", "path", w.file.Name(), "error", err)
+			}
+			w.file = file
+			w.writer.Reset(file)
+			w.segment.Store(int64(segment))
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("store: remove wal segment: %w", err)
+		}
+	}
+	w.segmentBytes.Store(offset)
+
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("store: sync wal segment: %w", err)
+	}
+	if err := syncDir(filepath.Dir(w.path)); err != nil {
+		return err
+	}
+
+	w.log.Warn("wal truncated at corrupt record",
+		"segment", segmentPath(w.path, segment), "offset", offset, "discarded_bytes", discarded, "error", cause)
+	return nil
+}
+
+func replaySegment(path string, fn func(entry WALEntry, size int64) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("store: open wal segment: %w", err)
+	}
+	defer file.Close()
+
+	if err := decodeRecords(bufio.NewReader(file), fn); err != nil {
+		return fmt.Errorf("store: replay %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// segmentPath returns the file name of the given segment.
+func segmentPath(path string, segment int) string {
+	if segment == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s.%06d", path, segment)
+}
+
+// listSegments returns the sequence numbers of the existing segments of the
+// WAL at path in ascending order.
+func listSegments(path string) ([]int, error) {
+	numbered, err := listNumbered(path + ".")
+	if err != nil {
+		return nil, fmt.Errorf("store: list wal segments: %w", err)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return append([]int{0}, numbered...), nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("store: stat wal: %w", err)
+	}
+	return numbered, nil
+}
+
+// listNumbered returns, in ascending order, the positive n for which a file
+// named prefix followed by n as six or more zero-padded digits exists.
+func listNumbered(prefix string) ([]int, error) {
+	names, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]int, 0, len(names))
+	for _, name := range names {
+		n, err := strconv.Atoi(strings.TrimPrefix(name, prefix))
+		if err != nil || n <= 0 || fmt.Sprintf("%s%06d", prefix, n) != name {
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// openSegment opens the given segment for appending and returns its size.
+func openSegment(path string, segment int) (*os.File, int64, error) {
+	file, err := os.OpenFile(segmentPath(path, segment), os.O_CREATE|os.O_RDWR|os.O_APPEND, walFileMode)
+	if err != nil {
+		return nil, 0, fmt.Errorf("store: open wal: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, fmt.Errorf("store: stat wal: %w", err)
+	}
+
+	return file, info.Size(), nil
+}
+
+// rotate syncs and closes the active segment and starts the next one. It must
+// be called with flushMu held and the writer flushed.
+func (w *WAL) rotate() error {
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("store: sync wal segment: %w", err)
+	}
+
+	next := int(w.segment.Load()) + 1
+	file, _, err := openSegment(w.path, next)
+	if err != nil {
+		return err
+	}
+
+	if err := w.file.Close(); err != nil {
+		w.log.Warn("close wal segment", "path", w.file.Name(), "error", err)
+	}
+
+	w.segment.Store(int64(next))
+	w.segmentBytes.Store(0)
+	w.file = file
+	w.writer.Reset(file)
+
+	w.log.Info("wal segment rotated", "segment", file.Name())
+	return nil
+}
+
+// decodeRecords parses WAL records from reader until EOF and passes each
+// decoded entry and its on-disk size to fn.
+func decodeRecords(reader io.Reader, fn func(entry WALEntry, size int64) error) error {
 	lengthBuf := make([]byte, lengthPrefix)
 	checksumBuf := make([]byte, checksumSize)
 
@@ -122,67 +626,90 @@ func (w *WAL) ReadAll() ([]WALEntry, error) {
 		// Read length prefix
 		if _, err := io.ReadFull(reader, lengthBuf); err != nil {
 			if errors.Is(err, io.EOF) {
-				break
+				return nil
 			}
 			if errors.Is(err, io.ErrUnexpectedEOF) {
-				return nil, ErrCorruptWAL
+				return ErrCorruptWAL
 			}
-			return nil, fmt.Errorf("store: read wal length: %w", err)
+			return fmt.Errorf("store: read wal length: %w", err)
 		}
 
 		length := binary.BigEndian.Uint32(lengthBuf)
+		versioned := length&versionedFlag != 0
+		length &^= versionedFlag
 		if length == 0 {
-			return nil, ErrCorruptWAL
+			return ErrCorruptWAL
 		}
 
 		// Read checksum
 		if _, err := io.ReadFull(reader, checksumBuf); err != nil {
 			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
-				return nil, ErrCorruptWAL
+				return ErrCorruptWAL
 			}
-			return nil, fmt.Errorf("store: read wal checksum: %w", err)
+			return fmt.Errorf("store: read wal checksum: %w", err)
 		}
 
 		expectedChecksum := binary.BigEndian.Uint32(checksumBuf)
 
-		// Read payload
-		payload := make([]byte, length)
-		if _, err := io.ReadFull(reader, payload); err != nil {
-			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
-				return nil, ErrCorruptWAL
+		// Read payload. The length prefix is untrusted, so the buffer grows
+		// with the bytes actually present instead of being allocated upfront.
+		var payloadBuf bytes.Buffer
+		payloadBuf.Grow(int(min(length, maxPayloadPrealloc)))
+		n, err := io.CopyN(&payloadBuf, reader, int64(length))
+		if err != nil {
+			if errors.Is(err, io.EOF) && n < int64(length) {
+				return ErrCorruptWAL
 			}
-			return nil, fmt.Errorf("store: read wal payload: %w", err)
+			return fmt.Errorf("store: read wal payload: %w", err)
 		}
+		payload := payloadBuf.Bytes()
 
 		// Validate checksum
 		actualChecksum := crc32.ChecksumIEEE(payload)
 		if actualChecksum != expectedChecksum {
-			return nil, fmt.Errorf("store: checksum validation failed for entry (expected: %d, actual: %d): %w", expectedChecksum, actualChecksum, ErrCorruptWAL)
+			return fmt.Errorf("store: checksum validation failed for entry (expected: %d, actual: %d): %w", expectedChecksum, actualChecksum, ErrCorruptWAL)
 		}
 
-		// Decode entry
-		var entry WALEntry
-		buf := bytes.NewReader(payload)
-		dec := gob.NewDecoder(buf)
-		if err := dec.Decode(&entry); err != nil {
-			return nil, fmt.Errorf("store: decode wal entry: %w", err)
+		entry, err := decodePayload(payload, versioned)
+		if err != nil {
+			return err
 		}
 
-		entries = append(entries, entry)
-	}
-
-	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
-		return nil, fmt.Errorf("store: seek wal end: %w", err)
+		if err := fn(entry, int64(lengthPrefix+checksumSize+len(payload))); err != nil {
+			return err
+		}
 	}
-
-	return entries, nil
 }
 
+// Close flushes and fsyncs buffered entries and closes the active segment.
+// Closing an already closed WAL is a no-op.
 func (w *WAL) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
 	w.ticker.Stop()
 	close(w.doneChan)
 	w.wg.Wait()
 	w.flushBuffer()
+
+	w.mu.Lock()
+	writeErr := w.err
+	w.mu.Unlock()
+	if writeErr != nil {
+		_ = w.file.Close()
+		return writeErr
+	}
+
+	if err := w.file.Sync(); err != nil {
+		_ = w.file.Close()
+		return fmt.Errorf("store: sync wal: %w", err)
+	}
+	w.markSynced()
 	return w.file.Close()
 }
 
@@ -200,6 +727,26 @@ func (w *WAL) asyncFlush(t *time.Ticker) {
 	}
 }
 
+// recoverFlusher stops the WAL like a failed write when the flusher
+// panics: the buffered entries are lost and later appends are rejected.
+// The panic is reported, but the flusher is never restarted.
+func (w *WAL) recoverFlusher() {
+	value := recover()
+	if value == nil {
+		return
+	}
+	w.mu.Lock()
+	if w.err == nil {
+		w.log.Error("wal flusher panicked, rejecting further appends", "path", w.file.Name())
+		w.err = fmt.Errorf("%w: flusher panicked: %v", ErrWriteFailed, value)
+		w.errSeq = w.flushedSeq + 1
+	}
+	w.flushedSeq = w.appendSeq
+	w.flushed.Broadcast()
+	w.mu.Unlock()
+	panics.Handle("wal-flusher", value)
+}
+
 func (w *WAL) swapBuffers() {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -209,43 +756,124 @@ func (w *WAL) swapBuffers() {
 	}
 
 	w.activeBuffer, w.pendingBuffer = w.pendingBuffer, w.activeBuffer
+	w.pendingSeq = w.appendSeq
 }
 
 func (w *WAL) flushBuffer() {
-	w.swapBuffers()
-
+	// Swap under flushMu so a concurrent flush never has its pending buffer
+	// replaced while it is still being written.
 	w.flushMu.Lock()
 	defer w.flushMu.Unlock()
 
-	for _, entry := range w.pendingBuffer {
-		var buf bytes.Buffer
-		enc := gob.NewEncoder(&buf)
-		if err := enc.Encode(entry); err != nil {
-			continue
-		}
-		data := buf.Bytes()
+	w.flushLocked()
+}
 
-		// Calculate CRC32 checksum of the payload
-		checksum := crc32.ChecksumIEEE(data)
+// flushLocked writes the buffered entries to the active segment, rotating it
+// once it grows past the configured size. flushMu must be held.
+func (w *WAL) flushLocked() {
+	w.swapBuffers()
 
-		// Write length prefix
-		var lengthBuf [lengthPrefix]byte
-		binary.BigEndian.PutUint32(lengthBuf[:], uint32(len(data)))
-		w.writer.Write(lengthBuf[:])
+	if len(w.pendingBuffer) == 0 {
+		return
+	}
 
-		// Write checksum
-		var checksumBuf [checksumSize]byte
-		binary.BigEndian.PutUint32(checksumBuf[:], checksum)
-		w.writer.Write(checksumBuf[:])
+	w.mu.Lock()
+	err := w.err
+	w.mu.Unlock()
 
-		// Write payload
-		w.writer.Write(data)
+	var revision uint64
+	for _, entry := range w.pendingBuffer {
+		revision = max(revision, entry.Revision)
 	}
 
-	w.writer.Flush()
-	w.file.Sync()
+	// After a failed write the segment may end in a torn record, so nothing
+	// more is written; the entries fail with the original error.
+	if err == nil {
+		start := time.Now()
+		err = w.writePending()
+		w.stall.record(time.Since(start))
+	}
 
 	w.mu.Lock()
+	if err != nil && w.err == nil {
+		w.log.Error("wal write failed, rejecting further appends", "path", w.file.Name(), "error", err)
+		w.err = fmt.Errorf("%w: %w", ErrWriteFailed, err)
+		w.errSeq = w.flushedSeq + 1
+	}
+	if err == nil {
+		w.writtenRevision = max(w.writtenRevision, revision)
+		if w.opts.Sync != SyncNever {
+			w.durableRevision = w.writtenRevision
+		}
+	}
 	w.pendingBuffer = w.pendingBuffer[:0]
+	w.flushedSeq = w.pendingSeq
+	w.flushed.Broadcast()
 	w.mu.Unlock()
 }
+
+// writePending encodes, writes and, unless the policy is SyncNever, fsyncs
+// the pending entries.
+func (w *WAL) writePending() error {
+	for _, entry := range w.pendingBuffer {
+		n, err := writeRecord(w.writer, entry, w.opts.Compression)
+		if err != nil {
+			return err
+		}
+
+		size := w.segmentBytes.Add(int64(n))
+		if w.opts.SegmentSize > 0 && size >= w.opts.SegmentSize {
+			if err := w.writer.Flush(); err != nil {
+				return fmt.Errorf("store: flush wal buffer: %w", err)
+			}
+			// A failed rotation leaves the current segment active, so
+			// appends can continue there.
+			if err := w.rotate(); err != nil {
+				w.log.Error("rotate wal segment", "path", w.file.Name(), "error", err)
+			}
+		}
+	}
+
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("store: flush wal buffer: %w", err)
+	}
+	if w.opts.Sync != SyncNever {
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("store: sync wal: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeRecord frames entry as a versioned [length][checksum][payload] record and writes it to
+// writer, returning the record's size.
+func writeRecord(writer *bufio.Writer, entry WALEntry, compression Compression) (int, error) {
+	data, err := encodePayload(entry, compression)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) >= versionedFlag {
+		return 0, fmt.Errorf("store: wal entry %q is too large", entry.Key)
+	}
+
+	// Calculate CRC32 checksum of the payload
+	checksum := crc32.ChecksumIEEE(data)
+
+	// Write length prefix
+	var lengthBuf [lengthPrefix]byte
+	binary.BigEndian.PutUint32(lengthBuf[:], uint32(len(data))|versionedFlag)
+	writer.Write(lengthBuf[:])
+
+	// Write checksum
+	var checksumBuf [checksumSize]byte
+	binary.BigEndian.PutUint32(checksumBuf[:], checksum)
+	writer.Write(checksumBuf[:])
+
+	// Write payload; bufio.Writer keeps the first error, so checking the
+	// last write covers all three.
+	if _, err := writer.Write(data); err != nil {
+		return 0, fmt.Errorf("store: write wal entry: %w", err)
+	}
+
+	return lengthPrefix + checksumSize + len(data), nil
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+	"universe/internal/panics"
+)
+
+// warmupChunk is how many deferred entries are applied per hold of the
+// write lock, so writes to warm keys are not held up for the whole load.
+const warmupChunk = 1024
+
+// warmup loads the keys with the warm prefixes before the rest. Recovery
+// applies only their entries and defers the others, which are applied in
+// the background once the store is open. Until then reads and writes of
+// other keys wait for them.
+type warmup struct {
+	prefixes keyFilter
+	deferred []WALEntry
+	// loading is set while deferred entries remain; done is closed when
+	// they have all been applied.
+	loading atomic.Bool
+	done    chan struct{}
+}
+
+func newWarmup(prefixes []string) *warmup {
+	return &warmup{prefixes: keyFilter(prefixes), done: make(chan struct{})}
+}
+
+// split returns the part of entry that recovery applies now and the part
+// it defers. Both keep the entry's revision.
+func (w *warmup) split(entry WALEntry) (now, later WALEntry) {
+	if len(w.prefixes) == 0 {
+		return entry, WALEntry{}
+	}
+	switch entry.Type {
+	case OperationSet, OperationDelete:
+		if w.prefixes.serves(entry.Key) {
+			return entry, WALEntry{}
+		}
+		// Applying an empty batch still advances the revision.
+		return WALEntry{Type: OperationBatch, Revision: entry.Revision}, entry
+	case OperationBatch:
+		now = WALEntry{Type: OperationBatch, Revision: entry.Revision}
+		later = now
+		for _, op := range entry.Batch {
+			if w.prefixes.serves(op.Key) {
+				now.Batch = append(now.Batch, op)
+			} else {
+				later.Batch = append(later.Batch, op)
+			}
+		}
+		if len(later.Batch) == 0 {
+			return entry, WALEntry{}
+		}
+		return now, later
+	default:
+		return entry, WALEntry{}
+	}
+}
+
+// wait blocks until key can be used.
+func (w *warmup) wait(key string) {
+	if w.loading.Load() && !w.prefixes.serves(key) {
+		<-w.done
+	}
+}
+
+// waitPrefix blocks until every key with prefix can be used.
+func (w *warmup) waitPrefix(prefix string) {
+	if !w.loading.Load() {
+		return
+	}
+	for _, warm := range w.prefixes {
+		if strings.HasPrefix(prefix, warm) {
+			return
+		}
+	}
+	<-w.done
+}
+
+// finishWarmup applies the entries recovery deferred, a chunk at a time,
+// and then lets waiting reads and writes through. Close waits for it, so a
+// checkpoint never misses the deferred keys.
+func (s *Store) finishWarmup() {
+	w := s.warmup
+	if len(w.deferred) == 0 {
+		close(w.done)
+		return
+	}
+	w.loading.Store(true)
+	s.recovery.deferred.Store(int64(len(w.deferred)))
+
+	panics.Go("warmup", &s.wg, func() {
+		start := time.Now()
+		entries := len(w.deferred)
+		for len(w.deferred) > 0 {
+			n := min(len(w.deferred), warmupChunk)
+			s.mu.Lock()
+			for _, entry := range w.deferred[:n] {
+				s.applyEntry(entry)
+			}
+			s.mu.Unlock()
+			w.deferred = w.deferred[n:]
+			s.recovery.deferred.Store(int64(len(w.deferred)))
+		}
+		w.deferred = nil
+		w.loading.Store(false)
+		close(w.done)
+		s.log.Info("warm-up complete", "deferred_entries", entries, "elapsed", time.Since(start))
+	})
+}
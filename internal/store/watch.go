@@ -0,0 +1,194 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"universe/internal/events"
+)
+
+// watchBuffer is how many events a watcher may fall behind before it is
+// dropped.
+const watchBuffer = 256
+
+// Event describes one change to a key. Value is empty for deletes.
+type Event struct {
+	Type     OperationType `json:"type"`
+	Key      string        `json:"key"`
+	Value    []byte        `json:"value,omitempty"`
+	Revision uint64        `json:"revision"`
+}
+
+type watcher struct {
+	prefix string
+	events chan Event
+}
+
+// watchers fans out changes to the registered watchers. Publishing never
+// blocks: a watcher whose buffer is full is removed and its channel closed,
+// so a slow consumer cannot stall writes.
+type watchers struct {
+	mu     sync.Mutex
+	active map[*watcher]struct{}
+	closed bool
+	log    *slog.Logger
+}
+
+// HistoryLostError is returned by WatchFrom when the changes after the
+// requested revision have been compacted away. The caller must re-read the
+// keys it cares about, at Revision or later, and watch from there.
+type HistoryLostError struct {
+	// Requested is the revision the watch asked to resume after.
+	Requested uint64
+	// Compacted is the oldest revision a watch can resume after.
+	Compacted uint64
+	// Revision is the store's revision when the watch was refused.
+	Revision uint64
+}
+
+func (e *HistoryLostError) Error() string {
+	return fmt.Sprintf("store: history lost: revision %d is before %d (current revision %d)", e.Requested, e.Compacted, e.Revision)
+}
+
+// Unwrap makes errors.Is(err, ErrCompacted) hold.
+func (e *HistoryLostError) Unwrap() error { return ErrCompacted }
+
+// Watch returns a channel that receives every change to keys with the given
+// prefix from now on, in revision order, and a function that stops the
+// watch. An empty prefix watches all keys. The channel is closed when the
+// watch is stopped, when the store closes, or when the receiver falls more
+// than a few hundred events behind; in that case changes were missed and
+// the caller should resume with WatchFrom after the last revision it saw.
+func (s *Store) Watch(prefix string) (<-chan Event, func()) {
+	s.warmup.waitPrefix(prefix)
+	w := &watcher{prefix: prefix, events: make(chan Event, watchBuffer)}
+	s.watchers.add(w)
+	return w.events, func() { s.watchers.remove(w) }
+}
+
+// WatchFrom is like Watch but first delivers the changes made after
+// revision, so a watcher can resume where it left off without missing
+// events. It fails with a *HistoryLostError if those changes have been
+// compacted away and with ErrFutureRevision if revision is not written yet.
+func (s *Store) WatchFrom(prefix string, revision uint64) (<-chan Event, func(), error) {
+	s.warmup.waitPrefix(prefix)
+	// Holding mu keeps writes out until the watcher is registered, so no
+	// change is both replayed and published, or neither.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := s.revision.Load()
+	if revision > current {
+		return nil, nil, fmt.Errorf("%w: %d is after %d", ErrFutureRevision, revision, current)
+	}
+	if compacted := s.compacted.Load(); revision < compacted {
+		return nil, nil, &HistoryLostError{Requested: revision, Compacted: compacted, Revision: current}
+	}
+
+	missed := s.changesSince(prefix, revision)
+	w := &watcher{prefix: prefix, events: make(chan Event, len(missed)+watchBuffer)}
+	for _, event := range missed {
+		w.events <- event
+	}
+	s.watchers.add(w)
+	return w.events, func() { s.watchers.remove(w) }, nil
+}
+
+// changesSince rebuilds the events for keys with prefix written after
+// revision from the history, in revision order. Versions after the
+// compacted revision are never discarded, so nothing is missing.
+func (s *Store) changesSince(prefix string, revision uint64) []Event {
+	var events []Event
+	s.history.Range(func(key string, versions []version) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		i := sort.Search(len(versions), func(i int) bool { return versions[i].revision > revision })
+		for _, v := range versions[i:] {
+			event := Event{Type: OperationSet, Key: key, Value: bytes.Clone(v.value), Revision: v.revision}
+			if v.deleted {
+				event = Event{Type: OperationDelete, Key: key, Revision: v.revision}
+			}
+			events = append(events, event)
+		}
+		return false
+	})
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Revision != events[j].Revision {
+			return events[i].Revision < events[j].Revision
+		}
+		return events[i].Key < events[j].Key
+	})
+	return events
+}
+
+// handle publishes a write from the event bus to the watchers.
+func (ws *watchers) handle(e events.Event) {
+	ws.publish(e.Payload.(Event))
+}
+
+func (ws *watchers) add(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.closed {
+		close(w.events)
+		return
+	}
+	if ws.active == nil {
+		ws.active = make(map[*watcher]struct{})
+	}
+	ws.active[w] = struct{}{}
+}
+
+func (ws *watchers) remove(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, ok := ws.active[w]; ok {
+		delete(ws.active, w)
+		close(w.events)
+	}
+}
+
+func (ws *watchers) publish(event Event) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for w := range ws.active {
+		if !strings.HasPrefix(event.Key, w.prefix) {
+			continue
+		}
+
+		delivered := event
+		delivered.Value = bytes.Clone(event.Value)
+		select {
+		case w.events <- delivered:
+		default:
+			ws.log.Warn("dropping slow watcher", "prefix", w.prefix)
+			delete(ws.active, w)
+			close(w.events)
+		}
+	}
+}
+
+func (ws *watchers) count() int {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return len(ws.active)
+}
+
+// close ends every watch and rejects new ones.
+func (ws *watchers) close() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for w := range ws.active {
+		close(w.events)
+	}
+	ws.active = nil
+	ws.closed = true
+}
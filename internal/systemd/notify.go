@@ -0,0 +1,36 @@
+// Package systemd implements the sd_notify readiness protocol.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// Notification states understood by systemd.
+const (
+	StateReady    = "READY=1"
+	StateStopping = "STOPPING=1"
+)
+
+// Notify sends state to the socket named by $NOTIFY_SOCKET. It returns false
+// without error when the process is not supervised by systemd.
+func Notify(state string) (bool, error) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return false, nil
+	}
+
+	addr := &net.UnixAddr{Name: socketPath, Net: "unixgram"}
+	conn, err := net.DialUnix(addr.Net, nil, addr)
+	if err != nil {
+		return false, fmt.Errorf("systemd: dial notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("systemd: write notify state: %w", err)
+	}
+
+	return true, nil
+}
@@ -1,7 +1,359 @@
-// Package client provides the client interface.
+// Package client is a Go client for the HTTP API of a universekv server.
+//
+// A Client is safe for concurrent use and keeps a pool of connections to
+// the server, so applications should create one and share it. Requests are
+// retried with exponential backoff when the server cannot be reached or
+// answers that it is temporarily unavailable, and every method takes a
+// context bounding the request, retries included.
+//
+// A Client is a session: it sends the X-Session-Token of its latest write
+// with each request, so its reads reflect its own writes.
 package client
 
-// Client represents the KV client
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxRetries is how often a failed request is retried unless
+	// WithRetries says otherwise.
+	DefaultMaxRetries = 3
+	// DefaultMaxConnsPerHost is the number of idle connections kept to
+	// the server unless WithMaxConnsPerHost says otherwise.
+	DefaultMaxConnsPerHost = 32
+
+	defaultMinBackoff = 50 * time.Millisecond
+	defaultMaxBackoff = 2 * time.Second
+
+	sessionHeader = "X-Session-Token"
+	userAgent     = "universekv-go-client"
+)
+
+// ErrNotFound is matched by the error returned for a key that does not
+// exist.
+var ErrNotFound = errors.New("client: key not found")
+
+// Error is a request the server refused or failed.
+type Error struct {
+	StatusCode int
+	// Message is the error the server gave.
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("client: server returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is makes a 404 match ErrNotFound.
+func (e *Error) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// Client talks to one universekv server.
 type Client struct {
-	// TODO: Implement client logic
+	base       string
+	http       *http.Client
+	ownsHTTP   bool
+	maxConns   int
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	bearer   string
+	username string
+	password string
+	basic    bool
+
+	mu      sync.Mutex
+	session string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends requests with hc instead of a client of the
+// package's own. WithMaxConnsPerHost has no effect then.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// WithMaxConnsPerHost caps the connections open to the server and keeps
+// up to n of them idle for reuse.
+func WithMaxConnsPerHost(n int) Option {
+	return func(c *Client) {
+		c.maxConns = n
+	}
+}
+
+// WithRetries sets how often a failed request is retried; 0 disables
+// retries.
+func WithRetries(n int) Option {
+	return func(c *Client) {
+		c.maxRetries = n
+	}
+}
+
+// WithBackoff sets the delay before the first retry, which doubles with
+// each further retry up to limit. Delays are randomised between zero and
+// that bound so that clients retrying together spread out.
+func WithBackoff(initial, limit time.Duration) Option {
+	return func(c *Client) {
+		c.minBackoff = initial
+		c.maxBackoff = limit
+	}
+}
+
+// WithBearerToken authenticates requests with token.
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.bearer = token
+	}
+}
+
+// WithBasicAuth authenticates requests with a user name and password.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+		c.basic = true
+	}
+}
+
+// WithSessionToken continues a session whose token was got from
+// SessionToken, for example in another process.
+func WithSessionToken(token string) Option {
+	return func(c *Client) {
+		c.session = token
+	}
+}
+
+// New returns a client for the server at baseURL, such as
+// http://localhost:8080.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid base url: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("client: base url %q must be http or https", baseURL)
+	}
+
+	c := &Client{
+		base:       strings.TrimSuffix(base.String(), "/"),
+		maxConns:   DefaultMaxConnsPerHost,
+		maxRetries: DefaultMaxRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.http == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConnsPerHost = c.maxConns
+		transport.MaxConnsPerHost = c.maxConns
+		c.http = &http.Client{Transport: transport}
+		c.ownsHTTP = true
+	}
+	return c, nil
+}
+
+// Close closes the pooled connections. Requests in flight are not
+// interrupted.
+func (c *Client) Close() error {
+	if c.ownsHTTP {
+		c.http.CloseIdleConnections()
+	}
+	return nil
+}
+
+// SessionToken returns the client's session token, which is empty until
+// the client has written.
+func (c *Client) SessionToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+// observeSession records the session token of a response. Tokens are
+// revisions, and of concurrent responses the one issued last may arrive
+// first, so the session only moves forward.
+func (c *Client) observeSession(token string) {
+	if token == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	current, err := strconv.ParseUint(c.session, 10, 64)
+	if next, nextErr := strconv.ParseUint(token, 10, 64); err == nil && nextErr == nil && next < current {
+		return
+	}
+	c.session = token
+}
+
+// request describes one API call.
+type request struct {
+	method string
+	// path is the escaped path, e.g. /v1/kv/a%3Fb.
+	path        string
+	query       url.Values
+	body        []byte
+	contentType string
+	accept      string
+	header      http.Header
+}
+
+// do sends req, retrying it while it fails in a way another attempt may
+// not. Every call the client makes is safe to repeat: reads, puts and
+// deletes of fixed values. It returns the response of a status below 400,
+// whose body the caller must close, or an *Error for any other.
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	target := c.base + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, target, req)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			return resp, nil
+		}
+		if err == nil {
+			err = responseError(resp)
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("client: %s %s: %w", req.method, req.path, ctx.Err())
+		}
+		if attempt >= c.maxRetries || !retryable(err) {
+			return nil, err
+		}
+		if err := sleep(ctx, c.backoff(attempt)); err != nil {
+			return nil, fmt.Errorf("client: %s %s: %w", req.method, req.path, err)
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, target string, req request) (*http.Response, error) {
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("client: %w", err)
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set("User-Agent", userAgent)
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	if req.accept != "" {
+		httpReq.Header.Set("Accept", req.accept)
+	}
+	if session := c.SessionToken(); session != "" {
+		httpReq.Header.Set(sessionHeader, session)
+	}
+	switch {
+	case c.bearer != "":
+		httpReq.Header.Set("Authorization", "Bearer "+c.bearer)
+	case c.basic:
+		httpReq.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, &transportError{err: err}
+	}
+	c.observeSession(resp.Header.Get(sessionHeader))
+	return resp, nil
+}
+
+// responseError reads the *Error of a failed response and closes it.
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+}
+
+// transportError is a request that got no response.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return "client: " + e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// retryable reports whether a request failing with err may succeed if
+// sent again: it got no response, or the server or a proxy in front of it
+// was unavailable.
+func retryable(err error) bool {
+	var transport *transportError
+	if errors.As(err, &transport) {
+		return true
+	}
+	var status *Error
+	if errors.As(err, &status) {
+		switch status.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before retry attempt+1.
+func (c *Client) backoff(attempt int) time.Duration {
+	bound := c.minBackoff
+	for range attempt {
+		if bound >= c.maxBackoff {
+			break
+		}
+		bound *= 2
+	}
+	bound = min(bound, c.maxBackoff)
+	if bound <= 0 {
+		return 0
+	}
+	return rand.N(bound) + 1
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// escapeKey escapes key for a path in which its slashes separate
+// segments, as the server's key routes expect.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
 }
@@ -0,0 +1,372 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+	server "universe/internal/server/http"
+	"universe/internal/store"
+)
+
+// newTestClient starts a server on a fresh store, its handler wrapped by
+// wrap when that is not nil, and returns a client for it.
+func newTestClient(t *testing.T, wrap func(http.Handler) http.Handler, opts ...Option) (*Client, *store.Store, *httptest.Server) {
+	t.Helper()
+
+	kv, err := store.New(filepath.Join(t.TempDir(), "client.wal"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	handler := server.NewServer(kv).Handler()
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	ts := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		ts.Close()
+		_ = kv.Close()
+	})
+
+	opts = append([]Option{WithBackoff(time.Millisecond, 10*time.Millisecond)}, opts...)
+	c, err := New(ts.URL, opts...)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c, kv, ts
+}
+
+func TestNewRejectsInvalidURL(t *testing.T) {
+	for _, baseURL := range []string{"", "localhost:8080", "ftp://example.com", "http://[::1"} {
+		if _, err := New(baseURL); err == nil {
+			t.Errorf("New(%q) succeeded", baseURL)
+		}
+	}
+}
+
+func TestClientKV(t *testing.T) {
+	c, kv, _ := newTestClient(t, nil)
+	ctx := context.Background()
+
+	keys := []string{"plain", "users/1/name", "odd key?#%"}
+	for i, key := range keys {
+		value := []byte{0xff, byte(i), 0x00, '{'}
+		if err := c.Set(ctx, key, value); err != nil {
+			t.Fatalf("Set(%q): %v", key, err)
+		}
+		got, err := c.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%q): %v", key, err)
+		}
+		if !bytes.Equal(got, value) {
+			t.Fatalf("Get(%q) = %q, want %q", key, got, value)
+		}
+		if stored, ok := kv.Get(key); !ok || !bytes.Equal(stored, value) {
+			t.Fatalf("store holds %q, %v for %q", stored, ok, key)
+		}
+	}
+
+	// JSON values come back verbatim too, not re-encoded.
+	if err := c.Set(ctx, "doc", []byte(`{"a": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get(ctx, "doc"); err != nil || string(got) != `{"a": 1}` {
+		t.Fatalf("Get(doc) = %q, %v", got, err)
+	}
+
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+
+	if err := c.SetWithTTL(ctx, "session", []byte("x"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, ok := kv.TTL("session"); !ok || ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("TTL(session) = %v, %v", ttl, ok)
+	}
+
+	existed, err := c.Delete(ctx, "plain")
+	if err != nil || !existed {
+		t.Fatalf("Delete(plain) = %v, %v", existed, err)
+	}
+	existed, err = c.Delete(ctx, "plain")
+	if err != nil || existed {
+		t.Fatalf("second Delete(plain) = %v, %v", existed, err)
+	}
+}
+
+func TestClientBatch(t *testing.T) {
+	c, kv, _ := newTestClient(t, nil)
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "old", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	var b Batch
+	b.Set("a", []byte(`{"n": 1}`))
+	b.Set("b", []byte(`"two"`))
+	b.Delete("old")
+	revision, err := c.Batch(ctx, &b)
+	if err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	if revision != kv.Revision() {
+		t.Fatalf("Batch revision = %d, store is at %d", revision, kv.Revision())
+	}
+	if value, _ := kv.Get("a"); string(value) != `{"n":1}` {
+		t.Fatalf("a = %q", value)
+	}
+	if _, ok := kv.Get("old"); ok {
+		t.Fatal("old survived the batch")
+	}
+
+	var invalid Batch
+	invalid.Set("c", []byte("not json"))
+	if _, err := c.Batch(ctx, &invalid); err == nil {
+		t.Fatal("Batch with a non-JSON value succeeded")
+	}
+	if _, ok := kv.Get("c"); ok {
+		t.Fatal("invalid batch was applied")
+	}
+}
+
+func TestClientScan(t *testing.T) {
+	c, kv, _ := newTestClient(t, nil)
+	ctx := context.Background()
+
+	// More than two pages, around keys outside the prefix.
+	const n = 2*scanPageSize + 17
+	var batch store.WriteBatch
+	batch.Set("scan", []byte("outside"))
+	batch.Set("scao", []byte("outside"))
+	for i := range n {
+		batch.Set(fmt.Sprintf("scan/%05d", i), []byte(fmt.Sprint(i)))
+	}
+	if err := kv.Write(&batch); err != nil {
+		t.Fatal(err)
+	}
+
+	scan := c.Scan(ctx, "scan/")
+	i := 0
+	for scan.Next() {
+		item := scan.KeyValue()
+		if want := fmt.Sprintf("scan/%05d", i); item.Key != want || string(item.Value) != fmt.Sprint(i) || item.Revision == 0 {
+			t.Fatalf("item %d = %+v, want key %s", i, item, want)
+		}
+		i++
+	}
+	if err := scan.Err(); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if i != n {
+		t.Fatalf("Scan returned %d keys, want %d", i, n)
+	}
+
+	empty := c.Scan(ctx, "nothing/")
+	if empty.Next() || empty.Err() != nil {
+		t.Fatalf("Scan of an empty prefix: %v", empty.Err())
+	}
+}
+
+// failing answers the first n requests with status instead of serving
+// them, and counts all requests.
+type failing struct {
+	n        int64
+	status   int
+	requests atomic.Int64
+}
+
+func (f *failing) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.requests.Add(1) <= f.n {
+			http.Error(w, "injected", f.status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestClientRetries(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unavailable", func(t *testing.T) {
+		f := &failing{n: 2, status: http.StatusServiceUnavailable}
+		c, kv, _ := newTestClient(t, f.wrap)
+		if err := c.Set(ctx, "k", []byte("v")); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if got := f.requests.Load(); got != 3 {
+			t.Fatalf("server saw %d requests, want 3", got)
+		}
+		if value, _ := kv.Get("k"); string(value) != "v" {
+			t.Fatalf("k = %q", value)
+		}
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		f := &failing{n: 100, status: http.StatusServiceUnavailable}
+		c, _, _ := newTestClient(t, f.wrap, WithRetries(1))
+		_, err := c.Get(ctx, "k")
+		var status *Error
+		if !errors.As(err, &status) || status.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("Get error = %v, want a 503 *Error", err)
+		}
+		if got := f.requests.Load(); got != 2 {
+			t.Fatalf("server saw %d requests, want 2", got)
+		}
+	})
+
+	t.Run("client error", func(t *testing.T) {
+		f := &failing{n: 1, status: http.StatusBadRequest}
+		c, _, _ := newTestClient(t, f.wrap)
+		if err := c.Set(ctx, "k", []byte("v")); err == nil {
+			t.Fatal("Set succeeded")
+		}
+		if got := f.requests.Load(); got != 1 {
+			t.Fatalf("a 400 was retried: server saw %d requests", got)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		c, _, ts := newTestClient(t, nil, WithRetries(2))
+		ts.Close()
+		if err := c.Set(ctx, "k", []byte("v")); err == nil {
+			t.Fatal("Set to a closed server succeeded")
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		f := &failing{n: 100, status: http.StatusServiceUnavailable}
+		c, _, _ := newTestClient(t, f.wrap, WithBackoff(time.Hour, time.Hour))
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		if _, err := c.Get(ctx, "k"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Get error = %v, want the context's", err)
+		}
+	})
+}
+
+func TestClientSession(t *testing.T) {
+	c, kv, ts := newTestClient(t, nil)
+	ctx := context.Background()
+
+	if c.SessionToken() != "" {
+		t.Fatalf("fresh client has session %q", c.SessionToken())
+	}
+	if err := c.Set(ctx, "k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprint(kv.Revision()); c.SessionToken() != want {
+		t.Fatalf("session after a write = %q, want %q", c.SessionToken(), want)
+	}
+	// A concurrent response carrying an older token does not move the
+	// session back.
+	c.observeSession("0")
+	if want := fmt.Sprint(kv.Revision()); c.SessionToken() != want {
+		t.Fatalf("session moved back to %q", c.SessionToken())
+	}
+
+	// A session that has written past what the server has is refused
+	// rather than served a stale read.
+	ahead, err := New(ts.URL, WithSessionToken(fmt.Sprint(kv.Revision()+10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ahead.Get(ctx, "k")
+	var status *Error
+	if !errors.As(err, &status) || status.StatusCode != http.StatusConflict {
+		t.Fatalf("Get with a session ahead = %v, want a 409 *Error", err)
+	}
+}
+
+func TestClientWatch(t *testing.T) {
+	c, kv, ts := newTestClient(t, nil)
+	ctx := context.Background()
+
+	w, err := c.Watch(ctx, "w/")
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	t.Cleanup(w.Close)
+
+	next := func() Event {
+		t.Helper()
+		select {
+		case event, ok := <-w.Events():
+			if !ok {
+				t.Fatalf("watch stopped: %v", w.Err())
+			}
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no watch event")
+		}
+		return Event{}
+	}
+
+	if err := c.Set(ctx, "other", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(ctx, "w/a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if event := next(); event.Type != EventSet || event.Key != "w/a" || string(event.Value) != "1" || event.Revision != kv.Revision() {
+		t.Fatalf("event = %+v", event)
+	}
+
+	// Changes made while the stream is broken are delivered once the
+	// watcher has reconnected.
+	ts.CloseClientConnections()
+	if err := kv.Set("w/b", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Delete("w/a"); err != nil {
+		t.Fatal(err)
+	}
+	if event := next(); event.Type != EventSet || event.Key != "w/b" {
+		t.Fatalf("event after reconnecting = %+v", event)
+	}
+	if event := next(); event.Type != EventDelete || event.Key != "w/a" || event.Revision != kv.Revision() {
+		t.Fatalf("event after reconnecting = %+v", event)
+	}
+
+	w.Close()
+	for range w.Events() {
+	}
+	if err := w.Err(); err != nil {
+		t.Fatalf("Err after Close = %v", err)
+	}
+}
+
+func TestClientWatchFrom(t *testing.T) {
+	c, kv, _ := newTestClient(t, nil)
+	ctx := context.Background()
+
+	if err := kv.Set("k", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	start := kv.Revision()
+	if err := kv.Set("k", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := c.WatchFrom(ctx, "", start)
+	if err != nil {
+		t.Fatalf("WatchFrom: %v", err)
+	}
+	defer w.Close()
+	select {
+	case event := <-w.Events():
+		if string(event.Value) != "2" || event.Revision != start+1 {
+			t.Fatalf("replayed event = %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing replayed")
+	}
+}
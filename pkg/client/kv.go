@@ -0,0 +1,281 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	contentTypeJSON  = "application/json"
+	contentTypeBytes = "application/octet-stream"
+
+	// scanPageSize is how many keys Scan lists and reads per request; it is
+	// the most the server returns at once.
+	scanPageSize = 1000
+)
+
+// KeyValue is a key with its value and the revision it was last written at.
+type KeyValue struct {
+	Key      string
+	Value    []byte
+	Revision uint64
+}
+
+// Get returns the value of key, or an error matching ErrNotFound if it
+// does not exist.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/v1/kv/" + escapeKey(key),
+		accept: contentTypeBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	value, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("client: read value of %q: %w", key, err)
+	}
+	return value, nil
+}
+
+// Set stores value under key. The value is stored verbatim.
+func (c *Client) Set(ctx context.Context, key string, value []byte) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL stores value under key and has the server delete it once ttl
+// has passed; a ttl of 0 keeps it.
+func (c *Client) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	var query url.Values
+	if ttl > 0 {
+		query = url.Values{"ttl": {ttl.String()}}
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	resp, err := c.do(ctx, request{
+		method:      http.MethodPut,
+		path:        "/v1/kv/" + escapeKey(key),
+		query:       query,
+		body:        value,
+		contentType: contentTypeBytes,
+	})
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	return nil
+}
+
+// Delete deletes key and reports whether it existed. A delete retried
+// after its first attempt went through reports that it did not.
+func (c *Client) Delete(ctx context.Context, key string) (bool, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/v1/kv/" + escapeKey(key),
+	})
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	drain(resp)
+	return true, nil
+}
+
+// Batch collects writes to apply together with Client.Batch. The zero
+// value is an empty batch.
+type Batch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	Op    string          `json:"op"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Set adds setting key to value. The server takes batch values as JSON
+// documents, so value must be valid JSON; it is stored compactly encoded.
+func (b *Batch) Set(key string, value []byte) {
+	b.ops = append(b.ops, batchOp{Op: "set", Key: key, Value: value})
+}
+
+// Delete adds deleting key.
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, batchOp{Op: "delete", Key: key})
+}
+
+// Len returns the number of writes in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Batch applies the writes of b atomically and in order, and returns the
+// revision they were written at.
+func (c *Client) Batch(ctx context.Context, b *Batch) (uint64, error) {
+	for _, op := range b.ops {
+		if op.Op == "set" && !json.Valid(op.Value) {
+			return 0, fmt.Errorf("client: batch value for %q is not valid JSON", op.Key)
+		}
+	}
+	body, err := json.Marshal(map[string]any{"ops": b.ops})
+	if err != nil {
+		return 0, fmt.Errorf("client: encode batch: %w", err)
+	}
+	resp, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		path:        "/v1/batch",
+		body:        body,
+		contentType: contentTypeJSON,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Revision uint64 `json:"revision"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("client: decode batch response: %w", err)
+	}
+	return result.Revision, nil
+}
+
+// Scanner walks the keys with a prefix in lexical order, fetching them
+// from the server a page at a time:
+//
+//	scan := c.Scan(ctx, "users/")
+//	for scan.Next() {
+//		kv := scan.KeyValue()
+//		...
+//	}
+//	if err := scan.Err(); err != nil {
+//		...
+//	}
+//
+// A scan is not a snapshot: each page reflects the store when it was
+// fetched, and keys deleted meanwhile are skipped. JSON values come back in
+// their compact encoding.
+type Scanner struct {
+	client *Client
+	ctx    context.Context
+	prefix string
+	cursor string
+	done   bool
+
+	page    []KeyValue
+	current KeyValue
+	err     error
+}
+
+// Scan returns a Scanner over the keys with prefix; an empty prefix scans
+// every key.
+func (c *Client) Scan(ctx context.Context, prefix string) *Scanner {
+	return &Scanner{client: c, ctx: ctx, prefix: prefix}
+}
+
+// Next advances to the next key, fetching another page when needed. It
+// returns false when the keys are exhausted or a request failed.
+func (s *Scanner) Next() bool {
+	for len(s.page) == 0 {
+		if s.done || s.err != nil {
+			return false
+		}
+		s.err = s.fetch()
+	}
+	s.current, s.page = s.page[0], s.page[1:]
+	return true
+}
+
+// KeyValue returns the key Next advanced to.
+func (s *Scanner) KeyValue() KeyValue {
+	return s.current
+}
+
+// Err returns the error that ended the scan, if any.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// fetch lists the next page of keys and reads their values.
+func (s *Scanner) fetch() error {
+	query := url.Values{"limit": {strconv.Itoa(scanPageSize)}}
+	if s.prefix != "" {
+		query.Set("prefix", s.prefix)
+	}
+	if s.cursor != "" {
+		query.Set("cursor", s.cursor)
+	}
+	resp, err := s.client.do(s.ctx, request{method: http.MethodGet, path: "/keys", query: query})
+	if err != nil {
+		return err
+	}
+	var listing struct {
+		Keys []string `json:"keys"`
+		Next string   `json:"next"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&listing)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("client: decode key listing: %w", err)
+	}
+	s.cursor = listing.Next
+	s.done = listing.Next == ""
+	if len(listing.Keys) == 0 {
+		return nil
+	}
+
+	body, _ := json.Marshal(map[string]any{"keys": listing.Keys})
+	resp, err = s.client.do(s.ctx, request{
+		method:      http.MethodPost,
+		path:        "/v1/mget",
+		body:        body,
+		contentType: contentTypeJSON,
+	})
+	if err != nil {
+		return err
+	}
+	var items []struct {
+		Key      string          `json:"key"`
+		Found    bool            `json:"found"`
+		Revision uint64          `json:"revision"`
+		Value    json.RawMessage `json:"value"`
+		Bytes    []byte          `json:"bytes"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&items)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("client: decode values: %w", err)
+	}
+	for _, item := range items {
+		if !item.Found {
+			continue
+		}
+		value := []byte(item.Value)
+		if value == nil {
+			value = item.Bytes
+		}
+		s.page = append(s.page, KeyValue{Key: item.Key, Value: value, Revision: item.Revision})
+	}
+	return nil
+}
+
+// drain reads what is left of a response body and closes it, so that its
+// connection goes back to the pool.
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+}
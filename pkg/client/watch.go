@@ -0,0 +1,253 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Event types.
+const (
+	EventSet    = "set"
+	EventDelete = "delete"
+)
+
+// Event is a change to a watched key.
+type Event struct {
+	// Type is EventSet or EventDelete.
+	Type     string
+	Key      string
+	Value    []byte
+	Revision uint64
+}
+
+// HistoryLostError ends a watch that resumed from a revision whose changes
+// have been compacted away. Re-read the keys, then watch again from
+// Revision or later.
+type HistoryLostError struct {
+	Compacted uint64 `json:"compacted_revision"`
+	Revision  uint64 `json:"revision"`
+}
+
+func (e *HistoryLostError) Error() string {
+	return fmt.Sprintf("client: watch history lost: changes up to revision %d were compacted", e.Compacted)
+}
+
+// errStreamEnded is a watch stream the server closed.
+var errStreamEnded = errors.New("client: watch stream ended")
+
+// Watcher delivers the changes to the keys with a prefix. When the stream
+// breaks it reconnects on its own, resuming after the last event it
+// delivered, so no change is missed or repeated. It stops when its context
+// is done, Close is called, reconnecting fails more often than the client
+// retries, or the history to resume from is lost.
+type Watcher struct {
+	client *Client
+	prefix string
+	events chan Event
+	cancel context.CancelFunc
+
+	// revision is the last revision delivered, from which a broken stream
+	// resumes; 0 until one is known.
+	revision uint64
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+// Watch watches the keys with prefix, an empty prefix watching every key,
+// from now on.
+//
+// Until a first event is delivered there is no revision to resume from, so
+// a stream that breaks before then ends the watch instead of reconnecting.
+func (c *Client) Watch(ctx context.Context, prefix string) (*Watcher, error) {
+	return c.watch(ctx, prefix, 0)
+}
+
+// WatchFrom watches the keys with prefix, first replaying their changes
+// after revision. If those have been compacted the watch stops with a
+// *HistoryLostError.
+func (c *Client) WatchFrom(ctx context.Context, prefix string, revision uint64) (*Watcher, error) {
+	return c.watch(ctx, prefix, revision)
+}
+
+func (c *Client) watch(ctx context.Context, prefix string, revision uint64) (*Watcher, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &Watcher{
+		client:   c,
+		prefix:   prefix,
+		events:   make(chan Event),
+		cancel:   cancel,
+		revision: revision,
+	}
+	resp, err := w.connect(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go w.run(ctx, resp)
+	return w, nil
+}
+
+// Events returns the channel the changes are delivered on. It is closed
+// when the watch stops; Err then tells why.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Err returns the error that stopped the watch, or nil while it runs and
+// after Close.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Close stops the watch.
+func (w *Watcher) Close() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	w.cancel()
+}
+
+func (w *Watcher) connect(ctx context.Context) (*http.Response, error) {
+	req := request{
+		method: http.MethodGet,
+		path:   "/watch/" + escapeKey(w.prefix),
+		accept: "text/event-stream",
+	}
+	if w.revision > 0 {
+		req.query = map[string][]string{"rev": {strconv.FormatUint(w.revision, 10)}}
+	}
+	return w.client.do(ctx, req)
+}
+
+// run delivers the events of the stream resp and of the streams resuming
+// it. Reconnecting is retried by connect; a stream that breaks again
+// without delivering anything counts as another failure, and those are
+// backed off from and limited like retries.
+func (w *Watcher) run(ctx context.Context, resp *http.Response) {
+	defer close(w.events)
+
+	failures := 0
+	for {
+		delivered := w.revision
+		err := w.stream(ctx, resp)
+
+		var lost *HistoryLostError
+		switch {
+		case ctx.Err() != nil:
+			w.stop(ctx.Err())
+			return
+		case errors.As(err, &lost), w.revision == 0:
+			w.stop(err)
+			return
+		case w.revision != delivered:
+			failures = 0
+		default:
+			failures++
+			if failures > w.client.maxRetries {
+				w.stop(err)
+				return
+			}
+			if err := sleep(ctx, w.client.backoff(failures-1)); err != nil {
+				w.stop(err)
+				return
+			}
+		}
+
+		resp, err = w.connect(ctx)
+		if err != nil {
+			w.stop(err)
+			return
+		}
+	}
+}
+
+// stream delivers the events of resp until the stream ends or fails, and
+// closes it.
+func (w *Watcher) stream(ctx context.Context, resp *http.Response) error {
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	for {
+		name, data, err := readEvent(reader)
+		if err != nil {
+			return err
+		}
+		if name == "history-lost" {
+			lost := &HistoryLostError{}
+			if err := json.Unmarshal(data, lost); err != nil {
+				return fmt.Errorf("client: decode history-lost event: %w", err)
+			}
+			return lost
+		}
+
+		var event struct {
+			Type     string `json:"type"`
+			Key      string `json:"key"`
+			Value    string `json:"value"`
+			Revision uint64 `json:"revision"`
+		}
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("client: decode watch event: %w", err)
+		}
+		select {
+		case w.events <- Event{Type: event.Type, Key: event.Key, Value: []byte(event.Value), Revision: event.Revision}:
+			w.revision = event.Revision
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// stop records why the watch stopped, unless it was closed.
+func (w *Watcher) stop(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.err = err
+	}
+}
+
+// readEvent reads the next server-sent event from reader, skipping
+// comments, and returns its name and data.
+func readEvent(reader *bufio.Reader) (string, []byte, error) {
+	var name string
+	var data []byte
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", nil, errStreamEnded
+			}
+			return "", nil, fmt.Errorf("client: read watch stream: %w", err)
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			if data != nil {
+				return name, data, nil
+			}
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			name = value
+		case "data":
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, value...)
+		}
+	}
+}
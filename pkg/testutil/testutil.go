@@ -0,0 +1,40 @@
+// Package testutil starts in-process universekv servers for integration tests.
+package testutil
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"universe/internal/server/http"
+	"universe/internal/store"
+)
+
+// Server is a universekv HTTP server running in the test process.
+type Server struct {
+	// URL is the base URL of the server, e.g. http://127.0.0.1:41234.
+	URL string
+	// Store is the store backing the server, for seeding and assertions.
+	Store *store.Store
+}
+
+// StartTestServer starts a server on an ephemeral port backed by a store in
+// a temporary directory. The server and store are shut down and the
+// directory removed when the test finishes.
+func StartTestServer(t testing.TB) *Server {
+	t.Helper()
+
+	kv, err := store.New(filepath.Join(t.TempDir(), "universe.wal"))
+	if err != nil {
+		t.Fatalf("testutil: open store: %v", err)
+	}
+
+	server := httptest.NewServer(http.NewServer(kv).Handler())
+	t.Cleanup(func() {
+		server.Close()
+		if err := kv.Close(); err != nil {
+			t.Errorf("testutil: close store: %v", err)
+		}
+	})
+
+	return &Server{URL: server.URL, Store: kv}
+}
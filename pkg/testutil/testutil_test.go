@@ -0,0 +1,36 @@
+package testutil
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStartTestServer(t *testing.T) {
+	server := StartTestServer(t)
+
+	resp, err := http.Post(server.URL+"/set/greeting", "application/json", strings.NewReader(`{"value":"hello"}`))
+	if err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("set: unexpected status %d", resp.StatusCode)
+	}
+
+	if _, ok := server.Store.Get("greeting"); !ok {
+		t.Fatalf("expected key to be stored")
+	}
+
+	resp, err = http.Get(server.URL + "/get/greeting")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "hello") {
+		t.Fatalf("unexpected get response: %s", body)
+	}
+}